@@ -0,0 +1,122 @@
+package audit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"gophermart/cmd/gophermart/models"
+	"time"
+)
+
+// Действия, которые пишутся в журнал аудита
+const (
+	ActionUserRegistered  = "user_registered"
+	ActionLoginSucceeded  = "login_succeeded"
+	ActionLoginFailed     = "login_failed"
+	ActionLoginLockout    = "login_lockout"
+	ActionOrderUploaded   = "order_uploaded"
+	ActionOrderConflict   = "order_conflict"
+	ActionAccrualCredited = "accrual_credited"
+	ActionWithdrawal      = "balance_withdrawn"
+	ActionAdjustment      = "balance_adjusted"
+	ActionAccessDenied    = "admin_access_denied"
+	ActionSearchUsers     = "admin_search_users"
+	ActionViewUser        = "admin_view_user"
+	ActionViewOrders      = "admin_view_orders"
+	ActionViewBalance     = "admin_view_balance"
+	ActionViewWithdrawals = "admin_view_withdrawals"
+	ActionOrderRecheck    = "admin_order_recheck"
+	ActionUserLocked      = "admin_user_lock"
+	ActionUserUnlocked    = "admin_user_unlock"
+	ActionRoleChanged     = "admin_role_change"
+)
+
+// ActorSystem - автор событий, которые происходят без запроса пользователя (ответы Accrual)
+const ActorSystem = "system"
+
+// Meta - сведения о запросе, в рамках которого произошло событие
+type Meta struct {
+	RequestID string
+	IP        string
+}
+
+type metaCtxKey struct{}
+
+// WithMeta сохраняет сведения о запросе в контексте, чтобы их могло взять хранилище
+func WithMeta(ctx context.Context, meta Meta) context.Context {
+	return context.WithValue(ctx, metaCtxKey{}, meta)
+}
+
+// MetaFrom - сведения о запросе (пустые вне HTTP-запроса)
+func MetaFrom(ctx context.Context) Meta {
+	meta, _ := ctx.Value(metaCtxKey{}).(Meta)
+	return meta
+}
+
+// New готовит событие; PrevHash и Hash заполняет хранилище при записи.
+// Время округляется до микросекунд, как в PostgreSQL, иначе хеш прочитанной записи не сойдётся.
+func New(ctx context.Context, actor, action, target string, payload map[string]any) models.AuditEvent {
+	meta := MetaFrom(ctx)
+	event := models.AuditEvent{
+		Actor:     actor,
+		Action:    action,
+		Target:    target,
+		RequestID: meta.RequestID,
+		IP:        meta.IP,
+		CreatedAt: time.Now().UTC().Truncate(time.Microsecond),
+	}
+	if len(payload) > 0 {
+		event.Payload, _ = json.Marshal(payload)
+	}
+	return event
+}
+
+// Hash - хеш события, продолжающего цепочку после записи с хешем prevHash
+func Hash(prevHash string, event models.AuditEvent) string {
+	// Массив, а не конкатенация: границы полей не сдвинуть
+	data, _ := json.Marshal([]string{
+		prevHash,
+		event.Actor,
+		event.Action,
+		event.Target,
+		event.RequestID,
+		event.IP,
+		string(event.Payload),
+		event.CreatedAt.UTC().Format(time.RFC3339Nano),
+	})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Chain дописывает событие к цепочке, последняя запись которой имеет хеш prevHash ("" для первой записи)
+func Chain(prevHash string, event models.AuditEvent) models.AuditEvent {
+	event.PrevHash = prevHash
+	event.Hash = Hash(prevHash, event)
+	return event
+}
+
+// Verifier проверяет записи журнала по порядку, начиная с первой
+type Verifier struct {
+	prevHash string
+	checked  int
+	brokenAt int64
+}
+
+// Next проверяет очередную запись. После первого расхождения возвращает false.
+func (v *Verifier) Next(event models.AuditEvent) bool {
+	if v.brokenAt != 0 {
+		return false
+	}
+	v.checked++
+	if event.PrevHash != v.prevHash || Hash(event.PrevHash, event) != event.Hash {
+		v.brokenAt = event.ID
+		return false
+	}
+	v.prevHash = event.Hash
+	return true
+}
+
+func (v *Verifier) Result() models.AuditVerification {
+	return models.AuditVerification{Valid: v.brokenAt == 0, Checked: v.checked, BrokenAt: v.brokenAt}
+}
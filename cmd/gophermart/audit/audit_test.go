@@ -0,0 +1,76 @@
+//go:build unit
+// +build unit
+
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"gophermart/cmd/gophermart/models"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testChain() []models.AuditEvent {
+	ctx := WithMeta(context.Background(), Meta{RequestID: "req-1", IP: "192.0.2.1"})
+	events := []models.AuditEvent{
+		New(ctx, "alice", ActionUserRegistered, "alice", nil),
+		New(ctx, "alice", ActionOrderUploaded, "alice", map[string]any{"order": "12345678903"}),
+		New(context.Background(), ActorSystem, ActionAccrualCredited, "alice", map[string]any{"amount": "10.50"}),
+	}
+	prevHash := ""
+	for i := range events {
+		events[i].ID = int64(i + 1)
+		events[i] = Chain(prevHash, events[i])
+		prevHash = events[i].Hash
+	}
+	return events
+}
+
+func verify(events []models.AuditEvent) models.AuditVerification {
+	var v Verifier
+	for _, event := range events {
+		if !v.Next(event) {
+			break
+		}
+	}
+	return v.Result()
+}
+
+func Test_New(t *testing.T) {
+	ctx := WithMeta(context.Background(), Meta{RequestID: "req-1", IP: "192.0.2.1"})
+	event := New(ctx, "admin", ActionAdjustment, "alice", map[string]any{"reason": "refund", "amount": "5"})
+
+	assert.Equal(t, "admin", event.Actor)
+	assert.Equal(t, "req-1", event.RequestID)
+	assert.Equal(t, "192.0.2.1", event.IP)
+	assert.JSONEq(t, `{"amount":"5","reason":"refund"}`, string(event.Payload))
+	assert.Equal(t, event.CreatedAt, event.CreatedAt.Truncate(time.Microsecond))
+
+	assert.Empty(t, New(context.Background(), ActorSystem, ActionAccrualCredited, "alice", nil).Payload)
+}
+
+func Test_Verifier(t *testing.T) {
+	events := testChain()
+	assert.Equal(t, models.AuditVerification{Valid: true, Checked: 3}, verify(events))
+
+	// Время в другом часовом поясе - тот же момент, хеш сходится
+	moved := append([]models.AuditEvent(nil), events...)
+	moved[1].CreatedAt = moved[1].CreatedAt.In(time.FixedZone("MSK", 3*60*60))
+	assert.True(t, verify(moved).Valid)
+
+	// Изменено содержимое записи
+	tampered := append([]models.AuditEvent(nil), events...)
+	tampered[1].Payload = json.RawMessage(`{"order":"79927398713"}`)
+	assert.Equal(t, models.AuditVerification{Valid: false, Checked: 2, BrokenAt: 2}, verify(tampered))
+
+	// Запись пересчитана, но следующая ссылается на старый хеш
+	tampered[1] = Chain(tampered[1].PrevHash, tampered[1])
+	assert.Equal(t, models.AuditVerification{Valid: false, Checked: 3, BrokenAt: 3}, verify(tampered))
+
+	// Запись удалена
+	deleted := []models.AuditEvent{events[0], events[2]}
+	assert.Equal(t, int64(3), verify(deleted).BrokenAt)
+}
@@ -2,6 +2,7 @@ package clients
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"gophermart/cmd/gophermart/models"
@@ -14,9 +15,10 @@ import (
 )
 
 type AccrualClient interface {
-	RequestToAccrualByOrderumber(orderNumber int) (*resty.Response, error)
-	MakePurchase(orderNumber int)
-	RegisterRewards()
+	RequestToAccrualByOrderumber(ctx context.Context, orderNumber int) (*resty.Response, error)
+	MakePurchase(ctx context.Context, orderNumber int)
+	RegisterRewards(ctx context.Context)
+	Ping(ctx context.Context) error
 }
 
 type AccrualConf struct {
@@ -31,7 +33,7 @@ func NewAccrualClient(addr string, logger *zap.SugaredLogger) *AccrualConf {
 	}
 }
 
-func (ac *AccrualConf) MakePurchase(orderNumber int) {
+func (ac *AccrualConf) MakePurchase(ctx context.Context, orderNumber int) {
 	n := []string{"Чайник", "Микроволновка", "Холодильник", "Стиральная машина", "Утюг", "Духовой шкаф"}
 	b := []string{"Bork", "Philips", "Samsung", "LG"}
 
@@ -72,6 +74,7 @@ func (ac *AccrualConf) MakePurchase(orderNumber int) {
 
 	client := resty.New()
 	resp, err := client.R().
+		SetContext(ctx).
 		SetHeader("Content-Type", "application/json").
 		SetBody(bytes.NewBuffer(orderJSON)).
 		Post(fmt.Sprintf("%s/api/orders", ac.AccrualSystemAddress))
@@ -85,7 +88,7 @@ func (ac *AccrualConf) MakePurchase(orderNumber int) {
 		ac.AccrualSystemAddress, resp.Status(), resp.Body())
 }
 
-func (ac *AccrualConf) RegisterRewards() {
+func (ac *AccrualConf) RegisterRewards(ctx context.Context) {
 	brands := []string{"Bork", "Philips", "Samsung", "LG"}
 	rewardTypes := []string{"%", "pt"}
 
@@ -112,6 +115,7 @@ func (ac *AccrualConf) RegisterRewards() {
 
 	client := resty.New()
 	resp, err := client.R().
+		SetContext(ctx).
 		SetHeader("Content-Type", "application/json").
 		SetBody(bytes.NewBuffer(rewardJSON)).
 		Post(fmt.Sprintf("%s/api/goods", ac.AccrualSystemAddress))
@@ -124,9 +128,10 @@ func (ac *AccrualConf) RegisterRewards() {
 	ac.logger.Debugf("POST %s/api/goods response status: %s\n", ac.AccrualSystemAddress, resp.Status())
 }
 
-func (ac *AccrualConf) RequestToAccrualByOrderumber(orderNumber int) (*resty.Response, error) {
+func (ac *AccrualConf) RequestToAccrualByOrderumber(ctx context.Context, orderNumber int) (*resty.Response, error) {
 	client := resty.New()
 	resp, err := client.R().
+		SetContext(ctx).
 		Get(fmt.Sprintf("%s/api/orders/%d", ac.AccrualSystemAddress, orderNumber))
 
 	if err != nil {
@@ -135,3 +140,13 @@ func (ac *AccrualConf) RequestToAccrualByOrderumber(orderNumber int) (*resty.Res
 
 	return resp, nil
 }
+
+// Ping проверяет, что Accrual отвечает по HTTP (код ответа не важен)
+func (ac *AccrualConf) Ping(ctx context.Context) error {
+	client := resty.New()
+	_, err := client.R().
+		SetContext(ctx).
+		Get(ac.AccrualSystemAddress)
+
+	return err
+}
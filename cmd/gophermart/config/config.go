@@ -4,56 +4,297 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"gophermart/cmd/gophermart/models"
+	"net/netip"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	StorageDB     = "db"
+	StorageMemory = "memory"
+
+	EnvDevelopment = "development"
+	EnvProduction  = "production"
 )
 
 type Config struct {
+	Env                  string
 	Addr                 string
+	StorageType          string
 	DBConnection         string
 	AccrualSystemAddress string
 	Timeout              int
 	NumWorkers           int
 	MaxRequestsPerMin    int
+	PollInterval         int
+	AccrualMaxAttempts   int
+	// Сколько раз опрашивать заказ, о котором Accrual не знает, прежде чем задача уходит в dead
+	AccrualMaxUnregistered int
+	ShutdownTimeout        int
+
+	// Пары ключей securecookie (см. user.ParseCookieKeys), первая - текущая. CookieKeysFile важнее CookieKeys.
+	CookieKeys     string
+	CookieKeysFile string
+	SessionTTL     time.Duration
+
+	PasswordMinLength      int
+	PasswordMinCharClasses int
+	PasswordBreachedFile   string // список утёкших паролей, по одному в строке
+
+	// Защита от перебора: после LoginMaxFailures неудач за LoginFailureWindow вход блокируется на LoginLockout
+	LoginMaxFailures      int
+	LoginMaxFailuresPerIP int
+	LoginFailureWindow    time.Duration
+	LoginLockout          time.Duration
+	// Адрес клиента берётся из заголовков прокси (X-Real-IP и др.) только для запросов с этих адресов,
+	// иначе за обратным прокси все клиенты делили бы один адрес и одну блокировку по IP
+	TrustedProxies []netip.Prefix
+
+	TOTPIssuer string
+	// Списания больше порога пользователи с TOTP подтверждают кодом в заголовке X-TOTP-Code
+	TOTPWithdrawalThreshold models.Money
+
+	// Пользователи, которым разрешено выпускать API-ключи магазина
+	MerchantLogins []string
+
+	// Bearer-токены включаются, если задан JWTSecret (HS256) или JWTPrivateKeyFile (EdDSA)
+	JWTAlgorithm      string
+	JWTSecret         string
+	JWTPrivateKeyFile string
+	JWTIssuer         string
+	JWTAudience       string
+	JWTTTL            time.Duration
 }
 
 func NewConfig() *Config {
 	return &Config{
-		Addr:                 ":8081", // Don't edit
-		DBConnection:         "",
-		AccrualSystemAddress: ":8080",
-		Timeout:              15,
-		NumWorkers:           2,
-		MaxRequestsPerMin:    240,
+		Env:                     EnvDevelopment,
+		Addr:                    ":8081", // Don't edit
+		StorageType:             StorageDB,
+		DBConnection:            "",
+		AccrualSystemAddress:    ":8080",
+		Timeout:                 15,
+		NumWorkers:              2,
+		MaxRequestsPerMin:       240,
+		PollInterval:            5,
+		AccrualMaxAttempts:      10,
+		AccrualMaxUnregistered:  30,
+		ShutdownTimeout:         10,
+		SessionTTL:              30 * 24 * time.Hour,
+		PasswordMinLength:       8,
+		PasswordMinCharClasses:  2,
+		LoginMaxFailures:        5,
+		LoginMaxFailuresPerIP:   20,
+		LoginFailureWindow:      15 * time.Minute,
+		LoginLockout:            15 * time.Minute,
+		TOTPIssuer:              "gophermart",
+		TOTPWithdrawalThreshold: models.NewMoney(1000),
+		JWTAlgorithm:            "HS256",
+		JWTIssuer:               "gophermart",
+		JWTAudience:             "gophermart",
+		JWTTTL:                  24 * time.Hour,
 	}
 }
 
+// IsTrustedProxy - пришёл ли запрос с адреса доверенного прокси
+func (c *Config) IsTrustedProxy(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range c.TrustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsMerchant - может ли пользователь выпускать API-ключи магазина
+func (c *Config) IsMerchant(login string) bool {
+	return slices.Contains(c.MerchantLogins, login)
+}
+
 func Init(c *Config) error {
+	if val, exist := os.LookupEnv("APP_ENV"); exist {
+		c.Env = val
+	}
 	if val, exist := os.LookupEnv("RUN_ADDRESS"); exist {
 		c.Addr = val
 	}
+	if val, exist := os.LookupEnv("STORAGE_TYPE"); exist {
+		c.StorageType = val
+	}
 	if val, exist := os.LookupEnv("DATABASE_URI"); exist {
 		c.DBConnection = val
 	}
 	if val, exist := os.LookupEnv("ACCRUAL_SYSTEM_ADDRESS"); exist {
 		c.AccrualSystemAddress = val
 	}
+	if val, exist := os.LookupEnv("COOKIE_KEYS"); exist {
+		c.CookieKeys = val
+	}
+	if val, exist := os.LookupEnv("COOKIE_KEYS_FILE"); exist {
+		c.CookieKeysFile = val
+	}
+	if val, exist := os.LookupEnv("SESSION_TTL"); exist {
+		ttl, err := time.ParseDuration(val)
+		if err != nil || ttl <= 0 {
+			return fmt.Errorf("invalid SESSION_TTL %q", val)
+		}
+		c.SessionTTL = ttl
+	}
+	if val, exist := os.LookupEnv("PASSWORD_MIN_LENGTH"); exist {
+		n, err := strconv.Atoi(val)
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid PASSWORD_MIN_LENGTH %q", val)
+		}
+		c.PasswordMinLength = n
+	}
+	if val, exist := os.LookupEnv("PASSWORD_MIN_CHAR_CLASSES"); exist {
+		n, err := strconv.Atoi(val)
+		if err != nil || n < 0 || n > 4 {
+			return fmt.Errorf("invalid PASSWORD_MIN_CHAR_CLASSES %q", val)
+		}
+		c.PasswordMinCharClasses = n
+	}
+	if val, exist := os.LookupEnv("PASSWORD_BREACHED_FILE"); exist {
+		c.PasswordBreachedFile = val
+	}
+	if val, exist := os.LookupEnv("LOGIN_MAX_FAILURES"); exist {
+		n, err := strconv.Atoi(val)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid LOGIN_MAX_FAILURES %q", val)
+		}
+		c.LoginMaxFailures = n
+	}
+	if val, exist := os.LookupEnv("LOGIN_MAX_FAILURES_PER_IP"); exist {
+		n, err := strconv.Atoi(val)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid LOGIN_MAX_FAILURES_PER_IP %q", val)
+		}
+		c.LoginMaxFailuresPerIP = n
+	}
+	if val, exist := os.LookupEnv("LOGIN_FAILURE_WINDOW"); exist {
+		window, err := time.ParseDuration(val)
+		if err != nil || window <= 0 {
+			return fmt.Errorf("invalid LOGIN_FAILURE_WINDOW %q", val)
+		}
+		c.LoginFailureWindow = window
+	}
+	if val, exist := os.LookupEnv("LOGIN_LOCKOUT"); exist {
+		lockout, err := time.ParseDuration(val)
+		if err != nil || lockout <= 0 {
+			return fmt.Errorf("invalid LOGIN_LOCKOUT %q", val)
+		}
+		c.LoginLockout = lockout
+	}
+	if val, exist := os.LookupEnv("TRUSTED_PROXIES"); exist {
+		c.TrustedProxies = nil
+		for _, proxy := range strings.Split(val, ",") {
+			if proxy = strings.TrimSpace(proxy); proxy == "" {
+				continue
+			}
+			prefix, err := parseProxy(proxy)
+			if err != nil {
+				return fmt.Errorf("invalid TRUSTED_PROXIES %q", val)
+			}
+			c.TrustedProxies = append(c.TrustedProxies, prefix)
+		}
+	}
+	if val, exist := os.LookupEnv("TOTP_ISSUER"); exist {
+		c.TOTPIssuer = val
+	}
+	if val, exist := os.LookupEnv("TOTP_WITHDRAWAL_THRESHOLD"); exist {
+		var threshold models.Money
+		if err := threshold.UnmarshalJSON([]byte(val)); err != nil || threshold < 0 {
+			return fmt.Errorf("invalid TOTP_WITHDRAWAL_THRESHOLD %q", val)
+		}
+		c.TOTPWithdrawalThreshold = threshold
+	}
+	if val, exist := os.LookupEnv("MERCHANT_LOGINS"); exist {
+		c.MerchantLogins = nil
+		for _, login := range strings.Split(val, ",") {
+			if login = strings.TrimSpace(login); login != "" {
+				c.MerchantLogins = append(c.MerchantLogins, login)
+			}
+		}
+	}
+	if val, exist := os.LookupEnv("JWT_ALGORITHM"); exist {
+		c.JWTAlgorithm = val
+	}
+	if val, exist := os.LookupEnv("JWT_SECRET"); exist {
+		c.JWTSecret = val
+	}
+	if val, exist := os.LookupEnv("JWT_PRIVATE_KEY_FILE"); exist {
+		c.JWTPrivateKeyFile = val
+	}
+	if val, exist := os.LookupEnv("JWT_ISSUER"); exist {
+		c.JWTIssuer = val
+	}
+	if val, exist := os.LookupEnv("JWT_AUDIENCE"); exist {
+		c.JWTAudience = val
+	}
+	if val, exist := os.LookupEnv("JWT_TTL"); exist {
+		ttl, err := time.ParseDuration(val)
+		if err != nil || ttl <= 0 {
+			return fmt.Errorf("invalid JWT_TTL %q", val)
+		}
+		c.JWTTTL = ttl
+	}
 
 	flag.StringVar(&c.Addr, "a", c.Addr, "HTTP-server startup address and port")
+	flag.StringVar(&c.StorageType, "s", c.StorageType, "storage backend: db or memory")
 	flag.StringVar(&c.DBConnection, "d", c.DBConnection, "database connection address")
 	flag.StringVar(&c.AccrualSystemAddress, "r", c.AccrualSystemAddress, "accrual calculation system address")
 
 	flag.Parse()
 
 	fmt.Printf("c.Addr %s\n", c.Addr)
+	fmt.Printf("c.StorageType %s\n", c.StorageType)
 	fmt.Printf("c.DBConnection %s\n", c.DBConnection)
 	fmt.Printf("c.AccrualSystemAddress %s\n", c.AccrualSystemAddress)
 
-	if c.DBConnection == "" {
-		return errors.New("set DATABASE_URI env variable")
+	switch c.StorageType {
+	case StorageDB:
+		if c.DBConnection == "" {
+			return errors.New("set DATABASE_URI env variable")
+		}
+	case StorageMemory:
+	default:
+		return fmt.Errorf("unknown storage type %q", c.StorageType)
 	}
 	if c.AccrualSystemAddress == "" {
 		return errors.New("set ACCRUAL_SYSTEM_ADDRESS env variable")
 	}
+	switch c.Env {
+	case EnvDevelopment:
+	case EnvProduction:
+		// Иначе cookie подписываются ключом из исходников
+		if c.CookieKeys == "" && c.CookieKeysFile == "" {
+			return errors.New("set COOKIE_KEYS or COOKIE_KEYS_FILE env variable in production")
+		}
+	default:
+		return fmt.Errorf("unknown APP_ENV %q", c.Env)
+	}
 
 	return nil
 }
+
+// parseProxy - подсеть (10.0.0.0/8) или отдельный адрес доверенного прокси
+func parseProxy(proxy string) (netip.Prefix, error) {
+	if strings.Contains(proxy, "/") {
+		prefix, err := netip.ParsePrefix(proxy)
+		return prefix.Masked(), err
+	}
+	addr, err := netip.ParseAddr(proxy)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
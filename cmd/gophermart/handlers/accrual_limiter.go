@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"gophermart/cmd/gophermart/metrics"
+	"net/http"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// defaultRetryAfter - пауза после 429 без корректного заголовка Retry-After
+const defaultRetryAfter = time.Minute
+
+var rateLimitRe = regexp.MustCompile(`No more than (\d+) requests per minute allowed`)
+
+// accrualLimiter - общий для всех воркеров лимит запросов к Accrual.
+// После 429 все воркеры ждут до дедлайна Retry-After, а не только получивший ответ.
+type accrualLimiter struct {
+	mu          sync.Mutex
+	interval    time.Duration // минимальный промежуток между запросами
+	next        time.Time     // раньше этого времени следующий запрос не отправляется
+	pausedUntil time.Time
+}
+
+func newAccrualLimiter(maxRequestsPerMinute int) *accrualLimiter {
+	l := &accrualLimiter{}
+	l.setRate(maxRequestsPerMinute)
+	return l
+}
+
+// wait блокируется до момента, когда можно отправить запрос. false - если закрыт stop.
+func (l *accrualLimiter) wait(stop <-chan struct{}) bool {
+	for {
+		l.mu.Lock()
+		now := time.Now()
+		slot := now
+		if l.next.After(slot) {
+			slot = l.next
+		}
+		if l.pausedUntil.After(slot) {
+			slot = l.pausedUntil
+		}
+		if !slot.After(now) {
+			l.next = now.Add(l.interval)
+			l.mu.Unlock()
+			return true
+		}
+		l.mu.Unlock()
+
+		// За время ожидания слот мог занять другой воркер или лимитер мог быть поставлен на паузу
+		timer := time.NewTimer(slot.Sub(now))
+		select {
+		case <-stop:
+			timer.Stop()
+			return false
+		case <-timer.C:
+		}
+	}
+}
+
+// pause откладывает все запросы до until
+func (l *accrualLimiter) pause(until time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if until.After(l.pausedUntil) {
+		l.pausedUntil = until
+	}
+}
+
+func (l *accrualLimiter) setRate(maxRequestsPerMinute int) {
+	if maxRequestsPerMinute <= 0 {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.interval = time.Minute / time.Duration(maxRequestsPerMinute)
+	metrics.AccrualRateLimit.Set(float64(maxRequestsPerMinute))
+}
+
+// parseRetryAfter понимает оба формата Retry-After: число секунд и HTTP-дату
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if d := date.Sub(now); d > 0 {
+			return d
+		}
+		return 0
+	}
+	return defaultRetryAfter
+}
+
+// parseRateLimit достаёт N из "No more than N requests per minute allowed", 0 - если не нашлось
+func parseRateLimit(body string) int {
+	m := rateLimitRe.FindStringSubmatch(body)
+	if m == nil {
+		return 0
+	}
+	n, err := strconv.Atoi(m[1])
+	if err != nil {
+		return 0
+	}
+	return n
+}
@@ -0,0 +1,74 @@
+//go:build unit
+// +build unit
+
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_AccrualLimiter_Interval(t *testing.T) {
+	l := newAccrualLimiter(600) // 100ms между запросами
+	stop := make(chan struct{})
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		assert.True(t, l.wait(stop))
+	}
+	assert.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+
+	l.setRate(60000)
+	l.next = time.Time{}
+	start = time.Now()
+	for i := 0; i < 3; i++ {
+		assert.True(t, l.wait(stop))
+	}
+	assert.Less(t, time.Since(start), 100*time.Millisecond, "rate was raised")
+}
+
+func Test_AccrualLimiter_PauseIsShared(t *testing.T) {
+	l := newAccrualLimiter(60000)
+	stop := make(chan struct{})
+
+	l.pause(time.Now().Add(150 * time.Millisecond))
+	l.pause(time.Now()) // более ранний дедлайн не сокращает паузу
+
+	done := make(chan time.Duration, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			start := time.Now()
+			l.wait(stop)
+			done <- time.Since(start)
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		assert.GreaterOrEqual(t, <-done, 140*time.Millisecond)
+	}
+}
+
+func Test_AccrualLimiter_Stop(t *testing.T) {
+	l := newAccrualLimiter(60000)
+	l.pause(time.Now().Add(time.Hour))
+
+	stop := make(chan struct{})
+	close(stop)
+	assert.False(t, l.wait(stop))
+}
+
+func Test_ParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, 60*time.Second, parseRetryAfter("60", now))
+	assert.Equal(t, 30*time.Second, parseRetryAfter("Mon, 01 Jan 2024 12:00:30 GMT", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("Mon, 01 Jan 2024 11:00:00 GMT", now))
+	assert.Equal(t, defaultRetryAfter, parseRetryAfter("", now))
+	assert.Equal(t, defaultRetryAfter, parseRetryAfter("-5", now))
+}
+
+func Test_ParseRateLimit(t *testing.T) {
+	assert.Equal(t, 10, parseRateLimit("No more than 10 requests per minute allowed"))
+	assert.Equal(t, 0, parseRateLimit("Too Many Requests"))
+}
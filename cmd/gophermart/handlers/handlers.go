@@ -1,11 +1,18 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"gophermart/cmd/gophermart/audit"
 	"gophermart/cmd/gophermart/clients"
 	"gophermart/cmd/gophermart/config"
+	"gophermart/cmd/gophermart/metrics"
 	"gophermart/cmd/gophermart/models"
+	"gophermart/cmd/gophermart/orderstate"
+	"gophermart/cmd/gophermart/problem"
+	"gophermart/cmd/gophermart/pubsub"
 	"gophermart/cmd/gophermart/storage"
 	"gophermart/cmd/gophermart/user"
 	"io"
@@ -14,6 +21,8 @@ import (
 	"strings"
 	"time"
 
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
 	"go.uber.org/zap"
 )
 
@@ -25,18 +34,34 @@ type Controller struct {
 	userService    user.UserService
 	accrualQueue   *AccrualQueue
 	AccrualClient  clients.AccrualClient
+	events         *pubsub.Broker
+	tokenService   user.TokenService // nil - Bearer-токены не принимаются
+	passwordPolicy *user.PasswordPolicy
+	loginThrottle  *user.LoginThrottle // nil - число попыток входа не ограничено
+	totp           *user.TOTP
 }
 
 var (
-	ErrUpdateUserBalance = errors.New("error UpdateUserBalance")
-	ErrUpdateOrder       = errors.New("error UpdateOrder")
-	ErrRetryAfter        = errors.New("error invalid Retry-After value")
-	ErrNoOKfromAccrual   = errors.New("response from Accrual with StatusCode != StatusOK")
-	ErrAccrualRequest    = errors.New("error sending GET request")
+	ErrUpdateOrder     = errors.New("error UpdateOrder")
+	ErrNoOKfromAccrual = errors.New("response from Accrual with StatusCode != StatusOK")
+	ErrAccrualRequest  = errors.New("error sending GET request")
+	ErrNotRegistered   = errors.New("order is not registered in Accrual")
 )
 
+// TooManyRequestsError - Accrual ответил 429, запросы нужно приостановить на RetryAfter
+type TooManyRequestsError struct {
+	RetryAfter        time.Duration
+	MaxRequestsPerMin int // 0, если лимит не указан в ответе
+}
+
+func (e *TooManyRequestsError) Error() string {
+	return fmt.Sprintf("accrual rate limit exceeded, retry after %s", e.RetryAfter)
+}
+
 func NewController(conf *config.Config, storageService storage.StorageService, storageUtils storage.StorageUtils,
-	logger *zap.SugaredLogger, us user.UserService, wp *AccrualQueue, accrualService clients.AccrualClient) *Controller {
+	logger *zap.SugaredLogger, us user.UserService, wp *AccrualQueue, accrualService clients.AccrualClient,
+	events *pubsub.Broker, tokenService user.TokenService, passwordPolicy *user.PasswordPolicy,
+	loginThrottle *user.LoginThrottle, totp *user.TOTP) *Controller {
 	con := &Controller{
 		conf:           conf,
 		storageService: storageService,
@@ -45,6 +70,11 @@ func NewController(conf *config.Config, storageService storage.StorageService, s
 		userService:    us,
 		accrualQueue:   wp,
 		AccrualClient:  accrualService,
+		events:         events,
+		tokenService:   tokenService,
+		passwordPolicy: passwordPolicy,
+		loginThrottle:  loginThrottle,
+		totp:           totp,
 	}
 
 	con.accrualQueue.Start(con)
@@ -52,77 +82,148 @@ func NewController(conf *config.Config, storageService storage.StorageService, s
 	return con
 }
 
-func (con *Controller) handleAuth(res http.ResponseWriter, userID string, user_ user.User) {
-	storedHashedPassword := con.storageService.GetHashedPasswordByLogin(user_.Login)
-	if storedHashedPassword == "" || !con.storageUtils.CheckPasswordHash(user_.Password, storedHashedPassword) {
-		con.Debug(res, "Unauthorized: Invalid login/password", http.StatusUnauthorized)
+// handleAuth проверяет пароль и открывает новую сессию
+func (con *Controller) handleAuth(res http.ResponseWriter, req *http.Request, user_ user.User) {
+	if !con.checkCredentials(req.Context(), user_) {
+		con.Problem(res, req, problem.InvalidCredentials, "")
 		return
 	}
+	// Пользователь только что создан: не заблокирован, версия токенов 0
+	con.startSession(res, req, models.Account{Login: user_.Login, Role: models.RoleUser})
+}
 
-	err := con.storageService.SaveUID(userID, user_.Login)
-	if err != nil {
-		con.Debug(res, "Bad request", http.StatusBadRequest)
+func (con *Controller) checkCredentials(ctx context.Context, user_ user.User) bool {
+	storedHashedPassword := con.storageService.GetHashedPasswordByLogin(ctx, user_.Login)
+	return storedHashedPassword != "" && con.storageUtils.CheckPasswordHash(user_.Password, storedHashedPassword)
+}
+
+// startSession открывает новую сессию (новый ID, а не присланный в cookie)
+func (con *Controller) startSession(res http.ResponseWriter, req *http.Request, account models.Account) {
+	login := account.Login
+	now := time.Now()
+	session := models.Session{
+		ID:        uuid.New().String(),
+		Login:     login,
+		CreatedAt: now,
+		UserAgent: req.UserAgent(),
+		IP:        clientIP(req),
+		ExpiresAt: now.Add(con.conf.SessionTTL),
+	}
+	if err := con.storageService.CreateSession(req.Context(), session); err != nil {
+		con.Error(res, req, err)
 		return
 	}
 
-	_ = con.userService.SetUserIDCookie(res, userID)
+	if !con.setBearerToken(res, req, account, session.ID) {
+		return
+	}
+
+	con.setUserIDCookie(res, session.ID)
 	con.Debug(res, "Login success", http.StatusOK)
 }
 
+// setBearerToken выдаёт Bearer-токен сессии sessionID в заголовке Authorization ответа,
+// если токены включены. При ошибке ответ уже отправлен.
+func (con *Controller) setBearerToken(res http.ResponseWriter, req *http.Request, account models.Account, sessionID string) bool {
+	if con.tokenService == nil {
+		return true
+	}
+	token, err := con.tokenService.IssueToken(user.TokenClaims{
+		Login:     account.Login,
+		SessionID: sessionID,
+		Version:   account.TokenVersion,
+	})
+	if err != nil {
+		con.Error(res, req, err)
+		return false
+	}
+	res.Header().Set("Authorization", "Bearer "+token)
+	return true
+}
+
 func (con *Controller) Register() http.HandlerFunc {
 	return func(res http.ResponseWriter, req *http.Request) {
-		userID := req.Header.Get("User-ID")
-
 		var user_ user.User
 		err := json.NewDecoder(req.Body).Decode(&user_)
 		login := user_.Login
 		password := user_.Password
 		if err != nil || login == "" || password == "" {
-			con.Debug(res, "Bad request", http.StatusBadRequest)
+			con.Problem(res, req, problem.BadRequest, "")
+			return
+		}
+		if violations := con.passwordPolicy.Check(login, password); violations != nil {
+			con.passwordPolicyError(res, req, violations)
 			return
 		}
 
 		hashedPassword, err := con.storageUtils.HashPassword(password)
 		if err != nil {
-			con.Debug(res, "(Register) Internal server error", http.StatusInternalServerError)
+			con.Error(res, req, err)
 			return
 		}
 
-		ok := con.storageService.SaveLoginPassword(login, hashedPassword)
+		ok := con.storageService.SaveLoginPassword(req.Context(), login, hashedPassword)
 		if !ok {
-			con.Debug(res, "Conflict: Login already taken", http.StatusConflict)
+			con.Problem(res, req, problem.LoginTaken, "")
 			return
 		}
+		con.writeAudit(req, login, audit.ActionUserRegistered, login, nil)
 
-		con.handleAuth(res, userID, user_)
+		con.handleAuth(res, req, user_)
 	}
 }
 
+// Login проверяет пароль. Если у пользователя включён TOTP, сессия открывается
+// только после POST /api/user/login/totp, а здесь возвращается 202 с challenge_id.
 func (con *Controller) Login() http.HandlerFunc {
 	return func(res http.ResponseWriter, req *http.Request) {
-		userID := req.Header.Get("User-ID")
-
 		var user_ user.User
 		err := json.NewDecoder(req.Body).Decode(&user_)
 		if err != nil || user_.Login == "" || user_.Password == "" {
-			con.Debug(res, "Bad request", http.StatusBadRequest)
+			con.Problem(res, req, problem.BadRequest, "")
 			return
 		}
-		con.handleAuth(res, userID, user_)
+
+		ip := clientIP(req)
+		if !con.checkLoginThrottle(res, req, user_.Login, ip) {
+			return
+		}
+		if !con.checkCredentials(req.Context(), user_) {
+			con.loginFailed(res, req, user_.Login, ip, "password")
+			return
+		}
+		account, ok := con.checkAccountActive(res, req, user_.Login)
+		if !ok {
+			return
+		}
+
+		totp, err := con.storageService.GetTOTP(req.Context(), user_.Login)
+		if err != nil {
+			con.Error(res, req, err)
+			return
+		}
+		if totp != nil && totp.Enabled {
+			con.startMFAChallenge(res, req, user_.Login)
+			return
+		}
+
+		con.loginSucceeded(req, user_.Login)
+		con.startSession(res, req, account)
 	}
 }
 
+// OrdersUpload загружает номер заказа пользователя. С ключом магазина можно указать покупателя в ?login=.
 func (con *Controller) OrdersUpload() http.HandlerFunc {
 	return func(res http.ResponseWriter, req *http.Request) {
-		userID := req.Header.Get("User-ID")
-		userLogin := con.storageService.GetLoginByUID(userID)
+		sessionID := requestSession(req)
+		userLogin := requestLogin(req)
 		if userLogin == "" {
-			con.Debug(res, "Unauthorized", http.StatusUnauthorized)
+			con.Problem(res, req, problem.Unauthorized, "")
 			return
 		}
 
 		if !strings.Contains(req.Header.Get("Content-Type"), "text/plain") {
-			con.Debug(res, "Bad Request", http.StatusBadRequest)
+			con.Problem(res, req, problem.BadRequest, "Content-Type must be text/plain")
 			return
 		}
 
@@ -130,27 +231,50 @@ func (con *Controller) OrdersUpload() http.HandlerFunc {
 		defer req.Body.Close()
 		orderNumber, _ := strconv.Atoi(string(body))
 		if !models.IsValidOrderNumber(strconv.Itoa(orderNumber)) {
-			con.Debug(res, "Unprocessable Entity", http.StatusUnprocessableEntity)
+			con.Problem(res, req, problem.InvalidOrderNumber, "")
 			return
 		}
 
+		// Магазин загружает заказ покупателя: POST /api/user/orders?login=<покупатель> с ключом магазина.
+		// Владелец ключа должен оставаться магазином: ключ, выпущенный до исключения из MERCHANT_LOGINS, не действует.
+		if target := req.URL.Query().Get("login"); target != "" && target != userLogin {
+			if key := requestAPIKey(req); key == nil || !key.Merchant || !con.conf.IsMerchant(key.Login) {
+				con.Problem(res, req, problem.APIKeyForbidden, "only merchant API keys can upload orders for other users")
+				return
+			}
+			if _, err := con.storageService.GetUser(req.Context(), target); err != nil {
+				con.Error(res, req, err) // ErrUserNotFound -> 404
+				return
+			}
+			userLogin = target
+		}
+
 		// TEST @@@ Типа совершаем покупку (POST /api/orders)
-		// con.AccrualClient.MakePurchase(orderNumber)
+		// con.AccrualClient.MakePurchase(req.Context(), orderNumber)
 
-		orderAdded, err := con.storageService.AddOrder(userLogin, orderNumber)
+		orderAdded, err := con.storageService.AddOrder(req.Context(), userLogin, orderNumber)
 		if err != nil {
 			if errors.Is(err, storage.ErrAddOrderConflict) {
-				con.Debug(res, "Conflict", http.StatusConflict)
+				con.writeAudit(req, requestLogin(req), audit.ActionOrderConflict, userLogin, orderAuditPayload(req, orderNumber))
+				con.Problem(res, req, problem.OrderConflict, "")
 				return
 			}
-			con.Debug(res, "(OrdersUpload) Internal Server Error", http.StatusInternalServerError)
+			con.Error(res, req, err)
 			return
 		}
 
-		// 3. Заказ попадает в систему расчёта баллов лояльности (в Accrual) @@@
-		con.accrualQueue.AddTask(Task{UserLogin: userLogin, OrderNumber: orderNumber})
+		// 3. Задача для Accrual сохранена вместе с заказом, воркеры подхватят её без ожидания
+		if orderAdded {
+			con.writeAudit(req, requestLogin(req), audit.ActionOrderUploaded, userLogin, orderAuditPayload(req, orderNumber))
+			con.accrualQueue.Notify()
+			con.events.Publish(userLogin, models.OrderEvent{
+				Number:    strconv.Itoa(orderNumber),
+				Status:    orderstate.New,
+				ChangedAt: time.Now(),
+			})
+		}
 
-		_ = con.userService.SetUserIDCookie(res, userID)
+		con.setUserIDCookie(res, sessionID)
 		if orderAdded {
 			res.WriteHeader(http.StatusAccepted) // Новый номер заказа принят в обработку
 		} else {
@@ -159,18 +283,35 @@ func (con *Controller) OrdersUpload() http.HandlerFunc {
 	}
 }
 
+// orderAuditPayload - номер заказа и API-ключ, которым он загружен (если загружен по ключу)
+func orderAuditPayload(req *http.Request, orderNumber int) map[string]any {
+	payload := map[string]any{"order": strconv.Itoa(orderNumber)}
+	if key := requestAPIKey(req); key != nil {
+		payload["api_key"] = key.ID
+	}
+	return payload
+}
+
+// OrdersGet - заказы пользователя, по умолчанию все и от новых к старым.
+// Фильтры и постраничная выдача - см. parseListQuery.
 func (con *Controller) OrdersGet() http.HandlerFunc {
 	return func(res http.ResponseWriter, req *http.Request) {
-		userID := req.Header.Get("User-ID")
-		userLogin := con.storageService.GetLoginByUID(userID)
+		sessionID := requestSession(req)
+		userLogin := requestLogin(req)
 		if userLogin == "" {
-			con.Debug(res, "(OrdersGet) Unauthorized", http.StatusUnauthorized)
+			con.Problem(res, req, problem.Unauthorized, "")
 			return
 		}
 
-		orders, err := con.storageService.GetOrders(userLogin)
+		list, err := parseListQuery(req, true)
 		if err != nil {
-			con.Debug(res, "(OrdersGet) Internal Server Error", http.StatusInternalServerError)
+			con.Problem(res, req, problem.InvalidParameter, err.Error())
+			return
+		}
+
+		orders, err := con.storageService.GetOrders(req.Context(), userLogin, lookahead(list))
+		if err != nil {
+			con.Error(res, req, err)
 			return
 		}
 
@@ -179,62 +320,83 @@ func (con *Controller) OrdersGet() http.HandlerFunc {
 			return
 		}
 
-		tasks := make([]Task, len(orders))
-		// Обновление статусов заказов через систему расчёта начислений (Accrual) @@@
-		for i, order := range orders {
-			orderNumber, _ := strconv.Atoi(order.Number)
-			tasks[i] = Task{UserLogin: userLogin, OrderNumber: orderNumber}
-			con.accrualQueue.AddTask(tasks[i])
-		}
-
-		go func() {
-			for range tasks {
-				select {
-				case result := <-con.accrualQueue.results:
-					for i, order := range orders {
-						if order.Number == result.Order {
-							orders[i].Status = result.Status
-							orders[i].Accrual = result.Accrual
-							break
-						}
-					}
-				case errA := <-con.accrualQueue.errors:
-					switch {
-					case errors.Is(errA, ErrUpdateUserBalance):
-						con.Debug(res, "(OrdersGet) Error UpdateUserBalance", http.StatusInternalServerError)
-					case errors.Is(errA, ErrUpdateOrder):
-						con.Debug(res, "(OrdersGet) Error UpdateOrder", http.StatusInternalServerError)
-						// default:
-						// 	con.Debug(res, "(OrdersGet) Internal Server Error", http.StatusInternalServerError)
-					}
-					return
-				}
-			}
-		}()
+		orders = nextPage(res, req, list, orders, orderCursor)
 
 		res.Header().Set("Content-Type", "application/json")
-		_ = con.userService.SetUserIDCookie(res, userID)
+		con.setUserIDCookie(res, sessionID)
 		res.WriteHeader(http.StatusOK)
 		_ = json.NewEncoder(res).Encode(orders)
 	}
 }
 
+// OrderGet - заказ пользователя с историей статусов и попытками запроса к Accrual.
+// Чужой заказ неотличим от несуществующего.
+func (con *Controller) OrderGet() http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		sessionID := requestSession(req)
+		userLogin := requestLogin(req)
+		if userLogin == "" {
+			con.Problem(res, req, problem.Unauthorized, "")
+			return
+		}
+
+		number := chi.URLParam(req, "number")
+		orderNumber, err := strconv.Atoi(number)
+		if err != nil || !models.IsValidOrderNumber(number) {
+			con.Problem(res, req, problem.InvalidOrderNumber, "")
+			return
+		}
+
+		order, err := con.storageService.GetOrder(req.Context(), orderNumber)
+		if errors.Is(err, storage.ErrOrderNotFound) || (err == nil && order.Login != userLogin) {
+			con.Problem(res, req, problem.OrderNotFound, "")
+			return
+		}
+		if err != nil {
+			con.Error(res, req, err)
+			return
+		}
+
+		history, err := con.storageService.GetOrderStatusHistory(req.Context(), orderNumber)
+		if err != nil {
+			con.Error(res, req, err)
+			return
+		}
+		task, err := con.storageService.GetAccrualTask(req.Context(), orderNumber)
+		if err != nil {
+			con.Error(res, req, err)
+			return
+		}
+
+		order.Login = ""
+		details := models.OrderDetails{Order: order, History: history, AccrualTask: task}
+		if details.History == nil {
+			details.History = []models.OrderStatusChange{}
+		}
+
+		res.Header().Set("Content-Type", "application/json")
+		con.setUserIDCookie(res, sessionID)
+		res.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(res).Encode(details)
+	}
+}
+
 func (con *Controller) UserBalance() http.HandlerFunc {
 	return func(res http.ResponseWriter, req *http.Request) {
-		userID := req.Header.Get("User-ID")
-		userLogin := con.storageService.GetLoginByUID(userID)
+		sessionID := requestSession(req)
+		userLogin := requestLogin(req)
 		if userLogin == "" {
-			con.Debug(res, "Unauthorized", http.StatusUnauthorized)
+			con.Problem(res, req, problem.Unauthorized, "")
 			return
 		}
-		balance, err := con.storageService.GetUserBalance(userLogin)
+		balance, err := con.storageService.GetUserBalance(req.Context(), userLogin)
 		if err != nil {
-			con.Debug(res, "(UserBalance) Internal Server Error", http.StatusInternalServerError)
+			con.Error(res, req, err)
 			return
 		}
 
 		res.Header().Set("Content-Type", "application/json")
-		_ = con.userService.SetUserIDCookie(res, userID)
+		con.setUserIDCookie(res, sessionID)
 		res.WriteHeader(http.StatusOK)
 		_ = json.NewEncoder(res).Encode(balance)
 	}
@@ -242,52 +404,82 @@ func (con *Controller) UserBalance() http.HandlerFunc {
 
 func (con *Controller) RequestForWithdrawal() http.HandlerFunc {
 	return func(res http.ResponseWriter, req *http.Request) {
-		userID := req.Header.Get("User-ID")
-		userLogin := con.storageService.GetLoginByUID(userID)
+		sessionID := requestSession(req)
+		userLogin := requestLogin(req)
 		if userLogin == "" {
-			con.Debug(res, "Unauthorized", http.StatusUnauthorized)
+			con.Problem(res, req, problem.Unauthorized, "")
 			return
 		}
 
 		var wr models.WithdrawRequest
 		if err := json.NewDecoder(req.Body).Decode(&wr); err != nil {
-			con.Debug(res, "Unprocessable Entity", http.StatusUnprocessableEntity)
+			con.Problem(res, req, problem.BadRequest, "")
 			return
 		}
 
 		orderNumber := wr.Order
 		if !models.IsValidOrderNumber(orderNumber) {
-			con.Debug(res, "Unprocessable Entity (invalid order number)", http.StatusUnprocessableEntity)
+			con.Problem(res, req, problem.InvalidOrderNumber, "")
+			return
+		}
+		if wr.Sum <= 0 {
+			con.Problem(res, req, problem.InvalidAmount, "")
 			return
 		}
 
+		if wr.Sum > con.conf.TOTPWithdrawalThreshold {
+			// Присланный код учитывается в ограничении неудачных входов, как и на втором шаге входа
+			code, ip := req.Header.Get(TOTPCodeHeader), clientIP(req)
+			if code != "" && !con.checkLoginThrottle(res, req, userLogin, ip) {
+				return
+			}
+			ok, err := con.requireFreshTOTP(req.Context(), userLogin, code)
+			if err != nil {
+				con.Error(res, req, err)
+				return
+			}
+			if !ok {
+				detail := "withdrawals above the threshold require the " + TOTPCodeHeader + " header"
+				if code == "" {
+					con.Problem(res, req, problem.TOTPRequired, detail)
+					return
+				}
+				con.secondFactorFailed(res, req, userLogin, ip, problem.TOTPRequired, detail)
+				return
+			}
+		}
+
 		on, _ := strconv.Atoi(orderNumber)
-		err := con.storageService.WithdrawFromUserBalance(userLogin, on, wr.Sum)
+		err := con.storageService.WithdrawFromUserBalance(req.Context(), userLogin, on, wr.Sum)
 		if err != nil {
-			if errors.Is(err, storage.ErrInsufficientFunds) {
-				con.Debug(res, "Insufficient funds", http.StatusPaymentRequired)
-			} else {
-				con.Debug(res, "(RequestForWithdrawal) Internal Server Error", http.StatusInternalServerError)
-			}
+			con.Error(res, req, err) // 402 при нехватке баллов, 422 при повторном списании по заказу
 			return
 		}
-		_ = con.userService.SetUserIDCookie(res, userID)
+		con.setUserIDCookie(res, sessionID)
 		con.Debug(res, "Request for withdrawal success", http.StatusOK)
 	}
 }
 
+// InfoAboutWithdrawals - списания пользователя, по умолчанию все и от новых к старым.
+// Фильтры (кроме status) и постраничная выдача - см. parseListQuery.
 func (con *Controller) InfoAboutWithdrawals() http.HandlerFunc {
 	return func(res http.ResponseWriter, req *http.Request) {
-		userID := req.Header.Get("User-ID")
-		userLogin := con.storageService.GetLoginByUID(userID)
+		sessionID := requestSession(req)
+		userLogin := requestLogin(req)
 		if userLogin == "" {
-			con.Debug(res, "Unauthorized", http.StatusUnauthorized)
+			con.Problem(res, req, problem.Unauthorized, "")
 			return
 		}
 
-		withdrawals, err := con.storageService.GetUserWithdrawals(userLogin)
+		list, err := parseListQuery(req, false)
 		if err != nil {
-			con.Debug(res, "(InfoAboutWithdrawals) Internal Server Error", http.StatusInternalServerError)
+			con.Problem(res, req, problem.InvalidParameter, err.Error())
+			return
+		}
+
+		withdrawals, err := con.storageService.GetUserWithdrawals(req.Context(), userLogin, lookahead(list))
+		if err != nil {
+			con.Error(res, req, err)
 			return
 		}
 
@@ -296,45 +488,59 @@ func (con *Controller) InfoAboutWithdrawals() http.HandlerFunc {
 			return
 		}
 
+		withdrawals = nextPage(res, req, list, withdrawals, withdrawalCursor)
+
 		res.Header().Set("Content-Type", "application/json")
-		_ = con.userService.SetUserIDCookie(res, userID)
+		con.setUserIDCookie(res, sessionID)
 		res.WriteHeader(http.StatusOK)
 		_ = json.NewEncoder(res).Encode(withdrawals)
 	}
 }
 
 // Запрос в систему расчёта баллов лояльности (в Accrual) @@@ GET /api/orders/{number}
-func (con *Controller) RequestToAccrual(userLogin string, orderNumber int) (*models.AccrualResponse, error) {
-	resp, err := con.AccrualClient.RequestToAccrualByOrderumber(orderNumber)
+func (con *Controller) RequestToAccrual(ctx context.Context, userLogin string, orderNumber int) (*models.AccrualResponse, error) {
+	resp, err := con.AccrualClient.RequestToAccrualByOrderumber(ctx, orderNumber)
 	if err != nil {
+		metrics.AccrualResponses.WithLabelValues("error").Inc()
 		return nil, ErrAccrualRequest
 	}
+	metrics.AccrualResponses.WithLabelValues(strconv.Itoa(resp.StatusCode())).Inc()
 
 	var accrualResponse *models.AccrualResponse
 	if resp.StatusCode() == http.StatusOK {
 		if err := json.Unmarshal(resp.Body(), &accrualResponse); err != nil {
 			return nil, err
 		}
-		status := accrualResponse.Status
+		status, err := orderstate.FromAccrual(accrualResponse.Status)
+		if err != nil {
+			return nil, err
+		}
 		accrual := accrualResponse.Accrual
 
-		// Обновить данные о бонусах в таблице users_balances
-		if err = con.storageService.UpdateUserBalance(userLogin, orderNumber, accrual); err != nil {
-			return nil, ErrUpdateUserBalance
+		// Обновить данные о заказе в таблице orders и начислить бонусы (одной транзакцией)
+		changed, err := con.storageService.UpdateOrder(ctx, orderNumber, status, accrual)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrUpdateOrder, err)
 		}
-
-		// Обновить данные о заказе в таблице orders
-		if err = con.storageService.UpdateOrder(orderNumber, status, accrual); err != nil {
-			return nil, ErrUpdateOrder
+		if changed {
+			con.events.Publish(userLogin, models.OrderEvent{
+				Number:    strconv.Itoa(orderNumber),
+				Status:    status,
+				Accrual:   accrual,
+				ChangedAt: time.Now(),
+			})
 		}
+		// Дальше статус в терминах gophermart
+		accrualResponse.Status = status
+	} else if resp.StatusCode() == http.StatusNoContent {
+		// Заказ остаётся NEW, Accrual может зарегистрировать его позже
+		return nil, ErrNotRegistered
 	} else if resp.StatusCode() == http.StatusTooManyRequests {
-		retryAfter := resp.Header().Get("Retry-After")
-		retryAfterDuration, err := strconv.Atoi(retryAfter)
-		if err != nil {
-			return nil, ErrRetryAfter
+		// Пауза общая для всех воркеров, её выдерживает AccrualQueue
+		return nil, &TooManyRequestsError{
+			RetryAfter:        parseRetryAfter(resp.Header().Get("Retry-After"), time.Now()),
+			MaxRequestsPerMin: parseRateLimit(string(resp.Body())),
 		}
-		con.sugar.Debugf("Rate limit exceeded, pausing for %d seconds\n", retryAfterDuration)
-		time.Sleep(time.Duration(retryAfterDuration) * time.Second)
 	} else {
 		return nil, ErrNoOKfromAccrual
 	}
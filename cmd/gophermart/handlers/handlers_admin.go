@@ -0,0 +1,281 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"gophermart/cmd/gophermart/audit"
+	"gophermart/cmd/gophermart/models"
+	"gophermart/cmd/gophermart/problem"
+	"gophermart/cmd/gophermart/storage"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Сколько пользователей возвращает поиск без ?limit= и максимум
+const (
+	adminSearchLimit    = 50
+	adminSearchMaxLimit = 200
+)
+
+// auditAdmin пишет в журнал аудита просмотр данных сотрудником.
+// Изменения (роль, блокировка, повторная проверка) хранилище записывает в своей транзакции.
+func (con *Controller) auditAdmin(req *http.Request, action, target string, payload map[string]any) {
+	con.writeAudit(req, requestLogin(req), action, target, payload)
+}
+
+// adminTarget - пользователь из {login} в пути. При ошибке ответ уже отправлен.
+func (con *Controller) adminTarget(res http.ResponseWriter, req *http.Request) (models.Account, bool) {
+	account, err := con.storageService.GetUser(req.Context(), chi.URLParam(req, "login"))
+	if err != nil {
+		con.Error(res, req, err)
+		return account, false
+	}
+	return account, true
+}
+
+func (con *Controller) writeAdminJSON(res http.ResponseWriter, req *http.Request, v any) {
+	res.Header().Set("Content-Type", "application/json")
+	con.setUserIDCookie(res, requestSession(req))
+	res.WriteHeader(http.StatusOK)
+	_ = json.NewEncoder(res).Encode(v)
+}
+
+// AdminUsers ищет пользователей по части логина: GET /api/admin/users?q=<строка>&limit=<n>
+func (con *Controller) AdminUsers() http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		query := req.URL.Query().Get("q")
+		limit := adminSearchLimit
+		if s := req.URL.Query().Get("limit"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n <= 0 || n > adminSearchMaxLimit {
+				con.Problem(res, req, problem.InvalidParameter, "invalid limit")
+				return
+			}
+			limit = n
+		}
+
+		accounts, err := con.storageService.SearchUsers(req.Context(), query, limit)
+		if err != nil {
+			con.Error(res, req, err)
+			return
+		}
+		con.auditAdmin(req, audit.ActionSearchUsers, "", map[string]any{"query": query, "results": len(accounts)})
+
+		if len(accounts) == 0 {
+			con.Debug(res, "(AdminUsers) No Content", http.StatusNoContent)
+			return
+		}
+		con.writeAdminJSON(res, req, accounts)
+	}
+}
+
+func (con *Controller) AdminUser() http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		account, ok := con.adminTarget(res, req)
+		if !ok {
+			return
+		}
+		con.auditAdmin(req, audit.ActionViewUser, account.Login, nil)
+		con.writeAdminJSON(res, req, account)
+	}
+}
+
+func (con *Controller) AdminUserOrders() http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		account, ok := con.adminTarget(res, req)
+		if !ok {
+			return
+		}
+
+		list, err := parseListQuery(req, true)
+		if err != nil {
+			con.Problem(res, req, problem.InvalidParameter, err.Error())
+			return
+		}
+
+		orders, err := con.storageService.GetOrders(req.Context(), account.Login, lookahead(list))
+		if err != nil {
+			con.Error(res, req, err)
+			return
+		}
+		con.auditAdmin(req, audit.ActionViewOrders, account.Login, nil)
+
+		if len(orders) == 0 {
+			con.Debug(res, "(AdminUserOrders) No Content", http.StatusNoContent)
+			return
+		}
+		orders = nextPage(res, req, list, orders, orderCursor)
+		con.writeAdminJSON(res, req, orders)
+	}
+}
+
+func (con *Controller) AdminUserBalance() http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		account, ok := con.adminTarget(res, req)
+		if !ok {
+			return
+		}
+
+		balance, err := con.storageService.GetUserBalance(req.Context(), account.Login)
+		if err != nil {
+			con.Error(res, req, err)
+			return
+		}
+		con.auditAdmin(req, audit.ActionViewBalance, account.Login, nil)
+		con.writeAdminJSON(res, req, balance)
+	}
+}
+
+func (con *Controller) AdminUserWithdrawals() http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		account, ok := con.adminTarget(res, req)
+		if !ok {
+			return
+		}
+
+		list, err := parseListQuery(req, false)
+		if err != nil {
+			con.Problem(res, req, problem.InvalidParameter, err.Error())
+			return
+		}
+
+		withdrawals, err := con.storageService.GetUserWithdrawals(req.Context(), account.Login, lookahead(list))
+		if err != nil {
+			con.Error(res, req, err)
+			return
+		}
+		con.auditAdmin(req, audit.ActionViewWithdrawals, account.Login, nil)
+
+		if len(withdrawals) == 0 {
+			con.Debug(res, "(AdminUserWithdrawals) No Content", http.StatusNoContent)
+			return
+		}
+		withdrawals = nextPage(res, req, list, withdrawals, withdrawalCursor)
+		con.writeAdminJSON(res, req, withdrawals)
+	}
+}
+
+// AdminOrderRecheck заново ставит заказ в очередь к Accrual (в том числе после исчерпания попыток)
+func (con *Controller) AdminOrderRecheck() http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		number := chi.URLParam(req, "number")
+		orderNumber, err := strconv.Atoi(number)
+		if err != nil || !models.IsValidOrderNumber(number) {
+			con.Problem(res, req, problem.InvalidOrderNumber, "")
+			return
+		}
+
+		// Событие аудита хранилище записывает вместе с задачей
+		err = con.storageService.RequeueAccrualTask(req.Context(), requestLogin(req), orderNumber)
+		if err != nil {
+			con.Error(res, req, err)
+			return
+		}
+		con.accrualQueue.Notify()
+
+		con.setUserIDCookie(res, requestSession(req))
+		res.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// AdminBalanceAdjust меняет текущий баланс пользователя на сумму со знаком; причина обязательна
+func (con *Controller) AdminBalanceAdjust() http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		var adjustment models.BalanceAdjustment
+		err := json.NewDecoder(req.Body).Decode(&adjustment)
+		adjustment.Reason = strings.TrimSpace(adjustment.Reason)
+		if err != nil || adjustment.Amount == 0 || adjustment.Reason == "" {
+			con.Problem(res, req, problem.BadRequest, "non-zero amount and reason are required")
+			return
+		}
+
+		account, ok := con.adminTarget(res, req)
+		if !ok {
+			return
+		}
+		adjustment.Login = account.Login
+		adjustment.Actor = requestLogin(req)
+		adjustment.CreatedAt = time.Now()
+
+		balance, err := con.storageService.AdjustBalance(req.Context(), adjustment)
+		if errors.Is(err, storage.ErrInsufficientFunds) {
+			con.Problem(res, req, problem.NegativeBalance, "")
+			return
+		}
+		if err != nil {
+			con.Error(res, req, err)
+			return
+		}
+		// Событие аудита хранилище записало вместе с корректировкой
+		con.writeAdminJSON(res, req, balance)
+	}
+}
+
+// AdminUserLock блокирует пользователя: его сессии завершаются, API-ключи и Bearer-токены перестают действовать
+func (con *Controller) AdminUserLock() http.HandlerFunc {
+	return con.setUserLocked(true)
+}
+
+func (con *Controller) AdminUserUnlock() http.HandlerFunc {
+	return con.setUserLocked(false)
+}
+
+func (con *Controller) setUserLocked(locked bool) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		account, ok := con.adminTarget(res, req)
+		if !ok {
+			return
+		}
+		if account.Login == requestLogin(req) {
+			con.Problem(res, req, problem.SelfAction, "cannot lock or unlock own account")
+			return
+		}
+
+		err := con.storageService.SetUserLocked(req.Context(), requestLogin(req), account.Login, locked)
+		if err != nil {
+			con.Error(res, req, err)
+			return
+		}
+
+		con.setUserIDCookie(res, requestSession(req))
+		if locked {
+			con.Debug(res, "Account locked", http.StatusOK)
+		} else {
+			con.Debug(res, "Account unlocked", http.StatusOK)
+		}
+	}
+}
+
+// AdminUserRole назначает роль. Свою роль администратор не меняет, чтобы не остаться без администраторов.
+func (con *Controller) AdminUserRole() http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		var body models.RoleChange
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil || !slices.Contains(models.Roles, body.Role) {
+			con.Problem(res, req, problem.BadRequest, "unknown role")
+			return
+		}
+
+		account, ok := con.adminTarget(res, req)
+		if !ok {
+			return
+		}
+		if account.Login == requestLogin(req) {
+			con.Problem(res, req, problem.SelfAction, "cannot change own role")
+			return
+		}
+
+		err := con.storageService.SetUserRole(req.Context(), requestLogin(req), account.Login, body.Role)
+		if err != nil {
+			con.Error(res, req, err)
+			return
+		}
+
+		account.Role = body.Role
+		con.writeAdminJSON(res, req, account)
+	}
+}
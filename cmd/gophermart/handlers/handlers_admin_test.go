@@ -0,0 +1,430 @@
+//go:build unit
+// +build unit
+
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"gophermart/cmd/gophermart/audit"
+	"gophermart/cmd/gophermart/mocks"
+	"gophermart/cmd/gophermart/models"
+	"gophermart/cmd/gophermart/storage"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ShiraazMoollatjie/goluhn"
+	"github.com/go-chi/chi/v5"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// serveAdmin - запрос сотрудника testUser к handler, смонтированному на pattern (для параметров пути).
+// Cookie сотрудника продлевается только на успешных ответах, поэтому SetUserIDCookie не обязателен.
+func serveAdmin(userSrv *mocks.MockUserService, handler http.HandlerFunc, method, pattern, target string, body io.Reader) *httptest.ResponseRecorder {
+	userSrv.EXPECT().SetUserIDCookie(gomock.Any(), "testUserID").Return(nil).AnyTimes()
+	r := chi.NewRouter()
+	r.Method(method, pattern, http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		handler(res, withSession(req, "testUserID"))
+	}))
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(method, target, body))
+	return w
+}
+
+func Test_RequireRole(t *testing.T) {
+	lockedAt := time.Now()
+
+	tests := []struct {
+		name           string
+		account        models.Account
+		err            error
+		expectedStatus int
+	}{
+		{name: "Support", account: models.Account{Login: "testUser", Role: models.RoleSupport}, expectedStatus: http.StatusOK},
+		{name: "Admin", account: models.Account{Login: "testUser", Role: models.RoleAdmin}, expectedStatus: http.StatusOK},
+		{name: "Regular user", account: models.Account{Login: "testUser", Role: models.RoleUser}, expectedStatus: http.StatusForbidden},
+		{name: "Locked admin", account: models.Account{Login: "testUser", Role: models.RoleAdmin, LockedAt: &lockedAt}, expectedStatus: http.StatusForbidden},
+		{name: "Unknown user", err: storage.ErrUserNotFound, expectedStatus: http.StatusForbidden},
+		{name: "Storage error", err: errors.New("connection refused"), expectedStatus: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorageService, _, _, _, controller := prepare(t)
+			mockStorageService.EXPECT().GetUser(gomock.Any(), "testUser").Return(tt.account, tt.err)
+			if tt.expectedStatus == http.StatusForbidden {
+				expectAudit(mockStorageService, audit.ActionAccessDenied, "")
+			}
+
+			called := false
+			handler := controller.RequireRole(models.RoleSupport, models.RoleAdmin)(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+				called = true
+			}))
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, withSession(httptest.NewRequest(http.MethodGet, "/api/admin/users", http.NoBody), "testUserID"))
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.Equal(t, tt.expectedStatus == http.StatusOK, called)
+		})
+	}
+}
+
+func Test_AdminUsers(t *testing.T) {
+	tests := []struct {
+		name           string
+		query          string
+		mockSetup      func(storage *mocks.MockStorageService)
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:  "Found",
+			query: "?q=test",
+			mockSetup: func(storage *mocks.MockStorageService) {
+				storage.EXPECT().SearchUsers(gomock.Any(), "test", adminSearchLimit).Return([]models.Account{
+					{Login: "otherUser", Role: models.RoleUser},
+					{Login: "testUser", Role: models.RoleAdmin},
+				}, nil)
+				expectAudit(storage, audit.ActionSearchUsers, "")
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody:   `[{"login": "otherUser", "role": "user"}, {"login": "testUser", "role": "admin"}]`,
+		},
+		{
+			name:  "Limit",
+			query: "?q=test&limit=1",
+			mockSetup: func(storage *mocks.MockStorageService) {
+				storage.EXPECT().SearchUsers(gomock.Any(), "test", 1).Return(nil, nil)
+				expectAudit(storage, audit.ActionSearchUsers, "")
+			},
+			expectedStatus: http.StatusNoContent,
+		},
+		{
+			name:  "Invalid limit",
+			query: "?limit=1000",
+			mockSetup: func(*mocks.MockStorageService) {
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorageService, _, mockUserService, _, controller := prepare(t)
+			tt.mockSetup(mockStorageService)
+
+			w := serveAdmin(mockUserService, controller.AdminUsers(), http.MethodGet, "/api/admin/users", "/api/admin/users"+tt.query, http.NoBody)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			if tt.expectedBody != "" {
+				assert.JSONEq(t, tt.expectedBody, w.Body.String())
+			}
+		})
+	}
+}
+
+func Test_AdminUserOrders(t *testing.T) {
+	errUserNotFound := storage.ErrUserNotFound
+	orders := []models.Order{{Number: "12345678903", Status: "PROCESSED", Accrual: models.NewMoney(500), UploadedAt: time.Now()}}
+
+	tests := []struct {
+		name           string
+		mockSetup      func(storage *mocks.MockStorageService)
+		expectedStatus int
+	}{
+		{
+			name: "Orders of a user",
+			mockSetup: func(storage *mocks.MockStorageService) {
+				storage.EXPECT().GetUser(gomock.Any(), "otherUser").Return(models.Account{Login: "otherUser", Role: models.RoleUser}, nil)
+				storage.EXPECT().GetOrders(gomock.Any(), "otherUser", models.ListQuery{}).Return(orders, nil)
+				expectAudit(storage, audit.ActionViewOrders, "otherUser")
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "No orders",
+			mockSetup: func(storage *mocks.MockStorageService) {
+				storage.EXPECT().GetUser(gomock.Any(), "otherUser").Return(models.Account{Login: "otherUser", Role: models.RoleUser}, nil)
+				storage.EXPECT().GetOrders(gomock.Any(), "otherUser", models.ListQuery{}).Return(nil, nil)
+				expectAudit(storage, audit.ActionViewOrders, "otherUser")
+			},
+			expectedStatus: http.StatusNoContent,
+		},
+		{
+			name: "Unknown user",
+			mockSetup: func(storage *mocks.MockStorageService) {
+				storage.EXPECT().GetUser(gomock.Any(), "otherUser").Return(models.Account{}, errUserNotFound)
+			},
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorageService, _, mockUserService, _, controller := prepare(t)
+			tt.mockSetup(mockStorageService)
+
+			w := serveAdmin(mockUserService, controller.AdminUserOrders(), http.MethodGet, "/api/admin/users/{login}/orders",
+				"/api/admin/users/otherUser/orders", http.NoBody)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+		})
+	}
+}
+
+func Test_AdminUserBalance(t *testing.T) {
+	mockStorageService, _, mockUserService, _, controller := prepare(t)
+	mockStorageService.EXPECT().GetUser(gomock.Any(), "otherUser").Return(models.Account{Login: "otherUser", Role: models.RoleUser}, nil)
+	mockStorageService.EXPECT().GetUserBalance(gomock.Any(), "otherUser").
+		Return(models.UserBalance{Current: models.NewMoney(500.5), Withdrawn: models.NewMoney(42)}, nil)
+	expectAudit(mockStorageService, audit.ActionViewBalance, "otherUser")
+
+	w := serveAdmin(mockUserService, controller.AdminUserBalance(), http.MethodGet, "/api/admin/users/{login}/balance",
+		"/api/admin/users/otherUser/balance", http.NoBody)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"current": 500.5, "withdrawn": 42}`, w.Body.String())
+}
+
+func Test_AdminOrderRecheck(t *testing.T) {
+	orderNumber := goluhn.Generate(10)
+	orderNumberInt, _ := strconv.Atoi(orderNumber)
+	errOrderNotFound := storage.ErrOrderNotFound
+
+	tests := []struct {
+		name           string
+		number         string
+		mockSetup      func(storage *mocks.MockStorageService)
+		expectedStatus int
+	}{
+		{
+			name:   "Requeued",
+			number: orderNumber,
+			mockSetup: func(storage *mocks.MockStorageService) {
+				storage.EXPECT().RequeueAccrualTask(gomock.Any(), "testUser", orderNumberInt).Return(nil)
+			},
+			expectedStatus: http.StatusAccepted,
+		},
+		{
+			name:   "Unknown order",
+			number: orderNumber,
+			mockSetup: func(storage *mocks.MockStorageService) {
+				storage.EXPECT().RequeueAccrualTask(gomock.Any(), "testUser", orderNumberInt).Return(errOrderNotFound)
+			},
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:   "Invalid number",
+			number: "12345678",
+			mockSetup: func(*mocks.MockStorageService) {
+			},
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorageService, _, mockUserService, _, controller := prepare(t)
+			tt.mockSetup(mockStorageService)
+
+			w := serveAdmin(mockUserService, controller.AdminOrderRecheck(), http.MethodPost, "/api/admin/orders/{number}/recheck",
+				"/api/admin/orders/"+tt.number+"/recheck", http.NoBody)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+		})
+	}
+}
+
+func Test_AdminBalanceAdjust(t *testing.T) {
+	errInsufficientFunds := storage.ErrInsufficientFunds
+	errUserNotFound := storage.ErrUserNotFound
+	otherUser := models.Account{Login: "otherUser", Role: models.RoleUser}
+
+	tests := []struct {
+		name           string
+		body           string
+		mockSetup      func(storage *mocks.MockStorageService)
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name: "Credit",
+			body: `{"amount": 100.5, "reason": " goodwill for ticket 42 "}`,
+			mockSetup: func(storage *mocks.MockStorageService) {
+				storage.EXPECT().GetUser(gomock.Any(), "otherUser").Return(otherUser, nil)
+				storage.EXPECT().AdjustBalance(gomock.Any(), gomock.Any()).DoAndReturn(
+					func(_ interface{}, adjustment models.BalanceAdjustment) (models.UserBalance, error) {
+						assert.Equal(t, "otherUser", adjustment.Login)
+						assert.Equal(t, models.NewMoney(100.5), adjustment.Amount)
+						assert.Equal(t, "goodwill for ticket 42", adjustment.Reason)
+						assert.Equal(t, "testUser", adjustment.Actor, "the admin is recorded as the author")
+						return models.UserBalance{Current: models.NewMoney(600.5)}, nil
+					})
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"current": 600.5, "withdrawn": 0}`,
+		},
+		{
+			name: "Debit below zero",
+			body: `{"amount": -1000, "reason": "fraud"}`,
+			mockSetup: func(storage *mocks.MockStorageService) {
+				storage.EXPECT().GetUser(gomock.Any(), "otherUser").Return(otherUser, nil)
+				storage.EXPECT().AdjustBalance(gomock.Any(), gomock.Any()).Return(models.UserBalance{}, errInsufficientFunds)
+			},
+			expectedStatus: http.StatusConflict,
+		},
+		{
+			name: "Missing reason",
+			body: `{"amount": 100, "reason": "  "}`,
+			mockSetup: func(*mocks.MockStorageService) {
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "Zero amount",
+			body: `{"amount": 0, "reason": "test"}`,
+			mockSetup: func(*mocks.MockStorageService) {
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name: "Unknown user",
+			body: `{"amount": 100, "reason": "test"}`,
+			mockSetup: func(storage *mocks.MockStorageService) {
+				storage.EXPECT().GetUser(gomock.Any(), "otherUser").Return(models.Account{}, errUserNotFound)
+			},
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorageService, _, mockUserService, _, controller := prepare(t)
+			tt.mockSetup(mockStorageService)
+
+			w := serveAdmin(mockUserService, controller.AdminBalanceAdjust(), http.MethodPost, "/api/admin/users/{login}/balance/adjustments",
+				"/api/admin/users/otherUser/balance/adjustments", bytes.NewReader([]byte(tt.body)))
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			if tt.expectedBody != "" {
+				assert.JSONEq(t, tt.expectedBody, w.Body.String())
+			}
+		})
+	}
+}
+
+func Test_AdminUserLock(t *testing.T) {
+	tests := []struct {
+		name           string
+		target         string
+		lock           bool
+		mockSetup      func(storage *mocks.MockStorageService)
+		expectedStatus int
+	}{
+		{
+			name:   "Lock",
+			target: "otherUser",
+			lock:   true,
+			mockSetup: func(storage *mocks.MockStorageService) {
+				storage.EXPECT().GetUser(gomock.Any(), "otherUser").Return(models.Account{Login: "otherUser", Role: models.RoleUser}, nil)
+				storage.EXPECT().SetUserLocked(gomock.Any(), "testUser", "otherUser", true).Return(nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:   "Unlock",
+			target: "otherUser",
+			mockSetup: func(storage *mocks.MockStorageService) {
+				storage.EXPECT().GetUser(gomock.Any(), "otherUser").Return(models.Account{Login: "otherUser", Role: models.RoleUser}, nil)
+				storage.EXPECT().SetUserLocked(gomock.Any(), "testUser", "otherUser", false).Return(nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:   "Own account",
+			target: "testUser",
+			lock:   true,
+			mockSetup: func(storage *mocks.MockStorageService) {
+				storage.EXPECT().GetUser(gomock.Any(), "testUser").Return(models.Account{Login: "testUser", Role: models.RoleAdmin}, nil)
+			},
+			expectedStatus: http.StatusConflict,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorageService, _, mockUserService, _, controller := prepare(t)
+			tt.mockSetup(mockStorageService)
+
+			handler, method := controller.AdminUserUnlock(), http.MethodDelete
+			if tt.lock {
+				handler, method = controller.AdminUserLock(), http.MethodPost
+			}
+			w := serveAdmin(mockUserService, handler, method, "/api/admin/users/{login}/lock", "/api/admin/users/"+tt.target+"/lock", http.NoBody)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+		})
+	}
+}
+
+func Test_AdminUserRole(t *testing.T) {
+	tests := []struct {
+		name           string
+		target         string
+		role           string
+		mockSetup      func(storage *mocks.MockStorageService)
+		expectedStatus int
+	}{
+		{
+			name:   "Grant support",
+			target: "otherUser",
+			role:   models.RoleSupport,
+			mockSetup: func(storage *mocks.MockStorageService) {
+				storage.EXPECT().GetUser(gomock.Any(), "otherUser").Return(models.Account{Login: "otherUser", Role: models.RoleUser}, nil)
+				storage.EXPECT().SetUserRole(gomock.Any(), "testUser", "otherUser", models.RoleSupport).Return(nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:   "Unknown role",
+			target: "otherUser",
+			role:   "root",
+			mockSetup: func(*mocks.MockStorageService) {
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:   "Own role",
+			target: "testUser",
+			role:   models.RoleUser,
+			mockSetup: func(storage *mocks.MockStorageService) {
+				storage.EXPECT().GetUser(gomock.Any(), "testUser").Return(models.Account{Login: "testUser", Role: models.RoleAdmin}, nil)
+			},
+			expectedStatus: http.StatusConflict,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorageService, _, mockUserService, _, controller := prepare(t)
+			tt.mockSetup(mockStorageService)
+
+			w := serveAdmin(mockUserService, controller.AdminUserRole(), http.MethodPut, "/api/admin/users/{login}/role",
+				"/api/admin/users/"+tt.target+"/role", jsonBody(models.RoleChange{Role: tt.role}))
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			if w.Code == http.StatusOK {
+				var account models.Account
+				require.NoError(t, json.NewDecoder(w.Body).Decode(&account))
+				assert.Equal(t, tt.role, account.Role)
+			}
+		})
+	}
+}
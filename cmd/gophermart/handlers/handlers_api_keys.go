@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"gophermart/cmd/gophermart/models"
+	"gophermart/cmd/gophermart/problem"
+	"gophermart/cmd/gophermart/storage"
+	"gophermart/cmd/gophermart/user"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// apiKeyTouchInterval - last_used_at обновляется не чаще, чтобы запросы по ключу не писали в БД каждый раз
+const apiKeyTouchInterval = time.Minute
+
+// checkAPIKey находит ключ по id и сверяет секрет с хешем. При ошибке ответ уже отправлен.
+func (con *Controller) checkAPIKey(res http.ResponseWriter, req *http.Request, apiKey string) (*models.APIKey, bool) {
+	id, secret, ok := user.ParseAPIKey(apiKey)
+	if !ok {
+		con.Problem(res, req, problem.InvalidAPIKey, "malformed API key")
+		return nil, false
+	}
+
+	key, err := con.storageService.GetAPIKey(req.Context(), id)
+	if errors.Is(err, storage.ErrAPIKeyNotFound) {
+		con.Problem(res, req, problem.InvalidAPIKey, "unknown or expired API key")
+		return nil, false
+	}
+	if err != nil {
+		con.Error(res, req, err)
+		return nil, false
+	}
+	if !user.CheckAPIKeySecret(secret, key.Hash) {
+		con.Problem(res, req, problem.InvalidAPIKey, "")
+		return nil, false
+	}
+
+	if key.LastUsedAt == nil || time.Since(*key.LastUsedAt) >= apiKeyTouchInterval {
+		if err := con.storageService.TouchAPIKey(req.Context(), id); err != nil {
+			con.sugar.Errorf("(AuthenticateMiddleware) Failed to update API key last use: %s", err)
+		}
+	}
+	return key, true
+}
+
+// APIKeyCreate выпускает API-ключ. Ключ возвращается один раз, хранится только его хеш.
+func (con *Controller) APIKeyCreate() http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		sessionID := requestSession(req)
+		userLogin := requestLogin(req)
+		if userLogin == "" {
+			con.Problem(res, req, problem.Unauthorized, "")
+			return
+		}
+
+		var body models.APIKeyCreate
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil || len(body.Scopes) == 0 {
+			con.Problem(res, req, problem.BadRequest, "")
+			return
+		}
+		for _, scope := range body.Scopes {
+			if !slices.Contains(models.APIScopes, scope) {
+				con.Problem(res, req, problem.BadRequest, "unknown scope "+scope)
+				return
+			}
+		}
+		now := time.Now()
+		if body.ExpiresAt != nil && !body.ExpiresAt.After(now) {
+			con.Problem(res, req, problem.BadRequest, "expires_at is in the past")
+			return
+		}
+		if body.Merchant && !con.conf.IsMerchant(userLogin) {
+			con.Problem(res, req, problem.Forbidden, "merchant keys are not allowed for this user")
+			return
+		}
+
+		id, secret, apiKey, err := user.GenerateAPIKey()
+		if err != nil {
+			con.Error(res, req, err)
+			return
+		}
+		scopes := slices.Clone(body.Scopes)
+		slices.Sort(scopes)
+
+		key := models.APIKey{
+			ID:        id,
+			Login:     userLogin,
+			Name:      body.Name,
+			Merchant:  body.Merchant,
+			Scopes:    slices.Compact(scopes),
+			Hash:      user.HashAPIKeySecret(secret),
+			CreatedAt: now,
+			ExpiresAt: body.ExpiresAt,
+		}
+		if err := con.storageService.CreateAPIKey(req.Context(), key); err != nil {
+			con.Error(res, req, err)
+			return
+		}
+
+		res.Header().Set("Content-Type", "application/json")
+		con.setUserIDCookie(res, sessionID)
+		res.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(res).Encode(models.APIKeyCreated{APIKey: key, Key: apiKey})
+	}
+}
+
+// APIKeys - ключи пользователя (без секретов)
+func (con *Controller) APIKeys() http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		sessionID := requestSession(req)
+		userLogin := requestLogin(req)
+		if userLogin == "" {
+			con.Problem(res, req, problem.Unauthorized, "")
+			return
+		}
+
+		keys, err := con.storageService.GetAPIKeys(req.Context(), userLogin)
+		if err != nil {
+			con.Error(res, req, err)
+			return
+		}
+		if len(keys) == 0 {
+			con.Debug(res, "(APIKeys) No Content", http.StatusNoContent)
+			return
+		}
+
+		res.Header().Set("Content-Type", "application/json")
+		con.setUserIDCookie(res, sessionID)
+		res.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(res).Encode(keys)
+	}
+}
+
+// APIKeyDelete отзывает ключ пользователя
+func (con *Controller) APIKeyDelete() http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		sessionID := requestSession(req)
+		userLogin := requestLogin(req)
+		if userLogin == "" {
+			con.Problem(res, req, problem.Unauthorized, "")
+			return
+		}
+
+		err := con.storageService.DeleteAPIKey(req.Context(), userLogin, chi.URLParam(req, "id"))
+		if err != nil {
+			con.Error(res, req, err)
+			return
+		}
+
+		con.setUserIDCookie(res, sessionID)
+		con.Debug(res, "API key deleted", http.StatusOK)
+	}
+}
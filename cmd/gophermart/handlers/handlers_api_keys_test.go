@@ -0,0 +1,456 @@
+//go:build unit
+// +build unit
+
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"gophermart/cmd/gophermart/audit"
+	"gophermart/cmd/gophermart/mocks"
+	"gophermart/cmd/gophermart/models"
+	"gophermart/cmd/gophermart/storage"
+	"gophermart/cmd/gophermart/user"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ShiraazMoollatjie/goluhn"
+	"github.com/go-chi/chi/v5"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testAPIKey = "gm_0123456789abcdef_secret"
+
+func Test_AuthenticateWithAPIKey(t *testing.T) {
+	errAPIKeyNotFound := storage.ErrAPIKeyNotFound
+	ordersKey := &models.APIKey{ID: "0123456789abcdef", Login: "testUser", Scopes: []string{models.ScopeOrdersRead}, Hash: user.HashAPIKeySecret("secret")}
+	recentlyUsed := time.Now().Add(-time.Second)
+	recentKey := *ordersKey
+	recentKey.LastUsedAt = &recentlyUsed
+
+	tests := []struct {
+		name           string
+		apiKey         string
+		scope          string // "" - маршрут под AuthenticateMiddleware
+		mockSetup      func(storage *mocks.MockStorageService, storageUtils *mocks.MockStorageUtils)
+		expectedStatus int
+		expectedLogin  string
+	}{
+		{
+			name:   "Valid key with scope",
+			apiKey: testAPIKey,
+			scope:  models.ScopeOrdersRead,
+			mockSetup: func(storage *mocks.MockStorageService, _ *mocks.MockStorageUtils) {
+				storage.EXPECT().GetAPIKey(gomock.Any(), "0123456789abcdef").Return(ordersKey, nil)
+				storage.EXPECT().TouchAPIKey(gomock.Any(), "0123456789abcdef").Return(nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedLogin:  "testUser",
+		},
+		{
+			name:   "Recently used key is not touched",
+			apiKey: testAPIKey,
+			scope:  models.ScopeOrdersRead,
+			mockSetup: func(storage *mocks.MockStorageService, _ *mocks.MockStorageUtils) {
+				storage.EXPECT().GetAPIKey(gomock.Any(), "0123456789abcdef").Return(&recentKey, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedLogin:  "testUser",
+		},
+		{
+			name:   "Missing scope",
+			apiKey: testAPIKey,
+			scope:  models.ScopeBalanceWithdraw,
+			mockSetup: func(storage *mocks.MockStorageService, _ *mocks.MockStorageUtils) {
+				storage.EXPECT().GetAPIKey(gomock.Any(), "0123456789abcdef").Return(ordersKey, nil)
+				storage.EXPECT().TouchAPIKey(gomock.Any(), "0123456789abcdef").Return(nil)
+			},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:   "Route without API key access",
+			apiKey: testAPIKey,
+			mockSetup: func(*mocks.MockStorageService, *mocks.MockStorageUtils) {
+			},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:   "Invalid secret",
+			apiKey: "gm_0123456789abcdef_wrong",
+			scope:  models.ScopeOrdersRead,
+			mockSetup: func(storage *mocks.MockStorageService, _ *mocks.MockStorageUtils) {
+				storage.EXPECT().GetAPIKey(gomock.Any(), "0123456789abcdef").Return(ordersKey, nil)
+			},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:   "Unknown or expired key",
+			apiKey: testAPIKey,
+			scope:  models.ScopeOrdersRead,
+			mockSetup: func(storage *mocks.MockStorageService, _ *mocks.MockStorageUtils) {
+				storage.EXPECT().GetAPIKey(gomock.Any(), "0123456789abcdef").Return(nil, errAPIKeyNotFound)
+			},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:   "Malformed key",
+			apiKey: "secret",
+			scope:  models.ScopeOrdersRead,
+			mockSetup: func(*mocks.MockStorageService, *mocks.MockStorageUtils) {
+			},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:   "Storage error",
+			apiKey: testAPIKey,
+			scope:  models.ScopeOrdersRead,
+			mockSetup: func(storage *mocks.MockStorageService, _ *mocks.MockStorageUtils) {
+				storage.EXPECT().GetAPIKey(gomock.Any(), "0123456789abcdef").Return(nil, errors.New("connection refused"))
+			},
+			expectedStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorageService, mockStorageUtils, _, _, controller := prepare(t)
+			tt.mockSetup(mockStorageService, mockStorageUtils)
+
+			var login, sessionID string
+			next := http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+				login, sessionID = requestLogin(req), requestSession(req)
+			})
+			handler := controller.AuthenticateMiddleware(next)
+			if tt.scope != "" {
+				handler = controller.AuthenticateWithAPIKey(tt.scope)(next)
+			}
+
+			req := httptest.NewRequest(http.MethodGet, "/api/user/orders", http.NoBody)
+			req.Header.Set(APIKeyHeader, tt.apiKey)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.Equal(t, tt.expectedLogin, login)
+			assert.Empty(t, sessionID, "API key requests have no session")
+		})
+	}
+}
+
+func Test_APIKeyCreate(t *testing.T) {
+	past := time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		name           string
+		userID         string
+		merchants      []string
+		body           models.APIKeyCreate
+		mockSetup      func(storage *mocks.MockStorageService, storageUtils *mocks.MockStorageUtils, userSrv *mocks.MockUserService)
+		expectedStatus int
+	}{
+		{
+			name:   "Created",
+			userID: "testUserID",
+			body:   models.APIKeyCreate{Name: "crm", Scopes: []string{models.ScopeOrdersRead, models.ScopeBalanceRead, models.ScopeOrdersRead}},
+			mockSetup: func(storage *mocks.MockStorageService, _ *mocks.MockStorageUtils, userSrv *mocks.MockUserService) {
+				storage.EXPECT().CreateAPIKey(gomock.Any(), gomock.Any()).DoAndReturn(func(_ context.Context, key models.APIKey) error {
+					assert.Equal(t, "testUser", key.Login)
+					assert.Regexp(t, `^[0-9a-f]{64}$`, key.Hash, "SHA-256 of the secret")
+					assert.Equal(t, []string{models.ScopeBalanceRead, models.ScopeOrdersRead}, key.Scopes)
+					return nil
+				})
+				userSrv.EXPECT().SetUserIDCookie(gomock.Any(), "testUserID").Return(nil)
+			},
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name:      "Merchant key",
+			userID:    "testUserID",
+			merchants: []string{"testUser"},
+			body:      models.APIKeyCreate{Name: "shop", Scopes: []string{models.ScopeOrdersWrite}, Merchant: true},
+			mockSetup: func(storage *mocks.MockStorageService, _ *mocks.MockStorageUtils, userSrv *mocks.MockUserService) {
+				storage.EXPECT().CreateAPIKey(gomock.Any(), gomock.Any()).Return(nil)
+				userSrv.EXPECT().SetUserIDCookie(gomock.Any(), "testUserID").Return(nil)
+			},
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name:   "Merchant key for a regular user",
+			userID: "testUserID",
+			body:   models.APIKeyCreate{Name: "shop", Scopes: []string{models.ScopeOrdersWrite}, Merchant: true},
+			mockSetup: func(*mocks.MockStorageService, *mocks.MockStorageUtils, *mocks.MockUserService) {
+			},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:   "Unknown scope",
+			userID: "testUserID",
+			body:   models.APIKeyCreate{Name: "crm", Scopes: []string{"admin"}},
+			mockSetup: func(*mocks.MockStorageService, *mocks.MockStorageUtils, *mocks.MockUserService) {
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:   "No scopes",
+			userID: "testUserID",
+			body:   models.APIKeyCreate{Name: "crm"},
+			mockSetup: func(*mocks.MockStorageService, *mocks.MockStorageUtils, *mocks.MockUserService) {
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:   "Expired",
+			userID: "testUserID",
+			body:   models.APIKeyCreate{Name: "crm", Scopes: []string{models.ScopeOrdersRead}, ExpiresAt: &past},
+			mockSetup: func(*mocks.MockStorageService, *mocks.MockStorageUtils, *mocks.MockUserService) {
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:   "Unauthorized",
+			userID: "unknownUserID",
+			body:   models.APIKeyCreate{Name: "crm", Scopes: []string{models.ScopeOrdersRead}},
+			mockSetup: func(*mocks.MockStorageService, *mocks.MockStorageUtils, *mocks.MockUserService) {
+			},
+			expectedStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorageService, mockStorageUtils, mockUserService, _, controller := prepare(t)
+			controller.conf.MerchantLogins = tt.merchants
+			tt.mockSetup(mockStorageService, mockStorageUtils, mockUserService)
+
+			req := withSession(httptest.NewRequest(http.MethodPost, "/api/user/api-keys", jsonBody(tt.body)), tt.userID)
+			w := httptest.NewRecorder()
+
+			controller.APIKeyCreate()(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			if w.Code == http.StatusCreated {
+				var created models.APIKeyCreated
+				require.NoError(t, json.NewDecoder(w.Body).Decode(&created))
+				assert.Regexp(t, "^gm_"+created.ID+"_", created.Key)
+				assert.Equal(t, tt.body.Merchant, created.Merchant)
+			}
+		})
+	}
+}
+
+func Test_APIKeys(t *testing.T) {
+	createdAt := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name           string
+		userID         string
+		mockSetup      func(storage *mocks.MockStorageService, userSrv *mocks.MockUserService)
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:   "Successful Getting API Keys",
+			userID: "testUserID",
+			mockSetup: func(storage *mocks.MockStorageService, userSrv *mocks.MockUserService) {
+				storage.EXPECT().GetAPIKeys(gomock.Any(), "testUser").Return([]models.APIKey{
+					{ID: "0123456789abcdef", Login: "testUser", Name: "crm", Scopes: []string{models.ScopeOrdersRead},
+						Hash: "hashedSecret", CreatedAt: createdAt},
+				}, nil)
+				userSrv.EXPECT().SetUserIDCookie(gomock.Any(), "testUserID").Return(nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody: `[
+				{"id": "0123456789abcdef", "name": "crm", "scopes": ["orders:read"], "created_at": "2024-01-01T12:00:00Z"}
+			]`,
+		},
+		{
+			name:   "No Content",
+			userID: "testUserID",
+			mockSetup: func(storage *mocks.MockStorageService, _ *mocks.MockUserService) {
+				storage.EXPECT().GetAPIKeys(gomock.Any(), "testUser").Return(nil, nil)
+			},
+			expectedStatus: http.StatusNoContent,
+		},
+		{
+			name:   "Unauthorized",
+			userID: "unknownUserID",
+			mockSetup: func(*mocks.MockStorageService, *mocks.MockUserService) {
+			},
+			expectedStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorageService, _, mockUserService, _, controller := prepare(t)
+			tt.mockSetup(mockStorageService, mockUserService)
+
+			req := withSession(httptest.NewRequest(http.MethodGet, "/api/user/api-keys", http.NoBody), tt.userID)
+			w := httptest.NewRecorder()
+
+			controller.APIKeys()(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			if tt.expectedBody != "" {
+				assert.JSONEq(t, tt.expectedBody, w.Body.String(), "hash and owner are not exposed")
+			}
+		})
+	}
+}
+
+func Test_APIKeyDelete(t *testing.T) {
+	errAPIKeyNotFound := storage.ErrAPIKeyNotFound
+
+	tests := []struct {
+		name           string
+		userID         string
+		mockSetup      func(storage *mocks.MockStorageService, userSrv *mocks.MockUserService)
+		expectedStatus int
+	}{
+		{
+			name:   "Deleted",
+			userID: "testUserID",
+			mockSetup: func(storage *mocks.MockStorageService, userSrv *mocks.MockUserService) {
+				storage.EXPECT().DeleteAPIKey(gomock.Any(), "testUser", "0123456789abcdef").Return(nil)
+				userSrv.EXPECT().SetUserIDCookie(gomock.Any(), "testUserID").Return(nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:   "Key of another user",
+			userID: "testUserID",
+			mockSetup: func(storage *mocks.MockStorageService, _ *mocks.MockUserService) {
+				storage.EXPECT().DeleteAPIKey(gomock.Any(), "testUser", "0123456789abcdef").Return(errAPIKeyNotFound)
+			},
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:   "Unauthorized",
+			userID: "unknownUserID",
+			mockSetup: func(*mocks.MockStorageService, *mocks.MockUserService) {
+			},
+			expectedStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorageService, _, mockUserService, _, controller := prepare(t)
+			tt.mockSetup(mockStorageService, mockUserService)
+
+			r := chi.NewRouter()
+			r.Delete("/api/user/api-keys/{id}", func(res http.ResponseWriter, req *http.Request) {
+				controller.APIKeyDelete()(res, withSession(req, tt.userID))
+			})
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodDelete, "/api/user/api-keys/0123456789abcdef", http.NoBody))
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+		})
+	}
+}
+
+func Test_OrdersUpload_Merchant(t *testing.T) {
+	errUserNotFound := storage.ErrUserNotFound
+	orderNumber := goluhn.Generate(10)
+	merchantKey := &models.APIKey{ID: "0123456789abcdef", Login: "shop", Scopes: []string{models.ScopeOrdersWrite}, Merchant: true}
+	regularKey := &models.APIKey{ID: "0123456789abcdef", Login: "shop", Scopes: []string{models.ScopeOrdersWrite}}
+
+	tests := []struct {
+		name           string
+		key            *models.APIKey
+		userID         string
+		merchants      []string // MERCHANT_LOGINS, по умолчанию - владелец ключа
+		mockSetup      func(storage *mocks.MockStorageService)
+		expectedStatus int
+	}{
+		{
+			name: "Merchant uploads order of a customer",
+			key:  merchantKey,
+			mockSetup: func(storage *mocks.MockStorageService) {
+				storage.EXPECT().GetUser(gomock.Any(), "customer").Return(models.Account{Login: "customer", Role: models.RoleUser}, nil)
+				storage.EXPECT().AddOrder(gomock.Any(), "customer", gomock.Any()).Return(true, nil)
+				// Автор - магазин, цель - покупатель
+				storage.EXPECT().AddAuditEvent(gomock.Any(), auditEvent{audit.ActionOrderUploaded, "customer"}).DoAndReturn(
+					func(_ interface{}, event models.AuditEvent) error {
+						assert.Equal(t, "shop", event.Actor)
+						// goluhn может дать номер с ведущим нулём, в журнал пишется число
+						number, _ := strconv.Atoi(orderNumber)
+						assert.JSONEq(t, `{"order": "`+strconv.Itoa(number)+`", "api_key": "0123456789abcdef"}`, string(event.Payload))
+						return nil
+					})
+			},
+			expectedStatus: http.StatusAccepted,
+		},
+		{
+			name: "Unknown customer",
+			key:  merchantKey,
+			mockSetup: func(storage *mocks.MockStorageService) {
+				storage.EXPECT().GetUser(gomock.Any(), "customer").Return(models.Account{}, errUserNotFound)
+			},
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name: "Storage error",
+			key:  merchantKey,
+			mockSetup: func(storage *mocks.MockStorageService) {
+				storage.EXPECT().GetUser(gomock.Any(), "customer").Return(models.Account{}, errors.New("connection refused"))
+			},
+			expectedStatus: http.StatusInternalServerError,
+		},
+		{
+			name:      "Owner is no longer a merchant",
+			key:       merchantKey,
+			merchants: []string{"otherShop"},
+			mockSetup: func(*mocks.MockStorageService) {
+			},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name: "Regular API key",
+			key:  regularKey,
+			mockSetup: func(*mocks.MockStorageService) {
+			},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:   "Session",
+			userID: "testUserID",
+			mockSetup: func(*mocks.MockStorageService) {
+			},
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorageService, _, _, _, controller := prepare(t)
+			tt.mockSetup(mockStorageService)
+			controller.conf.MerchantLogins = []string{"shop"}
+			if tt.merchants != nil {
+				controller.conf.MerchantLogins = tt.merchants
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/api/user/orders?login=customer", bytes.NewReader([]byte(orderNumber)))
+			req.Header.Set("Content-Type", "text/plain")
+			if tt.key != nil {
+				ctx := context.WithValue(req.Context(), loginCtxKey, tt.key.Login)
+				req = req.WithContext(context.WithValue(ctx, apiKeyCtxKey, tt.key))
+			} else {
+				req = withSession(req, tt.userID)
+			}
+			w := httptest.NewRecorder()
+
+			controller.OrdersUpload()(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+		})
+	}
+}
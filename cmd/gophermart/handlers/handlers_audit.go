@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"gophermart/cmd/gophermart/audit"
+	"gophermart/cmd/gophermart/models"
+	"gophermart/cmd/gophermart/problem"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// Сколько событий возвращает GET /api/admin/audit без ?limit= и максимум;
+// auditVerifyBatch - по сколько записей читается журнал при проверке цепочки
+const (
+	auditLimit       = 100
+	auditMaxLimit    = 1000
+	auditVerifyBatch = 1000
+)
+
+// AuditMiddleware кладёт в контекст ID запроса (его выдаёт middleware.RequestID) и IP клиента:
+// их получают события аудита, в том числе записанные хранилищем. ID возвращается в X-Request-Id.
+func (con *Controller) AuditMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		requestID := middleware.GetReqID(req.Context())
+		if requestID != "" {
+			res.Header().Set(middleware.RequestIDHeader, requestID)
+		}
+		ctx := audit.WithMeta(req.Context(), audit.Meta{RequestID: requestID, IP: clientIP(req)})
+		next.ServeHTTP(res, req.WithContext(ctx))
+	})
+}
+
+// writeAudit пишет событие в журнал аудита. Ошибка записи не прерывает запрос;
+// события о деньгах и изменения, сделанные сотрудниками, хранилище пишет в той же транзакции, что и сами операции.
+func (con *Controller) writeAudit(req *http.Request, actor, action, target string, payload map[string]any) {
+	event := audit.New(req.Context(), actor, action, target, payload)
+	if event.IP == "" {
+		event.IP = clientIP(req)
+	}
+	if err := con.storageService.AddAuditEvent(req.Context(), event); err != nil {
+		con.sugar.Errorw("Failed to write audit event", "action", action, "actor", actor, "error", err)
+	}
+}
+
+// AdminAudit - журнал аудита от новых событий к старым:
+// GET /api/admin/audit?actor=&action=&target=&request_id=&from=&to=&before=&limit=
+// from и to - в RFC 3339, before - ID последнего события предыдущей страницы.
+func (con *Controller) AdminAudit() http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		query := req.URL.Query()
+		filter := models.AuditFilter{
+			Actor:     query.Get("actor"),
+			Action:    query.Get("action"),
+			Target:    query.Get("target"),
+			RequestID: query.Get("request_id"),
+			Limit:     auditLimit,
+		}
+
+		var err error
+		if s := query.Get("from"); s != "" {
+			if filter.From, err = time.Parse(time.RFC3339, s); err != nil {
+				con.Problem(res, req, problem.InvalidParameter, "invalid from")
+				return
+			}
+		}
+		if s := query.Get("to"); s != "" {
+			if filter.To, err = time.Parse(time.RFC3339, s); err != nil {
+				con.Problem(res, req, problem.InvalidParameter, "invalid to")
+				return
+			}
+		}
+		if s := query.Get("before"); s != "" {
+			if filter.BeforeID, err = strconv.ParseInt(s, 10, 64); err != nil || filter.BeforeID <= 0 {
+				con.Problem(res, req, problem.InvalidParameter, "invalid before")
+				return
+			}
+		}
+		if s := query.Get("limit"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n <= 0 || n > auditMaxLimit {
+				con.Problem(res, req, problem.InvalidParameter, "invalid limit")
+				return
+			}
+			filter.Limit = n
+		}
+
+		events, err := con.storageService.GetAuditEvents(req.Context(), filter)
+		if err != nil {
+			con.Error(res, req, err)
+			return
+		}
+
+		if len(events) == 0 {
+			con.Debug(res, "(AdminAudit) No Content", http.StatusNoContent)
+			return
+		}
+		con.writeAdminJSON(res, req, events)
+	}
+}
+
+// AdminAuditVerify проверяет цепочку хешей всего журнала: GET /api/admin/audit/verify
+func (con *Controller) AdminAuditVerify() http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		var verifier audit.Verifier
+		var afterID int64
+		for {
+			events, err := con.storageService.GetAuditChain(req.Context(), afterID, auditVerifyBatch)
+			if err != nil {
+				con.Error(res, req, err)
+				return
+			}
+			ok := true
+			for _, event := range events {
+				if ok = verifier.Next(event); !ok {
+					break
+				}
+				afterID = event.ID
+			}
+			if !ok || len(events) < auditVerifyBatch {
+				break
+			}
+		}
+
+		result := verifier.Result()
+		if !result.Valid {
+			con.sugar.Errorw("Audit log hash chain is broken", "broken_at", result.BrokenAt)
+		}
+		con.writeAdminJSON(res, req, result)
+	}
+}
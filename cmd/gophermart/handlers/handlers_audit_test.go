@@ -0,0 +1,175 @@
+//go:build unit
+// +build unit
+
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gophermart/cmd/gophermart/audit"
+	"gophermart/cmd/gophermart/mocks"
+	"gophermart/cmd/gophermart/models"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// auditEvent сопоставляет событие аудита по действию и пользователю, которого оно касается
+type auditEvent struct {
+	action string
+	target string
+}
+
+func (m auditEvent) Matches(x interface{}) bool {
+	event, ok := x.(models.AuditEvent)
+	return ok && event.Action == m.action && event.Target == m.target
+}
+
+func (m auditEvent) String() string {
+	return fmt.Sprintf("audit event %s for %q", m.action, m.target)
+}
+
+func expectAudit(storage *mocks.MockStorageService, action, target string) {
+	storage.EXPECT().AddAuditEvent(gomock.Any(), auditEvent{action, target}).Return(nil)
+}
+
+func Test_AuditMiddleware(t *testing.T) {
+	mockStorageService, _, _, _, controller := prepare(t)
+	mockStorageService.EXPECT().AddAuditEvent(gomock.Any(), auditEvent{audit.ActionUserRegistered, "testUser"}).DoAndReturn(
+		func(_ context.Context, event models.AuditEvent) error {
+			assert.Equal(t, "req-42", event.RequestID)
+			assert.Equal(t, "192.0.2.10", event.IP)
+			return nil
+		})
+
+	handler := middleware.RequestID(controller.AuditMiddleware(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		controller.writeAudit(req, "testUser", audit.ActionUserRegistered, "testUser", nil)
+	})))
+	req := httptest.NewRequest(http.MethodPost, "/api/user/register", http.NoBody)
+	req.RemoteAddr = "192.0.2.10:1234"
+	req.Header.Set(middleware.RequestIDHeader, "req-42")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	assert.Equal(t, "req-42", w.Header().Get(middleware.RequestIDHeader))
+}
+
+func Test_WriteAudit_StorageError(t *testing.T) {
+	mockStorageService, _, _, _, controller := prepare(t)
+	mockStorageService.EXPECT().AddAuditEvent(gomock.Any(), gomock.Any()).Return(errors.New("connection refused"))
+
+	// Ошибка журнала не ломает запрос
+	controller.writeAudit(httptest.NewRequest(http.MethodPost, "/", http.NoBody), "testUser", audit.ActionLoginSucceeded, "testUser", nil)
+}
+
+func Test_AdminAudit(t *testing.T) {
+	events := []models.AuditEvent{
+		{ID: 2, Actor: "admin", Action: audit.ActionAdjustment, Target: "testUser", Payload: json.RawMessage(`{"amount":5}`)},
+		{ID: 1, Actor: "testUser", Action: audit.ActionUserRegistered, Target: "testUser"},
+	}
+	from := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name           string
+		query          string
+		mockSetup      func(storage *mocks.MockStorageService)
+		expectedStatus int
+	}{
+		{
+			name:  "Filters",
+			query: "?actor=admin&action=balance_adjusted&target=testUser&request_id=req-1&from=2026-10-01T00:00:00Z&before=10&limit=2",
+			mockSetup: func(storage *mocks.MockStorageService) {
+				storage.EXPECT().GetAuditEvents(gomock.Any(), models.AuditFilter{
+					Actor: "admin", Action: audit.ActionAdjustment, Target: "testUser", RequestID: "req-1",
+					From: from, BeforeID: 10, Limit: 2,
+				}).Return(events, nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "Defaults",
+			mockSetup: func(storage *mocks.MockStorageService) {
+				storage.EXPECT().GetAuditEvents(gomock.Any(), models.AuditFilter{Limit: auditLimit}).Return(nil, nil)
+			},
+			expectedStatus: http.StatusNoContent,
+		},
+		{
+			name:           "Invalid from",
+			query:          "?from=yesterday",
+			mockSetup:      func(*mocks.MockStorageService) {},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Invalid before",
+			query:          "?before=-1",
+			mockSetup:      func(*mocks.MockStorageService) {},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "Limit too large",
+			query:          "?limit=5000",
+			mockSetup:      func(*mocks.MockStorageService) {},
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorageService, _, mockUserService, _, controller := prepare(t)
+			tt.mockSetup(mockStorageService)
+
+			w := serveAdmin(mockUserService, controller.AdminAudit(), http.MethodGet, "/api/admin/audit", "/api/admin/audit"+tt.query, http.NoBody)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			if w.Code == http.StatusOK {
+				var got []models.AuditEvent
+				require.NoError(t, json.NewDecoder(w.Body).Decode(&got))
+				assert.Equal(t, []int64{2, 1}, []int64{got[0].ID, got[1].ID})
+				assert.JSONEq(t, `{"amount":5}`, string(got[0].Payload))
+			}
+		})
+	}
+}
+
+func Test_AdminAuditVerify(t *testing.T) {
+	var chain []models.AuditEvent
+	prevHash := ""
+	for i := 1; i <= 3; i++ {
+		event := audit.Chain(prevHash, audit.New(context.Background(), "testUser", audit.ActionLoginSucceeded, "testUser", nil))
+		event.ID = int64(i)
+		chain = append(chain, event)
+		prevHash = event.Hash
+	}
+	tampered := append([]models.AuditEvent(nil), chain...)
+	tampered[1].Actor = "admin"
+
+	tests := []struct {
+		name     string
+		events   []models.AuditEvent
+		expected string
+	}{
+		{name: "Valid", events: chain, expected: `{"valid": true, "checked": 3}`},
+		{name: "Tampered", events: tampered, expected: `{"valid": false, "checked": 2, "broken_at": 2}`},
+		{name: "Empty", expected: `{"valid": true, "checked": 0}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorageService, _, mockUserService, _, controller := prepare(t)
+			mockStorageService.EXPECT().GetAuditChain(gomock.Any(), int64(0), auditVerifyBatch).Return(tt.events, nil)
+
+			w := serveAdmin(mockUserService, controller.AdminAuditVerify(), http.MethodGet, "/api/admin/audit/verify", "/api/admin/audit/verify", http.NoBody)
+
+			assert.Equal(t, http.StatusOK, w.Code)
+			assert.JSONEq(t, tt.expected, w.Body.String())
+		})
+	}
+}
@@ -0,0 +1,218 @@
+//go:build unit
+// +build unit
+
+package handlers
+
+import (
+	"errors"
+	"gophermart/cmd/gophermart/mocks"
+	"gophermart/cmd/gophermart/models"
+	"gophermart/cmd/gophermart/storage"
+	"gophermart/cmd/gophermart/user"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_AuthenticateMiddleware(t *testing.T) {
+	errUserNotFound := storage.ErrUserNotFound
+	errSessionNotFound := storage.ErrSessionNotFound
+	lockedAt := time.Now()
+	validToken := func(con *Controller) string {
+		token, _ := con.tokenService.IssueToken(user.TokenClaims{Login: "tokenUser", SessionID: "tokenSessionID", Version: 1})
+		return token
+	}
+	activeAccount := models.Account{Login: "tokenUser", Role: models.RoleUser, TokenVersion: 1}
+
+	tests := []struct {
+		name           string
+		authorization  func(con *Controller) string
+		disableTokens  bool
+		mockSetup      func(storage *mocks.MockStorageService)
+		expectedStatus int
+		expectedLogin  string
+	}{
+		{
+			name:          "Bearer token",
+			authorization: func(con *Controller) string { return "Bearer " + validToken(con) },
+			mockSetup: func(storage *mocks.MockStorageService) {
+				storage.EXPECT().GetUser(gomock.Any(), "tokenUser").Return(activeAccount, nil)
+				storage.EXPECT().TouchSession(gomock.Any(), "tokenSessionID").Return("tokenUser", nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedLogin:  "tokenUser",
+		},
+		{
+			name:          "Scheme is case-insensitive",
+			authorization: func(con *Controller) string { return "bearer " + validToken(con) },
+			mockSetup: func(storage *mocks.MockStorageService) {
+				storage.EXPECT().GetUser(gomock.Any(), "tokenUser").Return(activeAccount, nil)
+				storage.EXPECT().TouchSession(gomock.Any(), "tokenSessionID").Return("tokenUser", nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedLogin:  "tokenUser",
+		},
+		{
+			name:          "Token issued before password change",
+			authorization: func(con *Controller) string { return "Bearer " + validToken(con) },
+			mockSetup: func(storage *mocks.MockStorageService) {
+				storage.EXPECT().GetUser(gomock.Any(), "tokenUser").Return(models.Account{Login: "tokenUser", Role: models.RoleUser, TokenVersion: 2}, nil)
+			},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:          "Session of the token is closed",
+			authorization: func(con *Controller) string { return "Bearer " + validToken(con) },
+			mockSetup: func(storage *mocks.MockStorageService) {
+				storage.EXPECT().GetUser(gomock.Any(), "tokenUser").Return(activeAccount, nil)
+				storage.EXPECT().TouchSession(gomock.Any(), "tokenSessionID").Return("", errSessionNotFound)
+			},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:          "Session storage error",
+			authorization: func(con *Controller) string { return "Bearer " + validToken(con) },
+			mockSetup: func(storage *mocks.MockStorageService) {
+				storage.EXPECT().GetUser(gomock.Any(), "tokenUser").Return(activeAccount, nil)
+				storage.EXPECT().TouchSession(gomock.Any(), "tokenSessionID").Return("", errors.New("connection refused"))
+			},
+			expectedStatus: http.StatusInternalServerError,
+		},
+		{
+			name:          "Locked account",
+			authorization: func(con *Controller) string { return "Bearer " + validToken(con) },
+			mockSetup: func(storage *mocks.MockStorageService) {
+				storage.EXPECT().GetUser(gomock.Any(), "tokenUser").Return(models.Account{Login: "tokenUser", Role: models.RoleUser, LockedAt: &lockedAt}, nil)
+			},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:          "Unknown user",
+			authorization: func(con *Controller) string { return "Bearer " + validToken(con) },
+			mockSetup: func(storage *mocks.MockStorageService) {
+				storage.EXPECT().GetUser(gomock.Any(), "tokenUser").Return(models.Account{}, errUserNotFound)
+			},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:          "Invalid token",
+			authorization: func(*Controller) string { return "Bearer not-a-jwt" },
+			mockSetup: func(*mocks.MockStorageService) {
+			},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:          "Bearer tokens are disabled",
+			authorization: func(con *Controller) string { return "Bearer " + validToken(con) },
+			disableTokens: true,
+			mockSetup: func(*mocks.MockStorageService) {
+			},
+			expectedStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorageService, _, _, _, controller := prepare(t)
+			tt.mockSetup(mockStorageService)
+			authorization := tt.authorization(controller)
+			if tt.disableTokens {
+				controller.tokenService = nil
+			}
+
+			var login, sessionID, tokenSessionID string
+			handler := controller.AuthenticateMiddleware(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+				login, sessionID, tokenSessionID = requestLogin(req), requestSession(req), requestTokenSession(req)
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/api/user/orders", http.NoBody)
+			req.Header.Set("Authorization", authorization)
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.Equal(t, tt.expectedLogin, login)
+			if tt.expectedLogin != "" {
+				assert.Equal(t, "tokenSessionID", tokenSessionID)
+			}
+			assert.Empty(t, sessionID, "bearer requests have no session")
+			assert.Empty(t, w.Result().Cookies(), "bearer requests do not get a cookie")
+		})
+	}
+}
+
+func Test_AuthenticateMiddleware_Cookie(t *testing.T) {
+	errSessionNotFound := storage.ErrSessionNotFound
+
+	tests := []struct {
+		name           string
+		mockSetup      func(storage *mocks.MockStorageService, userSrv *mocks.MockUserService)
+		expectedStatus int
+		expectedLogin  string
+	}{
+		{
+			name: "Active session",
+			mockSetup: func(storage *mocks.MockStorageService, userSrv *mocks.MockUserService) {
+				userSrv.EXPECT().GetUserIDFromCookie(gomock.Any()).Return("testUserID", nil)
+				storage.EXPECT().TouchSession(gomock.Any(), "testUserID").Return("testUser", nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedLogin:  "testUser",
+		},
+		{
+			name: "No cookie",
+			mockSetup: func(_ *mocks.MockStorageService, userSrv *mocks.MockUserService) {
+				userSrv.EXPECT().GetUserIDFromCookie(gomock.Any()).Return("", http.ErrNoCookie)
+			},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "No session for the cookie",
+			mockSetup: func(storage *mocks.MockStorageService, userSrv *mocks.MockUserService) {
+				userSrv.EXPECT().GetUserIDFromCookie(gomock.Any()).Return("testUserID", nil)
+				storage.EXPECT().TouchSession(gomock.Any(), "testUserID").Return("", errSessionNotFound)
+				userSrv.EXPECT().DeleteUserIDCookie(gomock.Any())
+			},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "Storage error",
+			mockSetup: func(storage *mocks.MockStorageService, userSrv *mocks.MockUserService) {
+				userSrv.EXPECT().GetUserIDFromCookie(gomock.Any()).Return("testUserID", nil)
+				storage.EXPECT().TouchSession(gomock.Any(), "testUserID").Return("", errors.New("connection refused"))
+			},
+			expectedStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorageService, _, mockUserService, _, controller := prepare(t)
+			tt.mockSetup(mockStorageService, mockUserService)
+
+			var login, sessionID string
+			called := false
+			handler := controller.AuthenticateMiddleware(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+				called = true
+				login, sessionID = requestLogin(req), requestSession(req)
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/api/user/orders", http.NoBody)
+			req.Header.Set("Authorization", "Basic dXNlcjpwYXNz") // не Bearer - проверяется cookie
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.Equal(t, tt.expectedStatus == http.StatusOK, called, "unauthenticated requests do not reach the handler")
+			assert.Equal(t, tt.expectedLogin, login)
+			if called {
+				assert.Equal(t, "testUserID", sessionID)
+			}
+			assert.Empty(t, w.Result().Cookies(), "no anonymous cookie is issued")
+		})
+	}
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"gophermart/cmd/gophermart/models"
+	"net/http"
+	"time"
+)
+
+const (
+	statusOK   = "ok"
+	statusFail = "fail"
+
+	readinessCheckTimeout = 3 * time.Second
+)
+
+// Healthz - процесс жив и обрабатывает запросы
+func (con *Controller) Healthz() http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		res.Header().Set("Content-Type", "application/json")
+		res.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(res).Encode(models.DependencyStatus{Status: statusOK})
+	}
+}
+
+// Readyz - доступны ли БД (с актуальными миграциями) и система расчёта баллов
+func (con *Controller) Readyz() http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		ctx, cancel := context.WithTimeout(req.Context(), readinessCheckTimeout)
+		defer cancel()
+
+		readiness := models.ReadinessResponse{
+			Status: statusOK,
+			Checks: map[string]models.DependencyStatus{
+				"database":   checkStatus(con.storageService.Ping(ctx)),
+				"migrations": con.checkMigrations(ctx),
+				"accrual":    checkStatus(con.AccrualClient.Ping(ctx)),
+			},
+		}
+
+		code := http.StatusOK
+		for name, check := range readiness.Checks {
+			if check.Status != statusOK {
+				con.sugar.Debugf("(Readyz) %s: %s", name, check.Error)
+				readiness.Status = statusFail
+				code = http.StatusServiceUnavailable
+			}
+		}
+
+		res.Header().Set("Content-Type", "application/json")
+		res.WriteHeader(code)
+		_ = json.NewEncoder(res).Encode(readiness)
+	}
+}
+
+func (con *Controller) checkMigrations(ctx context.Context) models.DependencyStatus {
+	current, latest, err := con.storageService.MigrationVersion(ctx)
+	if err != nil {
+		return checkStatus(err)
+	}
+	if current != latest {
+		return models.DependencyStatus{
+			Status:  statusFail,
+			Error:   fmt.Sprintf("database schema version %d, expected %d", current, latest),
+			Version: current,
+		}
+	}
+	return models.DependencyStatus{Status: statusOK, Version: current}
+}
+
+func checkStatus(err error) models.DependencyStatus {
+	if err != nil {
+		return models.DependencyStatus{Status: statusFail, Error: err.Error()}
+	}
+	return models.DependencyStatus{Status: statusOK}
+}
@@ -0,0 +1,93 @@
+//go:build unit
+// +build unit
+
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"gophermart/cmd/gophermart/mocks"
+	"gophermart/cmd/gophermart/models"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Healthz(t *testing.T) {
+	_, _, _, _, controller := prepare(t)
+
+	req := httptest.NewRequest("GET", "/healthz", nil)
+	w := httptest.NewRecorder()
+	controller.Healthz().ServeHTTP(w, req)
+
+	resp := w.Result()
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Empty(t, resp.Cookies())
+}
+
+func Test_Readyz(t *testing.T) {
+	tests := []struct {
+		name           string
+		mockSetup      func(storage *mocks.MockStorageService, accSrv *mocks.MockAccrualClient)
+		expectedStatus int
+		expectedChecks map[string]string
+	}{
+		{
+			name: "Ready",
+			mockSetup: func(storage *mocks.MockStorageService, accSrv *mocks.MockAccrualClient) {
+				storage.EXPECT().Ping(gomock.Any()).Return(nil)
+				storage.EXPECT().MigrationVersion(gomock.Any()).Return(int64(7), int64(7), nil)
+				accSrv.EXPECT().Ping(gomock.Any()).Return(nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedChecks: map[string]string{"database": "ok", "migrations": "ok", "accrual": "ok"},
+		},
+		{
+			name: "Database Unavailable",
+			mockSetup: func(storage *mocks.MockStorageService, accSrv *mocks.MockAccrualClient) {
+				storage.EXPECT().Ping(gomock.Any()).Return(errors.New("connection refused"))
+				storage.EXPECT().MigrationVersion(gomock.Any()).Return(int64(0), int64(0), errors.New("connection refused"))
+				accSrv.EXPECT().Ping(gomock.Any()).Return(nil)
+			},
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedChecks: map[string]string{"database": "fail", "migrations": "fail", "accrual": "ok"},
+		},
+		{
+			name: "Pending Migrations And Accrual Unavailable",
+			mockSetup: func(storage *mocks.MockStorageService, accSrv *mocks.MockAccrualClient) {
+				storage.EXPECT().Ping(gomock.Any()).Return(nil)
+				storage.EXPECT().MigrationVersion(gomock.Any()).Return(int64(5), int64(7), nil)
+				accSrv.EXPECT().Ping(gomock.Any()).Return(errors.New("timeout"))
+			},
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedChecks: map[string]string{"database": "ok", "migrations": "fail", "accrual": "fail"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorageService, _, _, mockAccrualClient, controller := prepare(t)
+			tt.mockSetup(mockStorageService, mockAccrualClient)
+
+			req := httptest.NewRequest("GET", "/readyz", nil)
+			w := httptest.NewRecorder()
+			controller.Readyz().ServeHTTP(w, req)
+
+			resp := w.Result()
+			defer resp.Body.Close()
+			assert.Equal(t, tt.expectedStatus, resp.StatusCode)
+			assert.Empty(t, resp.Cookies())
+
+			var body models.ReadinessResponse
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+			for name, status := range tt.expectedChecks {
+				assert.Equal(t, status, body.Checks[name].Status, name)
+			}
+		})
+	}
+}
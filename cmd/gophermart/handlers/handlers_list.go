@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"gophermart/cmd/gophermart/models"
+	"gophermart/cmd/gophermart/orderstate"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Размер страницы списков заказов и списаний, если передан cursor без limit, и максимум для limit
+const (
+	listLimit    = 100
+	listMaxLimit = 1000
+)
+
+// nextCursorHeader - курсор следующей страницы; та же страница доступна по ссылке rel="next" в Link
+const nextCursorHeader = "X-Next-Cursor"
+
+var ErrInvalidListQuery = errors.New("invalid list query")
+
+// parseListQuery разбирает параметры списка: ?limit=&cursor=&status=&from=&to=&sort=asc|desc.
+// Без limit и cursor возвращается весь список, как требует спецификация; status - через запятую
+// или повтором параметра, только если withStatus. from и to - в RFC 3339.
+func parseListQuery(req *http.Request, withStatus bool) (models.ListQuery, error) {
+	query := req.URL.Query()
+	var list models.ListQuery
+
+	if withStatus {
+		for _, value := range query["status"] {
+			for _, status := range strings.Split(value, ",") {
+				if !orderstate.IsKnown(status) {
+					return list, fmt.Errorf("%w: status %q", ErrInvalidListQuery, status)
+				}
+				list.Statuses = append(list.Statuses, status)
+			}
+		}
+	}
+
+	var err error
+	if s := query.Get("from"); s != "" {
+		if list.From, err = time.Parse(time.RFC3339, s); err != nil {
+			return list, fmt.Errorf("%w: from", ErrInvalidListQuery)
+		}
+	}
+	if s := query.Get("to"); s != "" {
+		if list.To, err = time.Parse(time.RFC3339, s); err != nil {
+			return list, fmt.Errorf("%w: to", ErrInvalidListQuery)
+		}
+	}
+
+	switch query.Get("sort") {
+	case "", "desc":
+	case "asc":
+		list.Asc = true
+	default:
+		return list, fmt.Errorf("%w: sort", ErrInvalidListQuery)
+	}
+
+	if s := query.Get("cursor"); s != "" {
+		cursor, err := decodeCursor(s)
+		if err != nil {
+			return list, fmt.Errorf("%w: cursor", ErrInvalidListQuery)
+		}
+		list.After = &cursor
+		list.Limit = listLimit
+	}
+	if s := query.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 || n > listMaxLimit {
+			return list, fmt.Errorf("%w: limit", ErrInvalidListQuery)
+		}
+		list.Limit = n
+	}
+	return list, nil
+}
+
+// lookahead - тот же запрос на одну запись больше: по ней nextPage узнаёт, есть ли следующая страница
+func lookahead(list models.ListQuery) models.ListQuery {
+	if list.Limit > 0 {
+		list.Limit++
+	}
+	return list
+}
+
+// nextPage отбрасывает запись, полученную сверх лимита через lookahead, и ставит для клиента
+// ссылку на следующую страницу и её курсор. Последняя страница заголовков не получает.
+func nextPage[T any](res http.ResponseWriter, req *http.Request, list models.ListQuery, records []T, key func(T) models.ListCursor) []T {
+	if list.Limit == 0 || len(records) <= list.Limit {
+		return records
+	}
+	records = records[:list.Limit]
+	cursor := encodeCursor(key(records[len(records)-1]))
+
+	query := req.URL.Query()
+	query.Set("cursor", cursor)
+	query.Set("limit", strconv.Itoa(list.Limit))
+	res.Header().Set("Link", fmt.Sprintf(`<%s?%s>; rel="next"`, req.URL.Path, query.Encode()))
+	res.Header().Set(nextCursorHeader, cursor)
+	return records
+}
+
+func orderCursor(order models.Order) models.ListCursor {
+	number, _ := strconv.ParseInt(order.Number, 10, 64)
+	return models.ListCursor{At: order.UploadedAt, Number: number}
+}
+
+func withdrawalCursor(withdrawal models.Withdrawal) models.ListCursor {
+	number, _ := strconv.ParseInt(withdrawal.Order, 10, 64)
+	return models.ListCursor{At: withdrawal.ProcessedAt, Number: number}
+}
+
+// Курсор непрозрачен для клиента: время последней записи в наносекундах и номер заказа
+func encodeCursor(cursor models.ListCursor) string {
+	raw := strconv.FormatInt(cursor.At.UnixNano(), 10) + "." + strconv.FormatInt(cursor.Number, 10)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeCursor(s string) (models.ListCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return models.ListCursor{}, err
+	}
+	at, number, ok := strings.Cut(string(raw), ".")
+	if !ok {
+		return models.ListCursor{}, ErrInvalidListQuery
+	}
+	nanos, err := strconv.ParseInt(at, 10, 64)
+	if err != nil {
+		return models.ListCursor{}, err
+	}
+	n, err := strconv.ParseInt(number, 10, 64)
+	if err != nil {
+		return models.ListCursor{}, err
+	}
+	return models.ListCursor{At: time.Unix(0, nanos).UTC(), Number: n}, nil
+}
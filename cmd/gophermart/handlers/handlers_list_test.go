@@ -0,0 +1,178 @@
+//go:build unit
+// +build unit
+
+package handlers
+
+import (
+	"encoding/json"
+	"gophermart/cmd/gophermart/mocks"
+	"gophermart/cmd/gophermart/models"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_ParseListQuery(t *testing.T) {
+	from := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	cursor := models.ListCursor{At: from.Add(90 * time.Second), Number: 12345678903}
+
+	tests := []struct {
+		name       string
+		query      string
+		withStatus bool
+		expected   models.ListQuery
+		wantErr    bool
+	}{
+		{name: "Defaults", withStatus: true, expected: models.ListQuery{}},
+		{
+			name:       "All params",
+			query:      "?status=NEW,PROCESSING&status=INVALID&from=2026-10-01T00:00:00Z&to=2026-10-02T00:00:00Z&sort=asc&limit=10&cursor=" + encodeCursor(cursor),
+			withStatus: true,
+			expected: models.ListQuery{
+				Statuses: []string{"NEW", "PROCESSING", "INVALID"},
+				From:     from,
+				To:       from.Add(24 * time.Hour),
+				Asc:      true,
+				After:    &cursor,
+				Limit:    10,
+			},
+		},
+		{name: "Cursor without limit", query: "?cursor=" + encodeCursor(cursor), expected: models.ListQuery{After: &cursor, Limit: listLimit}},
+		{name: "Status ignored for withdrawals", query: "?status=UNKNOWN&sort=desc", expected: models.ListQuery{}},
+		{name: "Unknown status", query: "?status=REGISTERED", withStatus: true, wantErr: true},
+		{name: "Invalid from", query: "?from=yesterday", wantErr: true},
+		{name: "Invalid to", query: "?to=2026-10-02", wantErr: true},
+		{name: "Invalid sort", query: "?sort=random", wantErr: true},
+		{name: "Limit too large", query: "?limit=5000", wantErr: true},
+		{name: "Zero limit", query: "?limit=0", wantErr: true},
+		{name: "Invalid cursor", query: "?cursor=not-a-cursor", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/user/orders"+tt.query, http.NoBody)
+
+			list, err := parseListQuery(req, tt.withStatus)
+
+			if tt.wantErr {
+				assert.ErrorIs(t, err, ErrInvalidListQuery)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, list)
+		})
+	}
+}
+
+func Test_Cursor(t *testing.T) {
+	cursor := models.ListCursor{At: time.Date(2026, 10, 17, 12, 0, 0, 123456000, time.UTC), Number: 79927398713}
+
+	decoded, err := decodeCursor(encodeCursor(cursor))
+	require.NoError(t, err)
+	assert.Equal(t, cursor, decoded)
+
+	_, err = decodeCursor("MTIz")
+	assert.Error(t, err)
+}
+
+func Test_OrdersGet_Page(t *testing.T) {
+	uploadedAt := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	orders := []models.Order{
+		{Number: "79927398713", Status: "NEW", UploadedAt: uploadedAt.Add(2 * time.Minute)},
+		{Number: "12345678903", Status: "NEW", UploadedAt: uploadedAt.Add(time.Minute)},
+		{Number: "4561261212345467", Status: "NEW", UploadedAt: uploadedAt},
+	}
+
+	tests := []struct {
+		name         string
+		query        string
+		mockSetup    func(storage *mocks.MockStorageService, userSrv *mocks.MockUserService)
+		expectedCode int
+		expectedLen  int
+		nextCursor   *models.ListCursor
+	}{
+		{
+			name:  "Has next page",
+			query: "?limit=2&status=NEW",
+			mockSetup: func(storage *mocks.MockStorageService, userSrv *mocks.MockUserService) {
+				storage.EXPECT().GetOrders(gomock.Any(), "testUser", models.ListQuery{Statuses: []string{"NEW"}, Limit: 3}).Return(orders, nil)
+				userSrv.EXPECT().SetUserIDCookie(gomock.Any(), "testUserID").Return(nil)
+			},
+			expectedCode: http.StatusOK,
+			expectedLen:  2,
+			nextCursor:   &models.ListCursor{At: orders[1].UploadedAt, Number: 12345678903},
+		},
+		{
+			name:  "Last page",
+			query: "?limit=3",
+			mockSetup: func(storage *mocks.MockStorageService, userSrv *mocks.MockUserService) {
+				storage.EXPECT().GetOrders(gomock.Any(), "testUser", models.ListQuery{Limit: 4}).Return(orders, nil)
+				userSrv.EXPECT().SetUserIDCookie(gomock.Any(), "testUserID").Return(nil)
+			},
+			expectedCode: http.StatusOK,
+			expectedLen:  3,
+		},
+		{
+			name:         "Bad Request",
+			query:        "?sort=up",
+			mockSetup:    func(*mocks.MockStorageService, *mocks.MockUserService) {},
+			expectedCode: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorageService, _, mockUserService, _, controller := prepare(t)
+			tt.mockSetup(mockStorageService, mockUserService)
+
+			req := withSession(httptest.NewRequest(http.MethodGet, "/api/user/orders"+tt.query, http.NoBody), "testUserID")
+			w := httptest.NewRecorder()
+			controller.OrdersGet().ServeHTTP(w, req)
+
+			require.Equal(t, tt.expectedCode, w.Code)
+			if tt.expectedCode != http.StatusOK {
+				return
+			}
+			var got []models.Order
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&got))
+			assert.Len(t, got, tt.expectedLen)
+
+			if tt.nextCursor == nil {
+				assert.Empty(t, w.Header().Get("Link"))
+				assert.Empty(t, w.Header().Get(nextCursorHeader))
+				return
+			}
+			cursor := w.Header().Get(nextCursorHeader)
+			decoded, err := decodeCursor(cursor)
+			require.NoError(t, err)
+			assert.Equal(t, *tt.nextCursor, decoded)
+
+			next := url.Values{"cursor": {cursor}, "limit": {"2"}, "status": {"NEW"}}
+			assert.Equal(t, `</api/user/orders?`+next.Encode()+`>; rel="next"`, w.Header().Get("Link"))
+		})
+	}
+}
+
+func Test_InfoAboutWithdrawals_Page(t *testing.T) {
+	processedAt := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	after := models.ListCursor{At: processedAt.Add(time.Hour), Number: 79927398713}
+
+	mockStorageService, _, mockUserService, _, controller := prepare(t)
+	mockStorageService.EXPECT().GetUserWithdrawals(gomock.Any(), "testUser", models.ListQuery{Asc: true, After: &after, Limit: listLimit + 1}).
+		Return([]models.Withdrawal{{Order: "12345678903", Sum: models.NewMoney(5), ProcessedAt: processedAt}}, nil)
+	mockUserService.EXPECT().SetUserIDCookie(gomock.Any(), "testUserID").Return(nil)
+
+	req := withSession(httptest.NewRequest(http.MethodGet, "/api/user/withdrawals?sort=asc&cursor="+encodeCursor(after), http.NoBody), "testUserID")
+	w := httptest.NewRecorder()
+	controller.InfoAboutWithdrawals().ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, w.Header().Get("Link"))
+	assert.JSONEq(t, `[{"order": "12345678903", "sum": 5, "processed_at": "2026-10-17T12:00:00Z"}]`, w.Body.String())
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"gophermart/cmd/gophermart/audit"
+	"gophermart/cmd/gophermart/metrics"
+	"gophermart/cmd/gophermart/problem"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// checkLoginThrottle отвечает 429, если вход по логину или с адреса временно заблокирован.
+// Вызывается до проверки пароля, чтобы заблокированные попытки не нагружали bcrypt.
+func (con *Controller) checkLoginThrottle(res http.ResponseWriter, req *http.Request, login, ip string) bool {
+	if con.loginThrottle == nil {
+		return true
+	}
+
+	retryAfter, err := con.loginThrottle.RetryAfter(req.Context(), login, ip)
+	if err != nil {
+		con.Error(res, req, err)
+		return false
+	}
+	if retryAfter > 0 {
+		con.tooManyLoginAttempts(res, req, retryAfter)
+		return false
+	}
+	return true
+}
+
+// loginFailed учитывает неудачный вход (factor - неверный пароль или код второго фактора).
+// Если из-за него начинается блокировка, она пишется в журнал аудита и клиент сразу получает 429.
+func (con *Controller) loginFailed(res http.ResponseWriter, req *http.Request, login, ip, factor string) {
+	con.writeAudit(req, login, audit.ActionLoginFailed, login, map[string]any{"factor": factor})
+	if retryAfter := con.recordLoginFailure(req, login, ip); retryAfter > 0 {
+		con.tooManyLoginAttempts(res, req, retryAfter)
+		return
+	}
+	con.Problem(res, req, problem.InvalidCredentials, "")
+}
+
+// secondFactorFailed учитывает неверный код TOTP вне входа (подтверждение списания, отключение TOTP)
+// в том же счётчике, что и неудачные входы: иначе код можно перебирать из открытой сессии.
+func (con *Controller) secondFactorFailed(res http.ResponseWriter, req *http.Request, login, ip string, kind problem.Kind, detail string) {
+	if retryAfter := con.recordLoginFailure(req, login, ip); retryAfter > 0 {
+		con.tooManyLoginAttempts(res, req, retryAfter)
+		return
+	}
+	con.Problem(res, req, kind, detail)
+}
+
+// recordLoginFailure возвращает, на сколько заблокирован вход, если блокировка началась из-за этой неудачи
+func (con *Controller) recordLoginFailure(req *http.Request, login, ip string) time.Duration {
+	if con.loginThrottle == nil {
+		return 0
+	}
+
+	lockouts, err := con.loginThrottle.Fail(req.Context(), login, ip)
+	if err != nil {
+		con.sugar.Errorf("(Login) Failed to record login failure: %v", err)
+	}
+
+	var retryAfter time.Duration
+	for _, lockout := range lockouts {
+		metrics.LoginLockouts.WithLabelValues(lockout.Scope).Inc()
+		con.writeAudit(req, login, audit.ActionLoginLockout, login, map[string]any{
+			"scope":        lockout.Scope,
+			"failures":     lockout.Failures,
+			"locked_until": lockout.LockedUntil,
+		})
+		retryAfter = max(retryAfter, time.Until(lockout.LockedUntil))
+	}
+	return retryAfter
+}
+
+// loginSucceeded пишет вход в журнал аудита и сбрасывает счётчик неудач логина. Вызывается только
+// после всех факторов, иначе знающий пароль мог бы перебирать коды TOTP, повторяя вход.
+func (con *Controller) loginSucceeded(req *http.Request, login string) {
+	con.writeAudit(req, login, audit.ActionLoginSucceeded, login, nil)
+	if con.loginThrottle == nil {
+		return
+	}
+	if err := con.loginThrottle.Succeed(req.Context(), login); err != nil {
+		con.sugar.Errorf("(Login) Failed to reset login failures: %v", err)
+	}
+}
+
+func (con *Controller) tooManyLoginAttempts(res http.ResponseWriter, req *http.Request, retryAfter time.Duration) {
+	res.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	con.Problem(res, req, problem.TooManyLoginAttempts, "")
+}
@@ -0,0 +1,167 @@
+//go:build unit
+// +build unit
+
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"gophermart/cmd/gophermart/audit"
+	"gophermart/cmd/gophermart/models"
+	"gophermart/cmd/gophermart/storage"
+	"gophermart/cmd/gophermart/user"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ShiraazMoollatjie/goluhn"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func loginRequest(login, password, remoteAddr string) *http.Request {
+	reqBody, _ := json.Marshal(user.User{Login: login, Password: password})
+	req := httptest.NewRequest(http.MethodPost, "/api/user/login", bytes.NewReader(reqBody))
+	req.RemoteAddr = remoteAddr
+	return req
+}
+
+func Test_Login_Lockout(t *testing.T) {
+	mockStorageService, mockStorageUtils, mockUserService, _, controller := prepare(t)
+	controller.loginThrottle = user.NewLoginThrottle(storage.NewMemoryStorage(), user.LoginLimits{
+		MaxFailuresPerLogin: 3,
+		MaxFailuresPerIP:    10,
+		Window:              time.Minute,
+		Lockout:             time.Hour,
+	})
+
+	mockStorageService.EXPECT().GetHashedPasswordByLogin(gomock.Any(), "testUser").Return("hashedPassword").Times(3)
+	mockStorageUtils.EXPECT().CheckPasswordHash("wrongPassword", "hashedPassword").Return(false).Times(3)
+	mockStorageService.EXPECT().AddAuditEvent(gomock.Any(), auditEvent{audit.ActionLoginFailed, "testUser"}).Return(nil).Times(3)
+	expectAudit(mockStorageService, audit.ActionLoginLockout, "testUser")
+
+	for i, expected := range []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusTooManyRequests} {
+		w := httptest.NewRecorder()
+		controller.Login()(w, loginRequest("testUser", "wrongPassword", "10.0.0.1:1234"))
+		assert.Equal(t, expected, w.Code, "attempt %d", i+1)
+	}
+
+	// Пароль больше не проверяется, в том числе верный и с другого адреса
+	w := httptest.NewRecorder()
+	controller.Login()(w, loginRequest("testUser", "testPassword", "10.0.0.2:1234"))
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	retryAfter, err := strconv.Atoi(w.Header().Get("Retry-After"))
+	assert.NoError(t, err)
+	assert.InDelta(t, 3600, retryAfter, 60)
+
+	// Другой логин с того же адреса не заблокирован
+	mockStorageService.EXPECT().GetHashedPasswordByLogin(gomock.Any(), "otherUser").Return("hashedPassword")
+	mockStorageUtils.EXPECT().CheckPasswordHash("otherPassword", "hashedPassword").Return(true)
+	mockStorageService.EXPECT().GetUser(gomock.Any(), "otherUser").Return(models.Account{Login: "otherUser", Role: models.RoleUser}, nil)
+	mockStorageService.EXPECT().GetTOTP(gomock.Any(), "otherUser").Return(nil, nil)
+	mockStorageService.EXPECT().CreateSession(gomock.Any(), newSessionOf("otherUser")).Return(nil)
+	mockUserService.EXPECT().SetUserIDCookie(gomock.Any(), newSessionID{}).Return(nil)
+	expectAudit(mockStorageService, audit.ActionLoginSucceeded, "otherUser")
+
+	w = httptest.NewRecorder()
+	controller.Login()(w, loginRequest("otherUser", "otherPassword", "10.0.0.1:1234"))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func Test_Login_LockoutPerIP(t *testing.T) {
+	mockStorageService, _, _, _, controller := prepare(t)
+	controller.loginThrottle = user.NewLoginThrottle(storage.NewMemoryStorage(), user.LoginLimits{
+		MaxFailuresPerLogin: 3,
+		MaxFailuresPerIP:    2,
+		Window:              time.Minute,
+		Lockout:             time.Hour,
+	})
+
+	mockStorageService.EXPECT().GetHashedPasswordByLogin(gomock.Any(), gomock.Any()).Return("").Times(2)
+	expectAudit(mockStorageService, audit.ActionLoginFailed, "first")
+	expectAudit(mockStorageService, audit.ActionLoginFailed, "second")
+	expectAudit(mockStorageService, audit.ActionLoginLockout, "second")
+
+	w := httptest.NewRecorder()
+	controller.Login()(w, loginRequest("first", "password", "10.0.0.1:1234"))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = httptest.NewRecorder()
+	controller.Login()(w, loginRequest("second", "password", "10.0.0.1:4321"))
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.NotEmpty(t, w.Header().Get("Retry-After"))
+
+	w = httptest.NewRecorder()
+	controller.Login()(w, loginRequest("third", "password", "10.0.0.1:1234"))
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+
+}
+
+func Test_Login_ThrottleStorageError(t *testing.T) {
+	mockStorageService, _, _, _, controller := prepare(t)
+	controller.loginThrottle = user.NewLoginThrottle(mockStorageService, user.LoginLimits{
+		MaxFailuresPerLogin: 3,
+		Window:              time.Minute,
+		Lockout:             time.Hour,
+	})
+
+	mockStorageService.EXPECT().GetLoginLockout(gomock.Any(), "login:testUser").Return(time.Time{}, errors.New("connection refused"))
+
+	w := httptest.NewRecorder()
+	controller.Login()(w, loginRequest("testUser", "testPassword", "10.0.0.1:1234"))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+// Коды TOTP из открытой сессии перебираются не дольше, чем при входе
+func Test_TOTP_Lockout(t *testing.T) {
+	secret, _ := testTOTPSecret(t)
+	enabled := &models.TOTP{Secret: secret, Enabled: true}
+	orderNumber := goluhn.Generate(10)
+
+	tests := []struct {
+		name    string
+		request func(code string) *http.Request
+		handler func(con *Controller) http.HandlerFunc
+	}{
+		{
+			name: "Disable TOTP",
+			request: func(code string) *http.Request {
+				return httptest.NewRequest(http.MethodDelete, "/api/user/2fa/totp", jsonBody(models.TOTPCode{Code: code}))
+			},
+			handler: (*Controller).TOTPDisable,
+		},
+		{
+			name: "Large withdrawal",
+			request: func(code string) *http.Request {
+				req := httptest.NewRequest(http.MethodPost, "/api/user/balance/withdraw",
+					jsonBody(models.WithdrawRequest{Order: orderNumber, Sum: models.NewMoney(5000)}))
+				req.Header.Set(TOTPCodeHeader, code)
+				return req
+			},
+			handler: (*Controller).RequestForWithdrawal,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorageService, _, _, _, controller := prepare(t)
+			controller.loginThrottle = user.NewLoginThrottle(storage.NewMemoryStorage(), user.LoginLimits{
+				MaxFailuresPerLogin: 2,
+				Window:              time.Minute,
+				Lockout:             time.Hour,
+			})
+
+			// Код проверяется только до блокировки
+			mockStorageService.EXPECT().GetTOTP(gomock.Any(), "testUser").Return(enabled, nil).Times(2)
+			expectAudit(mockStorageService, audit.ActionLoginLockout, "testUser")
+
+			for i, expected := range []int{http.StatusForbidden, http.StatusTooManyRequests, http.StatusTooManyRequests} {
+				w := httptest.NewRecorder()
+				tt.handler(controller)(w, withSession(tt.request("000000"), "testUserID"))
+				assert.Equal(t, expected, w.Code, "attempt %d", i+1)
+			}
+		})
+	}
+}
@@ -2,12 +2,22 @@ package handlers
 
 import (
 	"compress/gzip"
+	"context"
+	"errors"
+	"gophermart/cmd/gophermart/audit"
+	"gophermart/cmd/gophermart/metrics"
+	"gophermart/cmd/gophermart/models"
+	"gophermart/cmd/gophermart/problem"
+	"gophermart/cmd/gophermart/storage"
+	"gophermart/cmd/gophermart/user"
 	"net/http"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/google/uuid"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
 )
 
 func (con *Controller) PanicRecoveryMiddleware(next http.Handler) http.Handler {
@@ -15,7 +25,7 @@ func (con *Controller) PanicRecoveryMiddleware(next http.Handler) http.Handler {
 		defer func() {
 			if err := recover(); err != nil {
 				con.sugar.Errorf("Error recovering from panic: %v", err)
-				http.Error(res, "Error recovering from panic", http.StatusInternalServerError)
+				con.Problem(res, req, problem.Internal, "")
 			}
 		}()
 
@@ -23,6 +33,19 @@ func (con *Controller) PanicRecoveryMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// RealIPMiddleware подставляет адрес клиента из заголовков прокси (middleware.RealIP),
+// только если запрос пришёл с доверенного прокси: иначе клиент мог бы указать любой адрес сам
+func (con *Controller) RealIPMiddleware(next http.Handler) http.Handler {
+	realIP := middleware.RealIP(next)
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if con.conf.IsTrustedProxy(clientIP(req)) {
+			realIP.ServeHTTP(res, req)
+			return
+		}
+		next.ServeHTTP(res, req)
+	})
+}
+
 func (con *Controller) LoggingMiddleware(next http.Handler) http.Handler {
 	logFn := func(res http.ResponseWriter, req *http.Request) {
 		sugar := con.sugar
@@ -30,9 +53,19 @@ func (con *Controller) LoggingMiddleware(next http.Handler) http.Handler {
 		uri := req.RequestURI
 		method := req.Method
 
+		responseData := &responseData{
+			status: 0,
+			size:   0,
+		}
+		lw := loggingResponseWriter{
+			ResponseWriter: res,
+			responseData:   responseData,
+		}
+		next.ServeHTTP(&lw, req)
+		duration := time.Since(start)
+		observeHTTPRequest(req, responseData.status, duration)
+
 		if method == http.MethodGet {
-			next.ServeHTTP(res, req)
-			duration := time.Since(start)
 			sugar.Infoln(
 				"uri", uri,
 				"method", method,
@@ -40,32 +73,7 @@ func (con *Controller) LoggingMiddleware(next http.Handler) http.Handler {
 			)
 		}
 
-		if method == http.MethodPost {
-			responseData := &responseData{
-				status: 0,
-				size:   0,
-			}
-			lw := loggingResponseWriter{
-				ResponseWriter: res,
-				responseData:   responseData,
-			}
-			next.ServeHTTP(&lw, req)
-			sugar.Infoln(
-				"status", responseData.status,
-				"size", responseData.size,
-			)
-		}
-
-		if method == http.MethodDelete {
-			responseData := &responseData{
-				status: 0,
-				size:   0,
-			}
-			lw := loggingResponseWriter{
-				ResponseWriter: res,
-				responseData:   responseData,
-			}
-			next.ServeHTTP(&lw, req)
+		if method == http.MethodPost || method == http.MethodDelete {
 			sugar.Infoln(
 				"status", responseData.status,
 				"size", responseData.size,
@@ -76,12 +84,27 @@ func (con *Controller) LoggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(logFn)
 }
 
+// observeHTTPRequest учитывает запрос в метриках по шаблону маршрута chi, а не по URI,
+// чтобы номера заказов и т.п. не порождали новые серии
+func observeHTTPRequest(req *http.Request, status int, duration time.Duration) {
+	route := "unmatched"
+	if rctx := chi.RouteContext(req.Context()); rctx != nil && rctx.RoutePattern() != "" {
+		route = rctx.RoutePattern()
+	}
+	if status == 0 {
+		status = http.StatusOK
+	}
+	code := strconv.Itoa(status)
+	metrics.HTTPRequests.WithLabelValues(route, req.Method, code).Inc()
+	metrics.HTTPRequestDuration.WithLabelValues(route, req.Method, code).Observe(duration.Seconds())
+}
+
 func (con *Controller) GzipDecodeMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
 		if req.Header.Get("Content-Encoding") == "gzip" {
 			gz, err := gzip.NewReader(req.Body)
 			if err != nil {
-				http.Error(res, "Bad Request: Unable to decode gzip body", http.StatusBadRequest)
+				con.Problem(res, req, problem.BadRequest, "unable to decode gzip body")
 				return
 			}
 			defer gz.Close()
@@ -113,7 +136,7 @@ func (con *Controller) GzipEncodeMiddleware(next http.Handler) http.Handler {
 
 		gzip, err := gzip.NewWriterLevel(res, gzip.BestSpeed)
 		if err != nil {
-			http.Error(res, "Error creating gzip.Writer", http.StatusBadRequest)
+			con.Error(res, req, err)
 			return
 		}
 
@@ -126,27 +149,172 @@ func (con *Controller) GzipEncodeMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(compressFn)
 }
 
+type ctxKey int
+
+const (
+	loginCtxKey ctxKey = iota
+	sessionCtxKey
+	tokenSessionCtxKey
+	apiKeyCtxKey
+)
+
+// APIKeyHeader - заголовок с API-ключом для интеграций
+const APIKeyHeader = "X-API-Key"
+
+// AuthenticateMiddleware пропускает запрос с Authorization: Bearer <JWT> или cookie AuthToken действующей сессии,
+// остальным отвечает 401. Логин (и ID сессии) кладётся в контекст запроса. API-ключи здесь не принимаются.
 func (con *Controller) AuthenticateMiddleware(next http.Handler) http.Handler {
+	return con.authenticate(next, "")
+}
+
+// AuthenticateWithAPIKey - как AuthenticateMiddleware, но маршрут доступен и по X-API-Key с правом scope
+func (con *Controller) AuthenticateWithAPIKey(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return con.authenticate(next, scope)
+	}
+}
+
+func (con *Controller) authenticate(next http.Handler, scope string) http.Handler {
 	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
-		uidFromCookie, err := con.userService.GetUserIDFromCookie(req)
+		if apiKey := req.Header.Get(APIKeyHeader); apiKey != "" {
+			if scope == "" {
+				con.Problem(res, req, problem.APIKeyForbidden, "API keys are not accepted here")
+				return
+			}
+			key, ok := con.checkAPIKey(res, req, apiKey)
+			if !ok {
+				return
+			}
+			if !key.HasScope(scope) {
+				con.Problem(res, req, problem.APIKeyForbidden, "API key has no "+scope+" scope")
+				return
+			}
+
+			ctx := context.WithValue(req.Context(), loginCtxKey, key.Login)
+			ctx = context.WithValue(ctx, apiKeyCtxKey, key)
+			next.ServeHTTP(res, req.WithContext(ctx))
+			return
+		}
 
-		if err != nil || uidFromCookie == "" {
-			con.sugar.Debugf("(AuthenticateMiddleware) Missing or invalid cookie: %s", err)
+		if token, ok := bearerToken(req); ok {
+			if con.tokenService == nil {
+				con.Problem(res, req, problem.Unauthorized, "bearer tokens are disabled")
+				return
+			}
+			claims, err := con.tokenService.ParseToken(token)
+			if err != nil {
+				con.sugar.Debugf("(AuthenticateMiddleware) Invalid bearer token: %s", err)
+				res.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				con.Problem(res, req, problem.Unauthorized, "invalid bearer token")
+				return
+			}
 
-			uid := uuid.New().String()
-			if err := con.userService.SetUserIDCookie(res, uid); err != nil {
-				con.sugar.Errorf("(AuthenticateMiddleware) Failed to set user ID cookie: %s", err.Error())
-				http.Error(res, "Internal Server Error", http.StatusInternalServerError)
+			// Сам токен не отзывается, поэтому при каждом запросе проверяются блокировка пользователя,
+			// версия токенов (меняется со сменой пароля) и сессия, в которой токен выдан
+			account, ok := con.checkAccountActive(res, req, claims.Login)
+			if !ok || !con.checkTokenSession(res, req, claims, account) {
 				return
 			}
 
-			con.sugar.Debugf("(AuthenticateMiddleware) New user ID set in cookie: %s", uid)
-			req.Header.Set("User-ID", uid)
-		} else {
-			con.sugar.Debugf("(AuthenticateMiddleware) Valid user ID from cookie: %s", uidFromCookie)
-			req.Header.Set("User-ID", uidFromCookie)
+			ctx := context.WithValue(req.Context(), loginCtxKey, claims.Login)
+			ctx = context.WithValue(ctx, tokenSessionCtxKey, claims.SessionID)
+			next.ServeHTTP(res, req.WithContext(ctx))
+			return
 		}
 
-		next.ServeHTTP(res, req)
+		sessionID, err := con.userService.GetUserIDFromCookie(req)
+		if err != nil || sessionID == "" {
+			con.Problem(res, req, problem.Unauthorized, "")
+			return
+		}
+
+		login, err := con.storageService.TouchSession(req.Context(), sessionID)
+		if errors.Is(err, storage.ErrSessionNotFound) {
+			// Выполнен logout или сессия истекла
+			con.userService.DeleteUserIDCookie(res)
+			con.Problem(res, req, problem.Unauthorized, "no active session")
+			return
+		}
+		if err != nil {
+			con.Error(res, req, err)
+			return
+		}
+
+		ctx := context.WithValue(req.Context(), loginCtxKey, login)
+		ctx = context.WithValue(ctx, sessionCtxKey, sessionID)
+		next.ServeHTTP(res, req.WithContext(ctx))
 	})
 }
+
+// checkAccountActive отвечает 403, если пользователь заблокирован администратором, и 401, если его нет
+func (con *Controller) checkAccountActive(res http.ResponseWriter, req *http.Request, login string) (models.Account, bool) {
+	account, err := con.storageService.GetUser(req.Context(), login)
+	if errors.Is(err, storage.ErrUserNotFound) {
+		con.Problem(res, req, problem.Unauthorized, "unknown user")
+		return account, false
+	}
+	if err != nil {
+		con.Error(res, req, err)
+		return account, false
+	}
+	if account.Locked() {
+		con.Problem(res, req, problem.AccountLocked, "")
+		return account, false
+	}
+	return account, true
+}
+
+// checkTokenSession отвечает 401, если токен выдан до смены пароля или его сессия закрыта (logout и т.п.)
+func (con *Controller) checkTokenSession(res http.ResponseWriter, req *http.Request, claims user.TokenClaims, account models.Account) bool {
+	revoked := func() bool {
+		res.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		con.Problem(res, req, problem.Unauthorized, "bearer token has been revoked")
+		return false
+	}
+	if claims.Version != account.TokenVersion {
+		return revoked()
+	}
+
+	login, err := con.storageService.TouchSession(req.Context(), claims.SessionID)
+	if errors.Is(err, storage.ErrSessionNotFound) || (err == nil && login != claims.Login) {
+		return revoked()
+	}
+	if err != nil {
+		con.Error(res, req, err)
+		return false
+	}
+	return true
+}
+
+// RequireRole пропускает пользователей с одной из ролей, остальным отвечает 403.
+// Ставится после AuthenticateMiddleware; отказ пишется в журнал аудита.
+func (con *Controller) RequireRole(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			login := requestLogin(req)
+			account, err := con.storageService.GetUser(req.Context(), login)
+			if err != nil && !errors.Is(err, storage.ErrUserNotFound) {
+				con.Error(res, req, err)
+				return
+			}
+			if err != nil || account.Locked() || !slices.Contains(roles, account.Role) {
+				con.writeAudit(req, login, audit.ActionAccessDenied, "", map[string]any{
+					"role":   account.Role,
+					"method": req.Method,
+					"path":   req.URL.Path,
+				})
+				con.Problem(res, req, problem.Forbidden, "")
+				return
+			}
+			next.ServeHTTP(res, req)
+		})
+	}
+}
+
+func bearerToken(req *http.Request) (string, bool) {
+	scheme, token, found := strings.Cut(req.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", false
+	}
+	return token, true
+}
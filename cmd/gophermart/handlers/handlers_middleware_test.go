@@ -0,0 +1,73 @@
+//go:build unit
+// +build unit
+
+package handlers
+
+import (
+	"gophermart/cmd/gophermart/metrics"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_LoggingMiddleware_Metrics(t *testing.T) {
+	_, _, _, _, controller := prepare(t)
+
+	r := chi.NewRouter()
+	r.Use(controller.LoggingMiddleware)
+	r.Get("/api/test/{number}", func(res http.ResponseWriter, req *http.Request) {
+		res.WriteHeader(http.StatusNoContent)
+	})
+
+	counter := metrics.HTTPRequests.WithLabelValues("/api/test/{number}", http.MethodGet, "204")
+	notFound := metrics.HTTPRequests.WithLabelValues("unmatched", http.MethodGet, "404")
+	before, beforeNotFound := testutil.ToFloat64(counter), testutil.ToFloat64(notFound)
+
+	for _, uri := range []string{"/api/test/1", "/api/test/2", "/unknown"} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, uri, nil))
+		w.Result().Body.Close()
+	}
+
+	assert.Equal(t, before+2, testutil.ToFloat64(counter), "series by route pattern, not by URI")
+	assert.Equal(t, beforeNotFound+1, testutil.ToFloat64(notFound))
+}
+
+func Test_RealIPMiddleware(t *testing.T) {
+	tests := []struct {
+		name       string
+		remoteAddr string
+		realIP     string
+		expectedIP string
+	}{
+		{name: "Trusted proxy", remoteAddr: "10.0.0.5:1234", realIP: "203.0.113.7", expectedIP: "203.0.113.7"},
+		{name: "Trusted proxy without header", remoteAddr: "10.0.0.5:1234", expectedIP: "10.0.0.5"},
+		{name: "Untrusted client", remoteAddr: "198.51.100.1:1234", realIP: "203.0.113.7", expectedIP: "198.51.100.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, _, _, controller := prepare(t)
+			controller.conf.TrustedProxies = []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+
+			var ip string
+			handler := controller.RealIPMiddleware(http.HandlerFunc(func(_ http.ResponseWriter, req *http.Request) {
+				ip = clientIP(req)
+			}))
+
+			req := httptest.NewRequest(http.MethodPost, "/api/user/login", http.NoBody)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.realIP != "" {
+				req.Header.Set("X-Real-IP", tt.realIP)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			assert.Equal(t, tt.expectedIP, ip)
+		})
+	}
+}
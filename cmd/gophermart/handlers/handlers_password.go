@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"encoding/json"
+	"gophermart/cmd/gophermart/models"
+	"gophermart/cmd/gophermart/problem"
+	"net/http"
+)
+
+// PasswordChange меняет пароль, завершает остальные сессии пользователя и отзывает выданные ранее
+// Bearer-токены. Для текущей сессии в заголовке Authorization возвращается новый токен.
+func (con *Controller) PasswordChange() http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		sessionID := requestSession(req)
+		userLogin := requestLogin(req)
+		// При входе по Bearer-токену текущая - сессия, в которой он выдан
+		keepSessionID := sessionID
+		if keepSessionID == "" {
+			keepSessionID = requestTokenSession(req)
+		}
+		if userLogin == "" {
+			con.Problem(res, req, problem.Unauthorized, "")
+			return
+		}
+
+		var change models.PasswordChange
+		err := json.NewDecoder(req.Body).Decode(&change)
+		if err != nil || change.OldPassword == "" || change.NewPassword == "" {
+			con.Problem(res, req, problem.BadRequest, "")
+			return
+		}
+
+		storedHashedPassword := con.storageService.GetHashedPasswordByLogin(req.Context(), userLogin)
+		if storedHashedPassword == "" || !con.storageUtils.CheckPasswordHash(change.OldPassword, storedHashedPassword) {
+			con.Problem(res, req, problem.InvalidPassword, "")
+			return
+		}
+		if violations := con.passwordPolicy.Check(userLogin, change.NewPassword); violations != nil {
+			con.passwordPolicyError(res, req, violations)
+			return
+		}
+
+		hashedPassword, err := con.storageUtils.HashPassword(change.NewPassword)
+		if err != nil {
+			con.Error(res, req, err)
+			return
+		}
+
+		if err := con.storageService.ChangePassword(req.Context(), userLogin, hashedPassword, keepSessionID); err != nil {
+			con.Error(res, req, err)
+			return
+		}
+
+		if con.tokenService != nil && keepSessionID != "" {
+			account, err := con.storageService.GetUser(req.Context(), userLogin)
+			if err != nil {
+				con.Error(res, req, err)
+				return
+			}
+			if !con.setBearerToken(res, req, account, keepSessionID) {
+				return
+			}
+		}
+
+		con.setUserIDCookie(res, sessionID)
+		con.Debug(res, "Password changed", http.StatusOK)
+	}
+}
+
+func (con *Controller) passwordPolicyError(res http.ResponseWriter, req *http.Request, violations []models.PasswordViolation) {
+	p := problem.New(req, problem.WeakPassword, "")
+	p.Extensions = map[string]any{"violations": violations}
+	con.sugar.Debugf("Bad request: password policy violations %v", violations)
+	problem.Write(res, p)
+}
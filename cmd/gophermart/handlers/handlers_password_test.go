@@ -0,0 +1,139 @@
+//go:build unit
+// +build unit
+
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"gophermart/cmd/gophermart/mocks"
+	"gophermart/cmd/gophermart/models"
+	"gophermart/cmd/gophermart/user"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_PasswordChange(t *testing.T) {
+	tests := []struct {
+		name           string
+		userID         string
+		login          string // вход по Bearer-токену
+		requestBody    models.PasswordChange
+		mockSetup      func(storage *mocks.MockStorageService, storageUtils *mocks.MockStorageUtils, userSrv *mocks.MockUserService)
+		expectedStatus int
+		expectedToken  *user.TokenClaims // новый Bearer-токен в ответе
+	}{
+		{
+			name:        "Successful Password Change",
+			userID:      "testUserID",
+			requestBody: models.PasswordChange{OldPassword: "oldPassword", NewPassword: "newPassword1"},
+			mockSetup: func(storage *mocks.MockStorageService, storageUtils *mocks.MockStorageUtils, userSrv *mocks.MockUserService) {
+				storage.EXPECT().GetHashedPasswordByLogin(gomock.Any(), "testUser").Return("hashedOld")
+				storageUtils.EXPECT().CheckPasswordHash("oldPassword", "hashedOld").Return(true)
+				storageUtils.EXPECT().HashPassword("newPassword1").Return("hashedNew", nil)
+				// Остальные сессии завершаются, текущая остаётся и получает токен новой версии
+				storage.EXPECT().ChangePassword(gomock.Any(), "testUser", "hashedNew", "testUserID").Return(nil)
+				storage.EXPECT().GetUser(gomock.Any(), "testUser").Return(models.Account{Login: "testUser", TokenVersion: 1}, nil)
+				userSrv.EXPECT().SetUserIDCookie(gomock.Any(), "testUserID").Return(nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedToken:  &user.TokenClaims{Login: "testUser", SessionID: "testUserID", Version: 1},
+		},
+		{
+			name:        "Bearer token keeps its own session",
+			login:       "testUser",
+			requestBody: models.PasswordChange{OldPassword: "oldPassword", NewPassword: "newPassword1"},
+			mockSetup: func(storage *mocks.MockStorageService, storageUtils *mocks.MockStorageUtils, _ *mocks.MockUserService) {
+				storage.EXPECT().GetHashedPasswordByLogin(gomock.Any(), "testUser").Return("hashedOld")
+				storageUtils.EXPECT().CheckPasswordHash("oldPassword", "hashedOld").Return(true)
+				storageUtils.EXPECT().HashPassword("newPassword1").Return("hashedNew", nil)
+				storage.EXPECT().ChangePassword(gomock.Any(), "testUser", "hashedNew", "tokenSessionID").Return(nil)
+				storage.EXPECT().GetUser(gomock.Any(), "testUser").Return(models.Account{Login: "testUser", TokenVersion: 1}, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedToken:  &user.TokenClaims{Login: "testUser", SessionID: "tokenSessionID", Version: 1},
+		},
+		{
+			name:        "Invalid Old Password",
+			userID:      "testUserID",
+			requestBody: models.PasswordChange{OldPassword: "wrongPassword", NewPassword: "newPassword1"},
+			mockSetup: func(storage *mocks.MockStorageService, storageUtils *mocks.MockStorageUtils, _ *mocks.MockUserService) {
+				storage.EXPECT().GetHashedPasswordByLogin(gomock.Any(), "testUser").Return("hashedOld")
+				storageUtils.EXPECT().CheckPasswordHash("wrongPassword", "hashedOld").Return(false)
+			},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:        "Weak New Password",
+			userID:      "testUserID",
+			requestBody: models.PasswordChange{OldPassword: "oldPassword", NewPassword: "testuser"},
+			mockSetup: func(storage *mocks.MockStorageService, storageUtils *mocks.MockStorageUtils, _ *mocks.MockUserService) {
+				storage.EXPECT().GetHashedPasswordByLogin(gomock.Any(), "testUser").Return("hashedOld")
+				storageUtils.EXPECT().CheckPasswordHash("oldPassword", "hashedOld").Return(true)
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:        "Bad Request - Missing Fields",
+			userID:      "testUserID",
+			requestBody: models.PasswordChange{OldPassword: "oldPassword"},
+			mockSetup: func(*mocks.MockStorageService, *mocks.MockStorageUtils, *mocks.MockUserService) {
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:        "Internal Server Error",
+			userID:      "testUserID",
+			requestBody: models.PasswordChange{OldPassword: "oldPassword", NewPassword: "newPassword1"},
+			mockSetup: func(storage *mocks.MockStorageService, storageUtils *mocks.MockStorageUtils, _ *mocks.MockUserService) {
+				storage.EXPECT().GetHashedPasswordByLogin(gomock.Any(), "testUser").Return("hashedOld")
+				storageUtils.EXPECT().CheckPasswordHash("oldPassword", "hashedOld").Return(true)
+				storageUtils.EXPECT().HashPassword("newPassword1").Return("hashedNew", nil)
+				storage.EXPECT().ChangePassword(gomock.Any(), "testUser", "hashedNew", "testUserID").Return(errors.New("connection refused"))
+			},
+			expectedStatus: http.StatusInternalServerError,
+		},
+		{
+			name:        "Unauthorized",
+			userID:      "unknownUserID",
+			requestBody: models.PasswordChange{OldPassword: "oldPassword", NewPassword: "newPassword1"},
+			mockSetup: func(*mocks.MockStorageService, *mocks.MockStorageUtils, *mocks.MockUserService) {
+			},
+			expectedStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorageService, mockStorageUtils, mockUserService, _, controller := prepare(t)
+			tt.mockSetup(mockStorageService, mockStorageUtils, mockUserService)
+
+			reqBody, _ := json.Marshal(tt.requestBody)
+			req := withSession(httptest.NewRequest(http.MethodPost, "/api/user/password", bytes.NewReader(reqBody)), tt.userID)
+			if tt.login != "" {
+				ctx := context.WithValue(req.Context(), loginCtxKey, tt.login)
+				req = req.WithContext(context.WithValue(ctx, tokenSessionCtxKey, "tokenSessionID"))
+			}
+			w := httptest.NewRecorder()
+
+			controller.PasswordChange()(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			if tt.expectedToken != nil {
+				token, found := strings.CutPrefix(w.Header().Get("Authorization"), "Bearer ")
+				require.True(t, found)
+				claims, err := controller.tokenService.ParseToken(token)
+				require.NoError(t, err)
+				assert.Equal(t, *tt.expectedToken, claims)
+			}
+		})
+	}
+}
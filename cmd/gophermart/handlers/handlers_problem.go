@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"errors"
+	"gophermart/cmd/gophermart/problem"
+	"gophermart/cmd/gophermart/storage"
+	"net/http"
+)
+
+// errorKinds - известные ошибки хранилища и обработчиков и вид ответа на них
+var errorKinds = []struct {
+	err  error
+	kind problem.Kind
+}{
+	{storage.ErrAddOrderConflict, problem.OrderConflict},
+	{storage.ErrInsufficientFunds, problem.InsufficientFunds},
+	{storage.ErrWithdrawConflict, problem.WithdrawalExists},
+	{storage.ErrInvalidAmount, problem.InvalidAmount},
+	{storage.ErrOrderNotFound, problem.OrderNotFound},
+	{storage.ErrSessionNotFound, problem.SessionNotFound},
+	{storage.ErrTOTPEnabled, problem.TOTPEnabled},
+	{storage.ErrTOTPNotFound, problem.TOTPNotEnrolled},
+	{storage.ErrChallengeNotFound, problem.ChallengeExpired},
+	{storage.ErrAPIKeyNotFound, problem.APIKeyNotFound},
+	{storage.ErrUserNotFound, problem.UserNotFound},
+	{ErrAccrualRequest, problem.AccrualUnavailable},
+	{ErrNoOKfromAccrual, problem.AccrualUnavailable},
+}
+
+// Problem отвечает ошибкой kind в формате application/problem+json
+func (con *Controller) Problem(res http.ResponseWriter, req *http.Request, kind problem.Kind, detail string) {
+	p := problem.New(req, kind, detail)
+	con.sugar.Debugw("Request failed", "code", kind.Code, "detail", detail, "path", req.URL.Path, "request_id", p.RequestID)
+	problem.Write(res, p)
+}
+
+// Error отвечает на ошибку err: известные ошибки - своим видом, остальные - 500.
+// Текст ошибки клиенту не отправляется, он остаётся в логе.
+func (con *Controller) Error(res http.ResponseWriter, req *http.Request, err error) {
+	for _, known := range errorKinds {
+		if errors.Is(err, known.err) {
+			con.Problem(res, req, known.kind, "")
+			return
+		}
+	}
+	p := problem.New(req, problem.Internal, "")
+	con.sugar.Errorw("Internal server error", "path", req.URL.Path, "request_id", p.RequestID, "error", err)
+	problem.Write(res, p)
+}
+
+// NotFound и MethodNotAllowed - ответы роутера на неизвестные маршруты в том же формате
+func (con *Controller) NotFound() http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		con.Problem(res, req, problem.NotFound, "")
+	}
+}
+
+func (con *Controller) MethodNotAllowed() http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		con.Problem(res, req, problem.MethodNotAllowed, "")
+	}
+}
@@ -0,0 +1,97 @@
+//go:build unit
+// +build unit
+
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gophermart/cmd/gophermart/problem"
+	"gophermart/cmd/gophermart/storage"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func decodeProblem(t *testing.T, w *httptest.ResponseRecorder) problem.Problem {
+	t.Helper()
+	assert.Equal(t, problem.ContentType, w.Header().Get("Content-Type"))
+	var p problem.Problem
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&p))
+	return p
+}
+
+func Test_Error(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		code   string
+	}{
+		{"Order conflict", storage.ErrAddOrderConflict, http.StatusConflict, "order_conflict"},
+		{"Insufficient funds", storage.ErrInsufficientFunds, http.StatusPaymentRequired, "insufficient_funds"},
+		{"Wrapped", fmt.Errorf("withdraw: %w", storage.ErrWithdrawConflict), http.StatusConflict, "withdrawal_exists"},
+		{"Accrual", ErrAccrualRequest, http.StatusBadGateway, "accrual_unavailable"},
+		{"Unknown", errors.New("pq: connection refused"), http.StatusInternalServerError, "internal_error"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, _, _, controller := prepare(t)
+
+			w := httptest.NewRecorder()
+			controller.Error(w, httptest.NewRequest(http.MethodGet, "/api/user/orders", http.NoBody), tt.err)
+
+			assert.Equal(t, tt.status, w.Code)
+			p := decodeProblem(t, w)
+			assert.Equal(t, tt.code, p.Code)
+			assert.Equal(t, tt.status, p.Status)
+			assert.Empty(t, p.Detail, "error text is not sent to the client")
+		})
+	}
+}
+
+func Test_Problem_Handler(t *testing.T) {
+	mockStorageService, _, _, _, controller := prepare(t)
+	mockStorageService.EXPECT().WithdrawFromUserBalance(gomock.Any(), "testUser", gomock.Any(), gomock.Any()).Return(storage.ErrInsufficientFunds)
+
+	// Через middleware, как в routing: ID запроса из X-Request-Id попадает в ответ
+	handler := middleware.RequestID(controller.AuditMiddleware(controller.RequestForWithdrawal()))
+	req := httptest.NewRequest(http.MethodPost, "/api/user/balance/withdraw", strings.NewReader(`{"order": "2377225624", "sum": 751}`))
+	req.Header.Set(middleware.RequestIDHeader, "req-42")
+	req.Header.Set("Accept-Language", "ru")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, withSession(req, "testUserID"))
+
+	assert.Equal(t, http.StatusPaymentRequired, w.Code)
+	assert.Equal(t, "ru", w.Header().Get("Content-Language"))
+	assert.Equal(t, problem.Problem{
+		Type:      "urn:gophermart:problem:insufficient_funds",
+		Title:     "Недостаточно баллов на счёте",
+		Status:    http.StatusPaymentRequired,
+		Instance:  "/api/user/balance/withdraw",
+		Code:      "insufficient_funds",
+		RequestID: "req-42",
+	}, decodeProblem(t, w))
+}
+
+func Test_NotFound_MethodNotAllowed(t *testing.T) {
+	_, _, _, _, controller := prepare(t)
+
+	w := httptest.NewRecorder()
+	controller.NotFound().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/unknown", http.NoBody))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "not_found", decodeProblem(t, w).Code)
+
+	w = httptest.NewRecorder()
+	controller.MethodNotAllowed().ServeHTTP(w, httptest.NewRequest(http.MethodPut, "/api/user/orders", http.NoBody))
+	assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+	assert.Equal(t, "method_not_allowed", decodeProblem(t, w).Code)
+}
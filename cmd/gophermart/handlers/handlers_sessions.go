@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"gophermart/cmd/gophermart/problem"
+	"gophermart/cmd/gophermart/storage"
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// Logout закрывает сессию из cookie или сессию, в которой выдан Bearer-токен.
+// Выданный в закрытой сессии токен больше не принимается.
+func (con *Controller) Logout() http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		currentID := requestSession(req)
+		userLogin := requestLogin(req)
+		if userLogin == "" {
+			con.Problem(res, req, problem.Unauthorized, "")
+			return
+		}
+
+		sessionID := currentID
+		if sessionID == "" {
+			sessionID = requestTokenSession(req)
+		}
+		if sessionID != "" {
+			err := con.storageService.DeleteSession(req.Context(), userLogin, sessionID)
+			if err != nil && !errors.Is(err, storage.ErrSessionNotFound) {
+				con.Error(res, req, err)
+				return
+			}
+		}
+		if currentID != "" {
+			con.userService.DeleteUserIDCookie(res)
+		}
+
+		con.Debug(res, "Logout success", http.StatusOK)
+	}
+}
+
+// Sessions - действующие сессии пользователя, текущая отмечена current
+func (con *Controller) Sessions() http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		currentID := requestSession(req)
+		userLogin := requestLogin(req)
+		if userLogin == "" {
+			con.Problem(res, req, problem.Unauthorized, "")
+			return
+		}
+
+		sessions, err := con.storageService.GetSessions(req.Context(), userLogin)
+		if err != nil {
+			con.Error(res, req, err)
+			return
+		}
+
+		if len(sessions) == 0 {
+			con.Debug(res, "(Sessions) No Content", http.StatusNoContent)
+			return
+		}
+		for i := range sessions {
+			sessions[i].Current = currentID != "" && sessions[i].ID == currentID
+		}
+
+		res.Header().Set("Content-Type", "application/json")
+		con.setUserIDCookie(res, currentID)
+		res.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(res).Encode(sessions)
+	}
+}
+
+// SessionDelete закрывает сессию пользователя на другом (или этом) устройстве
+// вместе с выданным в ней Bearer-токеном
+func (con *Controller) SessionDelete() http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		currentID := requestSession(req)
+		userLogin := requestLogin(req)
+		if userLogin == "" {
+			con.Problem(res, req, problem.Unauthorized, "")
+			return
+		}
+
+		sessionID := chi.URLParam(req, "id")
+		err := con.storageService.DeleteSession(req.Context(), userLogin, sessionID)
+		if err != nil {
+			con.Error(res, req, err)
+			return
+		}
+
+		if sessionID == currentID {
+			con.userService.DeleteUserIDCookie(res)
+		} else {
+			con.setUserIDCookie(res, currentID)
+		}
+		con.Debug(res, "Session deleted", http.StatusOK)
+	}
+}
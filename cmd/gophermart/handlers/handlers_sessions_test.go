@@ -0,0 +1,218 @@
+//go:build unit
+// +build unit
+
+package handlers
+
+import (
+	"context"
+	"errors"
+	"gophermart/cmd/gophermart/mocks"
+	"gophermart/cmd/gophermart/models"
+	"gophermart/cmd/gophermart/storage"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Logout(t *testing.T) {
+	errSessionNotFound := storage.ErrSessionNotFound
+
+	tests := []struct {
+		name           string
+		userID         string
+		login          string // вход по Bearer-токену
+		mockSetup      func(storage *mocks.MockStorageService, userSrv *mocks.MockUserService)
+		expectedStatus int
+	}{
+		{
+			name:   "Successful Logout",
+			userID: "testUserID",
+			mockSetup: func(storage *mocks.MockStorageService, userSrv *mocks.MockUserService) {
+				storage.EXPECT().DeleteSession(gomock.Any(), "testUser", "testUserID").Return(nil)
+				userSrv.EXPECT().DeleteUserIDCookie(gomock.Any())
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:   "Session already deleted",
+			userID: "testUserID",
+			mockSetup: func(storage *mocks.MockStorageService, userSrv *mocks.MockUserService) {
+				storage.EXPECT().DeleteSession(gomock.Any(), "testUser", "testUserID").Return(errSessionNotFound)
+				userSrv.EXPECT().DeleteUserIDCookie(gomock.Any())
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:  "Bearer token closes its session",
+			login: "testUser",
+			mockSetup: func(storage *mocks.MockStorageService, _ *mocks.MockUserService) {
+				storage.EXPECT().DeleteSession(gomock.Any(), "testUser", "tokenSessionID").Return(nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:   "Unauthorized",
+			userID: "unknownUserID",
+			mockSetup: func(*mocks.MockStorageService, *mocks.MockUserService) {
+			},
+			expectedStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorageService, _, mockUserService, _, controller := prepare(t)
+			tt.mockSetup(mockStorageService, mockUserService)
+
+			req := httptest.NewRequest(http.MethodPost, "/api/user/logout", http.NoBody)
+			req = withSession(req, tt.userID)
+			if tt.login != "" {
+				ctx := context.WithValue(req.Context(), loginCtxKey, tt.login)
+				req = req.WithContext(context.WithValue(ctx, tokenSessionCtxKey, "tokenSessionID"))
+			}
+			w := httptest.NewRecorder()
+
+			controller.Logout()(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+		})
+	}
+}
+
+func Test_Sessions(t *testing.T) {
+	createdAt := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	sessions := []models.Session{
+		{ID: "testUserID", Login: "testUser", CreatedAt: createdAt, LastSeenAt: createdAt.Add(time.Hour),
+			UserAgent: "curl/8.0", IP: "127.0.0.1", ExpiresAt: createdAt.Add(720 * time.Hour)},
+		{ID: "otherDeviceID", Login: "testUser", CreatedAt: createdAt, LastSeenAt: createdAt,
+			ExpiresAt: createdAt.Add(720 * time.Hour)},
+	}
+
+	tests := []struct {
+		name           string
+		userID         string
+		mockSetup      func(storage *mocks.MockStorageService, userSrv *mocks.MockUserService)
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:   "Successful Getting Sessions",
+			userID: "testUserID",
+			mockSetup: func(storage *mocks.MockStorageService, userSrv *mocks.MockUserService) {
+				storage.EXPECT().GetSessions(gomock.Any(), "testUser").Return(sessions, nil)
+				userSrv.EXPECT().SetUserIDCookie(gomock.Any(), "testUserID").Return(nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody: `[
+				{"id": "testUserID", "created_at": "2024-01-01T12:00:00Z", "last_seen_at": "2024-01-01T13:00:00Z",
+				 "user_agent": "curl/8.0", "ip": "127.0.0.1", "expires_at": "2024-01-31T12:00:00Z", "current": true},
+				{"id": "otherDeviceID", "created_at": "2024-01-01T12:00:00Z", "last_seen_at": "2024-01-01T12:00:00Z",
+				 "expires_at": "2024-01-31T12:00:00Z"}
+			]`,
+		},
+		{
+			name:   "Unauthorized",
+			userID: "unknownUserID",
+			mockSetup: func(*mocks.MockStorageService, *mocks.MockUserService) {
+			},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:   "Internal Server Error",
+			userID: "testUserID",
+			mockSetup: func(storage *mocks.MockStorageService, _ *mocks.MockUserService) {
+				storage.EXPECT().GetSessions(gomock.Any(), "testUser").Return(nil, errors.New("connection refused"))
+			},
+			expectedStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorageService, _, mockUserService, _, controller := prepare(t)
+			tt.mockSetup(mockStorageService, mockUserService)
+
+			req := withSession(httptest.NewRequest(http.MethodGet, "/api/user/sessions", http.NoBody), tt.userID)
+			w := httptest.NewRecorder()
+
+			controller.Sessions()(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			if tt.expectedBody != "" {
+				assert.JSONEq(t, tt.expectedBody, w.Body.String())
+			}
+		})
+	}
+}
+
+func Test_SessionDelete(t *testing.T) {
+	errSessionNotFound := storage.ErrSessionNotFound
+
+	tests := []struct {
+		name           string
+		userID         string
+		sessionID      string
+		mockSetup      func(storage *mocks.MockStorageService, userSrv *mocks.MockUserService)
+		expectedStatus int
+	}{
+		{
+			name:      "Other device",
+			userID:    "testUserID",
+			sessionID: "otherDeviceID",
+			mockSetup: func(storage *mocks.MockStorageService, userSrv *mocks.MockUserService) {
+				storage.EXPECT().DeleteSession(gomock.Any(), "testUser", "otherDeviceID").Return(nil)
+				userSrv.EXPECT().SetUserIDCookie(gomock.Any(), "testUserID").Return(nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:      "Current session",
+			userID:    "testUserID",
+			sessionID: "testUserID",
+			mockSetup: func(storage *mocks.MockStorageService, userSrv *mocks.MockUserService) {
+				storage.EXPECT().DeleteSession(gomock.Any(), "testUser", "testUserID").Return(nil)
+				userSrv.EXPECT().DeleteUserIDCookie(gomock.Any())
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:      "Not Found",
+			userID:    "testUserID",
+			sessionID: "foreignSessionID",
+			mockSetup: func(storage *mocks.MockStorageService, _ *mocks.MockUserService) {
+				storage.EXPECT().DeleteSession(gomock.Any(), "testUser", "foreignSessionID").Return(errSessionNotFound)
+			},
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:      "Unauthorized",
+			userID:    "unknownUserID",
+			sessionID: "otherDeviceID",
+			mockSetup: func(*mocks.MockStorageService, *mocks.MockUserService) {
+			},
+			expectedStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorageService, _, mockUserService, _, controller := prepare(t)
+			tt.mockSetup(mockStorageService, mockUserService)
+
+			r := chi.NewRouter()
+			r.Delete("/api/user/sessions/{id}", controller.SessionDelete())
+
+			req := withSession(httptest.NewRequest(http.MethodDelete, "/api/user/sessions/"+tt.sessionID, http.NoBody), tt.userID)
+			w := httptest.NewRecorder()
+
+			r.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+		})
+	}
+}
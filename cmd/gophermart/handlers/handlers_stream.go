@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"gophermart/cmd/gophermart/metrics"
+	"gophermart/cmd/gophermart/models"
+	"gophermart/cmd/gophermart/problem"
+	"gophermart/cmd/gophermart/pubsub"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const sseRetry = 3 * time.Second
+
+// sseHeartbeatInterval - как часто отправлять комментарий, чтобы прокси не закрывали простаивающее соединение
+var sseHeartbeatInterval = 15 * time.Second
+
+// OrdersStream - Server-Sent Events об изменениях заказов пользователя.
+// С заголовком Last-Event-ID отдаёт пропущенные события, а если их уже нет - текущее состояние заказов.
+func (con *Controller) OrdersStream() http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		sessionID := requestSession(req)
+		userLogin := requestLogin(req)
+		if userLogin == "" {
+			con.Problem(res, req, problem.Unauthorized, "")
+			return
+		}
+
+		var lastEventID uint64
+		if header := req.Header.Get("Last-Event-ID"); header != "" {
+			id, err := strconv.ParseUint(header, 10, 64)
+			if err != nil {
+				con.Problem(res, req, problem.InvalidParameter, "invalid Last-Event-ID")
+				return
+			}
+			lastEventID = id
+		}
+
+		sub, missed, resumed, lastID := con.events.Subscribe(userLogin, lastEventID)
+		defer con.events.Unsubscribe(sub)
+		metrics.SSEConnections.Inc()
+		defer metrics.SSEConnections.Dec()
+
+		var snapshot []models.Order
+		if !resumed {
+			orders, err := con.storageService.GetOrders(req.Context(), userLogin, models.ListQuery{})
+			if err != nil {
+				con.Error(res, req, err)
+				return
+			}
+			snapshot = orders
+		}
+
+		rc := http.NewResponseController(res)
+		res.Header().Set("Content-Type", "text/event-stream")
+		res.Header().Set("Cache-Control", "no-cache")
+		res.Header().Set("Connection", "keep-alive")
+		res.Header().Set("X-Accel-Buffering", "no")
+		con.setUserIDCookie(res, sessionID)
+		res.WriteHeader(http.StatusOK)
+
+		_, _ = fmt.Fprintf(res, "retry: %d\n\n", sseRetry.Milliseconds())
+		for _, order := range snapshot {
+			event := pubsub.Event{ID: lastID, Login: userLogin, Order: models.OrderEvent{
+				Number:    order.Number,
+				Status:    order.Status,
+				Accrual:   order.Accrual,
+				ChangedAt: order.UploadedAt,
+			}}
+			if err := writeEvent(res, event); err != nil {
+				return
+			}
+		}
+		for _, event := range missed {
+			if err := writeEvent(res, event); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			con.sugar.Errorf("(OrdersStream) streaming is not supported: %v", err)
+			return
+		}
+
+		heartbeat := time.NewTicker(sseHeartbeatInterval)
+		defer heartbeat.Stop()
+
+		for {
+			select {
+			case <-req.Context().Done():
+				return
+			case event, ok := <-sub.Events():
+				if !ok { // отключены брокером, клиент переподключится с Last-Event-ID
+					return
+				}
+				if err := writeEvent(res, event); err != nil {
+					return
+				}
+			case <-heartbeat.C:
+				if _, err := io.WriteString(res, ": heartbeat\n\n"); err != nil {
+					return
+				}
+			}
+			if err := rc.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func writeEvent(w io.Writer, event pubsub.Event) error {
+	data, err := json.Marshal(event.Order)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\nevent: order\ndata: %s\n\n", event.ID, data)
+	return err
+}
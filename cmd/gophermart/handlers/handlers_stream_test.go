@@ -0,0 +1,169 @@
+//go:build unit
+// +build unit
+
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"gophermart/cmd/gophermart/mocks"
+	"gophermart/cmd/gophermart/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// openStream - подключиться к потоку событий, соединение закрывается в конце теста
+func openStream(t *testing.T, srv *httptest.Server, lastEventID string) *bufio.Reader {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, http.NoBody)
+	require.NoError(t, err)
+	if lastEventID != "" {
+		req.Header.Set("Last-Event-ID", lastEventID)
+	}
+
+	res, err := srv.Client().Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = res.Body.Close() })
+
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+
+	reader := bufio.NewReader(res.Body)
+	assert.Equal(t, "retry: 3000\n", readBlock(t, reader))
+	return reader
+}
+
+// newStreamServer - OrdersStream для пользователя с сессией testUserID
+func newStreamServer(t *testing.T, controller *Controller) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		controller.OrdersStream()(res, withSession(req, "testUserID"))
+	}))
+	t.Cleanup(srv.Close) // после закрытия клиентских соединений в openStream
+	return srv
+}
+
+// readBlock - прочитать одно сообщение SSE (до пустой строки)
+func readBlock(t *testing.T, r *bufio.Reader) string {
+	var block strings.Builder
+	for {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		if line == "\n" {
+			return block.String()
+		}
+		block.WriteString(line)
+	}
+}
+
+func Test_OrdersStream_Errors(t *testing.T) {
+	tests := []struct {
+		name           string
+		userID         string
+		lastEventID    string
+		expectedStatus int
+	}{
+		{
+			name:           "Unauthorized",
+			userID:         "unknownUserID",
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:           "Invalid Last-Event-ID",
+			userID:         "testUserID",
+			lastEventID:    "abc",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, _, _, controller := prepare(t)
+
+			req := withSession(httptest.NewRequest(http.MethodGet, "/api/user/orders/stream", http.NoBody), tt.userID)
+			if tt.lastEventID != "" {
+				req.Header.Set("Last-Event-ID", tt.lastEventID)
+			}
+			w := httptest.NewRecorder()
+
+			controller.OrdersStream()(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+		})
+	}
+}
+
+func Test_OrdersStream(t *testing.T) {
+	uploadedAt := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	changedAt := uploadedAt.Add(time.Minute)
+
+	_, _, mockUserService, _, controller := prepareWithTasks(t,
+		func(storage *mocks.MockStorageService, _ *mocks.MockAccrualClient) {
+			// Текущее состояние запрашивается только при подключении без Last-Event-ID
+			storage.EXPECT().GetOrders(gomock.Any(), "testUser", models.ListQuery{}).Return([]models.Order{
+				{Number: "12345678903", Status: "NEW", UploadedAt: uploadedAt},
+			}, nil)
+		})
+	mockUserService.EXPECT().SetUserIDCookie(gomock.Any(), "testUserID").Return(nil).Times(2)
+
+	srv := newStreamServer(t, controller)
+
+	stream := openStream(t, srv, "")
+	assert.Equal(t, "id: 0\nevent: order\n"+
+		`data: {"number":"12345678903","status":"NEW","changed_at":"2024-01-01T12:00:00Z"}`+"\n",
+		readBlock(t, stream))
+
+	controller.events.Publish("anotherUser", models.OrderEvent{Number: "79927398713", Status: "PROCESSING", ChangedAt: changedAt})
+	controller.events.Publish("testUser", models.OrderEvent{Number: "12345678903", Status: "PROCESSING", ChangedAt: changedAt})
+	assert.Equal(t, "id: 2\nevent: order\n"+
+		`data: {"number":"12345678903","status":"PROCESSING","changed_at":"2024-01-01T12:01:00Z"}`+"\n",
+		readBlock(t, stream))
+
+	// Переподключение: событие, опубликованное без клиента, доставляется по Last-Event-ID
+	controller.events.Publish("testUser", models.OrderEvent{Number: "12345678903", Status: "PROCESSED", Accrual: models.NewMoney(10), ChangedAt: changedAt})
+	resumed := openStream(t, srv, "2")
+	assert.Equal(t, "id: 3\nevent: order\n"+
+		`data: {"number":"12345678903","status":"PROCESSED","accrual":10,"changed_at":"2024-01-01T12:01:00Z"}`+"\n",
+		readBlock(t, resumed))
+}
+
+func Test_OrdersStream_Heartbeat(t *testing.T) {
+	defaultInterval := sseHeartbeatInterval
+	sseHeartbeatInterval = 10 * time.Millisecond
+	t.Cleanup(func() { sseHeartbeatInterval = defaultInterval })
+
+	_, _, mockUserService, _, controller := prepareWithTasks(t,
+		func(storage *mocks.MockStorageService, _ *mocks.MockAccrualClient) {
+			storage.EXPECT().GetOrders(gomock.Any(), "testUser", models.ListQuery{}).Return(nil, nil)
+		})
+	mockUserService.EXPECT().SetUserIDCookie(gomock.Any(), "testUserID").Return(nil)
+
+	srv := newStreamServer(t, controller)
+
+	stream := openStream(t, srv, "")
+	assert.Equal(t, ": heartbeat\n", readBlock(t, stream))
+}
+
+func Test_OrdersStream_ClosedBroker(t *testing.T) {
+	_, _, mockUserService, _, controller := prepareWithTasks(t,
+		func(storage *mocks.MockStorageService, _ *mocks.MockAccrualClient) {
+			storage.EXPECT().GetOrders(gomock.Any(), "testUser", models.ListQuery{}).Return(nil, nil)
+		})
+	mockUserService.EXPECT().SetUserIDCookie(gomock.Any(), "testUserID").Return(nil)
+
+	srv := newStreamServer(t, controller)
+
+	stream := openStream(t, srv, "")
+	controller.events.Close()
+
+	_, err := stream.ReadString('\n')
+	assert.Error(t, err, "stream must end when the broker is closed")
+}
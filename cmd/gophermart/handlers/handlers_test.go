@@ -5,28 +5,53 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"gophermart/cmd/gophermart/audit"
 	"gophermart/cmd/gophermart/config"
 	"gophermart/cmd/gophermart/logger"
 	"gophermart/cmd/gophermart/mocks"
 	"gophermart/cmd/gophermart/models"
+	"gophermart/cmd/gophermart/pubsub"
 	"gophermart/cmd/gophermart/storage"
 	"gophermart/cmd/gophermart/user"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/ShiraazMoollatjie/goluhn"
+	"github.com/go-chi/chi/v5"
 	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
 )
 
 func prepare(t *testing.T) (*mocks.MockStorageService, *mocks.MockStorageUtils, *mocks.MockUserService, *mocks.MockAccrualClient, *Controller) {
 	ctrl := gomock.NewController(t)
 	defer ctrl.Finish()
 
+	return prepareController(t, ctrl, nil)
+}
+
+// prepareWithTasks - воркеры AccrualQueue по одной получат tasks из хранилища, после чего очередь пуста.
+// setup вызывается до запуска воркеров, ожидания проверяются в конце теста.
+func prepareWithTasks(t *testing.T, setup func(*mocks.MockStorageService, *mocks.MockAccrualClient), tasks ...Task) (*mocks.MockStorageService, *mocks.MockStorageUtils, *mocks.MockUserService, *mocks.MockAccrualClient, *Controller) {
+	return prepareController(t, gomock.NewController(t), setup, tasks...)
+}
+
+func testTokenService(t *testing.T) *user.JWT {
+	tokens, err := user.NewJWT(user.AlgHS256, []byte("0123456789abcdef0123456789abcdef"), "gophermart", "gophermart", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tokens
+}
+
+func prepareController(t *testing.T, ctrl *gomock.Controller, setup func(*mocks.MockStorageService, *mocks.MockAccrualClient), tasks ...Task) (*mocks.MockStorageService, *mocks.MockStorageUtils, *mocks.MockUserService, *mocks.MockAccrualClient, *Controller) {
 	sugarLogger, _ := logger.NewLogger()
 	conf := config.NewConfig()
 	// _ = config.Init(conf) // TODO ???
@@ -36,18 +61,76 @@ func prepare(t *testing.T) (*mocks.MockStorageService, *mocks.MockStorageUtils,
 	mockUserService := mocks.NewMockUserService(ctrl)
 	mockAccrualClient := mocks.NewMockAccrualClient(ctrl)
 
-	controller := NewController(conf, mockStorageService, mockStorageUtils, sugarLogger, mockUserService, wp, mockAccrualClient)
+	for _, task := range tasks {
+		mockStorageService.EXPECT().ClaimAccrualTasks(gomock.Any(), 1, gomock.Any()).Return([]Task{task}, nil)
+	}
+	mockStorageService.EXPECT().ClaimAccrualTasks(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil, nil).AnyTimes()
+	mockStorageService.EXPECT().CountPendingAccrualTasks(gomock.Any()).Return(len(tasks), nil).AnyTimes()
+	if setup != nil {
+		setup(mockStorageService, mockAccrualClient)
+	}
+
+	controller := NewController(conf, mockStorageService, mockStorageUtils, sugarLogger, mockUserService, wp, mockAccrualClient,
+		pubsub.NewBroker(100), testTokenService(t), user.NewPasswordPolicy(8, 2), nil,
+		user.NewTOTP("gophermart"))
+	t.Cleanup(func() {
+		// Воркеры не должны обращаться к мокам после завершения теста
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		wp.Wait(ctx)
+	})
 
 	// mockAccrualClient.EXPECT().RegisterRewards().Times(1)
 	return mockStorageService, mockStorageUtils, mockUserService, mockAccrualClient, controller
 }
 
+// testSessions - сессии (ID -> логин), которые пропустил бы AuthenticateMiddleware
+var testSessions = map[string]string{
+	"testUserID":  "testUser",
+	"otherUserID": "otherUser",
+}
+
+// withSession - запрос после AuthenticateMiddleware; для неизвестного sessionID запрос не аутентифицирован
+func withSession(req *http.Request, sessionID string) *http.Request {
+	login, ok := testSessions[sessionID]
+	if !ok {
+		return req
+	}
+	ctx := context.WithValue(req.Context(), loginCtxKey, login)
+	return req.WithContext(context.WithValue(ctx, sessionCtxKey, sessionID))
+}
+
+// newSessionOf - сессия создаётся с новым ID, а не с ID из cookie запроса
+type newSessionOf string
+
+func (m newSessionOf) Matches(x interface{}) bool {
+	session, ok := x.(models.Session)
+	return ok && session.Login == string(m) && newSessionID{}.Matches(session.ID) &&
+		session.ExpiresAt.After(session.CreatedAt)
+}
+
+func (m newSessionOf) String() string {
+	return "new session of " + string(m)
+}
+
+type newSessionID struct{}
+
+func (newSessionID) Matches(x interface{}) bool {
+	id, ok := x.(string)
+	return ok && id != "" && id != "testUserID"
+}
+
+func (newSessionID) String() string {
+	return "new session ID"
+}
+
 func Test_Register(t *testing.T) {
 	tests := []struct {
 		name           string
 		requestBody    user.User
 		mockSetup      func(storage *mocks.MockStorageService, storageUtils *mocks.MockStorageUtils, userSrv *mocks.MockUserService)
 		expectedStatus int
+		expectedBody   string
 	}{
 		{
 			name: "Successful Register",
@@ -57,13 +140,14 @@ func Test_Register(t *testing.T) {
 			},
 			mockSetup: func(storage *mocks.MockStorageService, storageUtils *mocks.MockStorageUtils, userSrv *mocks.MockUserService) {
 				// Ожидания для методов, вызываемых в handleAuth
-				storage.EXPECT().GetHashedPasswordByLogin("testUser").Return("hashedPassword")
+				storage.EXPECT().GetHashedPasswordByLogin(gomock.Any(), "testUser").Return("hashedPassword")
 				storageUtils.EXPECT().CheckPasswordHash("testPassword", "hashedPassword").Return(true)
-				storage.EXPECT().SaveUID("testUserID", "testUser").Return(nil)
+				storage.EXPECT().CreateSession(gomock.Any(), newSessionOf("testUser")).Return(nil)
 
 				storageUtils.EXPECT().HashPassword("testPassword").Return("hashedPassword", nil)
-				storage.EXPECT().SaveLoginPassword("testUser", "hashedPassword").Return(true)
-				userSrv.EXPECT().SetUserIDCookie(gomock.Any(), "testUserID").Return(nil)
+				storage.EXPECT().SaveLoginPassword(gomock.Any(), "testUser", "hashedPassword").Return(true)
+				expectAudit(storage, audit.ActionUserRegistered, "testUser")
+				userSrv.EXPECT().SetUserIDCookie(gomock.Any(), newSessionID{}).Return(nil)
 			},
 			expectedStatus: http.StatusOK,
 		},
@@ -75,7 +159,7 @@ func Test_Register(t *testing.T) {
 			},
 			mockSetup: func(storage *mocks.MockStorageService, storageUtils *mocks.MockStorageUtils, userSrv *mocks.MockUserService) {
 				storageUtils.EXPECT().HashPassword("testPasswordDuplicate").Return("hashedPasswordDuplicate", nil)
-				storage.EXPECT().SaveLoginPassword("testUserDuplicate", "hashedPasswordDuplicate").Return(false)
+				storage.EXPECT().SaveLoginPassword(gomock.Any(), "testUserDuplicate", "hashedPasswordDuplicate").Return(false)
 			},
 			expectedStatus: http.StatusConflict,
 		},
@@ -90,6 +174,21 @@ func Test_Register(t *testing.T) {
 			},
 			expectedStatus: http.StatusInternalServerError,
 		},
+		{
+			name: "Weak Password",
+			requestBody: user.User{
+				Login:    "testUser",
+				Password: "short",
+			},
+			mockSetup: func(_ *mocks.MockStorageService, _ *mocks.MockStorageUtils, _ *mocks.MockUserService) {
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody: `{"type": "urn:gophermart:problem:weak_password", "title": "Password does not meet the policy",
+				"status": 400, "code": "weak_password", "instance": "/api/user/register", "violations": [
+				{"rule": "min_length", "message": "password must be at least 8 characters long"},
+				{"rule": "char_classes", "message": "password must contain at least 2 of: lowercase letters, uppercase letters, digits, symbols"}
+			]}`,
+		},
 	}
 
 	for _, tt := range tests {
@@ -99,7 +198,6 @@ func Test_Register(t *testing.T) {
 
 			reqBody, _ := json.Marshal(tt.requestBody)
 			req := httptest.NewRequest("POST", "/api/user/register", bytes.NewReader(reqBody))
-			req.Header.Set("User-ID", "testUserID")
 			w := httptest.NewRecorder()
 
 			handler := controller.Register()
@@ -109,6 +207,10 @@ func Test_Register(t *testing.T) {
 			if resp.StatusCode != tt.expectedStatus {
 				t.Errorf("expected status %v; got %v", tt.expectedStatus, resp.StatusCode)
 			}
+			if tt.expectedBody != "" {
+				body, _ := io.ReadAll(resp.Body)
+				assert.JSONEq(t, tt.expectedBody, string(body))
+			}
 			resp.Body.Close()
 		})
 	}
@@ -128,13 +230,63 @@ func Test_Login(t *testing.T) {
 				Password: "testPassword",
 			},
 			mockSetup: func(storage *mocks.MockStorageService, storageUtils *mocks.MockStorageUtils, userSrv *mocks.MockUserService) {
-				storage.EXPECT().GetHashedPasswordByLogin("testUser").Return("hashedPassword")
+				storage.EXPECT().GetHashedPasswordByLogin(gomock.Any(), "testUser").Return("hashedPassword")
 				storageUtils.EXPECT().CheckPasswordHash("testPassword", "hashedPassword").Return(true)
-				storage.EXPECT().SaveUID("testUserID", "testUser").Return(nil)
-				userSrv.EXPECT().SetUserIDCookie(gomock.Any(), "testUserID").Return(nil)
+				storage.EXPECT().GetUser(gomock.Any(), "testUser").Return(models.Account{Login: "testUser", Role: models.RoleUser}, nil)
+				storage.EXPECT().GetTOTP(gomock.Any(), "testUser").Return(nil, nil)
+				expectAudit(storage, audit.ActionLoginSucceeded, "testUser")
+				storage.EXPECT().CreateSession(gomock.Any(), newSessionOf("testUser")).Return(nil)
+				userSrv.EXPECT().SetUserIDCookie(gomock.Any(), newSessionID{}).Return(nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "Second factor required",
+			requestBody: user.User{
+				Login:    "testUser",
+				Password: "testPassword",
+			},
+			mockSetup: func(storage *mocks.MockStorageService, storageUtils *mocks.MockStorageUtils, _ *mocks.MockUserService) {
+				storage.EXPECT().GetHashedPasswordByLogin(gomock.Any(), "testUser").Return("hashedPassword")
+				storageUtils.EXPECT().CheckPasswordHash("testPassword", "hashedPassword").Return(true)
+				storage.EXPECT().GetUser(gomock.Any(), "testUser").Return(models.Account{Login: "testUser", Role: models.RoleUser}, nil)
+				storage.EXPECT().GetTOTP(gomock.Any(), "testUser").Return(&models.TOTP{Secret: "SECRET", Enabled: true}, nil)
+				// Сессия не открывается до проверки кода
+				storage.EXPECT().CreateMFAChallenge(gomock.Any(), gomock.Any()).Return(nil)
+			},
+			expectedStatus: http.StatusAccepted,
+		},
+		{
+			name: "Unconfirmed TOTP enrollment is ignored",
+			requestBody: user.User{
+				Login:    "testUser",
+				Password: "testPassword",
+			},
+			mockSetup: func(storage *mocks.MockStorageService, storageUtils *mocks.MockStorageUtils, userSrv *mocks.MockUserService) {
+				storage.EXPECT().GetHashedPasswordByLogin(gomock.Any(), "testUser").Return("hashedPassword")
+				storageUtils.EXPECT().CheckPasswordHash("testPassword", "hashedPassword").Return(true)
+				storage.EXPECT().GetUser(gomock.Any(), "testUser").Return(models.Account{Login: "testUser", Role: models.RoleUser}, nil)
+				storage.EXPECT().GetTOTP(gomock.Any(), "testUser").Return(&models.TOTP{Secret: "SECRET"}, nil)
+				expectAudit(storage, audit.ActionLoginSucceeded, "testUser")
+				storage.EXPECT().CreateSession(gomock.Any(), newSessionOf("testUser")).Return(nil)
+				userSrv.EXPECT().SetUserIDCookie(gomock.Any(), newSessionID{}).Return(nil)
 			},
 			expectedStatus: http.StatusOK,
 		},
+		{
+			name: "Locked account",
+			requestBody: user.User{
+				Login:    "testUser",
+				Password: "testPassword",
+			},
+			mockSetup: func(storage *mocks.MockStorageService, storageUtils *mocks.MockStorageUtils, _ *mocks.MockUserService) {
+				lockedAt := time.Now()
+				storage.EXPECT().GetHashedPasswordByLogin(gomock.Any(), "testUser").Return("hashedPassword")
+				storageUtils.EXPECT().CheckPasswordHash("testPassword", "hashedPassword").Return(true)
+				storage.EXPECT().GetUser(gomock.Any(), "testUser").Return(models.Account{Login: "testUser", Role: models.RoleUser, LockedAt: &lockedAt}, nil)
+			},
+			expectedStatus: http.StatusForbidden,
+		},
 		{
 			name: "Invalid Password",
 			requestBody: user.User{
@@ -142,8 +294,9 @@ func Test_Login(t *testing.T) {
 				Password: "wrongPassword",
 			},
 			mockSetup: func(storage *mocks.MockStorageService, storageUtils *mocks.MockStorageUtils, _ *mocks.MockUserService) {
-				storage.EXPECT().GetHashedPasswordByLogin("testUser").Return("hashedPassword")
+				storage.EXPECT().GetHashedPasswordByLogin(gomock.Any(), "testUser").Return("hashedPassword")
 				storageUtils.EXPECT().CheckPasswordHash("wrongPassword", "hashedPassword").Return(false)
+				expectAudit(storage, audit.ActionLoginFailed, "testUser")
 			},
 			expectedStatus: http.StatusUnauthorized,
 		},
@@ -154,7 +307,8 @@ func Test_Login(t *testing.T) {
 				Password: "somePassword",
 			},
 			mockSetup: func(storage *mocks.MockStorageService, storageUtils *mocks.MockStorageUtils, _ *mocks.MockUserService) {
-				storage.EXPECT().GetHashedPasswordByLogin("unknownUser").Return("")
+				storage.EXPECT().GetHashedPasswordByLogin(gomock.Any(), "unknownUser").Return("")
+				expectAudit(storage, audit.ActionLoginFailed, "unknownUser")
 			},
 			expectedStatus: http.StatusUnauthorized,
 		},
@@ -176,7 +330,6 @@ func Test_Login(t *testing.T) {
 
 			reqBody, _ := json.Marshal(tt.requestBody)
 			req := httptest.NewRequest("POST", "/api/user/login", bytes.NewReader(reqBody))
-			req.Header.Set("User-ID", "testUserID")
 			w := httptest.NewRecorder()
 
 			handler := controller.Login()
@@ -186,6 +339,14 @@ func Test_Login(t *testing.T) {
 			if resp.StatusCode != tt.expectedStatus {
 				t.Errorf("expected status %v; got %v", tt.expectedStatus, resp.StatusCode)
 			}
+			if resp.StatusCode == http.StatusOK {
+				token, found := strings.CutPrefix(resp.Header.Get("Authorization"), "Bearer ")
+				assert.True(t, found)
+				claims, err := controller.tokenService.ParseToken(token)
+				assert.NoError(t, err)
+				assert.Equal(t, tt.requestBody.Login, claims.Login)
+				assert.NotEmpty(t, claims.SessionID, "the token is bound to the new session")
+			}
 			resp.Body.Close()
 		})
 	}
@@ -209,9 +370,9 @@ func Test_OrdersUpload(t *testing.T) {
 			contentType: "text/plain",
 			body:        orderNumber,
 			mockSetup: func(storage *mocks.MockStorageService, userSrv *mocks.MockUserService, accSrv *mocks.MockAccrualClient) {
-				storage.EXPECT().GetLoginByUID("testUserID").Return("testUser")
-				accSrv.EXPECT().MakePurchase(orderNumber)
-				storage.EXPECT().AddOrder("testUser", orderNumberInt).Return(true, nil)
+				accSrv.EXPECT().MakePurchase(gomock.Any(), orderNumber)
+				storage.EXPECT().AddOrder(gomock.Any(), "testUser", orderNumberInt).Return(true, nil)
+				expectAudit(storage, audit.ActionOrderUploaded, "testUser")
 				userSrv.EXPECT().SetUserIDCookie(gomock.Any(), "testUserID").Return(nil)
 			},
 			expectedStatus: http.StatusAccepted,
@@ -222,7 +383,6 @@ func Test_OrdersUpload(t *testing.T) {
 			contentType: "text/plain",
 			body:        orderNumber,
 			mockSetup: func(storage *mocks.MockStorageService, _ *mocks.MockUserService, _ *mocks.MockAccrualClient) {
-				storage.EXPECT().GetLoginByUID("unknownUserID").Return("")
 			},
 			expectedStatus: http.StatusUnauthorized,
 		},
@@ -232,7 +392,6 @@ func Test_OrdersUpload(t *testing.T) {
 			contentType: "application/json", // должен быть "text/plain"
 			body:        orderNumber,
 			mockSetup: func(storage *mocks.MockStorageService, _ *mocks.MockUserService, _ *mocks.MockAccrualClient) {
-				storage.EXPECT().GetLoginByUID("testUserID").Return("testUser")
 			},
 			expectedStatus: http.StatusBadRequest,
 		},
@@ -242,9 +401,9 @@ func Test_OrdersUpload(t *testing.T) {
 			contentType: "text/plain",
 			body:        orderNumber,
 			mockSetup: func(storage *mocks.MockStorageService, userSrv *mocks.MockUserService, accSrv *mocks.MockAccrualClient) {
-				storage.EXPECT().GetLoginByUID("testUserID").Return("testUser")
-				accSrv.EXPECT().MakePurchase(orderNumber)
-				storage.EXPECT().AddOrder("testUser", orderNumberInt).Return(true, errAddOrderConflict)
+				accSrv.EXPECT().MakePurchase(gomock.Any(), orderNumber)
+				storage.EXPECT().AddOrder(gomock.Any(), "testUser", orderNumberInt).Return(true, errAddOrderConflict)
+				expectAudit(storage, audit.ActionOrderConflict, "testUser")
 			},
 			expectedStatus: http.StatusConflict,
 		},
@@ -254,9 +413,8 @@ func Test_OrdersUpload(t *testing.T) {
 			contentType: "text/plain",
 			body:        "12345678", // неподходящий номер
 			mockSetup: func(storage *mocks.MockStorageService, userSrv *mocks.MockUserService, accSrv *mocks.MockAccrualClient) {
-				storage.EXPECT().GetLoginByUID("testUserID").Return("testUser")
-				accSrv.EXPECT().MakePurchase("12345678")
-				storage.EXPECT().AddOrder("testUser", 12345678).Return(true, errors.New("some err"))
+				accSrv.EXPECT().MakePurchase(gomock.Any(), "12345678")
+				storage.EXPECT().AddOrder(gomock.Any(), "testUser", 12345678).Return(true, errors.New("some err"))
 			},
 			expectedStatus: http.StatusUnprocessableEntity,
 		},
@@ -266,9 +424,8 @@ func Test_OrdersUpload(t *testing.T) {
 			contentType: "text/plain",
 			body:        orderNumber,
 			mockSetup: func(storage *mocks.MockStorageService, userSrv *mocks.MockUserService, accSrv *mocks.MockAccrualClient) {
-				storage.EXPECT().GetLoginByUID("testUserID").Return("testUser")
-				accSrv.EXPECT().MakePurchase(orderNumber)
-				storage.EXPECT().AddOrder("testUser", orderNumberInt).Return(true, errors.New("not ErrAddOrderConflict"))
+				accSrv.EXPECT().MakePurchase(gomock.Any(), orderNumber)
+				storage.EXPECT().AddOrder(gomock.Any(), "testUser", orderNumberInt).Return(true, errors.New("not ErrAddOrderConflict"))
 			},
 			expectedStatus: http.StatusInternalServerError,
 		},
@@ -278,9 +435,8 @@ func Test_OrdersUpload(t *testing.T) {
 			contentType: "text/plain",
 			body:        orderNumber,
 			mockSetup: func(storage *mocks.MockStorageService, userSrv *mocks.MockUserService, accSrv *mocks.MockAccrualClient) {
-				storage.EXPECT().GetLoginByUID("testUserID").Return("testUser")
-				accSrv.EXPECT().MakePurchase(orderNumber)
-				storage.EXPECT().AddOrder("testUser", orderNumberInt).Return(false, nil)
+				accSrv.EXPECT().MakePurchase(gomock.Any(), orderNumber)
+				storage.EXPECT().AddOrder(gomock.Any(), "testUser", orderNumberInt).Return(false, nil)
 				userSrv.EXPECT().SetUserIDCookie(gomock.Any(), "testUserID").Return(nil)
 			},
 			expectedStatus: http.StatusOK,
@@ -293,7 +449,7 @@ func Test_OrdersUpload(t *testing.T) {
 			tt.mockSetup(mockStorageService, mockUserService, mockAccrualClient)
 
 			req := httptest.NewRequest("POST", "/api/user/orders", bytes.NewReader([]byte(tt.body)))
-			req.Header.Set("User-ID", tt.userID)
+			req = withSession(req, tt.userID)
 			req.Header.Set("Content-Type", tt.contentType)
 			w := httptest.NewRecorder()
 
@@ -324,17 +480,16 @@ func Test_OrdersGet(t *testing.T) {
 			name:   "Successful Getting Orders",
 			userID: "testUserID",
 			mockSetup: func(storage *mocks.MockStorageService, userSrv *mocks.MockUserService, accSrv *mocks.MockAccrualClient) {
-				storage.EXPECT().GetLoginByUID("testUserID").Return("testUser")
-				accSrv.EXPECT().MakePurchase(orderNumber1)
-				storage.EXPECT().GetOrders("testUser").Return([]models.Order{
-					{Number: orderNumber2, Status: "PROCESSED", Accrual: 10.0},
+				accSrv.EXPECT().MakePurchase(gomock.Any(), orderNumber1)
+				storage.EXPECT().GetOrders(gomock.Any(), "testUser", models.ListQuery{}).Return([]models.Order{
+					{Number: orderNumber2, Status: "PROCESSED", Accrual: models.NewMoney(10)},
 					{Number: orderNumber1, Status: "PROCESSING"},
 				}, nil)
 				userSrv.EXPECT().SetUserIDCookie(gomock.Any(), "testUserID").Return(nil)
 			},
 			expectedStatus: http.StatusOK,
 			expectedBody: []models.Order{
-				{Number: orderNumber2, Status: "PROCESSED", Accrual: 10.0},
+				{Number: orderNumber2, Status: "PROCESSED", Accrual: models.NewMoney(10)},
 				{Number: orderNumber1, Status: "PROCESSING"},
 			},
 		},
@@ -342,7 +497,6 @@ func Test_OrdersGet(t *testing.T) {
 			name:   "Unauthorized User",
 			userID: "unknownUserID",
 			mockSetup: func(storage *mocks.MockStorageService, userSrv *mocks.MockUserService, accSrv *mocks.MockAccrualClient) {
-				storage.EXPECT().GetLoginByUID("unknownUserID").Return("")
 			},
 			expectedStatus: http.StatusUnauthorized,
 			expectedBody:   nil,
@@ -351,8 +505,7 @@ func Test_OrdersGet(t *testing.T) {
 			name:   "No Content",
 			userID: "testUserID",
 			mockSetup: func(storage *mocks.MockStorageService, userSrv *mocks.MockUserService, accSrv *mocks.MockAccrualClient) {
-				storage.EXPECT().GetLoginByUID("testUserID").Return("testUser")
-				storage.EXPECT().GetOrders("testUser").Return(nil, nil)
+				storage.EXPECT().GetOrders(gomock.Any(), "testUser", models.ListQuery{}).Return(nil, nil)
 			},
 			expectedStatus: http.StatusNoContent,
 			expectedBody:   nil,
@@ -361,8 +514,7 @@ func Test_OrdersGet(t *testing.T) {
 			name:   "Internal Server Error",
 			userID: "testUserID",
 			mockSetup: func(storage *mocks.MockStorageService, userSrv *mocks.MockUserService, accSrv *mocks.MockAccrualClient) {
-				storage.EXPECT().GetLoginByUID("testUserID").Return("testUser")
-				storage.EXPECT().GetOrders("testUser").Return(nil, errors.New("some err"))
+				storage.EXPECT().GetOrders(gomock.Any(), "testUser", models.ListQuery{}).Return(nil, errors.New("some err"))
 			},
 			expectedStatus: http.StatusInternalServerError,
 			expectedBody:   nil,
@@ -371,8 +523,7 @@ func Test_OrdersGet(t *testing.T) {
 			name:   "Internal Server Error (Can't update balance)",
 			userID: "testUserID",
 			mockSetup: func(storage *mocks.MockStorageService, userSrv *mocks.MockUserService, accSrv *mocks.MockAccrualClient) {
-				storage.EXPECT().GetLoginByUID("testUserID").Return("testUser")
-				storage.EXPECT().GetOrders("testUser").Return(nil, ErrUpdateUserBalance)
+				storage.EXPECT().GetOrders(gomock.Any(), "testUser", models.ListQuery{}).Return(nil, errors.New("error UpdateUserBalance"))
 			},
 			expectedStatus: http.StatusInternalServerError,
 			expectedBody:   nil,
@@ -381,8 +532,7 @@ func Test_OrdersGet(t *testing.T) {
 			name:   "Internal Server Error (Can't update order)",
 			userID: "testUserID",
 			mockSetup: func(storage *mocks.MockStorageService, userSrv *mocks.MockUserService, accSrv *mocks.MockAccrualClient) {
-				storage.EXPECT().GetLoginByUID("testUserID").Return("testUser")
-				storage.EXPECT().GetOrders("testUser").Return(nil, ErrUpdateOrder)
+				storage.EXPECT().GetOrders(gomock.Any(), "testUser", models.ListQuery{}).Return(nil, ErrUpdateOrder)
 			},
 			expectedStatus: http.StatusInternalServerError,
 			expectedBody:   nil,
@@ -397,7 +547,7 @@ func Test_OrdersGet(t *testing.T) {
 			handler := controller.OrdersGet()
 
 			req := httptest.NewRequest("GET", "/api/user/orders", nil)
-			req.Header.Set("User-ID", tt.userID)
+			req = withSession(req, tt.userID)
 			w := httptest.NewRecorder()
 
 			handler.ServeHTTP(w, req)
@@ -424,6 +574,115 @@ func Test_OrdersGet(t *testing.T) {
 	}
 }
 
+func Test_OrderGet(t *testing.T) {
+	orderNumber := goluhn.Generate(10)
+	orderNumberInt, _ := strconv.Atoi(orderNumber)
+	uploadedAt := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	processedAt := uploadedAt.Add(time.Minute)
+	order := models.Order{Login: "testUser", Number: orderNumber, Status: "PROCESSED", Accrual: models.NewMoney(10), UploadedAt: uploadedAt}
+	history := []models.OrderStatusChange{
+		{To: "NEW", ChangedAt: uploadedAt},
+		{From: "NEW", To: "PROCESSED", ChangedAt: processedAt},
+	}
+	errOrderNotFound := storage.ErrOrderNotFound
+
+	tests := []struct {
+		name           string
+		userID         string
+		number         string
+		mockSetup      func(storage *mocks.MockStorageService, userSrv *mocks.MockUserService)
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:   "Successful Getting Order",
+			userID: "testUserID",
+			number: orderNumber,
+			mockSetup: func(storage *mocks.MockStorageService, userSrv *mocks.MockUserService) {
+				storage.EXPECT().GetOrder(gomock.Any(), orderNumberInt).Return(order, nil)
+				storage.EXPECT().GetOrderStatusHistory(gomock.Any(), orderNumberInt).Return(history, nil)
+				storage.EXPECT().GetAccrualTask(gomock.Any(), orderNumberInt).
+					Return(&models.AccrualTaskInfo{State: "done", Attempts: 1, LastError: "accrual is down"}, nil)
+				userSrv.EXPECT().SetUserIDCookie(gomock.Any(), "testUserID").Return(nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody: `{"number":"` + orderNumber + `","status":"PROCESSED","accrual":10,"uploaded_at":"2024-01-01T12:00:00Z",` +
+				`"history":[{"to":"NEW","changed_at":"2024-01-01T12:00:00Z"},{"from":"NEW","to":"PROCESSED","changed_at":"2024-01-01T12:01:00Z"}],` +
+				`"accrual_task":{"state":"done","attempts":1,"last_error":"accrual is down"}}`,
+		},
+		{
+			name:   "Unauthorized User",
+			userID: "unknownUserID",
+			number: orderNumber,
+			mockSetup: func(storage *mocks.MockStorageService, userSrv *mocks.MockUserService) {
+			},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name:   "Invalid Order Number",
+			userID: "testUserID",
+			number: "12345678",
+			mockSetup: func(storage *mocks.MockStorageService, userSrv *mocks.MockUserService) {
+			},
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:   "Order Not Found",
+			userID: "testUserID",
+			number: orderNumber,
+			mockSetup: func(storage *mocks.MockStorageService, userSrv *mocks.MockUserService) {
+				storage.EXPECT().GetOrder(gomock.Any(), orderNumberInt).Return(models.Order{}, errOrderNotFound)
+			},
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:   "Order Of Another User",
+			userID: "otherUserID",
+			number: orderNumber,
+			mockSetup: func(storage *mocks.MockStorageService, userSrv *mocks.MockUserService) {
+				storage.EXPECT().GetOrder(gomock.Any(), orderNumberInt).Return(order, nil)
+			},
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:   "Internal Server Error",
+			userID: "testUserID",
+			number: orderNumber,
+			mockSetup: func(storage *mocks.MockStorageService, userSrv *mocks.MockUserService) {
+				storage.EXPECT().GetOrder(gomock.Any(), orderNumberInt).Return(order, nil)
+				storage.EXPECT().GetOrderStatusHistory(gomock.Any(), orderNumberInt).Return(nil, errors.New("some err"))
+			},
+			expectedStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorageService, _, mockUserService, _, controller := prepare(t)
+			tt.mockSetup(mockStorageService, mockUserService)
+
+			r := chi.NewRouter()
+			r.Get("/api/user/orders/{number}", controller.OrderGet())
+
+			req := httptest.NewRequest("GET", "/api/user/orders/"+tt.number, nil)
+			req = withSession(req, tt.userID)
+			w := httptest.NewRecorder()
+
+			r.ServeHTTP(w, req)
+
+			resp := w.Result()
+			defer resp.Body.Close()
+			if resp.StatusCode != tt.expectedStatus {
+				t.Errorf("expected status %v; got %v", tt.expectedStatus, resp.StatusCode)
+			}
+
+			if tt.expectedBody != "" {
+				body, _ := io.ReadAll(resp.Body)
+				assert.JSONEq(t, tt.expectedBody, string(body))
+			}
+		})
+	}
+}
 func Test_UserBalance(t *testing.T) {
 	errGetUserBalance := storage.ErrGetUserBalance
 	tests := []struct {
@@ -437,21 +696,19 @@ func Test_UserBalance(t *testing.T) {
 			name:   "Successful Getting Balance",
 			userID: "testUserID",
 			mockSetup: func(storage *mocks.MockStorageService, userSrv *mocks.MockUserService) {
-				storage.EXPECT().GetLoginByUID("testUserID").Return("testUser")
-				storage.EXPECT().GetUserBalance("testUser").Return(models.UserBalance{Current: 100.0, Withdrawn: 20.0}, nil)
+				storage.EXPECT().GetUserBalance(gomock.Any(), "testUser").Return(models.UserBalance{Current: models.NewMoney(100), Withdrawn: models.NewMoney(20)}, nil)
 				userSrv.EXPECT().SetUserIDCookie(gomock.Any(), "testUserID").Return(nil)
 			},
 			expectedStatus: http.StatusOK,
 			expectedBody: models.UserBalance{
-				Current:   100.0,
-				Withdrawn: 20.0,
+				Current:   models.NewMoney(100),
+				Withdrawn: models.NewMoney(20),
 			},
 		},
 		{
 			name:   "Unauthorized User",
 			userID: "unknownUserID",
 			mockSetup: func(storage *mocks.MockStorageService, userSrv *mocks.MockUserService) {
-				storage.EXPECT().GetLoginByUID("unknownUserID").Return("")
 			},
 			expectedStatus: http.StatusUnauthorized,
 			expectedBody:   nil,
@@ -460,8 +717,7 @@ func Test_UserBalance(t *testing.T) {
 			name:   "Internal Server Error",
 			userID: "testUserID",
 			mockSetup: func(storage *mocks.MockStorageService, userSrv *mocks.MockUserService) {
-				storage.EXPECT().GetLoginByUID("testUserID").Return("testUser")
-				storage.EXPECT().GetUserBalance("testUser").Return(models.UserBalance{}, errGetUserBalance)
+				storage.EXPECT().GetUserBalance(gomock.Any(), "testUser").Return(models.UserBalance{}, errGetUserBalance)
 			},
 			expectedStatus: http.StatusInternalServerError,
 			expectedBody:   nil,
@@ -476,7 +732,7 @@ func Test_UserBalance(t *testing.T) {
 			handler := controller.UserBalance()
 
 			req := httptest.NewRequest("GET", "/api/user/balance", nil)
-			req.Header.Set("User-ID", tt.userID)
+			req = withSession(req, tt.userID)
 			w := httptest.NewRecorder()
 
 			handler.ServeHTTP(w, req)
@@ -511,6 +767,7 @@ func Test_RequestForWithdrawal(t *testing.T) {
 		name           string
 		userID         string
 		requestBody    models.WithdrawRequest
+		rawBody        string
 		mockSetup      func(storage *mocks.MockStorageService, userSrv *mocks.MockUserService)
 		expectedStatus int
 	}{
@@ -519,11 +776,10 @@ func Test_RequestForWithdrawal(t *testing.T) {
 			userID: "testUserID",
 			requestBody: models.WithdrawRequest{
 				Order: orderNumber,
-				Sum:   50.0,
+				Sum:   models.NewMoney(50),
 			},
 			mockSetup: func(storage *mocks.MockStorageService, userSrv *mocks.MockUserService) {
-				storage.EXPECT().GetLoginByUID("testUserID").Return("testUser")
-				storage.EXPECT().WithdrawFromUserBalance("testUser", orderNumberInt, 50.0).Return(nil)
+				storage.EXPECT().WithdrawFromUserBalance(gomock.Any(), "testUser", orderNumberInt, models.NewMoney(50)).Return(nil)
 				userSrv.EXPECT().SetUserIDCookie(gomock.Any(), "testUserID").Return(nil)
 			},
 			expectedStatus: http.StatusOK,
@@ -533,10 +789,9 @@ func Test_RequestForWithdrawal(t *testing.T) {
 			userID: "unknownUserID",
 			requestBody: models.WithdrawRequest{
 				Order: orderNumber,
-				Sum:   50.0,
+				Sum:   models.NewMoney(50),
 			},
 			mockSetup: func(storage *mocks.MockStorageService, userSrv *mocks.MockUserService) {
-				storage.EXPECT().GetLoginByUID("unknownUserID").Return("")
 			},
 			expectedStatus: http.StatusUnauthorized,
 		},
@@ -545,10 +800,38 @@ func Test_RequestForWithdrawal(t *testing.T) {
 			userID: "testUserID",
 			requestBody: models.WithdrawRequest{
 				Order: "invalidOrder",
-				Sum:   50.0,
+				Sum:   models.NewMoney(50),
+			},
+			mockSetup: func(storage *mocks.MockStorageService, userSrv *mocks.MockUserService) {
+			},
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:           "Bad Request - Malformed JSON",
+			userID:         "testUserID",
+			rawBody:        `{"order": `,
+			mockSetup:      func(storage *mocks.MockStorageService, userSrv *mocks.MockUserService) {},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:   "Unprocessable Entity - Zero Sum",
+			userID: "testUserID",
+			requestBody: models.WithdrawRequest{
+				Order: orderNumber,
+				Sum:   0,
+			},
+			mockSetup: func(storage *mocks.MockStorageService, userSrv *mocks.MockUserService) {
+			},
+			expectedStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:   "Unprocessable Entity - Negative Sum",
+			userID: "testUserID",
+			requestBody: models.WithdrawRequest{
+				Order: orderNumber,
+				Sum:   models.NewMoney(-1000), // не должна обходить проверку TOTP
 			},
 			mockSetup: func(storage *mocks.MockStorageService, userSrv *mocks.MockUserService) {
-				storage.EXPECT().GetLoginByUID("testUserID").Return("testUser")
 			},
 			expectedStatus: http.StatusUnprocessableEntity,
 		},
@@ -557,24 +840,34 @@ func Test_RequestForWithdrawal(t *testing.T) {
 			userID: "testUserID",
 			requestBody: models.WithdrawRequest{
 				Order: orderNumber,
-				Sum:   150.0, // пусть больше доступного баланса
+				Sum:   models.NewMoney(150), // пусть больше доступного баланса
 			},
 			mockSetup: func(storage_ *mocks.MockStorageService, userSrv *mocks.MockUserService) {
-				storage_.EXPECT().GetLoginByUID("testUserID").Return("testUser")
-				storage_.EXPECT().WithdrawFromUserBalance("testUser", orderNumberInt, 150.0).Return(storage.ErrInsufficientFunds)
+				storage_.EXPECT().WithdrawFromUserBalance(gomock.Any(), "testUser", orderNumberInt, models.NewMoney(150)).Return(storage.ErrInsufficientFunds)
 			},
 			expectedStatus: http.StatusPaymentRequired,
 		},
+		{
+			name:   "Order Already Used For Withdrawal",
+			userID: "testUserID",
+			requestBody: models.WithdrawRequest{
+				Order: orderNumber,
+				Sum:   models.NewMoney(50),
+			},
+			mockSetup: func(storage_ *mocks.MockStorageService, userSrv *mocks.MockUserService) {
+				storage_.EXPECT().WithdrawFromUserBalance(gomock.Any(), "testUser", orderNumberInt, models.NewMoney(50)).Return(storage.ErrWithdrawConflict)
+			},
+			expectedStatus: http.StatusConflict,
+		},
 		{
 			name:   "Internal Server Error",
 			userID: "testUserID",
 			requestBody: models.WithdrawRequest{
 				Order: orderNumber,
-				Sum:   50.0,
+				Sum:   models.NewMoney(50),
 			},
 			mockSetup: func(storage *mocks.MockStorageService, userSrv *mocks.MockUserService) {
-				storage.EXPECT().GetLoginByUID("testUserID").Return("testUser")
-				storage.EXPECT().WithdrawFromUserBalance("testUser", orderNumberInt, 50.0).Return(errors.New("not ErrInsufficientFunds"))
+				storage.EXPECT().WithdrawFromUserBalance(gomock.Any(), "testUser", orderNumberInt, models.NewMoney(50)).Return(errors.New("not ErrInsufficientFunds"))
 			},
 			expectedStatus: http.StatusInternalServerError,
 		},
@@ -588,8 +881,11 @@ func Test_RequestForWithdrawal(t *testing.T) {
 			handler := controller.RequestForWithdrawal()
 
 			reqBody, _ := json.Marshal(tt.requestBody)
+			if tt.rawBody != "" {
+				reqBody = []byte(tt.rawBody)
+			}
 			req := httptest.NewRequest("POST", "/api/user/balance/withdraw", bytes.NewReader(reqBody))
-			req.Header.Set("User-ID", tt.userID)
+			req = withSession(req, tt.userID)
 			w := httptest.NewRecorder()
 
 			handler.ServeHTTP(w, req)
@@ -620,24 +916,22 @@ func Test_InfoAboutWithdrawals(t *testing.T) {
 			name:   "Success Withdrawals",
 			userID: "testUserID",
 			mockSetup: func(storage *mocks.MockStorageService, userSrv *mocks.MockUserService) {
-				storage.EXPECT().GetLoginByUID("testUserID").Return("testUser")
-				storage.EXPECT().GetUserWithdrawals("testUser").Return([]models.Withdrawal{
-					{Order: orderNumber1, Sum: 50, ProcessedAt: pa1},
-					{Order: orderNumber2, Sum: 30, ProcessedAt: pa2},
+				storage.EXPECT().GetUserWithdrawals(gomock.Any(), "testUser", models.ListQuery{}).Return([]models.Withdrawal{
+					{Order: orderNumber1, Sum: models.NewMoney(50), ProcessedAt: pa1},
+					{Order: orderNumber2, Sum: models.NewMoney(30), ProcessedAt: pa2},
 				}, nil)
 				userSrv.EXPECT().SetUserIDCookie(gomock.Any(), "testUserID").Return(nil)
 			},
 			expectedStatus: http.StatusOK,
 			expectedBody: []models.Withdrawal{
-				{Order: orderNumber1, Sum: 50, ProcessedAt: pa1},
-				{Order: orderNumber2, Sum: 30, ProcessedAt: pa2},
+				{Order: orderNumber1, Sum: models.NewMoney(50), ProcessedAt: pa1},
+				{Order: orderNumber2, Sum: models.NewMoney(30), ProcessedAt: pa2},
 			},
 		},
 		{
 			name:   "Unauthorized User",
 			userID: "unknownUserID",
 			mockSetup: func(storage *mocks.MockStorageService, userSrv *mocks.MockUserService) {
-				storage.EXPECT().GetLoginByUID("unknownUserID").Return("")
 			},
 			expectedStatus: http.StatusUnauthorized,
 			expectedBody:   nil,
@@ -646,8 +940,7 @@ func Test_InfoAboutWithdrawals(t *testing.T) {
 			name:   "No Withdrawals",
 			userID: "testUserID",
 			mockSetup: func(storage *mocks.MockStorageService, userSrv *mocks.MockUserService) {
-				storage.EXPECT().GetLoginByUID("testUserID").Return("testUser")
-				storage.EXPECT().GetUserWithdrawals("testUser").Return(nil, nil)
+				storage.EXPECT().GetUserWithdrawals(gomock.Any(), "testUser", models.ListQuery{}).Return(nil, nil)
 			},
 			expectedStatus: http.StatusNoContent,
 			expectedBody:   nil,
@@ -656,8 +949,7 @@ func Test_InfoAboutWithdrawals(t *testing.T) {
 			name:   "Internal Server Error",
 			userID: "testUserID",
 			mockSetup: func(storage *mocks.MockStorageService, userSrv *mocks.MockUserService) {
-				storage.EXPECT().GetLoginByUID("testUserID").Return("testUser")
-				storage.EXPECT().GetUserWithdrawals("testUser").Return([]models.Withdrawal{}, errors.New("some err"))
+				storage.EXPECT().GetUserWithdrawals(gomock.Any(), "testUser", models.ListQuery{}).Return([]models.Withdrawal{}, errors.New("some err"))
 			},
 			expectedStatus: http.StatusInternalServerError,
 			expectedBody:   nil,
@@ -672,7 +964,7 @@ func Test_InfoAboutWithdrawals(t *testing.T) {
 			handler := controller.InfoAboutWithdrawals()
 
 			req := httptest.NewRequest("GET", "/api/user/withdrawals", nil)
-			req.Header.Set("User-ID", tt.userID)
+			req = withSession(req, tt.userID)
 			w := httptest.NewRecorder()
 
 			handler.ServeHTTP(w, req)
@@ -0,0 +1,284 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"gophermart/cmd/gophermart/models"
+	"gophermart/cmd/gophermart/problem"
+	"gophermart/cmd/gophermart/storage"
+	"gophermart/cmd/gophermart/user"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// mfaChallengeTTL - сколько после верного пароля ждать код второго фактора
+const mfaChallengeTTL = 5 * time.Minute
+
+// TOTPCodeHeader - код TOTP для подтверждения крупного списания
+const TOTPCodeHeader = "X-TOTP-Code"
+
+// startMFAChallenge откладывает открытие сессии до проверки второго фактора
+func (con *Controller) startMFAChallenge(res http.ResponseWriter, req *http.Request, login string) {
+	challenge := models.MFAChallenge{
+		ID:        uuid.New().String(),
+		Login:     login,
+		ExpiresAt: time.Now().Add(mfaChallengeTTL),
+	}
+	if err := con.storageService.CreateMFAChallenge(req.Context(), challenge); err != nil {
+		con.Error(res, req, err)
+		return
+	}
+
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(http.StatusAccepted)
+	_ = json.NewEncoder(res).Encode(models.LoginChallenge{
+		MFARequired: true,
+		ChallengeID: challenge.ID,
+		ExpiresAt:   challenge.ExpiresAt,
+	})
+}
+
+// LoginTOTP - второй шаг входа: код TOTP или код восстановления для challenge_id из ответа Login
+func (con *Controller) LoginTOTP() http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		var body models.LoginSecondFactor
+		err := json.NewDecoder(req.Body).Decode(&body)
+		if err != nil || body.ChallengeID == "" || body.Code == "" {
+			con.Problem(res, req, problem.BadRequest, "")
+			return
+		}
+
+		login, err := con.storageService.GetMFAChallenge(req.Context(), body.ChallengeID)
+		if err != nil {
+			con.Error(res, req, err)
+			return
+		}
+
+		ip := clientIP(req)
+		if !con.checkLoginThrottle(res, req, login, ip) {
+			return
+		}
+		ok, err := con.checkSecondFactor(req.Context(), login, body.Code)
+		if err != nil {
+			con.Error(res, req, err)
+			return
+		}
+		if !ok {
+			con.loginFailed(res, req, login, ip, "totp")
+			return
+		}
+
+		if err := con.storageService.DeleteMFAChallenge(req.Context(), body.ChallengeID); err != nil {
+			con.Error(res, req, err)
+			return
+		}
+		account, ok := con.checkAccountActive(res, req, login)
+		if !ok {
+			return
+		}
+		con.loginSucceeded(req, login)
+		con.startSession(res, req, account)
+	}
+}
+
+// TOTPEnroll начинает подключение TOTP. До подтверждения кодом (TOTPVerify) второй фактор не действует.
+func (con *Controller) TOTPEnroll() http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		sessionID := requestSession(req)
+		userLogin := requestLogin(req)
+		if userLogin == "" {
+			con.Problem(res, req, problem.Unauthorized, "")
+			return
+		}
+
+		secret, uri, err := con.totp.Generate(userLogin)
+		if err != nil {
+			con.Error(res, req, err)
+			return
+		}
+		err = con.storageService.SaveTOTPSecret(req.Context(), userLogin, secret)
+		if err != nil {
+			con.Error(res, req, err)
+			return
+		}
+
+		res.Header().Set("Content-Type", "application/json")
+		con.setUserIDCookie(res, sessionID)
+		res.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(res).Encode(models.TOTPEnrollment{Secret: secret, URI: uri})
+	}
+}
+
+// TOTPVerify включает TOTP после первого верного кода и выдаёт коды восстановления (показываются один раз)
+func (con *Controller) TOTPVerify() http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		sessionID := requestSession(req)
+		userLogin := requestLogin(req)
+		if userLogin == "" {
+			con.Problem(res, req, problem.Unauthorized, "")
+			return
+		}
+
+		var body models.TOTPCode
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil || body.Code == "" {
+			con.Problem(res, req, problem.BadRequest, "")
+			return
+		}
+
+		totp, err := con.storageService.GetTOTP(req.Context(), userLogin)
+		if err != nil {
+			con.Error(res, req, err)
+			return
+		}
+		if totp == nil {
+			con.Problem(res, req, problem.TOTPNotEnrolled, "")
+			return
+		}
+		if totp.Enabled {
+			con.Problem(res, req, problem.TOTPEnabled, "")
+			return
+		}
+
+		step, ok := con.totp.Validate(totp.Secret, body.Code)
+		if !ok {
+			con.Problem(res, req, problem.InvalidTOTPCode, "")
+			return
+		}
+
+		codes, err := user.GenerateRecoveryCodes()
+		if err != nil {
+			con.Error(res, req, err)
+			return
+		}
+		hashes := make([]string, 0, len(codes))
+		for _, code := range codes {
+			hash, err := con.storageUtils.HashPassword(code)
+			if err != nil {
+				con.Error(res, req, err)
+				return
+			}
+			hashes = append(hashes, hash)
+		}
+
+		err = con.storageService.EnableTOTP(req.Context(), userLogin, step, hashes)
+		if errors.Is(err, storage.ErrTOTPNotFound) {
+			con.Problem(res, req, problem.TOTPEnrollmentChanged, "")
+			return
+		}
+		if err != nil {
+			con.Error(res, req, err)
+			return
+		}
+
+		res.Header().Set("Content-Type", "application/json")
+		con.setUserIDCookie(res, sessionID)
+		res.WriteHeader(http.StatusOK)
+		_ = json.NewEncoder(res).Encode(models.RecoveryCodes{RecoveryCodes: codes})
+	}
+}
+
+// TOTPDisable отключает второй фактор по действующему коду TOTP или коду восстановления
+func (con *Controller) TOTPDisable() http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		sessionID := requestSession(req)
+		userLogin := requestLogin(req)
+		if userLogin == "" {
+			con.Problem(res, req, problem.Unauthorized, "")
+			return
+		}
+
+		var body models.TOTPCode
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil || body.Code == "" {
+			con.Problem(res, req, problem.BadRequest, "")
+			return
+		}
+
+		ip := clientIP(req)
+		if !con.checkLoginThrottle(res, req, userLogin, ip) {
+			return
+		}
+		ok, err := con.checkSecondFactor(req.Context(), userLogin, body.Code)
+		if err != nil {
+			con.Error(res, req, err)
+			return
+		}
+		if !ok {
+			con.secondFactorFailed(res, req, userLogin, ip, problem.InvalidTOTPCode, "")
+			return
+		}
+
+		if err := con.storageService.DisableTOTP(req.Context(), userLogin); err != nil {
+			con.Error(res, req, err)
+			return
+		}
+		con.setUserIDCookie(res, sessionID)
+		con.Debug(res, "TOTP disabled", http.StatusOK)
+	}
+}
+
+// checkSecondFactor принимает код TOTP (6 цифр) или код восстановления; каждый код действует один раз
+func (con *Controller) checkSecondFactor(ctx context.Context, login, code string) (bool, error) {
+	if isTOTPCode(code) {
+		return con.checkTOTP(ctx, login, code)
+	}
+
+	codes, err := con.storageService.GetRecoveryCodes(ctx, login)
+	if err != nil {
+		return false, err
+	}
+	code = user.NormalizeRecoveryCode(code)
+	for _, c := range codes {
+		if con.storageUtils.CheckPasswordHash(code, c.Hash) {
+			return con.storageService.UseRecoveryCode(ctx, login, c.ID)
+		}
+	}
+	return false, nil
+}
+
+// checkTOTP - false, если TOTP не включён, код неверен или уже использовался
+func (con *Controller) checkTOTP(ctx context.Context, login, code string) (bool, error) {
+	totp, err := con.storageService.GetTOTP(ctx, login)
+	if err != nil || totp == nil || !totp.Enabled {
+		return false, err
+	}
+	return con.useTOTPCode(ctx, login, totp, code)
+}
+
+func (con *Controller) useTOTPCode(ctx context.Context, login string, totp *models.TOTP, code string) (bool, error) {
+	step, ok := con.totp.Validate(totp.Secret, code)
+	if !ok {
+		return false, nil
+	}
+	return con.storageService.UseTOTPStep(ctx, login, step)
+}
+
+// requireFreshTOTP - для пользователей с TOTP крупное списание подтверждается текущим кодом
+// в заголовке X-TOTP-Code. Без подключённого TOTP подтверждение не требуется.
+func (con *Controller) requireFreshTOTP(ctx context.Context, login, code string) (bool, error) {
+	totp, err := con.storageService.GetTOTP(ctx, login)
+	if err != nil {
+		return false, err
+	}
+	if totp == nil || !totp.Enabled {
+		return true, nil
+	}
+	if !isTOTPCode(code) {
+		return false, nil
+	}
+	return con.useTOTPCode(ctx, login, totp, code)
+}
+
+func isTOTPCode(code string) bool {
+	if len(code) != 6 {
+		return false
+	}
+	for _, r := range code {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,410 @@
+//go:build unit
+// +build unit
+
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"gophermart/cmd/gophermart/audit"
+	"gophermart/cmd/gophermart/mocks"
+	"gophermart/cmd/gophermart/models"
+	"gophermart/cmd/gophermart/storage"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ShiraazMoollatjie/goluhn"
+	"github.com/golang/mock/gomock"
+	"github.com/pquerna/otp/totp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testTOTPSecret - секрет подключённого TOTP и текущий код для него
+func testTOTPSecret(t *testing.T) (secret, code string) {
+	secret = "JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP"
+	code, err := totp.GenerateCode(secret, time.Now())
+	require.NoError(t, err)
+	return secret, code
+}
+
+func jsonBody(v any) *bytes.Reader {
+	body, _ := json.Marshal(v)
+	return bytes.NewReader(body)
+}
+
+func Test_TOTPEnroll(t *testing.T) {
+	tests := []struct {
+		name           string
+		userID         string
+		mockSetup      func(storage *mocks.MockStorageService, userSrv *mocks.MockUserService)
+		expectedStatus int
+	}{
+		{
+			name:   "Enrollment started",
+			userID: "testUserID",
+			mockSetup: func(storage *mocks.MockStorageService, userSrv *mocks.MockUserService) {
+				storage.EXPECT().SaveTOTPSecret(gomock.Any(), "testUser", gomock.Any()).Return(nil)
+				userSrv.EXPECT().SetUserIDCookie(gomock.Any(), "testUserID").Return(nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:   "Already enabled",
+			userID: "testUserID",
+			mockSetup: func(storage_ *mocks.MockStorageService, _ *mocks.MockUserService) {
+				storage_.EXPECT().SaveTOTPSecret(gomock.Any(), "testUser", gomock.Any()).Return(storage.ErrTOTPEnabled)
+			},
+			expectedStatus: http.StatusConflict,
+		},
+		{
+			name:   "Unauthorized",
+			userID: "unknownUserID",
+			mockSetup: func(*mocks.MockStorageService, *mocks.MockUserService) {
+			},
+			expectedStatus: http.StatusUnauthorized,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorageService, _, mockUserService, _, controller := prepare(t)
+			tt.mockSetup(mockStorageService, mockUserService)
+
+			req := withSession(httptest.NewRequest(http.MethodPost, "/api/user/2fa/totp", http.NoBody), tt.userID)
+			w := httptest.NewRecorder()
+
+			controller.TOTPEnroll()(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			if w.Code == http.StatusOK {
+				var enrollment models.TOTPEnrollment
+				require.NoError(t, json.NewDecoder(w.Body).Decode(&enrollment))
+				assert.NotEmpty(t, enrollment.Secret)
+				assert.True(t, strings.HasPrefix(enrollment.URI, "otpauth://totp/gophermart:testUser?"))
+			}
+		})
+	}
+}
+
+func Test_TOTPVerify(t *testing.T) {
+	secret, code := testTOTPSecret(t)
+
+	tests := []struct {
+		name           string
+		userID         string
+		code           string
+		mockSetup      func(storage *mocks.MockStorageService, storageUtils *mocks.MockStorageUtils, userSrv *mocks.MockUserService)
+		expectedStatus int
+	}{
+		{
+			name:   "Enabled",
+			userID: "testUserID",
+			code:   code,
+			mockSetup: func(storage *mocks.MockStorageService, storageUtils *mocks.MockStorageUtils, userSrv *mocks.MockUserService) {
+				storage.EXPECT().GetTOTP(gomock.Any(), "testUser").Return(&models.TOTP{Secret: secret}, nil)
+				// Коды восстановления хранятся только в виде хешей
+				storageUtils.EXPECT().HashPassword(gomock.Any()).Return("hashedCode", nil).Times(10)
+				storage.EXPECT().EnableTOTP(gomock.Any(), "testUser", gomock.Any(), gomock.Len(10)).Return(nil)
+				userSrv.EXPECT().SetUserIDCookie(gomock.Any(), "testUserID").Return(nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:   "Invalid code",
+			userID: "testUserID",
+			code:   "000000",
+			mockSetup: func(storage *mocks.MockStorageService, _ *mocks.MockStorageUtils, _ *mocks.MockUserService) {
+				storage.EXPECT().GetTOTP(gomock.Any(), "testUser").Return(&models.TOTP{Secret: secret}, nil)
+			},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:   "Enrollment not started",
+			userID: "testUserID",
+			code:   code,
+			mockSetup: func(storage *mocks.MockStorageService, _ *mocks.MockStorageUtils, _ *mocks.MockUserService) {
+				storage.EXPECT().GetTOTP(gomock.Any(), "testUser").Return(nil, nil)
+			},
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:   "Already enabled",
+			userID: "testUserID",
+			code:   code,
+			mockSetup: func(storage *mocks.MockStorageService, _ *mocks.MockStorageUtils, _ *mocks.MockUserService) {
+				storage.EXPECT().GetTOTP(gomock.Any(), "testUser").Return(&models.TOTP{Secret: secret, Enabled: true}, nil)
+			},
+			expectedStatus: http.StatusConflict,
+		},
+		{
+			name:   "Missing code",
+			userID: "testUserID",
+			mockSetup: func(*mocks.MockStorageService, *mocks.MockStorageUtils, *mocks.MockUserService) {
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorageService, mockStorageUtils, mockUserService, _, controller := prepare(t)
+			tt.mockSetup(mockStorageService, mockStorageUtils, mockUserService)
+
+			req := withSession(httptest.NewRequest(http.MethodPost, "/api/user/2fa/totp/verify",
+				jsonBody(models.TOTPCode{Code: tt.code})), tt.userID)
+			w := httptest.NewRecorder()
+
+			controller.TOTPVerify()(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			if w.Code == http.StatusOK {
+				var codes models.RecoveryCodes
+				require.NoError(t, json.NewDecoder(w.Body).Decode(&codes))
+				assert.Len(t, codes.RecoveryCodes, 10)
+			}
+		})
+	}
+}
+
+func Test_LoginTOTP(t *testing.T) {
+	secret, code := testTOTPSecret(t)
+	enabled := &models.TOTP{Secret: secret, Enabled: true}
+
+	tests := []struct {
+		name           string
+		body           models.LoginSecondFactor
+		mockSetup      func(storage *mocks.MockStorageService, storageUtils *mocks.MockStorageUtils, userSrv *mocks.MockUserService)
+		expectedStatus int
+	}{
+		{
+			name: "TOTP code",
+			body: models.LoginSecondFactor{ChallengeID: "challengeID", Code: code},
+			mockSetup: func(storage *mocks.MockStorageService, _ *mocks.MockStorageUtils, userSrv *mocks.MockUserService) {
+				storage.EXPECT().GetMFAChallenge(gomock.Any(), "challengeID").Return("testUser", nil)
+				storage.EXPECT().GetTOTP(gomock.Any(), "testUser").Return(enabled, nil)
+				storage.EXPECT().UseTOTPStep(gomock.Any(), "testUser", gomock.Any()).Return(true, nil)
+				storage.EXPECT().DeleteMFAChallenge(gomock.Any(), "challengeID").Return(nil)
+				storage.EXPECT().GetUser(gomock.Any(), "testUser").Return(models.Account{Login: "testUser", Role: models.RoleUser}, nil)
+				expectAudit(storage, audit.ActionLoginSucceeded, "testUser")
+				storage.EXPECT().CreateSession(gomock.Any(), newSessionOf("testUser")).Return(nil)
+				userSrv.EXPECT().SetUserIDCookie(gomock.Any(), newSessionID{}).Return(nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "Replayed TOTP code",
+			body: models.LoginSecondFactor{ChallengeID: "challengeID", Code: code},
+			mockSetup: func(storage *mocks.MockStorageService, _ *mocks.MockStorageUtils, _ *mocks.MockUserService) {
+				storage.EXPECT().GetMFAChallenge(gomock.Any(), "challengeID").Return("testUser", nil)
+				storage.EXPECT().GetTOTP(gomock.Any(), "testUser").Return(enabled, nil)
+				storage.EXPECT().UseTOTPStep(gomock.Any(), "testUser", gomock.Any()).Return(false, nil)
+				expectAudit(storage, audit.ActionLoginFailed, "testUser")
+			},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "Recovery code",
+			body: models.LoginSecondFactor{ChallengeID: "challengeID", Code: "ABCDE-FGHIJ"},
+			mockSetup: func(storage *mocks.MockStorageService, storageUtils *mocks.MockStorageUtils, userSrv *mocks.MockUserService) {
+				storage.EXPECT().GetMFAChallenge(gomock.Any(), "challengeID").Return("testUser", nil)
+				storage.EXPECT().GetRecoveryCodes(gomock.Any(), "testUser").Return([]models.RecoveryCode{
+					{ID: 1, Hash: "hashedFirst"}, {ID: 2, Hash: "hashedSecond"},
+				}, nil)
+				storageUtils.EXPECT().CheckPasswordHash("abcde-fghij", "hashedFirst").Return(false)
+				storageUtils.EXPECT().CheckPasswordHash("abcde-fghij", "hashedSecond").Return(true)
+				storage.EXPECT().UseRecoveryCode(gomock.Any(), "testUser", int64(2)).Return(true, nil)
+				storage.EXPECT().DeleteMFAChallenge(gomock.Any(), "challengeID").Return(nil)
+				storage.EXPECT().GetUser(gomock.Any(), "testUser").Return(models.Account{Login: "testUser", Role: models.RoleUser}, nil)
+				expectAudit(storage, audit.ActionLoginSucceeded, "testUser")
+				storage.EXPECT().CreateSession(gomock.Any(), newSessionOf("testUser")).Return(nil)
+				userSrv.EXPECT().SetUserIDCookie(gomock.Any(), newSessionID{}).Return(nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "Invalid code",
+			body: models.LoginSecondFactor{ChallengeID: "challengeID", Code: "000000"},
+			mockSetup: func(storage *mocks.MockStorageService, _ *mocks.MockStorageUtils, _ *mocks.MockUserService) {
+				storage.EXPECT().GetMFAChallenge(gomock.Any(), "challengeID").Return("testUser", nil)
+				storage.EXPECT().GetTOTP(gomock.Any(), "testUser").Return(enabled, nil)
+				expectAudit(storage, audit.ActionLoginFailed, "testUser")
+			},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "Expired challenge",
+			body: models.LoginSecondFactor{ChallengeID: "expiredID", Code: code},
+			mockSetup: func(storage_ *mocks.MockStorageService, _ *mocks.MockStorageUtils, _ *mocks.MockUserService) {
+				storage_.EXPECT().GetMFAChallenge(gomock.Any(), "expiredID").Return("", storage.ErrChallengeNotFound)
+			},
+			expectedStatus: http.StatusUnauthorized,
+		},
+		{
+			name: "Bad request",
+			body: models.LoginSecondFactor{Code: code},
+			mockSetup: func(*mocks.MockStorageService, *mocks.MockStorageUtils, *mocks.MockUserService) {
+			},
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorageService, mockStorageUtils, mockUserService, _, controller := prepare(t)
+			tt.mockSetup(mockStorageService, mockStorageUtils, mockUserService)
+
+			req := httptest.NewRequest(http.MethodPost, "/api/user/login/totp", jsonBody(tt.body))
+			w := httptest.NewRecorder()
+
+			controller.LoginTOTP()(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+			assert.Equal(t, tt.expectedStatus == http.StatusOK, w.Header().Get("Authorization") != "",
+				"a token is issued only after the second factor")
+		})
+	}
+}
+
+func Test_TOTPDisable(t *testing.T) {
+	secret, code := testTOTPSecret(t)
+	enabled := &models.TOTP{Secret: secret, Enabled: true}
+
+	tests := []struct {
+		name           string
+		code           string
+		mockSetup      func(storage *mocks.MockStorageService, userSrv *mocks.MockUserService)
+		expectedStatus int
+	}{
+		{
+			name: "Disabled",
+			code: code,
+			mockSetup: func(storage *mocks.MockStorageService, userSrv *mocks.MockUserService) {
+				storage.EXPECT().GetTOTP(gomock.Any(), "testUser").Return(enabled, nil)
+				storage.EXPECT().UseTOTPStep(gomock.Any(), "testUser", gomock.Any()).Return(true, nil)
+				storage.EXPECT().DisableTOTP(gomock.Any(), "testUser").Return(nil)
+				userSrv.EXPECT().SetUserIDCookie(gomock.Any(), "testUserID").Return(nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "Invalid code",
+			code: "000000",
+			mockSetup: func(storage *mocks.MockStorageService, _ *mocks.MockUserService) {
+				storage.EXPECT().GetTOTP(gomock.Any(), "testUser").Return(enabled, nil)
+			},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name: "TOTP not enabled",
+			code: code,
+			mockSetup: func(storage *mocks.MockStorageService, _ *mocks.MockUserService) {
+				storage.EXPECT().GetTOTP(gomock.Any(), "testUser").Return(nil, nil)
+			},
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorageService, _, mockUserService, _, controller := prepare(t)
+			tt.mockSetup(mockStorageService, mockUserService)
+
+			req := withSession(httptest.NewRequest(http.MethodDelete, "/api/user/2fa/totp",
+				jsonBody(models.TOTPCode{Code: tt.code})), "testUserID")
+			w := httptest.NewRecorder()
+
+			controller.TOTPDisable()(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+		})
+	}
+}
+
+func Test_RequestForWithdrawal_TOTP(t *testing.T) {
+	secret, code := testTOTPSecret(t)
+	enabled := &models.TOTP{Secret: secret, Enabled: true}
+	orderNumber := goluhn.Generate(10)
+	orderNumberInt, _ := strconv.Atoi(orderNumber)
+	large := models.NewMoney(5000) // больше порога по умолчанию (1000)
+
+	tests := []struct {
+		name           string
+		sum            models.Money
+		code           string
+		mockSetup      func(storage *mocks.MockStorageService, userSrv *mocks.MockUserService)
+		expectedStatus int
+	}{
+		{
+			name: "Large withdrawal with a fresh code",
+			sum:  large,
+			code: code,
+			mockSetup: func(storage *mocks.MockStorageService, userSrv *mocks.MockUserService) {
+				storage.EXPECT().GetTOTP(gomock.Any(), "testUser").Return(enabled, nil)
+				storage.EXPECT().UseTOTPStep(gomock.Any(), "testUser", gomock.Any()).Return(true, nil)
+				storage.EXPECT().WithdrawFromUserBalance(gomock.Any(), "testUser", orderNumberInt, large).Return(nil)
+				userSrv.EXPECT().SetUserIDCookie(gomock.Any(), "testUserID").Return(nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "Large withdrawal without a code",
+			sum:  large,
+			mockSetup: func(storage *mocks.MockStorageService, _ *mocks.MockUserService) {
+				storage.EXPECT().GetTOTP(gomock.Any(), "testUser").Return(enabled, nil)
+			},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name: "Large withdrawal with a used code",
+			sum:  large,
+			code: code,
+			mockSetup: func(storage *mocks.MockStorageService, _ *mocks.MockUserService) {
+				storage.EXPECT().GetTOTP(gomock.Any(), "testUser").Return(enabled, nil)
+				storage.EXPECT().UseTOTPStep(gomock.Any(), "testUser", gomock.Any()).Return(false, nil)
+			},
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name: "Large withdrawal without TOTP",
+			sum:  large,
+			mockSetup: func(storage *mocks.MockStorageService, userSrv *mocks.MockUserService) {
+				storage.EXPECT().GetTOTP(gomock.Any(), "testUser").Return(nil, nil)
+				storage.EXPECT().WithdrawFromUserBalance(gomock.Any(), "testUser", orderNumberInt, large).Return(nil)
+				userSrv.EXPECT().SetUserIDCookie(gomock.Any(), "testUserID").Return(nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "Withdrawal at the threshold",
+			sum:  models.NewMoney(1000),
+			mockSetup: func(storage *mocks.MockStorageService, userSrv *mocks.MockUserService) {
+				storage.EXPECT().WithdrawFromUserBalance(gomock.Any(), "testUser", orderNumberInt, models.NewMoney(1000)).Return(nil)
+				userSrv.EXPECT().SetUserIDCookie(gomock.Any(), "testUserID").Return(nil)
+			},
+			expectedStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mockStorageService, _, mockUserService, _, controller := prepare(t)
+			tt.mockSetup(mockStorageService, mockUserService)
+
+			req := withSession(httptest.NewRequest(http.MethodPost, "/api/user/balance/withdraw",
+				jsonBody(models.WithdrawRequest{Order: orderNumber, Sum: tt.sum})), "testUserID")
+			if tt.code != "" {
+				req.Header.Set(TOTPCodeHeader, tt.code)
+			}
+			w := httptest.NewRecorder()
+
+			controller.RequestForWithdrawal()(w, req)
+
+			assert.Equal(t, tt.expectedStatus, w.Code)
+		})
+	}
+}
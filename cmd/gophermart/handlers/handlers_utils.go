@@ -1,7 +1,9 @@
 package handlers
 
 import (
+	"gophermart/cmd/gophermart/models"
 	"io"
+	"net"
 	"net/http"
 )
 
@@ -28,6 +30,11 @@ func (r *loggingResponseWriter) WriteHeader(statusCode int) {
 	r.responseData.status = statusCode
 }
 
+// Unwrap нужен http.ResponseController (например, для Flush в потоке событий)
+func (r *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
 type gzipWriter struct {
 	http.ResponseWriter
 	Writer io.Writer
@@ -37,12 +44,52 @@ func (w gzipWriter) Write(b []byte) (int, error) {
 	return w.Writer.Write(b)
 }
 
+// Debug - успешный ответ с текстом formatString (для 204 без тела); ошибки отправляются через Problem
 func (con *Controller) Debug(res http.ResponseWriter, formatString string, code int) {
 	con.sugar.Debugf(formatString)
 	if code != http.StatusOK {
-		http.Error(res, formatString, code)
+		res.WriteHeader(code)
 	} else {
 		_, _ = res.Write([]byte(formatString + "\n"))
-		res.WriteHeader(http.StatusOK)
 	}
 }
+
+// requestLogin - логин, проверенный AuthenticateMiddleware ("" вне защищённых маршрутов)
+func requestLogin(req *http.Request) string {
+	login, _ := req.Context().Value(loginCtxKey).(string)
+	return login
+}
+
+// requestSession - ID сессии из cookie ("" при входе по Bearer-токену)
+func requestSession(req *http.Request) string {
+	sessionID, _ := req.Context().Value(sessionCtxKey).(string)
+	return sessionID
+}
+
+// requestTokenSession - ID сессии, в которой выдан Bearer-токен запроса ("" при входе по cookie)
+func requestTokenSession(req *http.Request) string {
+	sessionID, _ := req.Context().Value(tokenSessionCtxKey).(string)
+	return sessionID
+}
+
+// requestAPIKey - API-ключ запроса (nil при входе по cookie или Bearer-токену)
+func requestAPIKey(req *http.Request) *models.APIKey {
+	key, _ := req.Context().Value(apiKeyCtxKey).(*models.APIKey)
+	return key
+}
+
+// setUserIDCookie продлевает cookie; для запросов с Bearer-токеном sessionID пуст и cookie не нужна
+func (con *Controller) setUserIDCookie(res http.ResponseWriter, sessionID string) {
+	if sessionID != "" {
+		_ = con.userService.SetUserIDCookie(res, sessionID)
+	}
+}
+
+// clientIP - адрес клиента без порта. За доверенным прокси RemoteAddr уже заменён RealIPMiddleware.
+func clientIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
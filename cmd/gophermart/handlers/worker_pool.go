@@ -1,61 +1,279 @@
 package handlers
 
 import (
+	"context"
+	"errors"
+	"gophermart/cmd/gophermart/metrics"
 	"gophermart/cmd/gophermart/models"
+	"gophermart/cmd/gophermart/orderstate"
 	"sync"
 	"time"
 )
 
-type Task struct {
-	UserLogin   string
-	OrderNumber int
-}
+type Task = models.AccrualTask
 
+// AccrualQueue - воркеры, которые разбирают задачи из таблицы accrual_tasks.
+// Сами задачи живут в хранилище: переживают перезапуск и делятся между экземплярами сервиса.
 type AccrualQueue struct {
-	tasks       chan Task
-	results     chan *models.AccrualResponse
-	errors      chan error
+	ctx         context.Context // отменяется при остановке сервиса, прерывает запросы воркеров
+	cancel      context.CancelFunc
+	wake        chan struct{} // Notify будит простаивающего воркера
+	stop        chan struct{} // закрывается в Stop: воркеры не берут новые задачи
+	stopOnce    sync.Once
 	workerCount int
-	throttle    *time.Ticker
-	wg          *sync.WaitGroup
+	limiter     *accrualLimiter
+	wg          *sync.WaitGroup // работающие воркеры и обновление accrual_queue_depth
 }
 
-const bufSize = 100
+const maxRetryDelay = 10 * time.Minute
+
+// queueDepthInterval - как часто обновляется accrual_queue_depth
+const queueDepthInterval = 5 * time.Second
 
 func NewAccrualQueue(workerCount, maxRequestsPerMinute int) *AccrualQueue {
-	interval := time.Minute / time.Duration(maxRequestsPerMinute)
+	ctx, cancel := context.WithCancel(context.Background())
 	return &AccrualQueue{
-		tasks:       make(chan Task, bufSize),
-		results:     make(chan *models.AccrualResponse),
-		errors:      make(chan error),
+		ctx:         ctx,
+		cancel:      cancel,
+		wake:        make(chan struct{}, 1),
+		stop:        make(chan struct{}),
 		workerCount: workerCount,
-		throttle:    time.NewTicker(interval),
+		limiter:     newAccrualLimiter(maxRequestsPerMinute),
 		wg:          &sync.WaitGroup{},
 	}
 }
 
 func (wp *AccrualQueue) Start(con *Controller) {
 	for i := 0; i < wp.workerCount; i++ {
+		wp.wg.Add(1)
 		go wp.worker(con)
 	}
+	wp.wg.Add(1)
+	go wp.reportDepth(con)
+}
+
+// reportDepth обновляет accrual_queue_depth по таблице задач (её разбирают и другие экземпляры сервиса).
+// Одна горутина на сервис, а не каждый захват: count(*) не должен удваивать запросы воркеров.
+func (wp *AccrualQueue) reportDepth(con *Controller) {
+	defer wp.wg.Done()
+
+	ticker := time.NewTicker(queueDepthInterval)
+	defer ticker.Stop()
+	for {
+		wp.observeDepth(con)
+		select {
+		case <-wp.stop:
+			return
+		case <-ticker.C:
+		}
+	}
 }
 
 func (wp *AccrualQueue) worker(con *Controller) {
-	for task := range wp.tasks {
-		<-wp.throttle.C // Контроль частоты запросов
+	defer wp.wg.Done()
 
-		wp.wg.Add(1)
-		response, err := con.RequestToAccrual(task.UserLogin, task.OrderNumber)
+	idle := time.Duration(con.conf.PollInterval) * time.Second
+	for {
+		select {
+		case <-wp.stop:
+			return
+		default:
+		}
+
+		// Слот берётся до захвата задачи, чтобы пауза после 429 не съедала срок захвата
+		waitStart := time.Now()
+		if !wp.limiter.wait(wp.stop) {
+			return
+		}
+		metrics.AccrualThrottleWait.Observe(time.Since(waitStart).Seconds())
+
+		task, ok := wp.claim(con)
+		if !ok {
+			select {
+			case <-wp.stop:
+				return
+			case <-wp.wake:
+			case <-time.After(idle):
+			}
+			continue
+		}
+
+		wp.process(con, task)
+	}
+}
+
+// claim берёт одну задачу, срок захвата с запасом покрывает запрос к Accrual
+func (wp *AccrualQueue) claim(con *Controller) (Task, bool) {
+	lease := 2 * time.Duration(con.conf.Timeout) * time.Second
+	tasks, err := con.storageService.ClaimAccrualTasks(wp.ctx, 1, lease)
+	if err != nil {
+		if wp.ctx.Err() == nil {
+			con.sugar.Errorf("(worker) ClaimAccrualTasks: %v", err)
+		}
+		return Task{}, false
+	}
+	if len(tasks) == 0 {
+		return Task{}, false
+	}
+	return tasks[0], true
+}
+
+func (wp *AccrualQueue) observeDepth(con *Controller) {
+	depth, err := con.storageService.CountPendingAccrualTasks(wp.ctx)
+	if err != nil {
+		if wp.ctx.Err() == nil {
+			con.sugar.Errorf("(worker) CountPendingAccrualTasks: %v", err)
+		}
+		return
+	}
+	metrics.AccrualQueueDepth.Set(float64(depth))
+}
+
+func (wp *AccrualQueue) process(con *Controller, task Task) {
+	metrics.AccrualWorkersBusy.Inc()
+	defer metrics.AccrualWorkersBusy.Dec()
+
+	ctx, cancel := context.WithTimeout(wp.ctx, time.Duration(con.conf.Timeout)*time.Second)
+	accrualResponse, err := con.RequestToAccrual(ctx, task.UserLogin, task.OrderNumber)
+	cancel()
+
+	// Результат сохраняется и после отмены wp.ctx
+	storeCtx, storeCancel := context.WithTimeout(context.Background(), time.Duration(con.conf.Timeout)*time.Second)
+	defer storeCancel()
+
+	var tooManyRequests *TooManyRequestsError
+	switch {
+	case err != nil && wp.ctx.Err() != nil: // запрос прерван остановкой сервиса
+		wp.release(con, task)
+	case errors.As(err, &tooManyRequests):
+		wp.throttled(con, task, tooManyRequests)
+	case errors.Is(err, orderstate.ErrTransitionNotAllowed): // заказ уже в финальном статусе
+		con.sugar.Warnf("(worker) order %d: %v", task.OrderNumber, err)
+		wp.complete(storeCtx, con, task)
+	case errors.Is(err, ErrNotRegistered): // Accrual ещё не получил заказ: попытка не расходуется, но опросы ограничены
+		delay := retryDelay(time.Duration(con.conf.PollInterval)*time.Second, task.Unregistered)
+		dead, err := con.storageService.PostponeUnregisteredAccrualTask(storeCtx, task.OrderNumber, err.Error(), delay, con.conf.AccrualMaxUnregistered)
+		if err != nil {
+			con.sugar.Errorf("(worker) PostponeUnregisteredAccrualTask %d: %v", task.OrderNumber, err)
+			return
+		}
+		if dead {
+			con.sugar.Warnf("(worker) order %d moved to dead letters: not registered in Accrual after %d polls", task.OrderNumber, task.Unregistered+1)
+			metrics.AccrualTasks.WithLabelValues("dead").Inc()
+		} else {
+			metrics.AccrualTasks.WithLabelValues("unregistered").Inc()
+		}
+	case err != nil:
+		con.sugar.Errorf("(worker) order %d: %v", task.OrderNumber, err)
+		delay := retryDelay(time.Duration(con.conf.PollInterval)*time.Second, task.Attempts)
+		dead, err := con.storageService.FailAccrualTask(storeCtx, task.OrderNumber, err.Error(), delay, con.conf.AccrualMaxAttempts)
 		if err != nil {
-			wp.errors <- err
+			con.sugar.Errorf("(worker) FailAccrualTask %d: %v", task.OrderNumber, err)
+			return
+		}
+		if dead {
+			con.sugar.Warnf("(worker) order %d moved to dead letters after %d attempts", task.OrderNumber, task.Attempts+1)
+			metrics.AccrualTasks.WithLabelValues("dead").Inc()
 		} else {
-			wp.results <- response
+			metrics.AccrualTasks.WithLabelValues("failed").Inc()
 		}
-		wp.wg.Done()
+	case accrualResponse != nil && orderstate.IsFinal(accrualResponse.Status):
+		wp.complete(storeCtx, con, task)
+	default: // заказ ещё в обработке у Accrual
+		wp.reschedule(storeCtx, con, task)
 	}
 }
 
-func (wp *AccrualQueue) AddTask(task Task) {
-	wp.wg.Add(1)
-	wp.tasks <- task
+// reschedule откладывает опрос заказа на PollInterval
+func (wp *AccrualQueue) reschedule(ctx context.Context, con *Controller, task Task) {
+	if err := con.storageService.RescheduleAccrualTask(ctx, task.OrderNumber, time.Duration(con.conf.PollInterval)*time.Second); err != nil {
+		con.sugar.Errorf("(worker) RescheduleAccrualTask %d: %v", task.OrderNumber, err)
+		return
+	}
+	metrics.AccrualTasks.WithLabelValues("rescheduled").Inc()
+}
+
+func (wp *AccrualQueue) complete(ctx context.Context, con *Controller, task Task) {
+	if err := con.storageService.CompleteAccrualTask(ctx, task.OrderNumber); err != nil {
+		con.sugar.Errorf("(worker) CompleteAccrualTask %d: %v", task.OrderNumber, err)
+		return
+	}
+	metrics.AccrualTasks.WithLabelValues("completed").Inc()
+}
+
+// throttled приостанавливает все воркеры до дедлайна Retry-After и возвращает заказ в очередь
+func (wp *AccrualQueue) throttled(con *Controller, task Task, e *TooManyRequestsError) {
+	con.sugar.Infof("(worker) accrual rate limit exceeded, pausing for %s", e.RetryAfter)
+	metrics.AccrualRetryAfterSleep.Observe(e.RetryAfter.Seconds())
+	wp.limiter.pause(time.Now().Add(e.RetryAfter))
+	if e.MaxRequestsPerMin > 0 {
+		con.sugar.Infof("(worker) accrual allows %d requests per minute", e.MaxRequestsPerMin)
+		wp.limiter.setRate(e.MaxRequestsPerMin)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(con.conf.Timeout)*time.Second)
+	defer cancel()
+	if err := con.storageService.RescheduleAccrualTask(ctx, task.OrderNumber, e.RetryAfter); err != nil {
+		con.sugar.Errorf("(worker) RescheduleAccrualTask %d: %v", task.OrderNumber, err)
+		return
+	}
+	metrics.AccrualTasks.WithLabelValues("throttled").Inc()
+}
+
+// release возвращает захваченную задачу в очередь при остановке.
+// Если это не удалось, задача освободится сама по истечении lease.
+func (wp *AccrualQueue) release(con *Controller, task Task) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(con.conf.Timeout)*time.Second)
+	defer cancel()
+
+	if err := con.storageService.RescheduleAccrualTask(ctx, task.OrderNumber, 0); err != nil {
+		con.sugar.Errorf("(worker) release order %d: %v", task.OrderNumber, err)
+		return
+	}
+	metrics.AccrualTasks.WithLabelValues("released").Inc()
+}
+
+// Notify сообщает воркерам о новой задаче в хранилище, не дожидаясь PollInterval. Не блокируется.
+func (wp *AccrualQueue) Notify() {
+	select {
+	case wp.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Stop запрещает воркерам брать новые задачи
+func (wp *AccrualQueue) Stop() {
+	wp.stopOnce.Do(func() { close(wp.stop) })
+}
+
+// Wait дожидается воркеров после Stop: текущие запросы к Accrual завершаются до дедлайна ctx,
+// после чего прерываются, а их задачи возвращаются в очередь.
+func (wp *AccrualQueue) Wait(ctx context.Context) {
+	wp.Stop()
+
+	done := make(chan struct{})
+	go func() {
+		wp.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		wp.cancel()
+		<-done
+	}
+	wp.cancel()
+}
+
+// retryDelay - экспоненциальная задержка после неудачной попытки, не больше maxRetryDelay
+func retryDelay(base time.Duration, attempts int) time.Duration {
+	delay := base
+	for i := 0; i < attempts && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryDelay {
+		return maxRetryDelay
+	}
+	return delay
 }
@@ -0,0 +1,189 @@
+//go:build unit
+// +build unit
+
+package handlers
+
+import (
+	"context"
+	"errors"
+	"gophermart/cmd/gophermart/config"
+	"gophermart/cmd/gophermart/metrics"
+	"gophermart/cmd/gophermart/mocks"
+	"gophermart/cmd/gophermart/models"
+	"gophermart/cmd/gophermart/orderstate"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/golang/mock/gomock"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// accrualResponse - настоящий ответ resty с заданным кодом и телом
+func accrualResponse(t *testing.T, code int, header http.Header, body string) *resty.Response {
+	srv := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, _ *http.Request) {
+		for k, v := range header {
+			res.Header()[k] = v
+		}
+		res.WriteHeader(code)
+		_, _ = res.Write([]byte(body))
+	}))
+	defer srv.Close()
+
+	resp, err := resty.New().R().Get(srv.URL)
+	require.NoError(t, err)
+	return resp
+}
+
+func Test_AccrualQueue_ProcessOutcomes(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      string
+		mockSetup func(storage *mocks.MockStorageService, done func())
+	}{
+		{
+			name: "Final status completes task",
+			body: `{"order":"7","status":"PROCESSED","accrual":500}`,
+			mockSetup: func(storage *mocks.MockStorageService, done func()) {
+				storage.EXPECT().UpdateOrder(gomock.Any(), 7, "PROCESSED", models.NewMoney(500)).Return(true, nil)
+				storage.EXPECT().CompleteAccrualTask(gomock.Any(), 7).
+					DoAndReturn(func(context.Context, int) error { done(); return nil })
+			},
+		},
+		{
+			name: "Registered order is processing",
+			body: `{"order":"7","status":"REGISTERED"}`,
+			mockSetup: func(storage *mocks.MockStorageService, done func()) {
+				storage.EXPECT().UpdateOrder(gomock.Any(), 7, "PROCESSING", models.Money(0)).Return(true, nil)
+				storage.EXPECT().RescheduleAccrualTask(gomock.Any(), 7, 5*time.Second).
+					DoAndReturn(func(context.Context, int, time.Duration) error { done(); return nil })
+			},
+		},
+		{
+			name: "Order already in final status completes task",
+			body: `{"order":"7","status":"PROCESSING"}`,
+			mockSetup: func(storage *mocks.MockStorageService, done func()) {
+				storage.EXPECT().UpdateOrder(gomock.Any(), 7, "PROCESSING", models.Money(0)).
+					Return(false, orderstate.ErrTransitionNotAllowed)
+				storage.EXPECT().CompleteAccrualTask(gomock.Any(), 7).
+					DoAndReturn(func(context.Context, int) error { done(); return nil })
+			},
+		},
+		{
+			name: "Not final status reschedules task",
+			body: `{"order":"7","status":"PROCESSING"}`,
+			mockSetup: func(storage *mocks.MockStorageService, done func()) {
+				storage.EXPECT().UpdateOrder(gomock.Any(), 7, "PROCESSING", models.Money(0)).Return(true, nil)
+				storage.EXPECT().RescheduleAccrualTask(gomock.Any(), 7, 5*time.Second).
+					DoAndReturn(func(context.Context, int, time.Duration) error { done(); return nil })
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := accrualResponse(t, http.StatusOK, http.Header{"Content-Type": {"application/json"}}, tt.body)
+			finished := make(chan struct{})
+			prepareWithTasks(t, func(storage *mocks.MockStorageService, accSrv *mocks.MockAccrualClient) {
+				accSrv.EXPECT().RequestToAccrualByOrderumber(gomock.Any(), 7).Return(resp, nil)
+				tt.mockSetup(storage, func() { close(finished) })
+			}, Task{UserLogin: "testUser", OrderNumber: 7})
+
+			<-finished
+		})
+	}
+}
+
+func Test_AccrualQueue_NotRegisteredIsRetried(t *testing.T) {
+	resp := accrualResponse(t, http.StatusNoContent, nil, "")
+	done := make(chan struct{})
+	prepareWithTasks(t, func(storage *mocks.MockStorageService, accSrv *mocks.MockAccrualClient) {
+		accSrv.EXPECT().RequestToAccrualByOrderumber(gomock.Any(), 7).Return(resp, nil)
+		// Попытка не расходуется (FailAccrualTask не вызывается), опросы откладываются всё дальше
+		storage.EXPECT().
+			PostponeUnregisteredAccrualTask(gomock.Any(), 7, ErrNotRegistered.Error(), 20*time.Second, config.NewConfig().AccrualMaxUnregistered).
+			DoAndReturn(func(context.Context, int, string, time.Duration, int) (bool, error) {
+				close(done)
+				return false, nil
+			})
+	}, Task{UserLogin: "testUser", OrderNumber: 7, Attempts: 1, Unregistered: 2})
+
+	<-done
+}
+
+func Test_AccrualQueue_QueueDepth(t *testing.T) {
+	resp := accrualResponse(t, http.StatusOK, http.Header{"Content-Type": {"application/json"}}, `{"order":"7","status":"PROCESSING"}`)
+	done := make(chan struct{})
+	prepareWithTasks(t, func(storage *mocks.MockStorageService, accSrv *mocks.MockAccrualClient) {
+		accSrv.EXPECT().RequestToAccrualByOrderumber(gomock.Any(), 7).Return(resp, nil)
+		storage.EXPECT().UpdateOrder(gomock.Any(), 7, "PROCESSING", models.Money(0)).Return(true, nil)
+		storage.EXPECT().RescheduleAccrualTask(gomock.Any(), 7, 5*time.Second).
+			DoAndReturn(func(context.Context, int, time.Duration) error { close(done); return nil })
+	}, Task{UserLogin: "testUser", OrderNumber: 7})
+
+	<-done
+	assert.Eventually(t, func() bool { return testutil.ToFloat64(metrics.AccrualQueueDepth) == 1 },
+		time.Second, 10*time.Millisecond, "depth is taken from storage on start")
+}
+
+func Test_AccrualQueue_TooManyRequestsPausesAllWorkers(t *testing.T) {
+	resp := accrualResponse(t, http.StatusTooManyRequests, http.Header{"Retry-After": {"60"}},
+		"No more than 10 requests per minute allowed")
+	done := make(chan struct{})
+	_, _, _, _, controller := prepareWithTasks(t, func(storage *mocks.MockStorageService, accSrv *mocks.MockAccrualClient) {
+		accSrv.EXPECT().RequestToAccrualByOrderumber(gomock.Any(), 7).Return(resp, nil)
+		storage.EXPECT().RescheduleAccrualTask(gomock.Any(), 7, time.Minute).
+			DoAndReturn(func(context.Context, int, time.Duration) error { close(done); return nil })
+	}, Task{UserLogin: "testUser", OrderNumber: 7})
+
+	<-done
+	limiter := controller.accrualQueue.limiter
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	assert.WithinDuration(t, time.Now().Add(time.Minute), limiter.pausedUntil, time.Second)
+	assert.Equal(t, 6*time.Second, limiter.interval, "rate is taken from the response body")
+}
+
+func Test_AccrualQueueWait_ReleasesInFlightTask(t *testing.T) {
+	started := make(chan struct{})
+	_, _, _, _, controller := prepareWithTasks(t, func(storage *mocks.MockStorageService, accSrv *mocks.MockAccrualClient) {
+		accSrv.EXPECT().RequestToAccrualByOrderumber(gomock.Any(), 42).
+			DoAndReturn(func(ctx context.Context, _ int) (*resty.Response, error) {
+				close(started)
+				<-ctx.Done()
+				return nil, ctx.Err()
+			})
+		storage.EXPECT().RescheduleAccrualTask(gomock.Any(), 42, time.Duration(0)).Return(nil)
+	}, Task{UserLogin: "testUser", OrderNumber: 42})
+
+	<-started
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	controller.accrualQueue.Wait(ctx)
+}
+
+func Test_AccrualQueue_FailedAttemptIsCounted(t *testing.T) {
+	done := make(chan struct{})
+	prepareWithTasks(t, func(storage *mocks.MockStorageService, accSrv *mocks.MockAccrualClient) {
+		accSrv.EXPECT().RequestToAccrualByOrderumber(gomock.Any(), 7).
+			Return(nil, errors.New("connection refused"))
+		storage.EXPECT().
+			FailAccrualTask(gomock.Any(), 7, ErrAccrualRequest.Error(), 20*time.Second, config.NewConfig().AccrualMaxAttempts).
+			DoAndReturn(func(context.Context, int, string, time.Duration, int) (bool, error) {
+				close(done)
+				return false, nil
+			})
+	}, Task{UserLogin: "testUser", OrderNumber: 7, Attempts: 2})
+
+	<-done
+}
+
+func Test_RetryDelay(t *testing.T) {
+	assert.Equal(t, 5*time.Second, retryDelay(5*time.Second, 0))
+	assert.Equal(t, 40*time.Second, retryDelay(5*time.Second, 3))
+	assert.Equal(t, maxRetryDelay, retryDelay(5*time.Second, 100))
+}
@@ -1,19 +1,29 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"gophermart/cmd/gophermart/clients"
 	"gophermart/cmd/gophermart/config"
 	"gophermart/cmd/gophermart/handlers"
 	"gophermart/cmd/gophermart/logger"
+	"gophermart/cmd/gophermart/pubsub"
 	"gophermart/cmd/gophermart/routing"
 	"gophermart/cmd/gophermart/storage"
 	"gophermart/cmd/gophermart/user"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	"go.uber.org/zap"
 )
 
+// eventHistorySize - сколько последних событий о заказах хранится для переподключения по Last-Event-ID
+const eventHistorySize = 1000
+
 func main() {
 	sugarLogger, err := logger.NewLogger()
 	if err != nil {
@@ -23,31 +33,133 @@ func main() {
 	c := config.NewConfig()
 	err = config.Init(c)
 	if err != nil {
-		sugarLogger.Fatalf("Failed to initialize config")
+		sugarLogger.Fatalf("Failed to initialize config: %v", err)
 	}
 
-	s, err := storage.NewStorage(c)
-	if errors.Is(err, storage.ErrOpenDBConnection) {
-		sugarLogger.Fatalf("Error opening database connection: %v\n", err)
-	} else if errors.Is(err, storage.ErrConnecting) {
-		sugarLogger.Fatalf("Error connecting to database: %v\n", err)
+	var s storage.StorageService
+	if c.StorageType == config.StorageMemory {
+		s = storage.NewMemoryStorage()
+	} else {
+		s, err = storage.NewStorage(c)
+		if errors.Is(err, storage.ErrOpenDBConnection) {
+			sugarLogger.Fatalf("Error opening database connection: %v\n", err)
+		} else if errors.Is(err, storage.ErrConnecting) {
+			sugarLogger.Fatalf("Error connecting to database: %v\n", err)
+		}
 	}
 
-	userService := user.NewUserService()
+	cookieKeys, err := loadCookieKeys(c)
+	if err != nil {
+		sugarLogger.Fatalf("Failed to load cookie keys: %v", err)
+	}
+	userService := user.NewUserService(c.SessionTTL, sugarLogger, cookieKeys...)
+	tokenService, err := newTokenService(c)
+	if err != nil {
+		sugarLogger.Fatalf("Failed to initialize JWT: %v", err)
+	}
+	passwordPolicy := user.NewPasswordPolicy(c.PasswordMinLength, c.PasswordMinCharClasses)
+	if c.PasswordBreachedFile != "" {
+		if err := passwordPolicy.LoadBreached(c.PasswordBreachedFile); err != nil {
+			sugarLogger.Fatalf("Failed to load breached passwords: %v", err)
+		}
+	}
+	loginThrottle := user.NewLoginThrottle(s, user.LoginLimits{
+		MaxFailuresPerLogin: c.LoginMaxFailures,
+		MaxFailuresPerIP:    c.LoginMaxFailuresPerIP,
+		Window:              c.LoginFailureWindow,
+		Lockout:             c.LoginLockout,
+	})
 	wp := handlers.NewAccrualQueue(c.NumWorkers, c.MaxRequestsPerMin)
 	accrualClient := clients.NewAccrualClient(c.AccrualSystemAddress, sugarLogger)
-	ctrl := handlers.NewController(c, s, storage.NewStorageUtils(), sugarLogger, userService, wp, accrualClient)
+	events := pubsub.NewBroker(eventHistorySize)
+	ctrl := handlers.NewController(c, s, storage.NewStorageUtils(), sugarLogger, userService, wp, accrualClient, events, tokenService,
+		passwordPolicy, loginThrottle, user.NewTOTP(c.TOTPIssuer))
 
 	// Регистрация информации о вознаграждении за товар (POST /api/goods) @@@
-	// ctrl.AccrualClient.RegisterRewards()
+	// ctrl.AccrualClient.RegisterRewards(context.Background())
 
 	r := chi.NewRouter()
 
 	routing.InitMiddleware(r, c, ctrl)
-	routing.Routing(r, ctrl)
+	routing.Routing(r, c, ctrl)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	srv := &http.Server{
+		Addr:              c.Addr,
+		Handler:           r,
+		ReadHeaderTimeout: time.Duration(c.Timeout) * time.Second,
+	}
+	// Открытые потоки событий иначе не дадут Shutdown дождаться обработчиков
+	srv.RegisterOnShutdown(events.Close)
+
+	go func() {
+		err := srv.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			sugarLogger.Fatalf("Failed to start server: %v", err)
+		}
+	}()
+
+	<-ctx.Done()
+	sugarLogger.Infof("Shutting down")
+	shutdown(srv, wp, s, time.Duration(c.ShutdownTimeout)*time.Second, sugarLogger)
+}
+
+// shutdown перестаёт принимать запросы, дожидается текущих обработчиков и воркеров AccrualQueue
+// (не дольше timeout) и закрывает хранилище. Прерванные задачи остаются в accrual_tasks.
+func shutdown(srv *http.Server, wp *handlers.AccrualQueue, s storage.StorageService,
+	timeout time.Duration, sugarLogger *zap.SugaredLogger) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
 
-	err = http.ListenAndServe(c.Addr, r) //nolint:gosec // Use chi Timeout (see above)
+	if err := srv.Shutdown(ctx); err != nil {
+		sugarLogger.Errorf("HTTP server shutdown: %v", err)
+	}
+
+	wp.Wait(ctx)
+
+	if err := s.Close(); err != nil {
+		sugarLogger.Errorf("Failed to close storage: %v", err)
+	}
+}
+
+// loadCookieKeys - ключи из COOKIE_KEYS_FILE или COOKIE_KEYS (пусто - ключ для разработки)
+func loadCookieKeys(c *config.Config) ([]user.CookieKey, error) {
+	keys := c.CookieKeys
+	if c.CookieKeysFile != "" {
+		data, err := os.ReadFile(c.CookieKeysFile)
+		if err != nil {
+			return nil, err
+		}
+		keys = string(data)
+	}
+
+	parsed, err := user.ParseCookieKeys(keys)
 	if err != nil {
-		sugarLogger.Fatalf("Failed to start server: %v", err)
+		return nil, err
+	}
+	if len(parsed) == 0 && c.Env == config.EnvProduction {
+		return nil, errors.New("no cookie keys configured")
+	}
+	return parsed, nil
+}
+
+// newTokenService - nil, если ключ для JWT не задан: тогда доступна только аутентификация по cookie
+func newTokenService(c *config.Config) (user.TokenService, error) {
+	var key []byte
+	switch {
+	case c.JWTAlgorithm == user.AlgEdDSA && c.JWTPrivateKeyFile != "":
+		pem, err := os.ReadFile(c.JWTPrivateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		key = pem
+	case c.JWTAlgorithm == user.AlgHS256 && c.JWTSecret != "":
+		key = []byte(c.JWTSecret)
+	case c.JWTSecret == "" && c.JWTPrivateKeyFile == "":
+		return nil, nil
 	}
+
+	return user.NewJWT(c.JWTAlgorithm, key, c.JWTIssuer, c.JWTAudience, c.JWTTTL)
 }
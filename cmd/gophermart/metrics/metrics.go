@@ -0,0 +1,99 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "gophermart"
+
+var (
+	HTTPRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by chi route pattern, method and status code.",
+	}, []string{"route", "method", "status"})
+
+	HTTPRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by chi route pattern, method and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	AccrualTasks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "accrual_tasks_total",
+		Help:      "Processed accrual tasks by outcome: completed, rescheduled, unregistered, throttled, failed, dead, released.",
+	}, []string{"outcome"})
+
+	AccrualQueueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "accrual_queue_depth",
+		Help:      "Pending accrual tasks in storage, including those waiting for their next attempt.",
+	})
+
+	AccrualWorkersBusy = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "accrual_workers_busy",
+		Help:      "AccrualQueue workers currently requesting the accrual system.",
+	})
+
+	AccrualRateLimit = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "accrual_rate_limit_per_minute",
+		Help:      "Current request rate limit to the accrual system, adapted from its 429 responses.",
+	})
+
+	AccrualThrottleWait = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "accrual_throttle_wait_seconds",
+		Help:      "Time a worker waited for the request rate limiter.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 12),
+	})
+
+	AccrualResponses = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "accrual_responses_total",
+		Help:      "Responses from the accrual system by status code (\"error\" for transport errors).",
+	}, []string{"code"})
+
+	AccrualRetryAfterSleep = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "accrual_retry_after_sleep_seconds",
+		Help:      "Pauses of all workers requested by the accrual system via Retry-After.",
+		Buckets:   []float64{1, 5, 10, 30, 60, 120, 300},
+	})
+
+	SSEConnections = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "sse_connections",
+		Help:      "Open GET /api/user/orders/stream connections.",
+	})
+
+	LoginLockouts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "login_lockouts_total",
+		Help:      "Login lockouts after repeated failed attempts by scope: login or ip.",
+	}, []string{"scope"})
+
+	DBQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_query_duration_seconds",
+		Help:      "StorageDB latency by StorageService method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method"})
+)
+
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// ObserveDBQuery использовать как defer metrics.ObserveDBQuery("Method", time.Now())
+func ObserveDBQuery(method string, start time.Time) {
+	DBQueryDuration.WithLabelValues(method).Observe(time.Since(start).Seconds())
+}
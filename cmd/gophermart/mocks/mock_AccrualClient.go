@@ -5,6 +5,7 @@
 package mocks
 
 import (
+	context "context"
 	reflect "reflect"
 
 	resty "github.com/go-resty/resty/v2"
@@ -35,40 +36,54 @@ func (m *MockAccrualClient) EXPECT() *MockAccrualClientMockRecorder {
 }
 
 // MakePurchase mocks base method.
-func (m *MockAccrualClient) MakePurchase(arg0 int) {
+func (m *MockAccrualClient) MakePurchase(arg0 context.Context, arg1 int) {
 	m.ctrl.T.Helper()
-	m.ctrl.Call(m, "MakePurchase", arg0)
+	m.ctrl.Call(m, "MakePurchase", arg0, arg1)
 }
 
 // MakePurchase indicates an expected call of MakePurchase.
-func (mr *MockAccrualClientMockRecorder) MakePurchase(arg0 interface{}) *gomock.Call {
+func (mr *MockAccrualClientMockRecorder) MakePurchase(arg0, arg1 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MakePurchase", reflect.TypeOf((*MockAccrualClient)(nil).MakePurchase), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MakePurchase", reflect.TypeOf((*MockAccrualClient)(nil).MakePurchase), arg0, arg1)
+}
+
+// Ping mocks base method.
+func (m *MockAccrualClient) Ping(arg0 context.Context) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Ping", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Ping indicates an expected call of Ping.
+func (mr *MockAccrualClientMockRecorder) Ping(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Ping", reflect.TypeOf((*MockAccrualClient)(nil).Ping), arg0)
 }
 
 // RegisterRewards mocks base method.
-func (m *MockAccrualClient) RegisterRewards() {
+func (m *MockAccrualClient) RegisterRewards(arg0 context.Context) {
 	m.ctrl.T.Helper()
-	m.ctrl.Call(m, "RegisterRewards")
+	m.ctrl.Call(m, "RegisterRewards", arg0)
 }
 
 // RegisterRewards indicates an expected call of RegisterRewards.
-func (mr *MockAccrualClientMockRecorder) RegisterRewards() *gomock.Call {
+func (mr *MockAccrualClientMockRecorder) RegisterRewards(arg0 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RegisterRewards", reflect.TypeOf((*MockAccrualClient)(nil).RegisterRewards))
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RegisterRewards", reflect.TypeOf((*MockAccrualClient)(nil).RegisterRewards), arg0)
 }
 
 // RequestToAccrualByOrderumber mocks base method.
-func (m *MockAccrualClient) RequestToAccrualByOrderumber(arg0 int) (*resty.Response, error) {
+func (m *MockAccrualClient) RequestToAccrualByOrderumber(arg0 context.Context, arg1 int) (*resty.Response, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "RequestToAccrualByOrderumber", arg0)
+	ret := m.ctrl.Call(m, "RequestToAccrualByOrderumber", arg0, arg1)
 	ret0, _ := ret[0].(*resty.Response)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // RequestToAccrualByOrderumber indicates an expected call of RequestToAccrualByOrderumber.
-func (mr *MockAccrualClientMockRecorder) RequestToAccrualByOrderumber(arg0 interface{}) *gomock.Call {
+func (mr *MockAccrualClientMockRecorder) RequestToAccrualByOrderumber(arg0, arg1 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RequestToAccrualByOrderumber", reflect.TypeOf((*MockAccrualClient)(nil).RequestToAccrualByOrderumber), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RequestToAccrualByOrderumber", reflect.TypeOf((*MockAccrualClient)(nil).RequestToAccrualByOrderumber), arg0, arg1)
 }
@@ -5,8 +5,10 @@
 package mocks
 
 import (
+	context "context"
 	models "gophermart/cmd/gophermart/models"
 	reflect "reflect"
+	time "time"
 
 	gomock "github.com/golang/mock/gomock"
 )
@@ -34,174 +36,789 @@ func (m *MockStorageService) EXPECT() *MockStorageServiceMockRecorder {
 	return m.recorder
 }
 
+// AddAuditEvent mocks base method.
+func (m *MockStorageService) AddAuditEvent(arg0 context.Context, arg1 models.AuditEvent) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AddAuditEvent", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AddAuditEvent indicates an expected call of AddAuditEvent.
+func (mr *MockStorageServiceMockRecorder) AddAuditEvent(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddAuditEvent", reflect.TypeOf((*MockStorageService)(nil).AddAuditEvent), arg0, arg1)
+}
+
+// AddLoginFailure mocks base method.
+func (m *MockStorageService) AddLoginFailure(arg0 context.Context, arg1 string, arg2 time.Duration) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AddLoginFailure", arg0, arg1, arg2)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// AddLoginFailure indicates an expected call of AddLoginFailure.
+func (mr *MockStorageServiceMockRecorder) AddLoginFailure(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddLoginFailure", reflect.TypeOf((*MockStorageService)(nil).AddLoginFailure), arg0, arg1, arg2)
+}
+
 // AddOrder mocks base method.
-func (m *MockStorageService) AddOrder(arg0 string, arg1 int) (bool, error) {
+func (m *MockStorageService) AddOrder(arg0 context.Context, arg1 string, arg2 int) (bool, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "AddOrder", arg0, arg1)
+	ret := m.ctrl.Call(m, "AddOrder", arg0, arg1, arg2)
 	ret0, _ := ret[0].(bool)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // AddOrder indicates an expected call of AddOrder.
-func (mr *MockStorageServiceMockRecorder) AddOrder(arg0, arg1 interface{}) *gomock.Call {
+func (mr *MockStorageServiceMockRecorder) AddOrder(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddOrder", reflect.TypeOf((*MockStorageService)(nil).AddOrder), arg0, arg1, arg2)
+}
+
+// AdjustBalance mocks base method.
+func (m *MockStorageService) AdjustBalance(arg0 context.Context, arg1 models.BalanceAdjustment) (models.UserBalance, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AdjustBalance", arg0, arg1)
+	ret0, _ := ret[0].(models.UserBalance)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// AdjustBalance indicates an expected call of AdjustBalance.
+func (mr *MockStorageServiceMockRecorder) AdjustBalance(arg0, arg1 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddOrder", reflect.TypeOf((*MockStorageService)(nil).AddOrder), arg0, arg1)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AdjustBalance", reflect.TypeOf((*MockStorageService)(nil).AdjustBalance), arg0, arg1)
 }
 
 // BalanceForUserLogin mocks base method.
-func (m *MockStorageService) BalanceForUserLogin(arg0 string) error {
+func (m *MockStorageService) BalanceForUserLogin(arg0 context.Context, arg1 string) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "BalanceForUserLogin", arg0)
+	ret := m.ctrl.Call(m, "BalanceForUserLogin", arg0, arg1)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // BalanceForUserLogin indicates an expected call of BalanceForUserLogin.
-func (mr *MockStorageServiceMockRecorder) BalanceForUserLogin(arg0 interface{}) *gomock.Call {
+func (mr *MockStorageServiceMockRecorder) BalanceForUserLogin(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "BalanceForUserLogin", reflect.TypeOf((*MockStorageService)(nil).BalanceForUserLogin), arg0, arg1)
+}
+
+// ChangePassword mocks base method.
+func (m *MockStorageService) ChangePassword(arg0 context.Context, arg1, arg2, arg3 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ChangePassword", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ChangePassword indicates an expected call of ChangePassword.
+func (mr *MockStorageServiceMockRecorder) ChangePassword(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ChangePassword", reflect.TypeOf((*MockStorageService)(nil).ChangePassword), arg0, arg1, arg2, arg3)
+}
+
+// ClaimAccrualTasks mocks base method.
+func (m *MockStorageService) ClaimAccrualTasks(arg0 context.Context, arg1 int, arg2 time.Duration) ([]models.AccrualTask, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ClaimAccrualTasks", arg0, arg1, arg2)
+	ret0, _ := ret[0].([]models.AccrualTask)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ClaimAccrualTasks indicates an expected call of ClaimAccrualTasks.
+func (mr *MockStorageServiceMockRecorder) ClaimAccrualTasks(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ClaimAccrualTasks", reflect.TypeOf((*MockStorageService)(nil).ClaimAccrualTasks), arg0, arg1, arg2)
+}
+
+// Close mocks base method.
+func (m *MockStorageService) Close() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Close")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Close indicates an expected call of Close.
+func (mr *MockStorageServiceMockRecorder) Close() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Close", reflect.TypeOf((*MockStorageService)(nil).Close))
+}
+
+// CompleteAccrualTask mocks base method.
+func (m *MockStorageService) CompleteAccrualTask(arg0 context.Context, arg1 int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CompleteAccrualTask", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CompleteAccrualTask indicates an expected call of CompleteAccrualTask.
+func (mr *MockStorageServiceMockRecorder) CompleteAccrualTask(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CompleteAccrualTask", reflect.TypeOf((*MockStorageService)(nil).CompleteAccrualTask), arg0, arg1)
+}
+
+// CountPendingAccrualTasks mocks base method.
+func (m *MockStorageService) CountPendingAccrualTasks(arg0 context.Context) (int, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CountPendingAccrualTasks", arg0)
+	ret0, _ := ret[0].(int)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CountPendingAccrualTasks indicates an expected call of CountPendingAccrualTasks.
+func (mr *MockStorageServiceMockRecorder) CountPendingAccrualTasks(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CountPendingAccrualTasks", reflect.TypeOf((*MockStorageService)(nil).CountPendingAccrualTasks), arg0)
+}
+
+// CreateAPIKey mocks base method.
+func (m *MockStorageService) CreateAPIKey(arg0 context.Context, arg1 models.APIKey) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateAPIKey", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateAPIKey indicates an expected call of CreateAPIKey.
+func (mr *MockStorageServiceMockRecorder) CreateAPIKey(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateAPIKey", reflect.TypeOf((*MockStorageService)(nil).CreateAPIKey), arg0, arg1)
+}
+
+// CreateMFAChallenge mocks base method.
+func (m *MockStorageService) CreateMFAChallenge(arg0 context.Context, arg1 models.MFAChallenge) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateMFAChallenge", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateMFAChallenge indicates an expected call of CreateMFAChallenge.
+func (mr *MockStorageServiceMockRecorder) CreateMFAChallenge(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateMFAChallenge", reflect.TypeOf((*MockStorageService)(nil).CreateMFAChallenge), arg0, arg1)
+}
+
+// CreateSession mocks base method.
+func (m *MockStorageService) CreateSession(arg0 context.Context, arg1 models.Session) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateSession", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateSession indicates an expected call of CreateSession.
+func (mr *MockStorageServiceMockRecorder) CreateSession(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateSession", reflect.TypeOf((*MockStorageService)(nil).CreateSession), arg0, arg1)
+}
+
+// DeleteAPIKey mocks base method.
+func (m *MockStorageService) DeleteAPIKey(arg0 context.Context, arg1, arg2 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteAPIKey", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteAPIKey indicates an expected call of DeleteAPIKey.
+func (mr *MockStorageServiceMockRecorder) DeleteAPIKey(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteAPIKey", reflect.TypeOf((*MockStorageService)(nil).DeleteAPIKey), arg0, arg1, arg2)
+}
+
+// DeleteMFAChallenge mocks base method.
+func (m *MockStorageService) DeleteMFAChallenge(arg0 context.Context, arg1 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteMFAChallenge", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteMFAChallenge indicates an expected call of DeleteMFAChallenge.
+func (mr *MockStorageServiceMockRecorder) DeleteMFAChallenge(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteMFAChallenge", reflect.TypeOf((*MockStorageService)(nil).DeleteMFAChallenge), arg0, arg1)
+}
+
+// DeleteSession mocks base method.
+func (m *MockStorageService) DeleteSession(arg0 context.Context, arg1, arg2 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteSession", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteSession indicates an expected call of DeleteSession.
+func (mr *MockStorageServiceMockRecorder) DeleteSession(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteSession", reflect.TypeOf((*MockStorageService)(nil).DeleteSession), arg0, arg1, arg2)
+}
+
+// DisableTOTP mocks base method.
+func (m *MockStorageService) DisableTOTP(arg0 context.Context, arg1 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DisableTOTP", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DisableTOTP indicates an expected call of DisableTOTP.
+func (mr *MockStorageServiceMockRecorder) DisableTOTP(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DisableTOTP", reflect.TypeOf((*MockStorageService)(nil).DisableTOTP), arg0, arg1)
+}
+
+// EnableTOTP mocks base method.
+func (m *MockStorageService) EnableTOTP(arg0 context.Context, arg1 string, arg2 int64, arg3 []string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "EnableTOTP", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// EnableTOTP indicates an expected call of EnableTOTP.
+func (mr *MockStorageServiceMockRecorder) EnableTOTP(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "EnableTOTP", reflect.TypeOf((*MockStorageService)(nil).EnableTOTP), arg0, arg1, arg2, arg3)
+}
+
+// FailAccrualTask mocks base method.
+func (m *MockStorageService) FailAccrualTask(arg0 context.Context, arg1 int, arg2 string, arg3 time.Duration, arg4 int) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FailAccrualTask", arg0, arg1, arg2, arg3, arg4)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// FailAccrualTask indicates an expected call of FailAccrualTask.
+func (mr *MockStorageServiceMockRecorder) FailAccrualTask(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FailAccrualTask", reflect.TypeOf((*MockStorageService)(nil).FailAccrualTask), arg0, arg1, arg2, arg3, arg4)
+}
+
+// GetAPIKey mocks base method.
+func (m *MockStorageService) GetAPIKey(arg0 context.Context, arg1 string) (*models.APIKey, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetAPIKey", arg0, arg1)
+	ret0, _ := ret[0].(*models.APIKey)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetAPIKey indicates an expected call of GetAPIKey.
+func (mr *MockStorageServiceMockRecorder) GetAPIKey(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAPIKey", reflect.TypeOf((*MockStorageService)(nil).GetAPIKey), arg0, arg1)
+}
+
+// GetAPIKeys mocks base method.
+func (m *MockStorageService) GetAPIKeys(arg0 context.Context, arg1 string) ([]models.APIKey, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetAPIKeys", arg0, arg1)
+	ret0, _ := ret[0].([]models.APIKey)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetAPIKeys indicates an expected call of GetAPIKeys.
+func (mr *MockStorageServiceMockRecorder) GetAPIKeys(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAPIKeys", reflect.TypeOf((*MockStorageService)(nil).GetAPIKeys), arg0, arg1)
+}
+
+// GetAccrualTask mocks base method.
+func (m *MockStorageService) GetAccrualTask(arg0 context.Context, arg1 int) (*models.AccrualTaskInfo, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetAccrualTask", arg0, arg1)
+	ret0, _ := ret[0].(*models.AccrualTaskInfo)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetAccrualTask indicates an expected call of GetAccrualTask.
+func (mr *MockStorageServiceMockRecorder) GetAccrualTask(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAccrualTask", reflect.TypeOf((*MockStorageService)(nil).GetAccrualTask), arg0, arg1)
+}
+
+// GetAuditChain mocks base method.
+func (m *MockStorageService) GetAuditChain(arg0 context.Context, arg1 int64, arg2 int) ([]models.AuditEvent, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetAuditChain", arg0, arg1, arg2)
+	ret0, _ := ret[0].([]models.AuditEvent)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetAuditChain indicates an expected call of GetAuditChain.
+func (mr *MockStorageServiceMockRecorder) GetAuditChain(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAuditChain", reflect.TypeOf((*MockStorageService)(nil).GetAuditChain), arg0, arg1, arg2)
+}
+
+// GetAuditEvents mocks base method.
+func (m *MockStorageService) GetAuditEvents(arg0 context.Context, arg1 models.AuditFilter) ([]models.AuditEvent, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetAuditEvents", arg0, arg1)
+	ret0, _ := ret[0].([]models.AuditEvent)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetAuditEvents indicates an expected call of GetAuditEvents.
+func (mr *MockStorageServiceMockRecorder) GetAuditEvents(arg0, arg1 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "BalanceForUserLogin", reflect.TypeOf((*MockStorageService)(nil).BalanceForUserLogin), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAuditEvents", reflect.TypeOf((*MockStorageService)(nil).GetAuditEvents), arg0, arg1)
 }
 
 // GetHashedPasswordByLogin mocks base method.
-func (m *MockStorageService) GetHashedPasswordByLogin(arg0 string) string {
+func (m *MockStorageService) GetHashedPasswordByLogin(arg0 context.Context, arg1 string) string {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetHashedPasswordByLogin", arg0)
+	ret := m.ctrl.Call(m, "GetHashedPasswordByLogin", arg0, arg1)
 	ret0, _ := ret[0].(string)
 	return ret0
 }
 
 // GetHashedPasswordByLogin indicates an expected call of GetHashedPasswordByLogin.
-func (mr *MockStorageServiceMockRecorder) GetHashedPasswordByLogin(arg0 interface{}) *gomock.Call {
+func (mr *MockStorageServiceMockRecorder) GetHashedPasswordByLogin(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetHashedPasswordByLogin", reflect.TypeOf((*MockStorageService)(nil).GetHashedPasswordByLogin), arg0, arg1)
+}
+
+// GetLoginLockout mocks base method.
+func (m *MockStorageService) GetLoginLockout(arg0 context.Context, arg1 string) (time.Time, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetLoginLockout", arg0, arg1)
+	ret0, _ := ret[0].(time.Time)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetLoginLockout indicates an expected call of GetLoginLockout.
+func (mr *MockStorageServiceMockRecorder) GetLoginLockout(arg0, arg1 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetHashedPasswordByLogin", reflect.TypeOf((*MockStorageService)(nil).GetHashedPasswordByLogin), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetLoginLockout", reflect.TypeOf((*MockStorageService)(nil).GetLoginLockout), arg0, arg1)
 }
 
-// GetLoginByUID mocks base method.
-func (m *MockStorageService) GetLoginByUID(arg0 string) string {
+// GetMFAChallenge mocks base method.
+func (m *MockStorageService) GetMFAChallenge(arg0 context.Context, arg1 string) (string, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetLoginByUID", arg0)
+	ret := m.ctrl.Call(m, "GetMFAChallenge", arg0, arg1)
 	ret0, _ := ret[0].(string)
-	return ret0
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetMFAChallenge indicates an expected call of GetMFAChallenge.
+func (mr *MockStorageServiceMockRecorder) GetMFAChallenge(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetMFAChallenge", reflect.TypeOf((*MockStorageService)(nil).GetMFAChallenge), arg0, arg1)
+}
+
+// GetOrder mocks base method.
+func (m *MockStorageService) GetOrder(arg0 context.Context, arg1 int) (models.Order, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetOrder", arg0, arg1)
+	ret0, _ := ret[0].(models.Order)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
 }
 
-// GetLoginByUID indicates an expected call of GetLoginByUID.
-func (mr *MockStorageServiceMockRecorder) GetLoginByUID(arg0 interface{}) *gomock.Call {
+// GetOrder indicates an expected call of GetOrder.
+func (mr *MockStorageServiceMockRecorder) GetOrder(arg0, arg1 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetLoginByUID", reflect.TypeOf((*MockStorageService)(nil).GetLoginByUID), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOrder", reflect.TypeOf((*MockStorageService)(nil).GetOrder), arg0, arg1)
+}
+
+// GetOrderStatusHistory mocks base method.
+func (m *MockStorageService) GetOrderStatusHistory(arg0 context.Context, arg1 int) ([]models.OrderStatusChange, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetOrderStatusHistory", arg0, arg1)
+	ret0, _ := ret[0].([]models.OrderStatusChange)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetOrderStatusHistory indicates an expected call of GetOrderStatusHistory.
+func (mr *MockStorageServiceMockRecorder) GetOrderStatusHistory(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOrderStatusHistory", reflect.TypeOf((*MockStorageService)(nil).GetOrderStatusHistory), arg0, arg1)
 }
 
 // GetOrders mocks base method.
-func (m *MockStorageService) GetOrders(arg0 string) ([]models.Order, error) {
+func (m *MockStorageService) GetOrders(arg0 context.Context, arg1 string, arg2 models.ListQuery) ([]models.Order, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetOrders", arg0)
+	ret := m.ctrl.Call(m, "GetOrders", arg0, arg1, arg2)
 	ret0, _ := ret[0].([]models.Order)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetOrders indicates an expected call of GetOrders.
-func (mr *MockStorageServiceMockRecorder) GetOrders(arg0 interface{}) *gomock.Call {
+func (mr *MockStorageServiceMockRecorder) GetOrders(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOrders", reflect.TypeOf((*MockStorageService)(nil).GetOrders), arg0, arg1, arg2)
+}
+
+// GetRecoveryCodes mocks base method.
+func (m *MockStorageService) GetRecoveryCodes(arg0 context.Context, arg1 string) ([]models.RecoveryCode, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetRecoveryCodes", arg0, arg1)
+	ret0, _ := ret[0].([]models.RecoveryCode)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetRecoveryCodes indicates an expected call of GetRecoveryCodes.
+func (mr *MockStorageServiceMockRecorder) GetRecoveryCodes(arg0, arg1 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetOrders", reflect.TypeOf((*MockStorageService)(nil).GetOrders), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRecoveryCodes", reflect.TypeOf((*MockStorageService)(nil).GetRecoveryCodes), arg0, arg1)
+}
+
+// GetSessions mocks base method.
+func (m *MockStorageService) GetSessions(arg0 context.Context, arg1 string) ([]models.Session, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetSessions", arg0, arg1)
+	ret0, _ := ret[0].([]models.Session)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetSessions indicates an expected call of GetSessions.
+func (mr *MockStorageServiceMockRecorder) GetSessions(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetSessions", reflect.TypeOf((*MockStorageService)(nil).GetSessions), arg0, arg1)
+}
+
+// GetTOTP mocks base method.
+func (m *MockStorageService) GetTOTP(arg0 context.Context, arg1 string) (*models.TOTP, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetTOTP", arg0, arg1)
+	ret0, _ := ret[0].(*models.TOTP)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetTOTP indicates an expected call of GetTOTP.
+func (mr *MockStorageServiceMockRecorder) GetTOTP(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetTOTP", reflect.TypeOf((*MockStorageService)(nil).GetTOTP), arg0, arg1)
+}
+
+// GetUser mocks base method.
+func (m *MockStorageService) GetUser(arg0 context.Context, arg1 string) (models.Account, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetUser", arg0, arg1)
+	ret0, _ := ret[0].(models.Account)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetUser indicates an expected call of GetUser.
+func (mr *MockStorageServiceMockRecorder) GetUser(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUser", reflect.TypeOf((*MockStorageService)(nil).GetUser), arg0, arg1)
 }
 
 // GetUserBalance mocks base method.
-func (m *MockStorageService) GetUserBalance(arg0 string) (models.UserBalance, error) {
+func (m *MockStorageService) GetUserBalance(arg0 context.Context, arg1 string) (models.UserBalance, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetUserBalance", arg0)
+	ret := m.ctrl.Call(m, "GetUserBalance", arg0, arg1)
 	ret0, _ := ret[0].(models.UserBalance)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetUserBalance indicates an expected call of GetUserBalance.
-func (mr *MockStorageServiceMockRecorder) GetUserBalance(arg0 interface{}) *gomock.Call {
+func (mr *MockStorageServiceMockRecorder) GetUserBalance(arg0, arg1 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserBalance", reflect.TypeOf((*MockStorageService)(nil).GetUserBalance), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserBalance", reflect.TypeOf((*MockStorageService)(nil).GetUserBalance), arg0, arg1)
 }
 
 // GetUserWithdrawals mocks base method.
-func (m *MockStorageService) GetUserWithdrawals(arg0 string) ([]models.Withdrawal, error) {
+func (m *MockStorageService) GetUserWithdrawals(arg0 context.Context, arg1 string, arg2 models.ListQuery) ([]models.Withdrawal, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetUserWithdrawals", arg0)
+	ret := m.ctrl.Call(m, "GetUserWithdrawals", arg0, arg1, arg2)
 	ret0, _ := ret[0].([]models.Withdrawal)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetUserWithdrawals indicates an expected call of GetUserWithdrawals.
-func (mr *MockStorageServiceMockRecorder) GetUserWithdrawals(arg0 interface{}) *gomock.Call {
+func (mr *MockStorageServiceMockRecorder) GetUserWithdrawals(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserWithdrawals", reflect.TypeOf((*MockStorageService)(nil).GetUserWithdrawals), arg0, arg1, arg2)
+}
+
+// LockLogin mocks base method.
+func (m *MockStorageService) LockLogin(arg0 context.Context, arg1 string, arg2 time.Duration) (time.Time, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "LockLogin", arg0, arg1, arg2)
+	ret0, _ := ret[0].(time.Time)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// LockLogin indicates an expected call of LockLogin.
+func (mr *MockStorageServiceMockRecorder) LockLogin(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "LockLogin", reflect.TypeOf((*MockStorageService)(nil).LockLogin), arg0, arg1, arg2)
+}
+
+// MigrationVersion mocks base method.
+func (m *MockStorageService) MigrationVersion(arg0 context.Context) (int64, int64, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MigrationVersion", arg0)
+	ret0, _ := ret[0].(int64)
+	ret1, _ := ret[1].(int64)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
+// MigrationVersion indicates an expected call of MigrationVersion.
+func (mr *MockStorageServiceMockRecorder) MigrationVersion(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MigrationVersion", reflect.TypeOf((*MockStorageService)(nil).MigrationVersion), arg0)
+}
+
+// Ping mocks base method.
+func (m *MockStorageService) Ping(arg0 context.Context) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Ping", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Ping indicates an expected call of Ping.
+func (mr *MockStorageServiceMockRecorder) Ping(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Ping", reflect.TypeOf((*MockStorageService)(nil).Ping), arg0)
+}
+
+// PostponeUnregisteredAccrualTask mocks base method.
+func (m *MockStorageService) PostponeUnregisteredAccrualTask(arg0 context.Context, arg1 int, arg2 string, arg3 time.Duration, arg4 int) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "PostponeUnregisteredAccrualTask", arg0, arg1, arg2, arg3, arg4)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// PostponeUnregisteredAccrualTask indicates an expected call of PostponeUnregisteredAccrualTask.
+func (mr *MockStorageServiceMockRecorder) PostponeUnregisteredAccrualTask(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "PostponeUnregisteredAccrualTask", reflect.TypeOf((*MockStorageService)(nil).PostponeUnregisteredAccrualTask), arg0, arg1, arg2, arg3, arg4)
+}
+
+// RequeueAccrualTask mocks base method.
+func (m *MockStorageService) RequeueAccrualTask(arg0 context.Context, arg1 string, arg2 int) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RequeueAccrualTask", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RequeueAccrualTask indicates an expected call of RequeueAccrualTask.
+func (mr *MockStorageServiceMockRecorder) RequeueAccrualTask(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RequeueAccrualTask", reflect.TypeOf((*MockStorageService)(nil).RequeueAccrualTask), arg0, arg1, arg2)
+}
+
+// RescheduleAccrualTask mocks base method.
+func (m *MockStorageService) RescheduleAccrualTask(arg0 context.Context, arg1 int, arg2 time.Duration) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RescheduleAccrualTask", arg0, arg1, arg2)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RescheduleAccrualTask indicates an expected call of RescheduleAccrualTask.
+func (mr *MockStorageServiceMockRecorder) RescheduleAccrualTask(arg0, arg1, arg2 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUserWithdrawals", reflect.TypeOf((*MockStorageService)(nil).GetUserWithdrawals), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RescheduleAccrualTask", reflect.TypeOf((*MockStorageService)(nil).RescheduleAccrualTask), arg0, arg1, arg2)
+}
+
+// ResetLoginFailures mocks base method.
+func (m *MockStorageService) ResetLoginFailures(arg0 context.Context, arg1 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ResetLoginFailures", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ResetLoginFailures indicates an expected call of ResetLoginFailures.
+func (mr *MockStorageServiceMockRecorder) ResetLoginFailures(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ResetLoginFailures", reflect.TypeOf((*MockStorageService)(nil).ResetLoginFailures), arg0, arg1)
 }
 
 // SaveLoginPassword mocks base method.
-func (m *MockStorageService) SaveLoginPassword(arg0, arg1 string) bool {
+func (m *MockStorageService) SaveLoginPassword(arg0 context.Context, arg1, arg2 string) bool {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "SaveLoginPassword", arg0, arg1)
+	ret := m.ctrl.Call(m, "SaveLoginPassword", arg0, arg1, arg2)
 	ret0, _ := ret[0].(bool)
 	return ret0
 }
 
 // SaveLoginPassword indicates an expected call of SaveLoginPassword.
-func (mr *MockStorageServiceMockRecorder) SaveLoginPassword(arg0, arg1 interface{}) *gomock.Call {
+func (mr *MockStorageServiceMockRecorder) SaveLoginPassword(arg0, arg1, arg2 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveLoginPassword", reflect.TypeOf((*MockStorageService)(nil).SaveLoginPassword), arg0, arg1)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveLoginPassword", reflect.TypeOf((*MockStorageService)(nil).SaveLoginPassword), arg0, arg1, arg2)
 }
 
-// SaveUID mocks base method.
-func (m *MockStorageService) SaveUID(arg0, arg1 string) error {
+// SaveTOTPSecret mocks base method.
+func (m *MockStorageService) SaveTOTPSecret(arg0 context.Context, arg1, arg2 string) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "SaveUID", arg0, arg1)
+	ret := m.ctrl.Call(m, "SaveTOTPSecret", arg0, arg1, arg2)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-// SaveUID indicates an expected call of SaveUID.
-func (mr *MockStorageServiceMockRecorder) SaveUID(arg0, arg1 interface{}) *gomock.Call {
+// SaveTOTPSecret indicates an expected call of SaveTOTPSecret.
+func (mr *MockStorageServiceMockRecorder) SaveTOTPSecret(arg0, arg1, arg2 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveUID", reflect.TypeOf((*MockStorageService)(nil).SaveUID), arg0, arg1)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SaveTOTPSecret", reflect.TypeOf((*MockStorageService)(nil).SaveTOTPSecret), arg0, arg1, arg2)
 }
 
-// UpdateOrder mocks base method.
-func (m *MockStorageService) UpdateOrder(arg0 int, arg1 string, arg2 float64) error {
+// SearchUsers mocks base method.
+func (m *MockStorageService) SearchUsers(arg0 context.Context, arg1 string, arg2 int) ([]models.Account, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SearchUsers", arg0, arg1, arg2)
+	ret0, _ := ret[0].([]models.Account)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// SearchUsers indicates an expected call of SearchUsers.
+func (mr *MockStorageServiceMockRecorder) SearchUsers(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SearchUsers", reflect.TypeOf((*MockStorageService)(nil).SearchUsers), arg0, arg1, arg2)
+}
+
+// SetUserLocked mocks base method.
+func (m *MockStorageService) SetUserLocked(arg0 context.Context, arg1, arg2 string, arg3 bool) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "UpdateOrder", arg0, arg1, arg2)
+	ret := m.ctrl.Call(m, "SetUserLocked", arg0, arg1, arg2, arg3)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-// UpdateOrder indicates an expected call of UpdateOrder.
-func (mr *MockStorageServiceMockRecorder) UpdateOrder(arg0, arg1, arg2 interface{}) *gomock.Call {
+// SetUserLocked indicates an expected call of SetUserLocked.
+func (mr *MockStorageServiceMockRecorder) SetUserLocked(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateOrder", reflect.TypeOf((*MockStorageService)(nil).UpdateOrder), arg0, arg1, arg2)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetUserLocked", reflect.TypeOf((*MockStorageService)(nil).SetUserLocked), arg0, arg1, arg2, arg3)
 }
 
-// UpdateUserBalance mocks base method.
-func (m *MockStorageService) UpdateUserBalance(arg0 string, arg1 int, arg2 float64) error {
+// SetUserRole mocks base method.
+func (m *MockStorageService) SetUserRole(arg0 context.Context, arg1, arg2, arg3 string) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "UpdateUserBalance", arg0, arg1, arg2)
+	ret := m.ctrl.Call(m, "SetUserRole", arg0, arg1, arg2, arg3)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-// UpdateUserBalance indicates an expected call of UpdateUserBalance.
-func (mr *MockStorageServiceMockRecorder) UpdateUserBalance(arg0, arg1, arg2 interface{}) *gomock.Call {
+// SetUserRole indicates an expected call of SetUserRole.
+func (mr *MockStorageServiceMockRecorder) SetUserRole(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetUserRole", reflect.TypeOf((*MockStorageService)(nil).SetUserRole), arg0, arg1, arg2, arg3)
+}
+
+// TouchAPIKey mocks base method.
+func (m *MockStorageService) TouchAPIKey(arg0 context.Context, arg1 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "TouchAPIKey", arg0, arg1)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// TouchAPIKey indicates an expected call of TouchAPIKey.
+func (mr *MockStorageServiceMockRecorder) TouchAPIKey(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TouchAPIKey", reflect.TypeOf((*MockStorageService)(nil).TouchAPIKey), arg0, arg1)
+}
+
+// TouchSession mocks base method.
+func (m *MockStorageService) TouchSession(arg0 context.Context, arg1 string) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "TouchSession", arg0, arg1)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// TouchSession indicates an expected call of TouchSession.
+func (mr *MockStorageServiceMockRecorder) TouchSession(arg0, arg1 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TouchSession", reflect.TypeOf((*MockStorageService)(nil).TouchSession), arg0, arg1)
+}
+
+// UpdateOrder mocks base method.
+func (m *MockStorageService) UpdateOrder(arg0 context.Context, arg1 int, arg2 string, arg3 models.Money) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateOrder", arg0, arg1, arg2, arg3)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UpdateOrder indicates an expected call of UpdateOrder.
+func (mr *MockStorageServiceMockRecorder) UpdateOrder(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateOrder", reflect.TypeOf((*MockStorageService)(nil).UpdateOrder), arg0, arg1, arg2, arg3)
+}
+
+// UseRecoveryCode mocks base method.
+func (m *MockStorageService) UseRecoveryCode(arg0 context.Context, arg1 string, arg2 int64) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UseRecoveryCode", arg0, arg1, arg2)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UseRecoveryCode indicates an expected call of UseRecoveryCode.
+func (mr *MockStorageServiceMockRecorder) UseRecoveryCode(arg0, arg1, arg2 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UseRecoveryCode", reflect.TypeOf((*MockStorageService)(nil).UseRecoveryCode), arg0, arg1, arg2)
+}
+
+// UseTOTPStep mocks base method.
+func (m *MockStorageService) UseTOTPStep(arg0 context.Context, arg1 string, arg2 int64) (bool, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UseTOTPStep", arg0, arg1, arg2)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// UseTOTPStep indicates an expected call of UseTOTPStep.
+func (mr *MockStorageServiceMockRecorder) UseTOTPStep(arg0, arg1, arg2 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateUserBalance", reflect.TypeOf((*MockStorageService)(nil).UpdateUserBalance), arg0, arg1, arg2)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UseTOTPStep", reflect.TypeOf((*MockStorageService)(nil).UseTOTPStep), arg0, arg1, arg2)
 }
 
 // WithdrawFromUserBalance mocks base method.
-func (m *MockStorageService) WithdrawFromUserBalance(arg0 string, arg1 int, arg2 float64) error {
+func (m *MockStorageService) WithdrawFromUserBalance(arg0 context.Context, arg1 string, arg2 int, arg3 models.Money) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "WithdrawFromUserBalance", arg0, arg1, arg2)
+	ret := m.ctrl.Call(m, "WithdrawFromUserBalance", arg0, arg1, arg2, arg3)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // WithdrawFromUserBalance indicates an expected call of WithdrawFromUserBalance.
-func (mr *MockStorageServiceMockRecorder) WithdrawFromUserBalance(arg0, arg1, arg2 interface{}) *gomock.Call {
+func (mr *MockStorageServiceMockRecorder) WithdrawFromUserBalance(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WithdrawFromUserBalance", reflect.TypeOf((*MockStorageService)(nil).WithdrawFromUserBalance), arg0, arg1, arg2)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WithdrawFromUserBalance", reflect.TypeOf((*MockStorageService)(nil).WithdrawFromUserBalance), arg0, arg1, arg2, arg3)
 }
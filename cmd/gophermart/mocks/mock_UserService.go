@@ -34,6 +34,18 @@ func (m *MockUserService) EXPECT() *MockUserServiceMockRecorder {
 	return m.recorder
 }
 
+// DeleteUserIDCookie mocks base method.
+func (m *MockUserService) DeleteUserIDCookie(arg0 http.ResponseWriter) {
+	m.ctrl.T.Helper()
+	m.ctrl.Call(m, "DeleteUserIDCookie", arg0)
+}
+
+// DeleteUserIDCookie indicates an expected call of DeleteUserIDCookie.
+func (mr *MockUserServiceMockRecorder) DeleteUserIDCookie(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteUserIDCookie", reflect.TypeOf((*MockUserService)(nil).DeleteUserIDCookie), arg0)
+}
+
 // GetUserIDFromCookie mocks base method.
 func (m *MockUserService) GetUserIDFromCookie(arg0 *http.Request) (string, error) {
 	m.ctrl.T.Helper()
@@ -1,38 +1,294 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 
 	"github.com/EClaesson/go-luhn"
 )
 
 type Order struct {
+	Login      string    `json:"-"`
 	Number     string    `json:"number"`
 	Status     string    `json:"status"`
-	Accrual    float64   `json:"accrual,omitempty"`
+	Accrual    Money     `json:"accrual,omitempty"`
 	UploadedAt time.Time `json:"uploaded_at"`
 }
 
+// Session - вход пользователя с устройства. ID хранится в cookie AuthToken.
+type Session struct {
+	ID         string    `json:"id"`
+	Login      string    `json:"-"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	UserAgent  string    `json:"user_agent,omitempty"`
+	IP         string    `json:"ip,omitempty"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current,omitempty"` // сессия, с которой пришёл запрос
+}
+
+// PasswordChange - тело POST /api/user/password
+type PasswordChange struct {
+	OldPassword string `json:"old_password"`
+	NewPassword string `json:"new_password"`
+}
+
+// PasswordViolation - нарушенное правило парольной политики
+type PasswordViolation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// TOTP - второй фактор пользователя. Пока Enabled = false, подключение не подтверждено кодом.
+type TOTP struct {
+	Secret       string
+	Enabled      bool
+	LastUsedStep int64 // интервал последнего принятого кода
+}
+
+// RecoveryCode - неиспользованный код восстановления (хранится только хеш)
+type RecoveryCode struct {
+	ID   int64
+	Hash string
+}
+
+// MFAChallenge - вход с верным паролем, ожидающий кода второго фактора
+type MFAChallenge struct {
+	ID        string
+	Login     string
+	ExpiresAt time.Time
+}
+
+// TOTPEnrollment - ответ POST /api/user/2fa/totp
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"otpauth_uri"`
+}
+
+// TOTPCode - код из приложения-аутентификатора или код восстановления
+type TOTPCode struct {
+	Code string `json:"code"`
+}
+
+// RecoveryCodes выдаются один раз при подтверждении TOTP
+type RecoveryCodes struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// LoginChallenge - ответ 202 на POST /api/user/login, если включён второй фактор
+type LoginChallenge struct {
+	MFARequired bool      `json:"mfa_required"`
+	ChallengeID string    `json:"challenge_id"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+// LoginSecondFactor - тело POST /api/user/login/totp
+type LoginSecondFactor struct {
+	ChallengeID string `json:"challenge_id"`
+	Code        string `json:"code"`
+}
+
+// Права API-ключа
+const (
+	ScopeOrdersRead      = "orders:read"
+	ScopeOrdersWrite     = "orders:write"
+	ScopeBalanceRead     = "balance:read"
+	ScopeBalanceWithdraw = "balance:withdraw"
+)
+
+// APIScopes - все права, которые можно выдать API-ключу
+var APIScopes = []string{ScopeOrdersRead, ScopeOrdersWrite, ScopeBalanceRead, ScopeBalanceWithdraw}
+
+// APIKey - ключ для интеграций. Ключ магазина (Merchant) может загружать заказы других пользователей.
+type APIKey struct {
+	ID         string     `json:"id"`
+	Login      string     `json:"-"` // владелец
+	Name       string     `json:"name"`
+	Merchant   bool       `json:"merchant,omitempty"`
+	Scopes     []string   `json:"scopes"`
+	Hash       string     `json:"-"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+// HasScope - выдано ли ключу право scope
+func (k *APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// APIKeyCreate - тело POST /api/user/api-keys
+type APIKeyCreate struct {
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	Merchant  bool       `json:"merchant"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// APIKeyCreated - ответ 201: сам ключ показывается только один раз
+type APIKeyCreated struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+// Роли пользователей: support видит данные пользователей, admin также меняет их
+const (
+	RoleUser    = "user"
+	RoleSupport = "support"
+	RoleAdmin   = "admin"
+)
+
+// Roles - все роли, которые можно назначить пользователю
+var Roles = []string{RoleUser, RoleSupport, RoleAdmin}
+
+// Account - пользователь в back-office API
+type Account struct {
+	Login    string     `json:"login"`
+	Role     string     `json:"role"`
+	LockedAt *time.Time `json:"locked_at,omitempty"`
+
+	TokenVersion int64 `json:"-"` // версия Bearer-токенов, см. StorageService.ChangePassword
+}
+
+func (a Account) Locked() bool {
+	return a.LockedAt != nil
+}
+
+// RoleChange - тело PUT /api/admin/users/{login}/role
+type RoleChange struct {
+	Role string `json:"role"`
+}
+
+// BalanceAdjustment - ручная корректировка баланса: Amount со знаком, причина обязательна
+type BalanceAdjustment struct {
+	Login     string    `json:"-"`
+	Amount    Money     `json:"amount"`
+	Reason    string    `json:"reason"`
+	Actor     string    `json:"-"` // администратор
+	CreatedAt time.Time `json:"-"`
+}
+
+// AuditEvent - запись журнала аудита. Hash связывает запись с предыдущей (PrevHash),
+// поэтому изменение или удаление записи в середине журнала обнаруживается.
+type AuditEvent struct {
+	ID        int64           `json:"id"`
+	Actor     string          `json:"actor"`
+	Action    string          `json:"action"`
+	Target    string          `json:"target,omitempty"` // пользователь, которого касается событие
+	RequestID string          `json:"request_id,omitempty"`
+	IP        string          `json:"ip,omitempty"`
+	Payload   json.RawMessage `json:"payload,omitempty"`
+	CreatedAt time.Time       `json:"created_at"`
+	PrevHash  string          `json:"prev_hash"`
+	Hash      string          `json:"hash"`
+}
+
+// AuditFilter - условия GET /api/admin/audit. Пустые поля не ограничивают выборку.
+type AuditFilter struct {
+	Actor     string
+	Action    string
+	Target    string
+	RequestID string
+	From      time.Time
+	To        time.Time
+	BeforeID  int64 // для следующей страницы: записи с ID меньше BeforeID
+	Limit     int
+}
+
+// AuditVerification - результат проверки цепочки хешей журнала аудита
+type AuditVerification struct {
+	Valid    bool  `json:"valid"`
+	Checked  int   `json:"checked"`
+	BrokenAt int64 `json:"broken_at,omitempty"` // первая запись, не сходящаяся с цепочкой
+}
+
 type UserBalance struct {
-	Current   float64 `json:"current"`
-	Withdrawn float64 `json:"withdrawn"`
+	Current   Money `json:"current"`
+	Withdrawn Money `json:"withdrawn"`
 }
 
 type WithdrawRequest struct {
-	Order string  `json:"order"` // тут строка (судя по примеру тела запроса POST /api/user/balance/withdraw)
-	Sum   float64 `json:"sum"`
+	Order string `json:"order"` // тут строка (судя по примеру тела запроса POST /api/user/balance/withdraw)
+	Sum   Money  `json:"sum"`
 }
 
 type Withdrawal struct {
 	Order       string    `json:"order"`
-	Sum         float64   `json:"sum"`
+	Sum         Money     `json:"sum"`
 	ProcessedAt time.Time `json:"processed_at"`
 }
 
+// ListQuery - фильтры и страница для списков заказов и списаний пользователя.
+// Пустой ListQuery - весь список от новых записей к старым, как требует спецификация.
+type ListQuery struct {
+	Statuses []string  // только для заказов
+	From     time.Time // включительно
+	To       time.Time // не включая
+	Asc      bool      // от старых записей к новым
+	After    *ListCursor
+	Limit    int // 0 - без ограничения
+}
+
+// ListCursor - последняя запись предыдущей страницы: время загрузки заказа или списания и номер заказа
+type ListCursor struct {
+	At     time.Time
+	Number int64
+}
+
 type AccrualResponse struct {
-	Order   string  `json:"order"`
-	Status  string  `json:"status"`
-	Accrual float64 `json:"accrual,omitempty"`
+	Order   string `json:"order"`
+	Status  string `json:"status"`
+	Accrual Money  `json:"accrual,omitempty"`
+}
+
+// OrderStatusChange - переход заказа между статусами. From пуст у первой записи (загрузка заказа).
+type OrderStatusChange struct {
+	From      string    `json:"from,omitempty"`
+	To        string    `json:"to"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+// OrderEvent - изменение заказа для потока GET /api/user/orders/stream
+type OrderEvent struct {
+	Number    string    `json:"number"`
+	Status    string    `json:"status"`
+	Accrual   Money     `json:"accrual,omitempty"`
+	ChangedAt time.Time `json:"changed_at"`
+}
+
+// AccrualTask - заказ, статус которого нужно запросить у Accrual
+type AccrualTask struct {
+	UserLogin    string
+	OrderNumber  int
+	Attempts     int // число неудачных попыток
+	Unregistered int // сколько раз Accrual ответил, что заказ не зарегистрирован
+}
+
+// Состояния задачи опроса Accrual
+const (
+	AccrualTaskPending = "pending"
+	AccrualTaskDone    = "done" // заказ получил финальный статус
+	AccrualTaskDead    = "dead" // попытки исчерпаны
+)
+
+// AccrualTaskInfo - сведения о попытках узнать статус заказа у Accrual
+type AccrualTaskInfo struct {
+	State         string     `json:"state"`
+	Attempts      int        `json:"attempts"` // неудачные попытки
+	LastError     string     `json:"last_error,omitempty"`
+	NextAttemptAt *time.Time `json:"next_attempt_at,omitempty"` // только для pending
+}
+
+// OrderDetails - заказ вместе с историей статусов (GET /api/user/orders/{number})
+type OrderDetails struct {
+	Order
+	History     []OrderStatusChange `json:"history"`
+	AccrualTask *AccrualTaskInfo    `json:"accrual_task,omitempty"`
 }
 
 type AccrualGoods struct {
@@ -51,6 +307,17 @@ type RewardRequest struct {
 	RewardType string `json:"reward_type"`
 }
 
+type DependencyStatus struct {
+	Status  string `json:"status"`
+	Error   string `json:"error,omitempty"`
+	Version int64  `json:"version,omitempty"`
+}
+
+type ReadinessResponse struct {
+	Status string                      `json:"status"`
+	Checks map[string]DependencyStatus `json:"checks"`
+}
+
 func IsValidOrderNumber(number string) bool {
 	res, _ := luhn.IsValid(number)
 	return res
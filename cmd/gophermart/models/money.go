@@ -0,0 +1,91 @@
+package models
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+)
+
+// Money - количество баллов в минимальных единицах (1 балл = 100 единиц).
+// В JSON передаётся как десятичное число с двумя знаками после точки (500.5, 729.98).
+type Money int64
+
+const moneyScale = 100
+
+var ErrInvalidMoney = errors.New("invalid money value")
+
+func NewMoney(points float64) Money {
+	return Money(math.Round(points * moneyScale))
+}
+
+func (m Money) String() string {
+	sign := ""
+	v := int64(m)
+	if v < 0 {
+		sign = "-"
+		v = -v
+	}
+
+	whole, frac := v/moneyScale, v%moneyScale
+	switch {
+	case frac == 0:
+		return fmt.Sprintf("%s%d", sign, whole)
+	case frac%10 == 0:
+		return fmt.Sprintf("%s%d.%d", sign, whole, frac/10)
+	default:
+		return fmt.Sprintf("%s%d.%02d", sign, whole, frac)
+	}
+}
+
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalJSON разбирает число без промежуточного float64, лишние знаки округляются до сотых
+func (m *Money) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+	if unquoted, err := strconv.Unquote(s); err == nil {
+		s = unquoted
+	}
+
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrInvalidMoney, s)
+	}
+	r.Mul(r, big.NewRat(moneyScale, 1))
+
+	// Округление половины от нуля
+	num, den := r.Num(), r.Denom()
+	q, rem := new(big.Int).QuoRem(num, den, new(big.Int))
+	if new(big.Int).Mul(new(big.Int).Abs(rem), big.NewInt(2)).Cmp(den) >= 0 {
+		q.Add(q, big.NewInt(int64(num.Sign())))
+	}
+	if !q.IsInt64() {
+		return fmt.Errorf("%w: %s", ErrInvalidMoney, s)
+	}
+
+	*m = Money(q.Int64())
+	return nil
+}
+
+func (m *Money) Scan(src any) error {
+	switch v := src.(type) {
+	case int64:
+		*m = Money(v)
+	case nil:
+		*m = 0
+	default:
+		return fmt.Errorf("%w: cannot scan %T", ErrInvalidMoney, src)
+	}
+	return nil
+}
+
+func (m Money) Value() (driver.Value, error) {
+	return int64(m), nil
+}
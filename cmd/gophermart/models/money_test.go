@@ -0,0 +1,68 @@
+//go:build unit
+// +build unit
+
+package models_test
+
+import (
+	"encoding/json"
+	"gophermart/cmd/gophermart/models"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_MoneyMarshalJSON(t *testing.T) {
+	tests := []struct {
+		money    models.Money
+		expected string
+	}{
+		{models.Money(50050), "500.5"},
+		{models.Money(72998), "729.98"},
+		{models.Money(50000), "500"},
+		{models.Money(5), "0.05"},
+		{models.Money(0), "0"},
+		{models.Money(-1250), "-12.5"},
+	}
+
+	for _, tt := range tests {
+		data, err := json.Marshal(tt.money)
+		require.NoError(t, err)
+		assert.Equal(t, tt.expected, string(data))
+	}
+}
+
+func Test_MoneyUnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		data     string
+		expected models.Money
+	}{
+		{"500.5", models.Money(50050)},
+		{"729.98", models.Money(72998)},
+		{"500", models.Money(50000)},
+		{"0.1", models.Money(10)},
+		{"1e2", models.Money(10000)},
+		{"0.005", models.Money(1)},
+		{"-0.005", models.Money(-1)},
+	}
+
+	for _, tt := range tests {
+		var m models.Money
+		require.NoError(t, json.Unmarshal([]byte(tt.data), &m), tt.data)
+		assert.Equal(t, tt.expected, m, tt.data)
+	}
+
+	var m models.Money
+	assert.ErrorIs(t, json.Unmarshal([]byte(`"abc"`), &m), models.ErrInvalidMoney)
+}
+
+func Test_MoneyRepeatedAdditionDoesNotDrift(t *testing.T) {
+	var sum models.Money
+	for range 10 {
+		sum += models.NewMoney(0.1)
+	}
+	assert.Equal(t, models.NewMoney(1), sum)
+
+	data, _ := json.Marshal(models.UserBalance{Current: sum})
+	assert.JSONEq(t, `{"current": 1, "withdrawn": 0}`, string(data))
+}
@@ -0,0 +1,81 @@
+package orderstate
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Статусы заказа в gophermart
+const (
+	New        = "NEW"        // заказ загружен, но ещё не попал в обработку
+	Processing = "PROCESSING" // вознаграждение рассчитывается
+	Invalid    = "INVALID"    // Accrual отказал в расчёте
+	Processed  = "PROCESSED"  // расчёт окончен
+)
+
+// Статусы заказа в Accrual. AccrualNotRegistered - ответ 204, у Accrual нет статуса для него.
+const (
+	AccrualNotRegistered = ""
+	AccrualRegistered    = "REGISTERED"
+	AccrualProcessing    = "PROCESSING"
+	AccrualInvalid       = "INVALID"
+	AccrualProcessed     = "PROCESSED"
+)
+
+var (
+	ErrUnknownStatus        = errors.New("unknown order status")
+	ErrTransitionNotAllowed = errors.New("order status transition is not allowed")
+)
+
+// transitions - из какого статуса в какие можно перейти. Из финальных статусов переходов нет.
+var transitions = map[string][]string{
+	New:        {Processing, Invalid, Processed},
+	Processing: {Invalid, Processed},
+	Invalid:    nil,
+	Processed:  nil,
+}
+
+// FromAccrual переводит статус Accrual в статус gophermart
+func FromAccrual(accrualStatus string) (string, error) {
+	switch accrualStatus {
+	case AccrualNotRegistered:
+		return New, nil
+	case AccrualRegistered, AccrualProcessing:
+		return Processing, nil
+	case AccrualInvalid:
+		return Invalid, nil
+	case AccrualProcessed:
+		return Processed, nil
+	}
+	return "", fmt.Errorf("%w: %q", ErrUnknownStatus, accrualStatus)
+}
+
+// IsKnown - является ли status статусом заказа в gophermart
+func IsKnown(status string) bool {
+	_, ok := transitions[status]
+	return ok
+}
+
+func IsFinal(status string) bool {
+	return status == Invalid || status == Processed
+}
+
+// Check проверяет переход from -> to. Повтор текущего статуса не считается переходом и разрешён.
+func Check(from, to string) error {
+	if _, ok := transitions[to]; !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownStatus, to)
+	}
+	allowed, ok := transitions[from]
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrUnknownStatus, from)
+	}
+	if from == to {
+		return nil
+	}
+	for _, s := range allowed {
+		if s == to {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s -> %s", ErrTransitionNotAllowed, from, to)
+}
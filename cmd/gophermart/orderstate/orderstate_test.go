@@ -0,0 +1,67 @@
+//go:build unit
+// +build unit
+
+package orderstate
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_FromAccrual(t *testing.T) {
+	tests := []struct {
+		accrual  string
+		expected string
+	}{
+		{AccrualNotRegistered, New},
+		{AccrualRegistered, Processing},
+		{AccrualProcessing, Processing},
+		{AccrualInvalid, Invalid},
+		{AccrualProcessed, Processed},
+	}
+
+	for _, tt := range tests {
+		status, err := FromAccrual(tt.accrual)
+		assert.NoError(t, err)
+		assert.Equal(t, tt.expected, status, "accrual status %q", tt.accrual)
+	}
+
+	_, err := FromAccrual("CANCELLED")
+	assert.ErrorIs(t, err, ErrUnknownStatus)
+}
+
+func Test_Check(t *testing.T) {
+	tests := []struct {
+		from, to string
+		err      error
+	}{
+		{New, New, nil},
+		{New, Processing, nil},
+		{New, Processed, nil},
+		{Processing, Processing, nil},
+		{Processing, Invalid, nil},
+		{Processed, Processed, nil},
+		{Processing, New, ErrTransitionNotAllowed},
+		{Processed, Processing, ErrTransitionNotAllowed},
+		{Invalid, Processed, ErrTransitionNotAllowed},
+		{New, "REGISTERED", ErrUnknownStatus},
+	}
+
+	for _, tt := range tests {
+		err := Check(tt.from, tt.to)
+		if tt.err == nil {
+			assert.NoError(t, err, "%s -> %s", tt.from, tt.to)
+		} else {
+			assert.ErrorIs(t, err, tt.err, "%s -> %s", tt.from, tt.to)
+		}
+	}
+}
+
+func Test_IsKnown(t *testing.T) {
+	for _, status := range []string{New, Processing, Invalid, Processed} {
+		assert.True(t, IsKnown(status), status)
+	}
+	assert.False(t, IsKnown(AccrualRegistered))
+	assert.False(t, IsKnown("new"))
+}
@@ -0,0 +1,161 @@
+package problem
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-chi/chi/v5/middleware"
+)
+
+// ContentType - тип тела ответа с ошибкой (RFC 7807)
+const ContentType = "application/problem+json"
+
+// typePrefix - Type ошибки: URN с её кодом, по нему клиент может различать ошибки так же, как по Code
+const typePrefix = "urn:gophermart:problem:"
+
+// Языки заголовков; DefaultLanguage - если в Accept-Language нет поддерживаемого
+const (
+	LanguageEN      = "en"
+	LanguageRU      = "ru"
+	DefaultLanguage = LanguageEN
+)
+
+type titles struct {
+	en string
+	ru string
+}
+
+// Kind - вид ошибки: стабильный код для клиентов, HTTP-статус и заголовок на поддерживаемых языках
+type Kind struct {
+	Code   string
+	Status int
+	title  titles
+}
+
+// Title - заголовок на языке lang (на DefaultLanguage, если перевода нет)
+func (k Kind) Title(lang string) string {
+	if lang == LanguageRU && k.title.ru != "" {
+		return k.title.ru
+	}
+	return k.title.en
+}
+
+// Коды ошибок - часть API: их нельзя менять, только добавлять новые
+var (
+	BadRequest            = Kind{"bad_request", http.StatusBadRequest, titles{"Bad request", "Некорректный запрос"}}
+	InvalidParameter      = Kind{"invalid_parameter", http.StatusBadRequest, titles{"Invalid query parameter", "Некорректный параметр запроса"}}
+	WeakPassword          = Kind{"weak_password", http.StatusBadRequest, titles{"Password does not meet the policy", "Пароль не соответствует требованиям"}}
+	Unauthorized          = Kind{"unauthorized", http.StatusUnauthorized, titles{"Authentication required", "Требуется аутентификация"}}
+	InvalidCredentials    = Kind{"invalid_credentials", http.StatusUnauthorized, titles{"Invalid login or password", "Неверный логин или пароль"}}
+	InvalidAPIKey         = Kind{"invalid_api_key", http.StatusUnauthorized, titles{"Invalid API key", "Недействительный API-ключ"}}
+	ChallengeExpired      = Kind{"login_challenge_expired", http.StatusUnauthorized, titles{"Login challenge expired", "Время на подтверждение входа истекло"}}
+	InsufficientFunds     = Kind{"insufficient_funds", http.StatusPaymentRequired, titles{"Insufficient funds", "Недостаточно баллов на счёте"}}
+	Forbidden             = Kind{"forbidden", http.StatusForbidden, titles{"Access denied", "Доступ запрещён"}}
+	APIKeyForbidden       = Kind{"api_key_forbidden", http.StatusForbidden, titles{"Not allowed for this API key", "Недоступно для этого API-ключа"}}
+	AccountLocked         = Kind{"account_locked", http.StatusForbidden, titles{"Account is locked", "Учётная запись заблокирована"}}
+	TOTPRequired          = Kind{"totp_required", http.StatusForbidden, titles{"TOTP code required", "Требуется одноразовый код"}}
+	InvalidTOTPCode       = Kind{"invalid_totp_code", http.StatusForbidden, titles{"Invalid TOTP code", "Неверный одноразовый код"}}
+	InvalidPassword       = Kind{"invalid_password", http.StatusForbidden, titles{"Invalid current password", "Неверный текущий пароль"}}
+	NotFound              = Kind{"not_found", http.StatusNotFound, titles{"Not found", "Не найдено"}}
+	UserNotFound          = Kind{"user_not_found", http.StatusNotFound, titles{"User not found", "Пользователь не найден"}}
+	OrderNotFound         = Kind{"order_not_found", http.StatusNotFound, titles{"Order not found", "Заказ не найден"}}
+	SessionNotFound       = Kind{"session_not_found", http.StatusNotFound, titles{"Session not found", "Сессия не найдена"}}
+	APIKeyNotFound        = Kind{"api_key_not_found", http.StatusNotFound, titles{"API key not found", "API-ключ не найден"}}
+	TOTPNotEnrolled       = Kind{"totp_not_enrolled", http.StatusNotFound, titles{"TOTP enrollment not started", "Подключение TOTP не начато"}}
+	MethodNotAllowed      = Kind{"method_not_allowed", http.StatusMethodNotAllowed, titles{"Method not allowed", "Метод не поддерживается"}}
+	LoginTaken            = Kind{"login_taken", http.StatusConflict, titles{"Login already taken", "Логин уже занят"}}
+	OrderConflict         = Kind{"order_conflict", http.StatusConflict, titles{"Order was uploaded by another user", "Заказ загружен другим пользователем"}}
+	TOTPEnabled           = Kind{"totp_already_enabled", http.StatusConflict, titles{"TOTP is already enabled", "TOTP уже включён"}}
+	TOTPEnrollmentChanged = Kind{"totp_enrollment_changed", http.StatusConflict, titles{"TOTP enrollment changed", "Подключение TOTP изменилось"}}
+	SelfAction            = Kind{"self_action", http.StatusConflict, titles{"Not allowed for own account", "Недоступно для своей учётной записи"}}
+	NegativeBalance       = Kind{"negative_balance", http.StatusConflict, titles{"Balance cannot become negative", "Баланс не может стать отрицательным"}}
+	InvalidOrderNumber    = Kind{"invalid_order_number", http.StatusUnprocessableEntity, titles{"Invalid order number", "Неверный номер заказа"}}
+	InvalidAmount         = Kind{"invalid_amount", http.StatusUnprocessableEntity, titles{"Amount must be positive", "Сумма должна быть больше нуля"}}
+	WithdrawalExists      = Kind{"withdrawal_exists", http.StatusConflict, titles{"Order already used for withdrawal", "По заказу уже было списание"}}
+	TooManyLoginAttempts  = Kind{"too_many_login_attempts", http.StatusTooManyRequests, titles{"Too many login attempts", "Слишком много попыток входа"}}
+	Internal              = Kind{"internal_error", http.StatusInternalServerError, titles{"Internal server error", "Внутренняя ошибка сервера"}}
+	AccrualUnavailable    = Kind{"accrual_unavailable", http.StatusBadGateway, titles{"Accrual service unavailable", "Сервис расчёта баллов недоступен"}}
+)
+
+// Problem - тело ответа с ошибкой. Code и RequestID - расширения RFC 7807,
+// Extensions дописываются в тот же объект (например, нарушения политики паролей).
+type Problem struct {
+	Type       string         `json:"type"`
+	Title      string         `json:"title"`
+	Status     int            `json:"status"`
+	Detail     string         `json:"detail,omitempty"`
+	Instance   string         `json:"instance,omitempty"`
+	Code       string         `json:"code"`
+	RequestID  string         `json:"request_id,omitempty"`
+	Extensions map[string]any `json:"-"`
+
+	lang string
+}
+
+// New - ошибка kind в ответ на req: заголовок на языке клиента, ID запроса от middleware.RequestID.
+// detail - пояснение для клиента; внутренние подробности в него не попадают.
+func New(req *http.Request, kind Kind, detail string) Problem {
+	lang := Language(req.Header.Get("Accept-Language"))
+	return Problem{
+		Type:      typePrefix + kind.Code,
+		Title:     kind.Title(lang),
+		Status:    kind.Status,
+		Detail:    detail,
+		Instance:  req.URL.Path,
+		Code:      kind.Code,
+		RequestID: middleware.GetReqID(req.Context()),
+		lang:      lang,
+	}
+}
+
+func (p Problem) MarshalJSON() ([]byte, error) {
+	type fields Problem
+	data, err := json.Marshal(fields(p))
+	if err != nil || len(p.Extensions) == 0 {
+		return data, err
+	}
+	extensions, err := json.Marshal(p.Extensions)
+	if err != nil {
+		return nil, err
+	}
+	// {...,"request_id":"..."} + {"violations":[...]} -> {...,"request_id":"...","violations":[...]}
+	return append(append(data[:len(data)-1], ','), extensions[1:]...), nil
+}
+
+// Write отправляет ошибку клиенту
+func Write(res http.ResponseWriter, p Problem) {
+	res.Header().Set("Content-Type", ContentType)
+	if p.lang != "" {
+		res.Header().Set("Content-Language", p.lang)
+		res.Header().Add("Vary", "Accept-Language")
+	}
+	res.WriteHeader(p.Status)
+	_ = json.NewEncoder(res).Encode(p)
+}
+
+// Language выбирает из Accept-Language поддерживаемый язык с наибольшим весом q
+func Language(acceptLanguage string) string {
+	lang, weight := DefaultLanguage, 0.0
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		base, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+		if base != LanguageEN && base != LanguageRU {
+			continue
+		}
+
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if q > weight {
+			lang, weight = base, q
+		}
+	}
+	return lang
+}
@@ -0,0 +1,99 @@
+//go:build unit
+// +build unit
+
+package problem
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Language(t *testing.T) {
+	tests := []struct {
+		header   string
+		expected string
+	}{
+		{"", LanguageEN},
+		{"ru", LanguageRU},
+		{"ru-RU,ru;q=0.9,en-US;q=0.8,en;q=0.7", LanguageRU},
+		{"en-GB, ru;q=0.5", LanguageEN},
+		{"de-DE, ru;q=0.3, en;q=0.5", LanguageEN},
+		{"de, fr;q=0.8", DefaultLanguage},
+		{"RU;q=0.9, en;q=bad", LanguageRU},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, Language(tt.header), "Accept-Language: %q", tt.header)
+	}
+}
+
+func Test_New(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/api/user/balance/withdraw", http.NoBody)
+	req.Header.Set("Accept-Language", "ru-RU")
+	req = req.WithContext(context.WithValue(req.Context(), middleware.RequestIDKey, "req-42"))
+
+	p := New(req, InsufficientFunds, "")
+
+	assert.Equal(t, Problem{
+		Type:      "urn:gophermart:problem:insufficient_funds",
+		Title:     "Недостаточно баллов на счёте",
+		Status:    http.StatusPaymentRequired,
+		Instance:  "/api/user/balance/withdraw",
+		Code:      "insufficient_funds",
+		RequestID: "req-42",
+		lang:      LanguageRU,
+	}, p)
+}
+
+func Test_Write(t *testing.T) {
+	req := httptest.NewRequest(http.MethodPost, "/api/user/register", http.NoBody)
+	p := New(req, WeakPassword, "too short")
+	p.Extensions = map[string]any{"violations": []string{"min_length"}}
+
+	w := httptest.NewRecorder()
+	Write(w, p)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, ContentType, w.Header().Get("Content-Type"))
+	assert.Equal(t, LanguageEN, w.Header().Get("Content-Language"))
+	assert.JSONEq(t, `{
+		"type": "urn:gophermart:problem:weak_password",
+		"title": "Password does not meet the policy",
+		"status": 400,
+		"detail": "too short",
+		"instance": "/api/user/register",
+		"code": "weak_password",
+		"violations": ["min_length"]
+	}`, w.Body.String())
+
+	var decoded Problem
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &decoded))
+	assert.Equal(t, "weak_password", decoded.Code)
+}
+
+func Test_Titles(t *testing.T) {
+	kinds := []Kind{
+		BadRequest, InvalidParameter, WeakPassword, Unauthorized, InvalidCredentials, InvalidAPIKey, ChallengeExpired,
+		InsufficientFunds, Forbidden, APIKeyForbidden, AccountLocked, TOTPRequired, InvalidTOTPCode, InvalidPassword,
+		NotFound, UserNotFound, OrderNotFound, SessionNotFound, APIKeyNotFound, TOTPNotEnrolled, MethodNotAllowed,
+		LoginTaken, OrderConflict, TOTPEnabled, TOTPEnrollmentChanged, SelfAction, NegativeBalance, InvalidOrderNumber,
+		InvalidAmount, WithdrawalExists, TooManyLoginAttempts, Internal, AccrualUnavailable,
+	}
+
+	codes := map[string]bool{}
+	for _, kind := range kinds {
+		assert.False(t, codes[kind.Code], "duplicate code %s", kind.Code)
+		codes[kind.Code] = true
+		assert.NotEmpty(t, kind.Title(LanguageEN), kind.Code)
+		assert.NotEmpty(t, kind.Title(LanguageRU), kind.Code)
+		assert.NotEqual(t, kind.Title(LanguageEN), kind.Title(LanguageRU), kind.Code)
+		assert.GreaterOrEqual(t, kind.Status, 400, kind.Code)
+	}
+}
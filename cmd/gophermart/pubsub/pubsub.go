@@ -0,0 +1,141 @@
+package pubsub
+
+import (
+	"gophermart/cmd/gophermart/models"
+	"sync"
+)
+
+// subscriptionBuffer - сколько событий может ждать медленный подписчик, прежде чем его отключат
+const subscriptionBuffer = 16
+
+type Event struct {
+	ID    uint64
+	Login string
+	Order models.OrderEvent
+}
+
+type Subscription struct {
+	login  string
+	events chan Event
+}
+
+// Events закрывается при отключении подписчика: Unsubscribe, переполнение буфера или Close
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Broker рассылает изменения заказов подписчикам их владельца и хранит последние события,
+// чтобы переподключившийся клиент получил пропущенное (Last-Event-ID)
+type Broker struct {
+	mu          sync.Mutex
+	lastID      uint64
+	history     []Event // кольцевой буфер последних событий
+	historySize int
+	subscribers map[string]map[*Subscription]struct{}
+	closed      bool
+}
+
+func NewBroker(historySize int) *Broker {
+	return &Broker{
+		historySize: historySize,
+		subscribers: make(map[string]map[*Subscription]struct{}),
+	}
+}
+
+func (b *Broker) Publish(login string, order models.OrderEvent) Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lastID++
+	event := Event{ID: b.lastID, Login: login, Order: order}
+
+	b.history = append(b.history, event)
+	if len(b.history) > b.historySize {
+		b.history = b.history[len(b.history)-b.historySize:]
+	}
+
+	for sub := range b.subscribers[login] {
+		select {
+		case sub.events <- event:
+		default:
+			// Клиент переподключится с Last-Event-ID и догонит по истории
+			b.remove(sub)
+		}
+	}
+	return event
+}
+
+// Subscribe подписывает на события login. Если lastEventID != 0, возвращает события после него;
+// resumed == false для нового подключения или если событий уже нет в истории (нужно отдать текущее состояние).
+// lastID - последний выданный ID на момент подписки.
+func (b *Broker) Subscribe(login string, lastEventID uint64) (sub *Subscription, missed []Event, resumed bool, lastID uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	sub = &Subscription{login: login, events: make(chan Event, subscriptionBuffer)}
+	if b.closed {
+		close(sub.events)
+		return sub, nil, true, b.lastID
+	}
+	if b.subscribers[login] == nil {
+		b.subscribers[login] = make(map[*Subscription]struct{})
+	}
+	b.subscribers[login][sub] = struct{}{}
+
+	if lastEventID == 0 {
+		return sub, nil, false, b.lastID
+	}
+
+	// ID из другого запуска сервиса или вытесненный из истории
+	oldest := b.lastID + 1
+	if len(b.history) > 0 {
+		oldest = b.history[0].ID
+	}
+	if lastEventID > b.lastID || lastEventID+1 < oldest {
+		return sub, nil, false, b.lastID
+	}
+
+	for _, event := range b.history {
+		if event.ID > lastEventID && event.Login == login {
+			missed = append(missed, event)
+		}
+	}
+	return sub, missed, true, b.lastID
+}
+
+func (b *Broker) Unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.remove(sub)
+}
+
+// Close отключает всех подписчиков, новые подписки сразу закрыты. Нужен для остановки сервера:
+// иначе открытые потоки не дают завершиться http.Server.Shutdown.
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for _, subs := range b.subscribers {
+		for sub := range subs {
+			b.remove(sub)
+		}
+	}
+}
+
+// remove вызывать под b.mu
+func (b *Broker) remove(sub *Subscription) {
+	subs, ok := b.subscribers[sub.login]
+	if !ok {
+		return
+	}
+	if _, ok := subs[sub]; !ok {
+		return
+	}
+	delete(subs, sub)
+	if len(subs) == 0 {
+		delete(b.subscribers, sub.login)
+	}
+	close(sub.events)
+}
@@ -0,0 +1,81 @@
+//go:build unit
+// +build unit
+
+package pubsub
+
+import (
+	"gophermart/cmd/gophermart/models"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Broker_PublishToOwner(t *testing.T) {
+	b := NewBroker(10)
+	sub, _, _, _ := b.Subscribe("alice", 0)
+	other, _, _, _ := b.Subscribe("bob", 0)
+
+	event := b.Publish("alice", models.OrderEvent{Number: "1", Status: "PROCESSED"})
+
+	assert.Equal(t, event, <-sub.Events())
+	assert.Empty(t, other.Events())
+
+	b.Unsubscribe(sub)
+	_, ok := <-sub.Events()
+	assert.False(t, ok)
+	b.Unsubscribe(sub) // повторно - без паники
+}
+
+func Test_Broker_Resume(t *testing.T) {
+	b := NewBroker(3)
+	first := b.Publish("alice", models.OrderEvent{Number: "1"})
+	b.Publish("bob", models.OrderEvent{Number: "2"})
+	third := b.Publish("alice", models.OrderEvent{Number: "3"})
+
+	_, missed, resumed, lastID := b.Subscribe("alice", first.ID)
+	assert.True(t, resumed)
+	assert.Equal(t, []Event{third}, missed)
+	assert.Equal(t, third.ID, lastID)
+
+	b.Publish("alice", models.OrderEvent{Number: "4"})
+	b.Publish("alice", models.OrderEvent{Number: "5"})
+
+	_, missed, resumed, _ = b.Subscribe("alice", first.ID)
+	assert.False(t, resumed, "event 2 is evicted from history")
+	assert.Empty(t, missed)
+
+	_, _, resumed, _ = b.Subscribe("alice", 100)
+	assert.False(t, resumed, "id from another run")
+
+	_, _, resumed, _ = b.Subscribe("alice", 0)
+	assert.False(t, resumed, "new connection")
+}
+
+func Test_Broker_SlowSubscriberIsDropped(t *testing.T) {
+	b := NewBroker(100)
+	sub, _, _, _ := b.Subscribe("alice", 0)
+
+	for i := 0; i < subscriptionBuffer+1; i++ {
+		b.Publish("alice", models.OrderEvent{Number: "1"})
+	}
+
+	received := 0
+	for range sub.Events() {
+		received++
+	}
+	assert.Equal(t, subscriptionBuffer, received)
+}
+
+func Test_Broker_Close(t *testing.T) {
+	b := NewBroker(10)
+	sub, _, _, _ := b.Subscribe("alice", 0)
+
+	b.Close()
+	_, ok := <-sub.Events()
+	assert.False(t, ok)
+
+	late, _, _, _ := b.Subscribe("alice", 0)
+	_, ok = <-late.Events()
+	require.False(t, ok)
+}
@@ -3,6 +3,9 @@ package routing
 import (
 	"gophermart/cmd/gophermart/config"
 	"gophermart/cmd/gophermart/handlers"
+	"gophermart/cmd/gophermart/metrics"
+	"gophermart/cmd/gophermart/models"
+	"net/http"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -12,19 +15,86 @@ import (
 func InitMiddleware(r *chi.Mux, conf *config.Config, ctrl *handlers.Controller) {
 	r.Use(ctrl.PanicRecoveryMiddleware)
 	r.Use(middleware.Recoverer)
-	r.Use(middleware.Timeout(time.Duration(conf.Timeout) * time.Second))
-	r.Use(ctrl.AuthenticateMiddleware)
+	r.Use(middleware.RequestID)
+	r.Use(ctrl.RealIPMiddleware)
+	r.Use(ctrl.AuditMiddleware)
 	r.Use(ctrl.LoggingMiddleware)
 	r.Use(ctrl.GzipEncodeMiddleware)
 	r.Use(ctrl.GzipDecodeMiddleware)
 }
 
-func Routing(r *chi.Mux, ctrl *handlers.Controller) {
-	r.Post("/api/user/register", ctrl.Register())
-	r.Post("/api/user/login", ctrl.Login())
-	r.Post("/api/user/orders", ctrl.OrdersUpload())
-	r.Get("/api/user/orders", ctrl.OrdersGet())
-	r.Get("/api/user/balance", ctrl.UserBalance())
-	r.Post("/api/user/balance/withdraw", ctrl.RequestForWithdrawal())
-	r.Get("/api/user/withdrawals", ctrl.InfoAboutWithdrawals())
+func Routing(r *chi.Mux, conf *config.Config, ctrl *handlers.Controller) {
+	r.NotFound(ctrl.NotFound())
+	r.MethodNotAllowed(ctrl.MethodNotAllowed())
+
+	r.Group(func(r chi.Router) {
+		r.Use(middleware.Timeout(time.Duration(conf.Timeout) * time.Second))
+
+		// Проверки для оркестратора и метрики Prometheus: без аутентификации и cookie
+		r.Get("/healthz", ctrl.Healthz())
+		r.Get("/readyz", ctrl.Readyz())
+		r.Method(http.MethodGet, "/metrics", metrics.Handler())
+
+		r.Post("/api/user/register", ctrl.Register())
+		r.Post("/api/user/login", ctrl.Login())
+		r.Post("/api/user/login/totp", ctrl.LoginTOTP())
+
+		// Без Bearer-токена или cookie действующей сессии - 401 до обработчика, API-ключи не принимаются
+		r.Group(func(r chi.Router) {
+			r.Use(ctrl.AuthenticateMiddleware)
+
+			r.Post("/api/user/logout", ctrl.Logout())
+			r.Post("/api/user/password", ctrl.PasswordChange())
+			r.Get("/api/user/sessions", ctrl.Sessions())
+			r.Delete("/api/user/sessions/{id}", ctrl.SessionDelete())
+			r.Post("/api/user/2fa/totp", ctrl.TOTPEnroll())
+			r.Post("/api/user/2fa/totp/verify", ctrl.TOTPVerify())
+			r.Delete("/api/user/2fa/totp", ctrl.TOTPDisable())
+			r.Post("/api/user/api-keys", ctrl.APIKeyCreate())
+			r.Get("/api/user/api-keys", ctrl.APIKeys())
+			r.Delete("/api/user/api-keys/{id}", ctrl.APIKeyDelete())
+		})
+
+		// Back-office: просмотр для support и admin, изменения и журнал аудита - только для admin
+		r.Route("/api/admin", func(r chi.Router) {
+			r.Use(ctrl.AuthenticateMiddleware)
+
+			r.Group(func(r chi.Router) {
+				r.Use(ctrl.RequireRole(models.RoleSupport, models.RoleAdmin))
+
+				r.Get("/users", ctrl.AdminUsers())
+				r.Get("/users/{login}", ctrl.AdminUser())
+				r.Get("/users/{login}/orders", ctrl.AdminUserOrders())
+				r.Get("/users/{login}/balance", ctrl.AdminUserBalance())
+				r.Get("/users/{login}/withdrawals", ctrl.AdminUserWithdrawals())
+				r.Post("/orders/{number}/recheck", ctrl.AdminOrderRecheck())
+			})
+
+			r.Group(func(r chi.Router) {
+				r.Use(ctrl.RequireRole(models.RoleAdmin))
+
+				r.Post("/users/{login}/balance/adjustments", ctrl.AdminBalanceAdjust())
+				r.Post("/users/{login}/lock", ctrl.AdminUserLock())
+				r.Delete("/users/{login}/lock", ctrl.AdminUserUnlock())
+				r.Put("/users/{login}/role", ctrl.AdminUserRole())
+				r.Get("/audit", ctrl.AdminAudit())
+				r.Get("/audit/verify", ctrl.AdminAuditVerify())
+			})
+		})
+
+		// Доступны также по X-API-Key с соответствующим правом
+		r.With(ctrl.AuthenticateWithAPIKey(models.ScopeOrdersWrite)).Post("/api/user/orders", ctrl.OrdersUpload())
+		r.With(ctrl.AuthenticateWithAPIKey(models.ScopeOrdersRead)).Get("/api/user/orders", ctrl.OrdersGet())
+		r.With(ctrl.AuthenticateWithAPIKey(models.ScopeOrdersRead)).Get("/api/user/orders/{number}", ctrl.OrderGet())
+		r.With(ctrl.AuthenticateWithAPIKey(models.ScopeBalanceRead)).Get("/api/user/balance", ctrl.UserBalance())
+		r.With(ctrl.AuthenticateWithAPIKey(models.ScopeBalanceWithdraw)).Post("/api/user/balance/withdraw", ctrl.RequestForWithdrawal())
+		r.With(ctrl.AuthenticateWithAPIKey(models.ScopeBalanceRead)).Get("/api/user/withdrawals", ctrl.InfoAboutWithdrawals())
+	})
+
+	// Поток событий открыт дольше conf.Timeout, поэтому без middleware.Timeout
+	r.Group(func(r chi.Router) {
+		r.Use(ctrl.AuthenticateWithAPIKey(models.ScopeOrdersRead))
+
+		r.Get("/api/user/orders/stream", ctrl.OrdersStream())
+	})
 }
@@ -0,0 +1,54 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS balance_ledger (
+    id           SERIAL PRIMARY KEY,
+    login        TEXT NOT NULL,
+    order_number BIGINT NOT NULL,
+    kind         TEXT NOT NULL CHECK (kind IN ('credit', 'debit')),
+    amount       FLOAT NOT NULL CHECK (amount >= 0),
+    created_at   TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    withdrawal_id INTEGER UNIQUE REFERENCES users_withdrawals(id), -- списание, которому соответствует debit
+    FOREIGN KEY (login) REFERENCES users(login),
+    CONSTRAINT balance_ledger_withdrawal_check CHECK ((kind = 'debit') = (withdrawal_id IS NOT NULL))
+);
+-- +goose StatementEnd
+
+-- Начисление по заказу - не более одного. Списания уникальны по withdrawal_id:
+-- разные пользователи могут списывать в счёт одного номера заказа.
+-- +goose StatementBegin
+CREATE UNIQUE INDEX IF NOT EXISTS balance_ledger_credit_order_idx ON balance_ledger (order_number) WHERE kind = 'credit';
+-- +goose StatementEnd
+
+-- Начисления по уже обработанным заказам и все существующие списания
+INSERT INTO balance_ledger (login, order_number, kind, amount, created_at)
+SELECT login, number, 'credit', accrual, uploaded_at
+FROM orders
+WHERE status = 'PROCESSED' AND accrual > 0
+ON CONFLICT (order_number) WHERE kind = 'credit' DO NOTHING;
+
+-- Списание без номера заказа всё равно уменьшило баланс, в журнал оно попадает с номером 0
+INSERT INTO balance_ledger (login, order_number, kind, amount, created_at, withdrawal_id)
+SELECT login, COALESCE(order_number, 0), 'debit', sum, COALESCE(processed_at, now()), id
+FROM users_withdrawals
+WHERE login IS NOT NULL;
+
+-- Сверка users_balances с журналом (исправляет повторные начисления)
+INSERT INTO users_balances (login)
+SELECT DISTINCT login FROM balance_ledger
+ON CONFLICT (login) DO NOTHING;
+
+UPDATE users_balances b
+SET current   = COALESCE((SELECT SUM(CASE WHEN l.kind = 'credit' THEN l.amount ELSE -l.amount END)
+                          FROM balance_ledger l WHERE l.login = b.login), 0),
+    withdrawn = COALESCE((SELECT SUM(l.amount)
+                          FROM balance_ledger l WHERE l.login = b.login AND l.kind = 'debit'), 0);
+
+UPDATE orders o
+SET accrual_added = EXISTS (
+    SELECT 1 FROM balance_ledger l WHERE l.order_number = o.number AND l.kind = 'credit'
+);
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS balance_ledger;
+-- +goose StatementEnd
@@ -0,0 +1,44 @@
+-- +goose Up
+-- Баллы хранятся целым числом минимальных единиц (1 балл = 100), значения округляются до сотых
+ALTER TABLE orders
+    ALTER COLUMN accrual DROP DEFAULT,
+    ALTER COLUMN accrual TYPE BIGINT USING ROUND(accrual::NUMERIC * 100)::BIGINT,
+    ALTER COLUMN accrual SET DEFAULT 0;
+
+ALTER TABLE users_balances
+    ALTER COLUMN current DROP DEFAULT,
+    ALTER COLUMN current TYPE BIGINT USING ROUND(current::NUMERIC * 100)::BIGINT,
+    ALTER COLUMN current SET DEFAULT 0,
+    ALTER COLUMN withdrawn DROP DEFAULT,
+    ALTER COLUMN withdrawn TYPE BIGINT USING ROUND(withdrawn::NUMERIC * 100)::BIGINT,
+    ALTER COLUMN withdrawn SET DEFAULT 0;
+
+ALTER TABLE users_withdrawals
+    ALTER COLUMN sum DROP DEFAULT,
+    ALTER COLUMN sum TYPE BIGINT USING ROUND(sum::NUMERIC * 100)::BIGINT,
+    ALTER COLUMN sum SET DEFAULT 0;
+
+ALTER TABLE balance_ledger
+    ALTER COLUMN amount TYPE BIGINT USING ROUND(amount::NUMERIC * 100)::BIGINT;
+
+-- +goose Down
+ALTER TABLE balance_ledger
+    ALTER COLUMN amount TYPE FLOAT USING amount / 100.0;
+
+ALTER TABLE users_withdrawals
+    ALTER COLUMN sum DROP DEFAULT,
+    ALTER COLUMN sum TYPE FLOAT USING sum / 100.0,
+    ALTER COLUMN sum SET DEFAULT 0.0;
+
+ALTER TABLE users_balances
+    ALTER COLUMN current DROP DEFAULT,
+    ALTER COLUMN current TYPE FLOAT USING current / 100.0,
+    ALTER COLUMN current SET DEFAULT 0.0,
+    ALTER COLUMN withdrawn DROP DEFAULT,
+    ALTER COLUMN withdrawn TYPE FLOAT USING withdrawn / 100.0,
+    ALTER COLUMN withdrawn SET DEFAULT 0.0;
+
+ALTER TABLE orders
+    ALTER COLUMN accrual DROP DEFAULT,
+    ALTER COLUMN accrual TYPE FLOAT USING accrual / 100.0,
+    ALTER COLUMN accrual SET DEFAULT 0.0;
@@ -0,0 +1,14 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS accrual_tasks (
+    order_number BIGINT PRIMARY KEY,
+    login        TEXT NOT NULL,
+    saved_at     TIMESTAMP WITH TIME ZONE NOT NULL,
+    FOREIGN KEY (login) REFERENCES users(login)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS accrual_tasks;
+-- +goose StatementEnd
@@ -0,0 +1,44 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE accrual_tasks RENAME COLUMN saved_at TO created_at;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+ALTER TABLE accrual_tasks
+    ALTER COLUMN created_at SET DEFAULT now(),
+    ADD COLUMN state           TEXT NOT NULL DEFAULT 'pending' CHECK (state IN ('pending', 'dead')),
+    ADD COLUMN attempts        INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN next_attempt_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    ADD COLUMN locked_until    TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN last_error      TEXT;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE INDEX IF NOT EXISTS accrual_tasks_ready_idx ON accrual_tasks (next_attempt_at) WHERE state = 'pending';
+-- +goose StatementEnd
+
+-- Заказы без финального статуса раньше ставил в очередь Poller
+INSERT INTO accrual_tasks (order_number, login)
+SELECT number, login
+FROM orders
+WHERE status IN ('NEW', 'PROCESSING')
+ON CONFLICT (order_number) DO NOTHING;
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS accrual_tasks_ready_idx;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+ALTER TABLE accrual_tasks
+    DROP COLUMN state,
+    DROP COLUMN attempts,
+    DROP COLUMN next_attempt_at,
+    DROP COLUMN locked_until,
+    DROP COLUMN last_error,
+    ALTER COLUMN created_at DROP DEFAULT;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+ALTER TABLE accrual_tasks RENAME COLUMN created_at TO saved_at;
+-- +goose StatementEnd
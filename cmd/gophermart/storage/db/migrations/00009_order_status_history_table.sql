@@ -0,0 +1,30 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS order_status_history (
+    id           SERIAL PRIMARY KEY,
+    order_number BIGINT NOT NULL,
+    from_status  TEXT,
+    to_status    TEXT NOT NULL,
+    changed_at   TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    FOREIGN KEY (order_number) REFERENCES orders(number) ON DELETE CASCADE
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE INDEX IF NOT EXISTS order_status_history_order_idx ON order_status_history (order_number, changed_at);
+-- +goose StatementEnd
+
+-- История для уже загруженных заказов: время перехода в текущий статус неизвестно
+INSERT INTO order_status_history (order_number, from_status, to_status, changed_at)
+SELECT number, NULL, 'NEW', uploaded_at
+FROM orders;
+
+INSERT INTO order_status_history (order_number, from_status, to_status, changed_at)
+SELECT number, 'NEW', status, now()
+FROM orders
+WHERE status != 'NEW';
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS order_status_history;
+-- +goose StatementEnd
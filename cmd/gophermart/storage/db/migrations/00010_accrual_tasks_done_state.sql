@@ -0,0 +1,19 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE accrual_tasks DROP CONSTRAINT IF EXISTS accrual_tasks_state_check;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+ALTER TABLE accrual_tasks ADD CONSTRAINT accrual_tasks_state_check CHECK (state IN ('pending', 'done', 'dead'));
+-- +goose StatementEnd
+
+-- +goose Down
+DELETE FROM accrual_tasks WHERE state = 'done';
+
+-- +goose StatementBegin
+ALTER TABLE accrual_tasks DROP CONSTRAINT IF EXISTS accrual_tasks_state_check;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+ALTER TABLE accrual_tasks ADD CONSTRAINT accrual_tasks_state_check CHECK (state IN ('pending', 'dead'));
+-- +goose StatementEnd
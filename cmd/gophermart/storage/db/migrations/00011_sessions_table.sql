@@ -0,0 +1,40 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS sessions (
+    id           TEXT PRIMARY KEY,
+    login        TEXT NOT NULL,
+    created_at   TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    last_seen_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    user_agent   TEXT NOT NULL DEFAULT '',
+    ip           TEXT NOT NULL DEFAULT '',
+    expires_at   TIMESTAMP WITH TIME ZONE NOT NULL,
+    FOREIGN KEY (login) REFERENCES users(login) ON DELETE CASCADE
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE INDEX IF NOT EXISTS sessions_login_idx ON sessions (login);
+-- +goose StatementEnd
+
+-- Текущие входы продолжают работать: срок как у cookie (30 дней)
+INSERT INTO sessions (id, login, expires_at)
+SELECT uid, login, now() + interval '30 days'
+FROM users
+WHERE uid IS NOT NULL AND uid != '';
+
+ALTER TABLE users DROP COLUMN IF EXISTS uid;
+
+-- +goose Down
+ALTER TABLE users ADD COLUMN IF NOT EXISTS uid TEXT;
+
+-- У пользователя снова одна сессия - последняя
+UPDATE users SET uid = (
+    SELECT id FROM sessions
+    WHERE sessions.login = users.login AND expires_at > now()
+    ORDER BY last_seen_at DESC
+    LIMIT 1
+);
+
+-- +goose StatementBegin
+DROP TABLE IF EXISTS sessions;
+-- +goose StatementEnd
@@ -0,0 +1,48 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS user_totp (
+    login          TEXT PRIMARY KEY,
+    secret         TEXT NOT NULL,
+    enabled        BOOLEAN NOT NULL DEFAULT false,
+    last_used_step BIGINT NOT NULL DEFAULT 0, -- защита от повторного использования кода
+    created_at     TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now(),
+    FOREIGN KEY (login) REFERENCES users(login) ON DELETE CASCADE
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS totp_recovery_codes (
+    id        BIGSERIAL PRIMARY KEY,
+    login     TEXT NOT NULL,
+    code_hash TEXT NOT NULL,
+    used_at   TIMESTAMP WITH TIME ZONE,
+    FOREIGN KEY (login) REFERENCES users(login) ON DELETE CASCADE
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE INDEX IF NOT EXISTS totp_recovery_codes_login_idx ON totp_recovery_codes (login);
+-- +goose StatementEnd
+
+-- Вход, ожидающий второго фактора
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS mfa_challenges (
+    id         TEXT PRIMARY KEY,
+    login      TEXT NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    FOREIGN KEY (login) REFERENCES users(login) ON DELETE CASCADE
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS mfa_challenges;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP TABLE IF EXISTS totp_recovery_codes;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP TABLE IF EXISTS user_totp;
+-- +goose StatementEnd
@@ -0,0 +1,52 @@
+-- +goose Up
+-- Первый администратор назначается вручную: UPDATE users SET role = 'admin' WHERE login = '...'
+-- +goose StatementBegin
+ALTER TABLE users
+    ADD COLUMN role      TEXT NOT NULL DEFAULT 'user' CHECK (role IN ('user', 'support', 'admin')),
+    ADD COLUMN locked_at TIMESTAMP WITH TIME ZONE;
+-- +goose StatementEnd
+
+-- Ручные корректировки баланса: без заказа, сумма со знаком, с причиной и автором
+-- +goose StatementBegin
+ALTER TABLE balance_ledger
+    ALTER COLUMN order_number DROP NOT NULL,
+    ADD COLUMN reason TEXT,
+    ADD COLUMN actor  TEXT,
+    DROP CONSTRAINT IF EXISTS balance_ledger_kind_check,
+    DROP CONSTRAINT IF EXISTS balance_ledger_amount_check;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+ALTER TABLE balance_ledger
+    ADD CONSTRAINT balance_ledger_kind_check CHECK (kind IN ('credit', 'debit', 'adjustment')),
+    ADD CONSTRAINT balance_ledger_amount_check CHECK (amount >= 0 OR kind = 'adjustment'),
+    ADD CONSTRAINT balance_ledger_adjustment_check CHECK (
+        kind != 'adjustment' OR (order_number IS NULL AND reason IS NOT NULL AND actor IS NOT NULL)
+    );
+-- +goose StatementEnd
+
+-- +goose Down
+-- Корректировки остаются в users_balances, из журнала они удаляются
+DELETE FROM balance_ledger WHERE kind = 'adjustment';
+
+-- +goose StatementBegin
+ALTER TABLE balance_ledger
+    DROP CONSTRAINT IF EXISTS balance_ledger_adjustment_check,
+    DROP CONSTRAINT IF EXISTS balance_ledger_amount_check,
+    DROP CONSTRAINT IF EXISTS balance_ledger_kind_check;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+ALTER TABLE balance_ledger
+    ADD CONSTRAINT balance_ledger_kind_check CHECK (kind IN ('credit', 'debit')),
+    ADD CONSTRAINT balance_ledger_amount_check CHECK (amount >= 0),
+    ALTER COLUMN order_number SET NOT NULL,
+    DROP COLUMN reason,
+    DROP COLUMN actor;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+ALTER TABLE users
+    DROP COLUMN locked_at,
+    DROP COLUMN role;
+-- +goose StatementEnd
@@ -0,0 +1,18 @@
+-- +goose Up
+-- Постраничная выдача заказов и списаний пользователя идёт по (время, номер заказа)
+-- +goose StatementBegin
+CREATE INDEX IF NOT EXISTS orders_login_uploaded_at_idx ON orders (login, uploaded_at, number);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE INDEX IF NOT EXISTS users_withdrawals_login_processed_at_idx ON users_withdrawals (login, processed_at, order_number);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX IF EXISTS users_withdrawals_login_processed_at_idx;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP INDEX IF EXISTS orders_login_uploaded_at_idx;
+-- +goose StatementEnd
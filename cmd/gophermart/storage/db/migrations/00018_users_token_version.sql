@@ -0,0 +1,10 @@
+-- +goose Up
+-- Версия Bearer-токенов пользователя: растёт при смене пароля, токены со старой версией не принимаются
+-- +goose StatementBegin
+ALTER TABLE users ADD COLUMN token_version BIGINT NOT NULL DEFAULT 0;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE users DROP COLUMN token_version;
+-- +goose StatementEnd
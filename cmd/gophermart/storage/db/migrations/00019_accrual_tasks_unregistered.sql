@@ -0,0 +1,10 @@
+-- +goose Up
+-- Ответы Accrual «заказ не зарегистрирован» считаются отдельно от неудачных попыток
+-- +goose StatementBegin
+ALTER TABLE accrual_tasks ADD COLUMN unregistered INTEGER NOT NULL DEFAULT 0;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE accrual_tasks DROP COLUMN unregistered;
+-- +goose StatementEnd
@@ -0,0 +1,9 @@
+-- +goose Up
+-- Секреты API-ключей хешируются SHA-256 вместо bcrypt. Ключи с bcrypt-хешем проверить больше нельзя,
+-- их нужно выпустить заново.
+-- +goose StatementBegin
+DELETE FROM api_keys WHERE hash LIKE '$2%';
+-- +goose StatementEnd
+
+-- +goose Down
+-- Удалённые ключи не восстанавливаются, ключи с SHA-256 остаются в таблице
@@ -1,31 +1,81 @@
 package storage
 
 import (
+	"context"
 	"database/sql"
 	"embed"
 	"errors"
+	"fmt"
+	"gophermart/cmd/gophermart/audit"
 	"gophermart/cmd/gophermart/config"
+	"gophermart/cmd/gophermart/metrics"
 	"gophermart/cmd/gophermart/models"
+	"gophermart/cmd/gophermart/orderstate"
 	"log"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/jackc/pgx/v5/pgconn"
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/pressly/goose/v3"
 )
 
 type StorageService interface {
-	SaveLoginPassword(login, hashedPassword string) bool
-	GetHashedPasswordByLogin(login string) string
-	SaveUID(userID, login string) error
-	GetLoginByUID(userID string) string
-	AddOrder(userLogin string, orderNumber int) (isAddedToDB bool, err error)
-	GetOrders(userLogin string) ([]models.Order, error)
-	UpdateOrder(orderNumber int, status string, accrual float64) error
-	GetUserBalance(userLogin string) (models.UserBalance, error)
-	UpdateUserBalance(userLogin string, orderNumber int, accrualToAdd float64) error
-	WithdrawFromUserBalance(userLogin string, orderNumber int, amount float64) error
-	GetUserWithdrawals(userLogin string) ([]models.Withdrawal, error)
-	BalanceForUserLogin(userLogin string) error
+	SaveLoginPassword(ctx context.Context, login, hashedPassword string) bool
+	GetHashedPasswordByLogin(ctx context.Context, login string) string
+	ChangePassword(ctx context.Context, login, hashedPassword, keepSessionID string) error
+	CreateSession(ctx context.Context, session models.Session) error
+	TouchSession(ctx context.Context, sessionID string) (login string, err error)
+	GetSessions(ctx context.Context, login string) ([]models.Session, error)
+	DeleteSession(ctx context.Context, login, sessionID string) error
+	AddLoginFailure(ctx context.Context, key string, window time.Duration) (failures int, err error)
+	ResetLoginFailures(ctx context.Context, key string) error
+	LockLogin(ctx context.Context, key string, duration time.Duration) (lockedUntil time.Time, err error)
+	GetLoginLockout(ctx context.Context, key string) (lockedUntil time.Time, err error)
+	GetTOTP(ctx context.Context, login string) (*models.TOTP, error)
+	SaveTOTPSecret(ctx context.Context, login, secret string) error
+	EnableTOTP(ctx context.Context, login string, step int64, recoveryCodeHashes []string) error
+	DisableTOTP(ctx context.Context, login string) error
+	UseTOTPStep(ctx context.Context, login string, step int64) (bool, error)
+	GetRecoveryCodes(ctx context.Context, login string) ([]models.RecoveryCode, error)
+	UseRecoveryCode(ctx context.Context, login string, id int64) (bool, error)
+	CreateMFAChallenge(ctx context.Context, challenge models.MFAChallenge) error
+	GetMFAChallenge(ctx context.Context, id string) (login string, err error)
+	DeleteMFAChallenge(ctx context.Context, id string) error
+	CreateAPIKey(ctx context.Context, key models.APIKey) error
+	GetAPIKey(ctx context.Context, id string) (*models.APIKey, error)
+	GetAPIKeys(ctx context.Context, login string) ([]models.APIKey, error)
+	TouchAPIKey(ctx context.Context, id string) error
+	DeleteAPIKey(ctx context.Context, login, id string) error
+	GetUser(ctx context.Context, login string) (models.Account, error)
+	SearchUsers(ctx context.Context, query string, limit int) ([]models.Account, error)
+	SetUserRole(ctx context.Context, actor, login, role string) error
+	SetUserLocked(ctx context.Context, actor, login string, locked bool) error
+	AdjustBalance(ctx context.Context, adjustment models.BalanceAdjustment) (models.UserBalance, error)
+	RequeueAccrualTask(ctx context.Context, actor string, orderNumber int) error
+	AddAuditEvent(ctx context.Context, event models.AuditEvent) error
+	GetAuditEvents(ctx context.Context, filter models.AuditFilter) ([]models.AuditEvent, error)
+	GetAuditChain(ctx context.Context, afterID int64, limit int) ([]models.AuditEvent, error)
+	AddOrder(ctx context.Context, userLogin string, orderNumber int) (isAddedToDB bool, err error)
+	GetOrder(ctx context.Context, orderNumber int) (models.Order, error)
+	GetOrders(ctx context.Context, userLogin string, list models.ListQuery) ([]models.Order, error)
+	UpdateOrder(ctx context.Context, orderNumber int, status string, accrual models.Money) (changed bool, err error)
+	GetOrderStatusHistory(ctx context.Context, orderNumber int) ([]models.OrderStatusChange, error)
+	GetUserBalance(ctx context.Context, userLogin string) (models.UserBalance, error)
+	WithdrawFromUserBalance(ctx context.Context, userLogin string, orderNumber int, amount models.Money) error
+	GetUserWithdrawals(ctx context.Context, userLogin string, list models.ListQuery) ([]models.Withdrawal, error)
+	BalanceForUserLogin(ctx context.Context, userLogin string) error
+	ClaimAccrualTasks(ctx context.Context, limit int, lease time.Duration) ([]models.AccrualTask, error)
+	CompleteAccrualTask(ctx context.Context, orderNumber int) error
+	RescheduleAccrualTask(ctx context.Context, orderNumber int, delay time.Duration) error
+	FailAccrualTask(ctx context.Context, orderNumber int, cause string, delay time.Duration, maxAttempts int) (dead bool, err error)
+	PostponeUnregisteredAccrualTask(ctx context.Context, orderNumber int, cause string, delay time.Duration, maxPolls int) (dead bool, err error)
+	GetAccrualTask(ctx context.Context, orderNumber int) (*models.AccrualTaskInfo, error)
+	CountPendingAccrualTasks(ctx context.Context) (int, error)
+	Ping(ctx context.Context) error
+	MigrationVersion(ctx context.Context) (current, latest int64, err error)
+	Close() error
 }
 
 type StorageDB struct {
@@ -39,71 +89,956 @@ var (
 	ErrOpenDBConnection  = errors.New("error opening database connection")
 	ErrConnecting        = errors.New("error connecting to database")
 	ErrTransaction       = errors.New("error transaction")
+	ErrWithdrawConflict  = errors.New("error withdrawal for this order already exists")
+	ErrOrderNotFound     = errors.New("error order not found")
+	ErrSessionNotFound   = errors.New("error session not found")
+	ErrTOTPEnabled       = errors.New("error TOTP is already enabled")
+	ErrTOTPNotFound      = errors.New("error TOTP enrollment not found")
+	ErrChallengeNotFound = errors.New("error login challenge not found")
+	ErrAPIKeyNotFound    = errors.New("error API key not found")
+	ErrUserNotFound      = errors.New("error user not found")
+	ErrInvalidAmount     = errors.New("error amount must be positive")
 )
 
+// foreignKeyViolation - код ошибки PostgreSQL foreign_key_violation
+const foreignKeyViolation = "23503"
+
+// userMissing - запись ссылается на несуществующего пользователя (FOREIGN KEY (login) REFERENCES users)
+func userMissing(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == foreignKeyViolation
+}
+
 //go:embed db/migrations/*.sql
 var embedMigrations embed.FS
 
-func UpDBMigrations(db *sql.DB) {
-	goose.SetBaseFS(embedMigrations)
+const migrationsDir = "db/migrations"
+
+func UpDBMigrations(db *sql.DB) {
+	goose.SetBaseFS(embedMigrations)
+
+	if err := goose.SetDialect("postgres"); err != nil {
+		log.Printf("error setting SQL dialect\n")
+	}
+
+	if err := goose.Up(db, migrationsDir); err != nil {
+		log.Printf("error migration %s\n", err.Error())
+	}
+}
+
+func NewStorage(c *config.Config) (*StorageDB, error) {
+	dbConn, err := sql.Open("pgx", c.DBConnection)
+	if err != nil {
+		return nil, ErrOpenDBConnection
+	}
+
+	if err := dbConn.Ping(); err != nil {
+		return nil, ErrConnecting
+	}
+
+	UpDBMigrations(dbConn)
+
+	return &StorageDB{
+		DBConn: dbConn,
+	}, nil
+}
+
+func (s *StorageDB) SaveLoginPassword(ctx context.Context, login, hashedPassword string) bool {
+	defer metrics.ObserveDBQuery("SaveLoginPassword", time.Now())
+
+	_, err1 := s.DBConn.ExecContext(ctx, "INSERT INTO users (login, password) VALUES ($1, $2)", login, hashedPassword)
+
+	return err1 == nil
+}
+
+func (s *StorageDB) GetHashedPasswordByLogin(ctx context.Context, login string) string {
+	defer metrics.ObserveDBQuery("GetHashedPasswordByLogin", time.Now())
+
+	var hashedPassword string
+	_ = s.DBConn.QueryRowContext(ctx, "SELECT password FROM users WHERE login=$1", login).Scan(&hashedPassword)
+	return hashedPassword
+}
+
+// ChangePassword меняет пароль, завершает все сессии пользователя, кроме keepSessionID,
+// и увеличивает версию токенов, отзывая выданные ранее Bearer-токены
+func (s *StorageDB) ChangePassword(ctx context.Context, login, hashedPassword, keepSessionID string) error {
+	defer metrics.ObserveDBQuery("ChangePassword", time.Now())
+
+	tx, err := s.DBConn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	_, err = tx.ExecContext(ctx, "UPDATE users SET password = $1, token_version = token_version + 1 WHERE login = $2", hashedPassword, login)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM sessions WHERE login = $1 AND id != $2", login, keepSessionID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// CreateSession сохраняет новую сессию и удаляет истёкшие сессии пользователя
+func (s *StorageDB) CreateSession(ctx context.Context, session models.Session) error {
+	defer metrics.ObserveDBQuery("CreateSession", time.Now())
+
+	tx, err := s.DBConn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM sessions WHERE login = $1 AND expires_at <= now()", session.Login)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO sessions (id, login, created_at, last_seen_at, user_agent, ip, expires_at)
+		VALUES ($1, $2, $3, $3, $4, $5, $6)`,
+		session.ID, session.Login, session.CreatedAt, session.UserAgent, session.IP, session.ExpiresAt)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// TouchSession возвращает логин действующей сессии и обновляет время последнего запроса
+func (s *StorageDB) TouchSession(ctx context.Context, sessionID string) (string, error) {
+	defer metrics.ObserveDBQuery("TouchSession", time.Now())
+
+	var login string
+	err := s.DBConn.QueryRowContext(ctx, `
+		UPDATE sessions SET last_seen_at = now()
+		WHERE id = $1 AND expires_at > now()
+		RETURNING login`, sessionID).Scan(&login)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrSessionNotFound
+	}
+	return login, err
+}
+
+// GetSessions возвращает действующие сессии пользователя, последние активные первыми
+func (s *StorageDB) GetSessions(ctx context.Context, login string) ([]models.Session, error) {
+	defer metrics.ObserveDBQuery("GetSessions", time.Now())
+
+	rows, err := s.DBConn.QueryContext(ctx, `
+		SELECT id, login, created_at, last_seen_at, user_agent, ip, expires_at
+		FROM sessions
+		WHERE login = $1 AND expires_at > now()
+		ORDER BY last_seen_at DESC`, login)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []models.Session
+	for rows.Next() {
+		var session models.Session
+		err := rows.Scan(&session.ID, &session.Login, &session.CreatedAt, &session.LastSeenAt,
+			&session.UserAgent, &session.IP, &session.ExpiresAt)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// DeleteSession удаляет сессию пользователя login (ErrSessionNotFound, если она чужая или её нет)
+func (s *StorageDB) DeleteSession(ctx context.Context, login, sessionID string) error {
+	defer metrics.ObserveDBQuery("DeleteSession", time.Now())
+
+	result, err := s.DBConn.ExecContext(ctx, "DELETE FROM sessions WHERE id = $1 AND login = $2", sessionID, login)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// AddLoginFailure учитывает неудачный вход по ключу и возвращает число неудач за последние window
+func (s *StorageDB) AddLoginFailure(ctx context.Context, key string, window time.Duration) (int, error) {
+	defer metrics.ObserveDBQuery("AddLoginFailure", time.Now())
+
+	tx, err := s.DBConn.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM login_failures WHERE key = $1 AND failed_at <= now() - make_interval(secs => $2)",
+		key, window.Seconds())
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO login_failures (key, failed_at) VALUES ($1, now())", key)
+	if err != nil {
+		return 0, err
+	}
+
+	var failures int
+	err = tx.QueryRowContext(ctx, "SELECT count(*) FROM login_failures WHERE key = $1", key).Scan(&failures)
+	if err != nil {
+		return 0, err
+	}
+
+	return failures, tx.Commit()
+}
+
+// ResetLoginFailures сбрасывает счётчик неудачных входов по ключу
+func (s *StorageDB) ResetLoginFailures(ctx context.Context, key string) error {
+	defer metrics.ObserveDBQuery("ResetLoginFailures", time.Now())
+
+	_, err := s.DBConn.ExecContext(ctx, "DELETE FROM login_failures WHERE key = $1", key)
+	return err
+}
+
+// LockLogin блокирует вход по ключу на duration. Счётчик неудач сбрасывается:
+// после окончания блокировки отсчёт начинается заново.
+func (s *StorageDB) LockLogin(ctx context.Context, key string, duration time.Duration) (time.Time, error) {
+	defer metrics.ObserveDBQuery("LockLogin", time.Now())
+
+	tx, err := s.DBConn.BeginTx(ctx, nil)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var lockedUntil time.Time
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO login_lockouts (key, locked_until) VALUES ($1, now() + make_interval(secs => $2))
+		ON CONFLICT (key) DO UPDATE SET locked_until = EXCLUDED.locked_until
+		RETURNING locked_until`, key, duration.Seconds()).Scan(&lockedUntil)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM login_failures WHERE key = $1", key)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return lockedUntil, tx.Commit()
+}
+
+// GetLoginLockout возвращает окончание действующей блокировки (нулевое время - блокировки нет)
+func (s *StorageDB) GetLoginLockout(ctx context.Context, key string) (time.Time, error) {
+	defer metrics.ObserveDBQuery("GetLoginLockout", time.Now())
+
+	var lockedUntil time.Time
+	err := s.DBConn.QueryRowContext(ctx, "SELECT locked_until FROM login_lockouts WHERE key = $1 AND locked_until > now()",
+		key).Scan(&lockedUntil)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, nil
+	}
+	return lockedUntil, err
+}
+
+// GetTOTP возвращает второй фактор пользователя (nil, если он не подключался)
+func (s *StorageDB) GetTOTP(ctx context.Context, login string) (*models.TOTP, error) {
+	defer metrics.ObserveDBQuery("GetTOTP", time.Now())
+
+	var t models.TOTP
+	err := s.DBConn.QueryRowContext(ctx, "SELECT secret, enabled, last_used_step FROM user_totp WHERE login = $1",
+		login).Scan(&t.Secret, &t.Enabled, &t.LastUsedStep)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// SaveTOTPSecret начинает (или начинает заново) подключение TOTP. ErrTOTPEnabled, если TOTP уже включён.
+func (s *StorageDB) SaveTOTPSecret(ctx context.Context, login, secret string) error {
+	defer metrics.ObserveDBQuery("SaveTOTPSecret", time.Now())
+
+	result, err := s.DBConn.ExecContext(ctx, `
+		INSERT INTO user_totp (login, secret) VALUES ($1, $2)
+		ON CONFLICT (login) DO UPDATE SET secret = EXCLUDED.secret, last_used_step = 0, created_at = now()
+		WHERE user_totp.enabled = false`, login, secret)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrTOTPEnabled
+	}
+	return nil
+}
+
+// EnableTOTP подтверждает подключение TOTP и заменяет коды восстановления
+func (s *StorageDB) EnableTOTP(ctx context.Context, login string, step int64, recoveryCodeHashes []string) error {
+	defer metrics.ObserveDBQuery("EnableTOTP", time.Now())
+
+	tx, err := s.DBConn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	result, err := tx.ExecContext(ctx, "UPDATE user_totp SET enabled = true, last_used_step = $2 WHERE login = $1 AND enabled = false",
+		login, step)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrTOTPNotFound
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM totp_recovery_codes WHERE login = $1", login)
+	if err != nil {
+		return err
+	}
+	for _, hash := range recoveryCodeHashes {
+		_, err = tx.ExecContext(ctx, "INSERT INTO totp_recovery_codes (login, code_hash) VALUES ($1, $2)", login, hash)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// DisableTOTP отключает второй фактор и удаляет коды восстановления
+func (s *StorageDB) DisableTOTP(ctx context.Context, login string) error {
+	defer metrics.ObserveDBQuery("DisableTOTP", time.Now())
+
+	tx, err := s.DBConn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM totp_recovery_codes WHERE login = $1", login)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "DELETE FROM user_totp WHERE login = $1", login)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UseTOTPStep отмечает интервал принятого кода. false - код этого или более позднего интервала уже использовался.
+func (s *StorageDB) UseTOTPStep(ctx context.Context, login string, step int64) (bool, error) {
+	defer metrics.ObserveDBQuery("UseTOTPStep", time.Now())
+
+	result, err := s.DBConn.ExecContext(ctx, `
+		UPDATE user_totp SET last_used_step = $2
+		WHERE login = $1 AND enabled = true AND last_used_step < $2`, login, step)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// GetRecoveryCodes возвращает неиспользованные коды восстановления
+func (s *StorageDB) GetRecoveryCodes(ctx context.Context, login string) ([]models.RecoveryCode, error) {
+	defer metrics.ObserveDBQuery("GetRecoveryCodes", time.Now())
+
+	rows, err := s.DBConn.QueryContext(ctx, "SELECT id, code_hash FROM totp_recovery_codes WHERE login = $1 AND used_at IS NULL ORDER BY id",
+		login)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var codes []models.RecoveryCode
+	for rows.Next() {
+		var code models.RecoveryCode
+		if err := rows.Scan(&code.ID, &code.Hash); err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// UseRecoveryCode помечает код использованным. false - код уже был использован.
+func (s *StorageDB) UseRecoveryCode(ctx context.Context, login string, id int64) (bool, error) {
+	defer metrics.ObserveDBQuery("UseRecoveryCode", time.Now())
+
+	result, err := s.DBConn.ExecContext(ctx, "UPDATE totp_recovery_codes SET used_at = now() WHERE id = $1 AND login = $2 AND used_at IS NULL",
+		id, login)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// CreateMFAChallenge сохраняет вход, ожидающий второго фактора, и удаляет истёкшие
+func (s *StorageDB) CreateMFAChallenge(ctx context.Context, challenge models.MFAChallenge) error {
+	defer metrics.ObserveDBQuery("CreateMFAChallenge", time.Now())
+
+	tx, err := s.DBConn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM mfa_challenges WHERE login = $1 AND expires_at <= now()", challenge.Login)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "INSERT INTO mfa_challenges (id, login, expires_at) VALUES ($1, $2, $3)",
+		challenge.ID, challenge.Login, challenge.ExpiresAt)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetMFAChallenge возвращает логин действующего входа, ожидающего второго фактора
+func (s *StorageDB) GetMFAChallenge(ctx context.Context, id string) (string, error) {
+	defer metrics.ObserveDBQuery("GetMFAChallenge", time.Now())
+
+	var login string
+	err := s.DBConn.QueryRowContext(ctx, "SELECT login FROM mfa_challenges WHERE id = $1 AND expires_at > now()",
+		id).Scan(&login)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrChallengeNotFound
+	}
+	return login, err
+}
+
+func (s *StorageDB) DeleteMFAChallenge(ctx context.Context, id string) error {
+	defer metrics.ObserveDBQuery("DeleteMFAChallenge", time.Now())
+
+	_, err := s.DBConn.ExecContext(ctx, "DELETE FROM mfa_challenges WHERE id = $1", id)
+	return err
+}
+
+func (s *StorageDB) CreateAPIKey(ctx context.Context, key models.APIKey) error {
+	defer metrics.ObserveDBQuery("CreateAPIKey", time.Now())
+
+	_, err := s.DBConn.ExecContext(ctx, `
+		INSERT INTO api_keys (id, login, name, merchant, scopes, hash, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		key.ID, key.Login, key.Name, key.Merchant, strings.Join(key.Scopes, " "), key.Hash, key.CreatedAt, key.ExpiresAt)
+	return err
+}
+
+const selectAPIKey = `
+	SELECT id, login, name, merchant, scopes, hash, created_at, expires_at, last_used_at
+	FROM api_keys`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanAPIKey(row rowScanner) (models.APIKey, error) {
+	var key models.APIKey
+	var scopes string
+	var expiresAt, lastUsedAt sql.NullTime
+	err := row.Scan(&key.ID, &key.Login, &key.Name, &key.Merchant, &scopes, &key.Hash, &key.CreatedAt, &expiresAt, &lastUsedAt)
+	if err != nil {
+		return key, err
+	}
+	key.Scopes = strings.Fields(scopes)
+	if expiresAt.Valid {
+		key.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		key.LastUsedAt = &lastUsedAt.Time
+	}
+	return key, nil
+}
+
+// GetAPIKey возвращает действующий ключ по id: не истёкший и не принадлежащий заблокированному пользователю
+func (s *StorageDB) GetAPIKey(ctx context.Context, id string) (*models.APIKey, error) {
+	defer metrics.ObserveDBQuery("GetAPIKey", time.Now())
+
+	key, err := scanAPIKey(s.DBConn.QueryRowContext(ctx, selectAPIKey+`
+		WHERE id = $1 AND (expires_at IS NULL OR expires_at > now())
+			AND NOT EXISTS (SELECT 1 FROM users WHERE users.login = api_keys.login AND users.locked_at IS NOT NULL)`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// GetAPIKeys возвращает все ключи пользователя (и истёкшие), новые первыми
+func (s *StorageDB) GetAPIKeys(ctx context.Context, login string) ([]models.APIKey, error) {
+	defer metrics.ObserveDBQuery("GetAPIKeys", time.Now())
+
+	rows, err := s.DBConn.QueryContext(ctx, selectAPIKey+`
+		WHERE login = $1
+		ORDER BY created_at DESC`, login)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []models.APIKey
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// TouchAPIKey обновляет время последнего использования ключа
+func (s *StorageDB) TouchAPIKey(ctx context.Context, id string) error {
+	defer metrics.ObserveDBQuery("TouchAPIKey", time.Now())
+
+	_, err := s.DBConn.ExecContext(ctx, "UPDATE api_keys SET last_used_at = now() WHERE id = $1", id)
+	return err
+}
+
+// DeleteAPIKey отзывает ключ пользователя login (ErrAPIKeyNotFound, если он чужой или его нет)
+func (s *StorageDB) DeleteAPIKey(ctx context.Context, login, id string) error {
+	defer metrics.ObserveDBQuery("DeleteAPIKey", time.Now())
+
+	result, err := s.DBConn.ExecContext(ctx, "DELETE FROM api_keys WHERE id = $1 AND login = $2", id, login)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+func (s *StorageDB) GetUser(ctx context.Context, login string) (models.Account, error) {
+	defer metrics.ObserveDBQuery("GetUser", time.Now())
+
+	account, err := scanAccount(s.DBConn.QueryRowContext(ctx, "SELECT login, role, locked_at, token_version FROM users WHERE login = $1", login))
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Account{}, ErrUserNotFound
+	}
+	return account, err
+}
+
+// SearchUsers ищет пользователей по части логина (без учёта регистра)
+func (s *StorageDB) SearchUsers(ctx context.Context, query string, limit int) ([]models.Account, error) {
+	defer metrics.ObserveDBQuery("SearchUsers", time.Now())
+
+	pattern := "%" + likeEscaper.Replace(query) + "%"
+	rows, err := s.DBConn.QueryContext(ctx, `
+		SELECT login, role, locked_at, token_version
+		FROM users
+		WHERE login ILIKE $1
+		ORDER BY login
+		LIMIT $2`, pattern, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var accounts []models.Account
+	for rows.Next() {
+		account, err := scanAccount(rows)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, account)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return accounts, nil
+}
+
+// likeEscaper экранирует спецсимволы LIKE (экранирующий символ по умолчанию - \)
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+func scanAccount(row rowScanner) (models.Account, error) {
+	var account models.Account
+	var lockedAt sql.NullTime
+	if err := row.Scan(&account.Login, &account.Role, &lockedAt, &account.TokenVersion); err != nil {
+		return models.Account{}, err
+	}
+	if lockedAt.Valid {
+		account.LockedAt = &lockedAt.Time
+	}
+	return account, nil
+}
+
+// SetUserRole назначает роль; событие аудита от имени actor пишется в той же транзакции
+func (s *StorageDB) SetUserRole(ctx context.Context, actor, login, role string) error {
+	defer metrics.ObserveDBQuery("SetUserRole", time.Now())
+
+	tx, err := s.DBConn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			log.Printf("rollback error: %v", err)
+		}
+	}()
+
+	var from string
+	err = tx.QueryRowContext(ctx, "SELECT role FROM users WHERE login = $1 FOR UPDATE", login).Scan(&from)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrUserNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "UPDATE users SET role = $2 WHERE login = $1", login, role); err != nil {
+		return err
+	}
+
+	event := audit.New(ctx, actor, audit.ActionRoleChanged, login, map[string]any{"from": from, "to": role})
+	return commitWithAudit(ctx, tx, event)
+}
+
+// SetUserLocked блокирует или разблокирует пользователя. При блокировке в той же транзакции
+// завершаются его сессии и незавершённые входы со вторым фактором; событие аудита от имени actor
+// тоже пишется в этой транзакции.
+func (s *StorageDB) SetUserLocked(ctx context.Context, actor, login string, locked bool) error {
+	defer metrics.ObserveDBQuery("SetUserLocked", time.Now())
+
+	tx, err := s.DBConn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			log.Printf("rollback error: %v", err)
+		}
+	}()
+
+	// Повторная блокировка сохраняет исходное время
+	result, err := tx.ExecContext(ctx, `
+		UPDATE users
+		SET locked_at = CASE WHEN $2 THEN COALESCE(locked_at, now()) END
+		WHERE login = $1`, login, locked)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrUserNotFound
+	}
+
+	if locked {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM sessions WHERE login = $1", login); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM mfa_challenges WHERE login = $1", login); err != nil {
+			return err
+		}
+	}
+
+	action := audit.ActionUserUnlocked
+	if locked {
+		action = audit.ActionUserLocked
+	}
+	return commitWithAudit(ctx, tx, audit.New(ctx, actor, action, login, nil))
+}
+
+// AdjustBalance меняет текущий баланс на adjustment.Amount и записывает корректировку в balance_ledger.
+// Баланс не может стать отрицательным (ErrInsufficientFunds), ErrUserNotFound - если пользователя нет.
+func (s *StorageDB) AdjustBalance(ctx context.Context, adjustment models.BalanceAdjustment) (models.UserBalance, error) {
+	defer metrics.ObserveDBQuery("AdjustBalance", time.Now())
+
+	var insertAdjustment = `INSERT INTO balance_ledger (login, order_number, kind, amount, reason, actor, created_at)
+		VALUES ($1, NULL, 'adjustment', $2, $3, $4, $5)`
+	var balance models.UserBalance
+
+	tx, err := s.DBConn.BeginTx(ctx, nil)
+	if err != nil {
+		return balance, err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			log.Printf("rollback error: %v", err)
+		}
+	}()
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO users_balances (login) VALUES ($1) ON CONFLICT (login) DO NOTHING", adjustment.Login)
+	if userMissing(err) {
+		return balance, ErrUserNotFound
+	} else if err != nil {
+		return balance, err
+	}
+	err = tx.QueryRowContext(ctx, "SELECT current, withdrawn FROM users_balances WHERE login = $1 FOR UPDATE", adjustment.Login).
+		Scan(&balance.Current, &balance.Withdrawn)
+	if err != nil {
+		return balance, err
+	}
+	if balance.Current+adjustment.Amount < 0 {
+		return balance, ErrInsufficientFunds
+	}
+
+	_, err = tx.ExecContext(ctx, insertAdjustment,
+		adjustment.Login, adjustment.Amount, adjustment.Reason, adjustment.Actor, adjustment.CreatedAt)
+	if err != nil {
+		return balance, err
+	}
+	_, err = tx.ExecContext(ctx, "UPDATE users_balances SET current = current + $1 WHERE login = $2", adjustment.Amount, adjustment.Login)
+	if err != nil {
+		return balance, err
+	}
+	balance.Current += adjustment.Amount
+
+	event := audit.New(ctx, adjustment.Actor, audit.ActionAdjustment, adjustment.Login, map[string]any{
+		"amount":  adjustment.Amount,
+		"reason":  adjustment.Reason,
+		"current": balance.Current,
+	})
+	if err := commitWithAudit(ctx, tx, event); err != nil {
+		return balance, err
+	}
+	return balance, nil
+}
+
+// RequeueAccrualTask снова ставит заказ в очередь к Accrual, в том числе после done и dead.
+// Попытки считаются заново. ErrOrderNotFound - если заказа нет. Событие аудита от имени actor
+// пишется в той же транзакции.
+func (s *StorageDB) RequeueAccrualTask(ctx context.Context, actor string, orderNumber int) error {
+	defer metrics.ObserveDBQuery("RequeueAccrualTask", time.Now())
+
+	tx, err := s.DBConn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			log.Printf("rollback error: %v", err)
+		}
+	}()
+
+	var userLogin string
+	err = tx.QueryRowContext(ctx, "SELECT login FROM orders WHERE number = $1 FOR UPDATE", orderNumber).Scan(&userLogin)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrOrderNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO accrual_tasks (login, order_number) VALUES ($1, $2)
+		ON CONFLICT (order_number) DO UPDATE
+		SET state = $3, attempts = 0, unregistered = 0, next_attempt_at = now(), locked_until = NULL, last_error = NULL`,
+		userLogin, orderNumber, models.AccrualTaskPending)
+	if err != nil {
+		return err
+	}
+
+	event := audit.New(ctx, actor, audit.ActionOrderRecheck, "", map[string]any{"order": strconv.Itoa(orderNumber)})
+	return commitWithAudit(ctx, tx, event)
+}
+
+// auditChainLock - ключ advisory-блокировки журнала аудита: записи добавляются строго по одной,
+// иначе две транзакции продолжат цепочку от одной и той же записи
+const auditChainLock = 0x61756469
 
-	if err := goose.SetDialect("postgres"); err != nil {
-		log.Printf("error setting SQL dialect\n")
+// commitWithAudit дописывает events в журнал и сразу фиксирует tx. Блокировка журнала берётся
+// последним запросом транзакции, после всех изменений баланса и заказов, и держится до конца Commit.
+//
+// Цена: все транзакции с аудитом (начисления, списания, корректировки, действия администратора)
+// проходят этот участок по одной, поэтому вместе их не больше 1 / (вставка записи + Commit) в секунду.
+// Commit ждёт сброса WAL на диск, так что это порядка тысячи в секунду на SSD и заметно меньше
+// при синхронной репликации. Если этого станет мало, цепочку нужно строить отдельным
+// последовательным процессом после фиксации, а не внутри денежных транзакций.
+func commitWithAudit(ctx context.Context, tx *sql.Tx, events ...models.AuditEvent) error {
+	if len(events) > 0 {
+		if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", auditChainLock); err != nil {
+			return err
+		}
+		for _, event := range events {
+			if err := insertAuditEvent(ctx, tx, event); err != nil {
+				return err
+			}
+		}
 	}
 
-	if err := goose.Up(db, "db/migrations"); err != nil {
-		log.Printf("error migration %s\n", err.Error())
+	if err := tx.Commit(); err != nil {
+		return ErrTransaction
 	}
+	return nil
 }
 
-func NewStorage(c *config.Config) (*StorageDB, error) {
-	dbConn, err := sql.Open("pgx", c.DBConnection)
-	if err != nil {
-		return nil, ErrOpenDBConnection
+// insertAuditEvent продолжает цепочку от последней записи журнала; вызывающий держит auditChainLock
+func insertAuditEvent(ctx context.Context, tx *sql.Tx, event models.AuditEvent) error {
+	var prevHash string
+	err := tx.QueryRowContext(ctx, "SELECT hash FROM audit_events ORDER BY id DESC LIMIT 1").Scan(&prevHash)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
 	}
 
-	if err := dbConn.Ping(); err != nil {
-		return nil, ErrConnecting
+	event = audit.Chain(prevHash, event)
+	var payload any
+	if event.Payload != nil {
+		payload = string(event.Payload)
 	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO audit_events (actor, action, target, request_id, ip, payload, created_at, prev_hash, hash)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		event.Actor, event.Action, event.Target, event.RequestID, event.IP, payload, event.CreatedAt, event.PrevHash, event.Hash)
+	return err
+}
 
-	UpDBMigrations(dbConn)
+func (s *StorageDB) AddAuditEvent(ctx context.Context, event models.AuditEvent) error {
+	defer metrics.ObserveDBQuery("AddAuditEvent", time.Now())
 
-	return &StorageDB{
-		DBConn: dbConn,
-	}, nil
+	tx, err := s.DBConn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			log.Printf("rollback error: %v", err)
+		}
+	}()
+
+	return commitWithAudit(ctx, tx, event)
 }
 
-func (s *StorageDB) SaveLoginPassword(login, hashedPassword string) bool {
-	_, err1 := s.DBConn.Exec("INSERT INTO users (login, password) VALUES ($1, $2)", login, hashedPassword)
+const selectAuditEvents = `
+	SELECT id, actor, action, target, request_id, ip, payload, created_at, prev_hash, hash
+	FROM audit_events`
 
-	return err1 == nil
-}
+// GetAuditEvents возвращает до filter.Limit событий от новых к старым
+func (s *StorageDB) GetAuditEvents(ctx context.Context, filter models.AuditFilter) ([]models.AuditEvent, error) {
+	defer metrics.ObserveDBQuery("GetAuditEvents", time.Now())
 
-func (s *StorageDB) GetHashedPasswordByLogin(login string) string {
-	var hashedPassword string
-	_ = s.DBConn.QueryRow("SELECT password FROM users WHERE login=$1", login).Scan(&hashedPassword)
-	return hashedPassword
+	var conditions []string
+	var args []any
+	where := func(condition string, arg any) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+	if filter.Actor != "" {
+		where("actor = $%d", filter.Actor)
+	}
+	if filter.Action != "" {
+		where("action = $%d", filter.Action)
+	}
+	if filter.Target != "" {
+		where("target = $%d", filter.Target)
+	}
+	if filter.RequestID != "" {
+		where("request_id = $%d", filter.RequestID)
+	}
+	if !filter.From.IsZero() {
+		where("created_at >= $%d", filter.From)
+	}
+	if !filter.To.IsZero() {
+		where("created_at < $%d", filter.To)
+	}
+	if filter.BeforeID > 0 {
+		where("id < $%d", filter.BeforeID)
+	}
+
+	query := selectAuditEvents
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	args = append(args, filter.Limit)
+	query += fmt.Sprintf(" ORDER BY id DESC LIMIT $%d", len(args))
+
+	return s.queryAuditEvents(ctx, query, args...)
 }
 
-func (s *StorageDB) SaveUID(userID, login string) error {
-	_, err := s.DBConn.Exec("UPDATE users SET uid = $1 WHERE login = $2", userID, login)
-	return err
+// GetAuditChain возвращает до limit событий с ID больше afterID в порядке записи (для проверки цепочки)
+func (s *StorageDB) GetAuditChain(ctx context.Context, afterID int64, limit int) ([]models.AuditEvent, error) {
+	defer metrics.ObserveDBQuery("GetAuditChain", time.Now())
+
+	return s.queryAuditEvents(ctx, selectAuditEvents+" WHERE id > $1 ORDER BY id LIMIT $2", afterID, limit)
 }
 
-func (s *StorageDB) GetLoginByUID(userID string) string {
-	var login string
-	_ = s.DBConn.QueryRow("SELECT login FROM users WHERE uid=$1", userID).Scan(&login)
-	return login
+func (s *StorageDB) queryAuditEvents(ctx context.Context, query string, args ...any) ([]models.AuditEvent, error) {
+	rows, err := s.DBConn.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []models.AuditEvent
+	for rows.Next() {
+		var event models.AuditEvent
+		var payload []byte
+		err := rows.Scan(&event.ID, &event.Actor, &event.Action, &event.Target, &event.RequestID, &event.IP,
+			&payload, &event.CreatedAt, &event.PrevHash, &event.Hash)
+		if err != nil {
+			return nil, err
+		}
+		event.Payload = payload
+		events = append(events, event)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
 }
 
-func (s *StorageDB) AddOrder(userLogin string, orderNumber int) (isAddedToDB bool, err error) {
+func (s *StorageDB) AddOrder(ctx context.Context, userLogin string, orderNumber int) (isAddedToDB bool, err error) {
+	defer metrics.ObserveDBQuery("AddOrder", time.Now())
+
 	var selectLoginFromUsersOrders = "SELECT login FROM orders WHERE number = $1 AND login != $2"
 	var isOrderNumberExistsForLogin = "SELECT 1 FROM orders WHERE login = $1 AND number = $2"
 	var insertNewOrder = "INSERT INTO orders (login, number, status, uploaded_at) VALUES ($1, $2, $3, $4)"
+	var insertAccrualTask = "INSERT INTO accrual_tasks (login, order_number) VALUES ($1, $2) ON CONFLICT (order_number) DO NOTHING"
+	var insertStatusHistory = "INSERT INTO order_status_history (order_number, from_status, to_status, changed_at) VALUES ($1, $2, $3, $4)"
 	isAddedToDB = false
 
 	// проверяем есть ли заказ orderNumber у другого пользователя
-	row := s.DBConn.QueryRow(selectLoginFromUsersOrders, orderNumber, userLogin)
+	row := s.DBConn.QueryRowContext(ctx, selectLoginFromUsersOrders, orderNumber, userLogin)
 	if err := row.Scan(new(string)); !errors.Is(err, sql.ErrNoRows) {
 		if err == nil {
 			return isAddedToDB, ErrAddOrderConflict // StatusConflict
@@ -112,7 +1047,7 @@ func (s *StorageDB) AddOrder(userLogin string, orderNumber int) (isAddedToDB boo
 	}
 
 	// существует ли запись с orderNumber в orders для заданного userLogin
-	row = s.DBConn.QueryRow(isOrderNumberExistsForLogin, userLogin, orderNumber)
+	row = s.DBConn.QueryRowContext(ctx, isOrderNumberExistsForLogin, userLogin, orderNumber)
 	if err := row.Scan(new(int)); !errors.Is(err, sql.ErrNoRows) {
 		if err == nil {
 			return isAddedToDB, nil // StatusOK номер заказа уже был загружен этим пользователем
@@ -120,26 +1055,68 @@ func (s *StorageDB) AddOrder(userLogin string, orderNumber int) (isAddedToDB boo
 		return isAddedToDB, err
 	}
 
-	// Сохранить в таблице orders
-	_, err = s.DBConn.Exec(insertNewOrder, userLogin, orderNumber, "NEW", time.Now())
+	// Заказ, его начальный статус в истории и задача для Accrual сохраняются одной транзакцией
+	tx, err := s.DBConn.BeginTx(ctx, nil)
+	if err != nil {
+		return isAddedToDB, err
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			log.Printf("rollback error: %v", err)
+		}
+	}()
+
+	now := time.Now()
+	_, err = tx.ExecContext(ctx, insertNewOrder, userLogin, orderNumber, orderstate.New, now)
+	if userMissing(err) {
+		return isAddedToDB, ErrUserNotFound
+	} else if err != nil {
+		return isAddedToDB, err
+	}
+	_, err = tx.ExecContext(ctx, insertStatusHistory, orderNumber, nil, orderstate.New, now)
+	if err != nil {
+		return isAddedToDB, err
+	}
+	_, err = tx.ExecContext(ctx, insertAccrualTask, userLogin, orderNumber)
 	if err != nil {
 		return isAddedToDB, err
 	}
 
+	if err = tx.Commit(); err != nil {
+		return isAddedToDB, ErrTransaction
+	}
+
 	isAddedToDB = true
 	return isAddedToDB, nil // StatusAccepted новый номер заказа принят в обработку
 }
 
-func (s *StorageDB) GetOrders(userLogin string) ([]models.Order, error) {
-	rows, err := s.DBConn.Query(`
+// GetOrder возвращает заказ вместе с логином владельца, ErrOrderNotFound - если заказа нет
+func (s *StorageDB) GetOrder(ctx context.Context, orderNumber int) (models.Order, error) {
+	defer metrics.ObserveDBQuery("GetOrder", time.Now())
+
+	var order models.Order
+	err := s.DBConn.QueryRowContext(ctx,
+		"SELECT login, number, status, accrual, uploaded_at FROM orders WHERE number = $1", orderNumber).
+		Scan(&order.Login, &order.Number, &order.Status, &order.Accrual, &order.UploadedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Order{}, ErrOrderNotFound
+	}
+	if err != nil {
+		return models.Order{}, err
+	}
+
+	return order, nil
+}
+
+func (s *StorageDB) GetOrders(ctx context.Context, userLogin string, list models.ListQuery) ([]models.Order, error) {
+	defer metrics.ObserveDBQuery("GetOrders", time.Now())
+
+	query, args := pageQuery(`
 		SELECT number, status, accrual, uploaded_at
         FROM orders
-        WHERE login = $1
-        ORDER BY uploaded_at DESC
-    `, userLogin)
-	// DESC - в порядке убывания
-
-	if err != nil && rows.Err() != nil {
+        WHERE login = $1`, "status", "uploaded_at", "number", userLogin, list)
+	rows, err := s.DBConn.QueryContext(ctx, query, args...)
+	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
@@ -147,84 +1124,227 @@ func (s *StorageDB) GetOrders(userLogin string) ([]models.Order, error) {
 	var orders []models.Order
 	for rows.Next() {
 		var order models.Order
-		var uploadedAt time.Time
 
-		err := rows.Scan(&order.Number, &order.Status, &order.Accrual, &uploadedAt)
+		err := rows.Scan(&order.Number, &order.Status, &order.Accrual, &order.UploadedAt)
 		if err != nil {
 			return nil, err
 		}
 
-		order.UploadedAt = time.Now()
 		orders = append(orders, order)
 	}
 
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
 	return orders, nil
 }
 
-func (s *StorageDB) UpdateOrder(orderNumber int, status string, accrual float64) error {
-	var updateOrder = "UPDATE orders SET status = $1, accrual = $2, accrual_added = (TRUE) WHERE number = $3"
-	_, err := s.DBConn.Exec(updateOrder, status, accrual, orderNumber)
-	return err
+// pageQuery дописывает к выборке записей пользователя (WHERE login = $1) фильтры, порядок и лимит из list.
+// Порядок по времени и номеру заказа (он уникален и у списаний) однозначен, поэтому курсор не теряет и не повторяет записи.
+// statusColumn "" - фильтр по статусу не применяется.
+func pageQuery(selectQuery, statusColumn, timeColumn, numberColumn, userLogin string, list models.ListQuery) (string, []any) {
+	query := selectQuery
+	args := []any{userLogin}
+	and := func(condition string, values ...any) {
+		placeholders := make([]any, len(values))
+		for i, value := range values {
+			args = append(args, value)
+			placeholders[i] = len(args)
+		}
+		query += " AND " + fmt.Sprintf(condition, placeholders...)
+	}
+
+	if statusColumn != "" && len(list.Statuses) > 0 {
+		statuses := make([]any, len(list.Statuses))
+		for i, status := range list.Statuses {
+			statuses[i] = status
+		}
+		and(statusColumn+" IN ("+strings.TrimSuffix(strings.Repeat("$%d, ", len(statuses)), ", ")+")", statuses...)
+	}
+	if !list.From.IsZero() {
+		and(timeColumn+" >= $%d", list.From)
+	}
+	if !list.To.IsZero() {
+		and(timeColumn+" < $%d", list.To)
+	}
+
+	direction, compare := "DESC", "<"
+	if list.Asc {
+		direction, compare = "ASC", ">"
+	}
+	if list.After != nil {
+		and("("+timeColumn+", "+numberColumn+") "+compare+" ($%d, $%d)", list.After.At, list.After.Number)
+	}
+	query += fmt.Sprintf(" ORDER BY %s %s, %s %s", timeColumn, direction, numberColumn, direction)
+	if list.Limit > 0 {
+		args = append(args, list.Limit)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+	return query, args
 }
 
-func (s *StorageDB) GetUserBalance(userLogin string) (models.UserBalance, error) {
-	err := s.BalanceForUserLogin(userLogin)
+// UpdateOrder переводит заказ в status (см. orderstate.Check), записывает переход в историю
+// и начисляет accrual на баланс при переходе в PROCESSED. Повтор текущего статуса ничего не меняет (changed == false).
+func (s *StorageDB) UpdateOrder(ctx context.Context, orderNumber int, status string, accrual models.Money) (changed bool, err error) {
+	defer metrics.ObserveDBQuery("UpdateOrder", time.Now())
+
+	var selectOrder = "SELECT login, status FROM orders WHERE number = $1 FOR UPDATE"
+	var updateOrder = "UPDATE orders SET status = $1, accrual = $2 WHERE number = $3"
+	var insertStatusHistory = "INSERT INTO order_status_history (order_number, from_status, to_status, changed_at) VALUES ($1, $2, $3, $4)"
+	var insertCredit = `INSERT INTO balance_ledger (login, order_number, kind, amount, created_at)
+		VALUES ($1, $2, 'credit', $3, $4)
+		ON CONFLICT (order_number) WHERE kind = 'credit' DO NOTHING`
+	var userLogin, currentStatus string
+	var events []models.AuditEvent
+
+	tx, err := s.DBConn.BeginTx(ctx, nil)
 	if err != nil {
-		return models.UserBalance{}, ErrGetUserBalance
+		return false, err
 	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			log.Printf("rollback error: %v", err)
+		}
+	}()
 
-	var getUserBalance = "SELECT current, withdrawn FROM users_balances WHERE login = $1"
-	var balance models.UserBalance
-	err = s.DBConn.QueryRow(getUserBalance, userLogin).Scan(&balance.Current, &balance.Withdrawn)
+	err = tx.QueryRowContext(ctx, selectOrder, orderNumber).Scan(&userLogin, &currentStatus)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, ErrOrderNotFound
+	} else if err != nil {
+		return false, err
+	}
+
+	if err := orderstate.Check(currentStatus, status); err != nil {
+		return false, err
+	}
+	if currentStatus == status {
+		return false, nil
+	}
+
+	now := time.Now()
+	if _, err = tx.ExecContext(ctx, updateOrder, status, accrual, orderNumber); err != nil {
+		return false, err
+	}
+	if _, err = tx.ExecContext(ctx, insertStatusHistory, orderNumber, currentStatus, status, now); err != nil {
+		return false, err
+	}
+
+	if status == orderstate.Processed && accrual > 0 {
+		result, err := tx.ExecContext(ctx, insertCredit, userLogin, orderNumber, accrual, now)
+		if err != nil {
+			return false, err
+		}
+
+		credited, err := result.RowsAffected()
+		if err != nil {
+			return false, err
+		}
+
+		// credited == 0, если начисление по этому заказу уже было
+		if credited == 1 {
+			if err := creditBalance(ctx, tx, userLogin, orderNumber, accrual); err != nil {
+				return false, err
+			}
+			event := audit.New(ctx, audit.ActorSystem, audit.ActionAccrualCredited, userLogin, map[string]any{
+				"order":  strconv.Itoa(orderNumber),
+				"amount": accrual,
+			})
+			events = append(events, event)
+		}
+	}
 
+	if err := commitWithAudit(ctx, tx, events...); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// GetOrderStatusHistory возвращает переходы заказа от старых к новым
+func (s *StorageDB) GetOrderStatusHistory(ctx context.Context, orderNumber int) ([]models.OrderStatusChange, error) {
+	defer metrics.ObserveDBQuery("GetOrderStatusHistory", time.Now())
+
+	rows, err := s.DBConn.QueryContext(ctx, `
+		SELECT COALESCE(from_status, ''), to_status, changed_at
+		FROM order_status_history
+		WHERE order_number = $1
+		ORDER BY changed_at, id`, orderNumber)
 	if err != nil {
-		return models.UserBalance{}, ErrGetUserBalance
+		return nil, err
 	}
+	defer rows.Close()
 
-	return balance, nil
+	var history []models.OrderStatusChange
+	for rows.Next() {
+		var change models.OrderStatusChange
+		if err := rows.Scan(&change.From, &change.To, &change.ChangedAt); err != nil {
+			return nil, err
+		}
+		history = append(history, change)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return history, nil
 }
 
-func (s *StorageDB) UpdateUserBalance(userLogin string, orderNumber int, accrualToAdd float64) error {
-	tx, err := s.DBConn.Begin()
+func creditBalance(ctx context.Context, tx *sql.Tx, userLogin string, orderNumber int, accrual models.Money) error {
+	_, err := tx.ExecContext(ctx, "INSERT INTO users_balances (login) VALUES ($1) ON CONFLICT (login) DO NOTHING", userLogin)
 	if err != nil {
 		return err
 	}
-	defer func() {
-		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
-			log.Printf("rollback error: %v", err)
-		}
-	}()
 
-	err = s.BalanceForUserLogin(userLogin)
+	_, err = tx.ExecContext(ctx, "UPDATE users_balances SET current = current + $1 WHERE login = $2", accrual, userLogin)
 	if err != nil {
 		return err
 	}
 
-	// Обновляем баланс для заказа пользователя, только если у заказа accrual_added == FALSE
-	_, err = tx.Exec(`UPDATE users_balances
-		SET current = current + $1
-		FROM orders o
-		WHERE users_balances.login = (SELECT login FROM users WHERE users.login = $2)
-		AND o.accrual_added = FALSE`, accrualToAdd, userLogin)
+	_, err = tx.ExecContext(ctx, "UPDATE orders SET accrual_added = TRUE WHERE number = $1", orderNumber)
+	return err
+}
+
+func (s *StorageDB) GetUserBalance(ctx context.Context, userLogin string) (models.UserBalance, error) {
+	defer metrics.ObserveDBQuery("GetUserBalance", time.Now())
 
+	err := s.BalanceForUserLogin(ctx, userLogin)
 	if err != nil {
-		return err
+		return models.UserBalance{}, ErrGetUserBalance
 	}
 
-	err = tx.Commit()
+	var getUserBalance = "SELECT current, withdrawn FROM users_balances WHERE login = $1"
+	var balance models.UserBalance
+	err = s.DBConn.QueryRowContext(ctx, getUserBalance, userLogin).Scan(&balance.Current, &balance.Withdrawn)
+
 	if err != nil {
-		return ErrTransaction
+		return models.UserBalance{}, ErrGetUserBalance
 	}
 
-	return nil
+	return balance, nil
 }
 
-func (s *StorageDB) WithdrawFromUserBalance(userLogin string, orderNumber int, amount float64) error {
-	var getCurrentBalance = "SELECT current FROM users_balances WHERE login = $1"
+func (s *StorageDB) WithdrawFromUserBalance(ctx context.Context, userLogin string, orderNumber int, amount models.Money) error {
+	defer metrics.ObserveDBQuery("WithdrawFromUserBalance", time.Now())
+
+	if amount <= 0 {
+		return ErrInvalidAmount
+	}
+
+	var getCurrentBalance = "SELECT current FROM users_balances WHERE login = $1 FOR UPDATE"
 	var updateMoney = "UPDATE users_balances SET current = current - $1, withdrawn = withdrawn + $1 WHERE login = $2"
-	var currentBalance float64
+	var withdrawalExists = "SELECT EXISTS (SELECT 1 FROM users_withdrawals WHERE login = $1 AND order_number = $2)"
+	var insertWithdrawal = `INSERT INTO users_withdrawals (login, order_number, sum, processed_at)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id`
+	var insertDebit = `INSERT INTO balance_ledger (login, order_number, kind, amount, created_at, withdrawal_id)
+		VALUES ($1, $2, 'debit', $3, $4, $5)`
+	var currentBalance models.Money
+	var exists bool
+	var withdrawalID int64
+	processedAt := time.Now()
 
-	tx, err := s.DBConn.Begin()
+	tx, err := s.DBConn.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
@@ -234,47 +1354,60 @@ func (s *StorageDB) WithdrawFromUserBalance(userLogin string, orderNumber int, a
 		}
 	}()
 
-	err = s.BalanceForUserLogin(userLogin)
+	_, err = tx.ExecContext(ctx, "INSERT INTO users_balances (login) VALUES ($1) ON CONFLICT (login) DO NOTHING", userLogin)
+	if err != nil {
+		return err
+	}
+
+	err = tx.QueryRowContext(ctx, getCurrentBalance, userLogin).Scan(&currentBalance)
 	if err != nil {
 		return err
 	}
 
-	err = tx.QueryRow(getCurrentBalance, userLogin).Scan(&currentBalance)
+	// Повтор списания проверяется только среди списаний пользователя.
+	// Строка баланса заблокирована выше, поэтому параллельный повтор дождётся этой транзакции.
+	err = tx.QueryRowContext(ctx, withdrawalExists, userLogin, orderNumber).Scan(&exists)
 	if err != nil {
 		return err
 	}
+	if exists {
+		return ErrWithdrawConflict
+	}
 
 	if currentBalance < amount {
 		return ErrInsufficientFunds
 	}
-	_, err = tx.Exec(updateMoney, amount, userLogin)
+
+	err = tx.QueryRowContext(ctx, insertWithdrawal, userLogin, orderNumber, amount, processedAt).Scan(&withdrawalID)
 	if err != nil {
 		return err
 	}
 
-	// Добавляем _каждую_ операцию списания
-	_, err = s.DBConn.Exec(`
-		INSERT INTO users_withdrawals (login, order_number, sum, processed_at)
-		VALUES ($1, $2, $3, $4)`, userLogin, orderNumber, amount, time.Now())
-
+	_, err = tx.ExecContext(ctx, insertDebit, userLogin, orderNumber, amount, processedAt, withdrawalID)
 	if err != nil {
 		return err
 	}
 
-	err = tx.Commit()
+	_, err = tx.ExecContext(ctx, updateMoney, amount, userLogin)
 	if err != nil {
-		return ErrTransaction
+		return err
 	}
 
-	return nil
+	event := audit.New(ctx, userLogin, audit.ActionWithdrawal, userLogin, map[string]any{
+		"order": strconv.Itoa(orderNumber),
+		"sum":   amount,
+	})
+	return commitWithAudit(ctx, tx, event)
 }
 
-func (s *StorageDB) GetUserWithdrawals(userLogin string) ([]models.Withdrawal, error) {
-	rows, err := s.DBConn.Query(`
-        SELECT order_number, sum, processed_at 
-        FROM users_withdrawals 
-        WHERE login = $1 
-        ORDER BY processed_at DESC`, userLogin)
+func (s *StorageDB) GetUserWithdrawals(ctx context.Context, userLogin string, list models.ListQuery) ([]models.Withdrawal, error) {
+	defer metrics.ObserveDBQuery("GetUserWithdrawals", time.Now())
+
+	query, args := pageQuery(`
+        SELECT order_number, sum, processed_at
+        FROM users_withdrawals
+        WHERE login = $1`, "", "processed_at", "order_number", userLogin, list)
+	rows, err := s.DBConn.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -296,11 +1429,13 @@ func (s *StorageDB) GetUserWithdrawals(userLogin string) ([]models.Withdrawal, e
 	return withdrawals, nil
 }
 
-func (s *StorageDB) BalanceForUserLogin(userLogin string) error {
-	err := s.DBConn.QueryRow("SELECT 1 FROM users_balances WHERE login=$1", userLogin).Scan(new(int))
+func (s *StorageDB) BalanceForUserLogin(ctx context.Context, userLogin string) error {
+	defer metrics.ObserveDBQuery("BalanceForUserLogin", time.Now())
+
+	err := s.DBConn.QueryRowContext(ctx, "SELECT 1 FROM users_balances WHERE login=$1", userLogin).Scan(new(int))
 	if errors.Is(err, sql.ErrNoRows) {
 		// Если записи с userLogin нет, добавляем
-		_, err = s.DBConn.Exec("INSERT INTO users_balances (login) VALUES ($1)", userLogin)
+		_, err = s.DBConn.ExecContext(ctx, "INSERT INTO users_balances (login) VALUES ($1)", userLogin)
 		if err != nil {
 			return err
 		}
@@ -309,3 +1444,187 @@ func (s *StorageDB) BalanceForUserLogin(userLogin string) error {
 	}
 	return nil
 }
+
+// ClaimAccrualTasks захватывает до limit готовых к запросу задач на время lease.
+// SKIP LOCKED позволяет нескольким экземплярам сервиса разбирать очередь, не мешая друг другу,
+// а задача упавшего экземпляра снова станет доступна после истечения lease.
+func (s *StorageDB) ClaimAccrualTasks(ctx context.Context, limit int, lease time.Duration) ([]models.AccrualTask, error) {
+	defer metrics.ObserveDBQuery("ClaimAccrualTasks", time.Now())
+
+	rows, err := s.DBConn.QueryContext(ctx, `
+		UPDATE accrual_tasks
+		SET locked_until = now() + make_interval(secs => $2)
+		WHERE order_number IN (
+			SELECT order_number
+			FROM accrual_tasks
+			WHERE state = $3
+				AND next_attempt_at <= now()
+				AND (locked_until IS NULL OR locked_until <= now())
+			ORDER BY next_attempt_at
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING login, order_number, attempts, unregistered`, limit, lease.Seconds(), models.AccrualTaskPending)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tasks []models.AccrualTask
+	for rows.Next() {
+		var task models.AccrualTask
+		if err := rows.Scan(&task.UserLogin, &task.OrderNumber, &task.Attempts, &task.Unregistered); err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, task)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tasks, nil
+}
+
+// CompleteAccrualTask закрывает задачу: заказ получил финальный статус. Число попыток остаётся для истории.
+func (s *StorageDB) CompleteAccrualTask(ctx context.Context, orderNumber int) error {
+	defer metrics.ObserveDBQuery("CompleteAccrualTask", time.Now())
+
+	_, err := s.DBConn.ExecContext(ctx, `
+		UPDATE accrual_tasks
+		SET state = $2, locked_until = NULL
+		WHERE order_number = $1`, orderNumber, models.AccrualTaskDone)
+	return err
+}
+
+// RescheduleAccrualTask снимает захват и откладывает задачу на delay, не считая попытку неудачной
+func (s *StorageDB) RescheduleAccrualTask(ctx context.Context, orderNumber int, delay time.Duration) error {
+	defer metrics.ObserveDBQuery("RescheduleAccrualTask", time.Now())
+
+	_, err := s.DBConn.ExecContext(ctx, `
+		UPDATE accrual_tasks
+		SET next_attempt_at = now() + make_interval(secs => $2), locked_until = NULL
+		WHERE order_number = $1 AND state = $3`, orderNumber, delay.Seconds(), models.AccrualTaskPending)
+	return err
+}
+
+// FailAccrualTask учитывает неудачную попытку и откладывает задачу на delay.
+// После maxAttempts неудач задача переходит в состояние dead и больше не выдаётся воркерам.
+func (s *StorageDB) FailAccrualTask(ctx context.Context, orderNumber int, cause string, delay time.Duration, maxAttempts int) (dead bool, err error) {
+	defer metrics.ObserveDBQuery("FailAccrualTask", time.Now())
+
+	var state string
+	err = s.DBConn.QueryRowContext(ctx, `
+		UPDATE accrual_tasks
+		SET attempts = attempts + 1,
+			last_error = $2,
+			next_attempt_at = now() + make_interval(secs => $3),
+			locked_until = NULL,
+			state = CASE WHEN attempts + 1 >= $4 THEN $5 ELSE state END
+		WHERE order_number = $1 AND state = $6
+		RETURNING state`, orderNumber, cause, delay.Seconds(), maxAttempts,
+		models.AccrualTaskDead, models.AccrualTaskPending).Scan(&state)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return state == models.AccrualTaskDead, nil
+}
+
+// PostponeUnregisteredAccrualTask откладывает задачу на delay, когда Accrual ещё не знает о заказе.
+// Такие ответы не расходуют попытки, но после maxPolls задача тоже переходит в dead.
+func (s *StorageDB) PostponeUnregisteredAccrualTask(ctx context.Context, orderNumber int, cause string, delay time.Duration, maxPolls int) (dead bool, err error) {
+	defer metrics.ObserveDBQuery("PostponeUnregisteredAccrualTask", time.Now())
+
+	var state string
+	err = s.DBConn.QueryRowContext(ctx, `
+		UPDATE accrual_tasks
+		SET unregistered = unregistered + 1,
+			last_error = $2,
+			next_attempt_at = now() + make_interval(secs => $3),
+			locked_until = NULL,
+			state = CASE WHEN unregistered + 1 >= $4 THEN $5 ELSE state END
+		WHERE order_number = $1 AND state = $6
+		RETURNING state`, orderNumber, cause, delay.Seconds(), maxPolls,
+		models.AccrualTaskDead, models.AccrualTaskPending).Scan(&state)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return state == models.AccrualTaskDead, nil
+}
+
+// GetAccrualTask возвращает состояние задачи по заказу, nil - если задачи нет
+func (s *StorageDB) GetAccrualTask(ctx context.Context, orderNumber int) (*models.AccrualTaskInfo, error) {
+	defer metrics.ObserveDBQuery("GetAccrualTask", time.Now())
+
+	var task models.AccrualTaskInfo
+	var lastError sql.NullString
+	var nextAttemptAt time.Time
+	err := s.DBConn.QueryRowContext(ctx, `
+		SELECT state, attempts, last_error, next_attempt_at
+		FROM accrual_tasks
+		WHERE order_number = $1`, orderNumber).Scan(&task.State, &task.Attempts, &lastError, &nextAttemptAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	task.LastError = lastError.String
+	if task.State == models.AccrualTaskPending {
+		task.NextAttemptAt = &nextAttemptAt
+	}
+	return &task, nil
+}
+
+// CountPendingAccrualTasks - число незавершённых задач: готовых к запросу и отложенных
+func (s *StorageDB) CountPendingAccrualTasks(ctx context.Context) (int, error) {
+	defer metrics.ObserveDBQuery("CountPendingAccrualTasks", time.Now())
+
+	var count int
+	err := s.DBConn.QueryRowContext(ctx, `
+		SELECT count(*)
+		FROM accrual_tasks
+		WHERE state = $1`, models.AccrualTaskPending).Scan(&count)
+	return count, err
+}
+
+func (s *StorageDB) Ping(ctx context.Context) error {
+	defer metrics.ObserveDBQuery("Ping", time.Now())
+
+	return s.DBConn.PingContext(ctx)
+}
+
+// MigrationVersion возвращает применённую версию миграций и последнюю из встроенных в бинарник
+func (s *StorageDB) MigrationVersion(ctx context.Context) (current, latest int64, err error) {
+	defer metrics.ObserveDBQuery("MigrationVersion", time.Now())
+
+	current, err = goose.GetDBVersionContext(ctx, s.DBConn)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	goose.SetBaseFS(embedMigrations)
+	migrations, err := goose.CollectMigrations(migrationsDir, 0, goose.MaxVersion)
+	if err != nil {
+		return 0, 0, err
+	}
+	last, err := migrations.Last()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	return current, last.Version, nil
+}
+
+func (s *StorageDB) Close() error {
+	return s.DBConn.Close()
+}
@@ -0,0 +1,716 @@
+//go:build unit
+// +build unit
+
+package storage_test
+
+import (
+	"context"
+	"gophermart/cmd/gophermart/audit"
+	"gophermart/cmd/gophermart/config"
+	"gophermart/cmd/gophermart/models"
+	"gophermart/cmd/gophermart/orderstate"
+	"gophermart/cmd/gophermart/storage"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ShiraazMoollatjie/goluhn"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// Набор проверок, которые должна проходить любая реализация StorageService.
+// Логины и номера заказов случайные, поэтому тесты можно запускать на непустой БД.
+func runStorageConformance(t *testing.T, s storage.StorageService) {
+	ctx := context.Background()
+	newUser := func(t *testing.T) string {
+		login := "user-" + uuid.New().String()
+		require.True(t, s.SaveLoginPassword(ctx, login, "hashed-"+login))
+		return login
+	}
+	updateOrder := func(number int, status string, accrual models.Money) error {
+		_, err := s.UpdateOrder(ctx, number, status, accrual)
+		return err
+	}
+	newOrder := func() int {
+		n, _ := strconv.Atoi(goluhn.Generate(12))
+		return n
+	}
+
+	t.Run("Users", func(t *testing.T) {
+		login := newUser(t)
+
+		assert.False(t, s.SaveLoginPassword(ctx, login, "other"), "login must be unique")
+		assert.Equal(t, "hashed-"+login, s.GetHashedPasswordByLogin(ctx, login))
+		assert.Equal(t, "", s.GetHashedPasswordByLogin(ctx, "unknown-"+login))
+
+	})
+
+	t.Run("Sessions", func(t *testing.T) {
+		login, other := newUser(t), newUser(t)
+		now := time.Now()
+		newSession := func(login string, expiresAt time.Time) models.Session {
+			session := models.Session{ID: uuid.New().String(), Login: login, CreatedAt: now, UserAgent: "test", ExpiresAt: expiresAt}
+			require.NoError(t, s.CreateSession(ctx, session))
+			return session
+		}
+		first := newSession(login, now.Add(time.Hour))
+		second := newSession(login, now.Add(time.Hour))
+		expired := newSession(login, now.Add(-time.Minute))
+		foreign := newSession(other, now.Add(time.Hour))
+
+		touched, err := s.TouchSession(ctx, first.ID)
+		require.NoError(t, err)
+		assert.Equal(t, login, touched)
+		touched, err = s.TouchSession(ctx, second.ID)
+		require.NoError(t, err)
+		assert.Equal(t, login, touched, "a second device does not end the first session")
+		_, err = s.TouchSession(ctx, expired.ID)
+		assert.ErrorIs(t, err, storage.ErrSessionNotFound, "expired session")
+		_, err = s.TouchSession(ctx, uuid.New().String())
+		assert.ErrorIs(t, err, storage.ErrSessionNotFound)
+
+		sessions, err := s.GetSessions(ctx, login)
+		require.NoError(t, err)
+		require.Len(t, sessions, 2)
+		assert.Equal(t, second.ID, sessions[0].ID, "most recently used first")
+		assert.Equal(t, "test", sessions[0].UserAgent)
+
+		assert.ErrorIs(t, s.DeleteSession(ctx, login, foreign.ID), storage.ErrSessionNotFound, "other user's session")
+		require.NoError(t, s.DeleteSession(ctx, login, first.ID))
+		_, err = s.TouchSession(ctx, first.ID)
+		assert.ErrorIs(t, err, storage.ErrSessionNotFound)
+		assert.ErrorIs(t, s.DeleteSession(ctx, login, first.ID), storage.ErrSessionNotFound)
+		touched, err = s.TouchSession(ctx, foreign.ID)
+		require.NoError(t, err)
+		assert.Equal(t, other, touched)
+	})
+
+	t.Run("ChangePassword", func(t *testing.T) {
+		login, other := newUser(t), newUser(t)
+		now := time.Now()
+		newSession := func(login string) models.Session {
+			session := models.Session{ID: uuid.New().String(), Login: login, CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+			require.NoError(t, s.CreateSession(ctx, session))
+			return session
+		}
+		current, stale, foreign := newSession(login), newSession(login), newSession(other)
+		before, err := s.GetUser(ctx, login)
+		require.NoError(t, err)
+
+		require.NoError(t, s.ChangePassword(ctx, login, "rehashed-"+login, current.ID))
+		assert.Equal(t, "rehashed-"+login, s.GetHashedPasswordByLogin(ctx, login))
+
+		after, err := s.GetUser(ctx, login)
+		require.NoError(t, err)
+		assert.Equal(t, before.TokenVersion+1, after.TokenVersion, "earlier bearer tokens are revoked")
+		unchanged, err := s.GetUser(ctx, other)
+		require.NoError(t, err)
+		assert.Equal(t, int64(0), unchanged.TokenVersion)
+
+		_, err = s.TouchSession(ctx, current.ID)
+		assert.NoError(t, err, "the current session is kept")
+		_, err = s.TouchSession(ctx, stale.ID)
+		assert.ErrorIs(t, err, storage.ErrSessionNotFound, "other sessions are ended")
+		_, err = s.TouchSession(ctx, foreign.ID)
+		assert.NoError(t, err, "other users are not affected")
+
+		// Без сессии (вход по токену) завершаются все сессии
+		require.NoError(t, s.ChangePassword(ctx, login, "hashed-"+login, ""))
+		_, err = s.TouchSession(ctx, current.ID)
+		assert.ErrorIs(t, err, storage.ErrSessionNotFound)
+	})
+
+	t.Run("LoginThrottle", func(t *testing.T) {
+		key := "login:" + newUser(t)
+
+		lockedUntil, err := s.GetLoginLockout(ctx, key)
+		require.NoError(t, err)
+		assert.True(t, lockedUntil.IsZero())
+
+		for i := 1; i <= 3; i++ {
+			failures, err := s.AddLoginFailure(ctx, key, time.Minute)
+			require.NoError(t, err)
+			assert.Equal(t, i, failures)
+		}
+		failures, err := s.AddLoginFailure(ctx, key, time.Nanosecond)
+		require.NoError(t, err)
+		assert.Equal(t, 1, failures, "failures outside the window are dropped")
+
+		require.NoError(t, s.ResetLoginFailures(ctx, key))
+		failures, err = s.AddLoginFailure(ctx, key, time.Minute)
+		require.NoError(t, err)
+		assert.Equal(t, 1, failures)
+
+		lockedUntil, err = s.LockLogin(ctx, key, time.Hour)
+		require.NoError(t, err)
+		assert.WithinDuration(t, time.Now().Add(time.Hour), lockedUntil, time.Minute)
+		stored, err := s.GetLoginLockout(ctx, key)
+		require.NoError(t, err)
+		assert.WithinDuration(t, lockedUntil, stored, time.Millisecond)
+
+		failures, err = s.AddLoginFailure(ctx, key, time.Minute)
+		require.NoError(t, err)
+		assert.Equal(t, 1, failures, "the lockout resets the counter")
+
+		_, err = s.LockLogin(ctx, key, time.Nanosecond)
+		require.NoError(t, err)
+		time.Sleep(time.Millisecond)
+		lockedUntil, err = s.GetLoginLockout(ctx, key)
+		require.NoError(t, err)
+		assert.True(t, lockedUntil.IsZero(), "expired lockout")
+	})
+
+	t.Run("TOTP", func(t *testing.T) {
+		login := newUser(t)
+
+		totp, err := s.GetTOTP(ctx, login)
+		require.NoError(t, err)
+		assert.Nil(t, totp)
+		assert.ErrorIs(t, s.EnableTOTP(ctx, login, 1, nil), storage.ErrTOTPNotFound)
+
+		require.NoError(t, s.SaveTOTPSecret(ctx, login, "first"))
+		require.NoError(t, s.SaveTOTPSecret(ctx, login, "second"), "enrollment can be restarted")
+		totp, err = s.GetTOTP(ctx, login)
+		require.NoError(t, err)
+		assert.Equal(t, &models.TOTP{Secret: "second"}, totp)
+		used, err := s.UseTOTPStep(ctx, login, 100)
+		require.NoError(t, err)
+		assert.False(t, used, "unconfirmed TOTP does not accept codes")
+
+		require.NoError(t, s.EnableTOTP(ctx, login, 100, []string{"hash-1", "hash-2"}))
+		assert.ErrorIs(t, s.SaveTOTPSecret(ctx, login, "third"), storage.ErrTOTPEnabled)
+		totp, err = s.GetTOTP(ctx, login)
+		require.NoError(t, err)
+		assert.Equal(t, &models.TOTP{Secret: "second", Enabled: true, LastUsedStep: 100}, totp)
+
+		used, err = s.UseTOTPStep(ctx, login, 100)
+		require.NoError(t, err)
+		assert.False(t, used, "the enrollment code cannot be reused")
+		used, err = s.UseTOTPStep(ctx, login, 101)
+		require.NoError(t, err)
+		assert.True(t, used)
+
+		codes, err := s.GetRecoveryCodes(ctx, login)
+		require.NoError(t, err)
+		require.Len(t, codes, 2)
+		assert.Equal(t, "hash-1", codes[0].Hash)
+		used, err = s.UseRecoveryCode(ctx, login, codes[0].ID)
+		require.NoError(t, err)
+		assert.True(t, used)
+		used, err = s.UseRecoveryCode(ctx, login, codes[0].ID)
+		require.NoError(t, err)
+		assert.False(t, used, "recovery codes are single-use")
+		codes, err = s.GetRecoveryCodes(ctx, login)
+		require.NoError(t, err)
+		require.Len(t, codes, 1)
+		assert.Equal(t, "hash-2", codes[0].Hash)
+
+		require.NoError(t, s.DisableTOTP(ctx, login))
+		totp, err = s.GetTOTP(ctx, login)
+		require.NoError(t, err)
+		assert.Nil(t, totp)
+		codes, err = s.GetRecoveryCodes(ctx, login)
+		require.NoError(t, err)
+		assert.Empty(t, codes)
+	})
+
+	t.Run("MFAChallenges", func(t *testing.T) {
+		login := newUser(t)
+		now := time.Now()
+
+		active := models.MFAChallenge{ID: uuid.New().String(), Login: login, ExpiresAt: now.Add(time.Minute)}
+		expired := models.MFAChallenge{ID: uuid.New().String(), Login: login, ExpiresAt: now.Add(-time.Minute)}
+		require.NoError(t, s.CreateMFAChallenge(ctx, active))
+		require.NoError(t, s.CreateMFAChallenge(ctx, expired))
+
+		got, err := s.GetMFAChallenge(ctx, active.ID)
+		require.NoError(t, err)
+		assert.Equal(t, login, got)
+		_, err = s.GetMFAChallenge(ctx, expired.ID)
+		assert.ErrorIs(t, err, storage.ErrChallengeNotFound)
+
+		require.NoError(t, s.DeleteMFAChallenge(ctx, active.ID))
+		_, err = s.GetMFAChallenge(ctx, active.ID)
+		assert.ErrorIs(t, err, storage.ErrChallengeNotFound)
+	})
+
+	t.Run("APIKeys", func(t *testing.T) {
+		login, other := newUser(t), newUser(t)
+		now := time.Now()
+		newKey := func(login string, createdAt time.Time, expiresAt *time.Time) models.APIKey {
+			key := models.APIKey{ID: uuid.New().String()[:16], Login: login, Name: "test", Merchant: true,
+				Scopes: []string{models.ScopeOrdersRead, models.ScopeOrdersWrite}, Hash: "hashed", CreatedAt: createdAt, ExpiresAt: expiresAt}
+			require.NoError(t, s.CreateAPIKey(ctx, key))
+			return key
+		}
+		past := now.Add(-time.Minute)
+		older := newKey(login, now.Add(-time.Hour), nil)
+		newer := newKey(login, now, nil)
+		expired := newKey(login, now.Add(-2*time.Hour), &past)
+
+		got, err := s.GetAPIKey(ctx, newer.ID)
+		require.NoError(t, err)
+		assert.Equal(t, login, got.Login)
+		assert.True(t, got.Merchant)
+		assert.Equal(t, newer.Scopes, got.Scopes)
+		assert.Equal(t, "hashed", got.Hash)
+		assert.Nil(t, got.LastUsedAt)
+		_, err = s.GetAPIKey(ctx, expired.ID)
+		assert.ErrorIs(t, err, storage.ErrAPIKeyNotFound)
+
+		require.NoError(t, s.TouchAPIKey(ctx, newer.ID))
+		got, err = s.GetAPIKey(ctx, newer.ID)
+		require.NoError(t, err)
+		assert.NotNil(t, got.LastUsedAt)
+
+		keys, err := s.GetAPIKeys(ctx, login)
+		require.NoError(t, err)
+		require.Len(t, keys, 3, "expired keys are listed too")
+		assert.Equal(t, []string{newer.ID, older.ID, expired.ID}, []string{keys[0].ID, keys[1].ID, keys[2].ID})
+
+		assert.ErrorIs(t, s.DeleteAPIKey(ctx, other, newer.ID), storage.ErrAPIKeyNotFound, "only the owner revokes a key")
+		require.NoError(t, s.DeleteAPIKey(ctx, login, newer.ID))
+		_, err = s.GetAPIKey(ctx, newer.ID)
+		assert.ErrorIs(t, err, storage.ErrAPIKeyNotFound)
+		assert.ErrorIs(t, s.DeleteAPIKey(ctx, login, newer.ID), storage.ErrAPIKeyNotFound)
+	})
+
+	t.Run("Accounts", func(t *testing.T) {
+		login, other := newUser(t), newUser(t)
+
+		account, err := s.GetUser(ctx, login)
+		require.NoError(t, err)
+		assert.Equal(t, models.Account{Login: login, Role: models.RoleUser}, account)
+		_, err = s.GetUser(ctx, "unknown-"+login)
+		assert.ErrorIs(t, err, storage.ErrUserNotFound)
+
+		accounts, err := s.SearchUsers(ctx, strings.ToUpper(login[5:]), 10)
+		require.NoError(t, err)
+		require.Len(t, accounts, 1, "search is case-insensitive")
+		assert.Equal(t, login, accounts[0].Login)
+		accounts, err = s.SearchUsers(ctx, "user-%", 10)
+		require.NoError(t, err)
+		assert.Empty(t, accounts, "LIKE wildcards are matched literally")
+
+		require.NoError(t, s.SetUserRole(ctx, "admin", login, models.RoleSupport))
+		account, err = s.GetUser(ctx, login)
+		require.NoError(t, err)
+		assert.Equal(t, models.RoleSupport, account.Role)
+		assert.ErrorIs(t, s.SetUserRole(ctx, "admin", "unknown-"+login, models.RoleAdmin), storage.ErrUserNotFound)
+
+		// Блокировка завершает сессии и отключает API-ключи
+		now := time.Now()
+		session := models.Session{ID: uuid.New().String(), Login: other, CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+		require.NoError(t, s.CreateSession(ctx, session))
+		key := models.APIKey{ID: uuid.New().String()[:16], Login: other, Name: "test", Scopes: []string{models.ScopeOrdersRead},
+			Hash: "hashed", CreatedAt: now}
+		require.NoError(t, s.CreateAPIKey(ctx, key))
+
+		require.NoError(t, s.SetUserLocked(ctx, "admin", other, true))
+		account, err = s.GetUser(ctx, other)
+		require.NoError(t, err)
+		require.True(t, account.Locked())
+		lockedAt := *account.LockedAt
+		_, err = s.TouchSession(ctx, session.ID)
+		assert.ErrorIs(t, err, storage.ErrSessionNotFound)
+		_, err = s.GetAPIKey(ctx, key.ID)
+		assert.ErrorIs(t, err, storage.ErrAPIKeyNotFound)
+
+		require.NoError(t, s.SetUserLocked(ctx, "admin", other, true))
+		account, err = s.GetUser(ctx, other)
+		require.NoError(t, err)
+		assert.True(t, lockedAt.Equal(*account.LockedAt), "repeated lock keeps the original time")
+
+		require.NoError(t, s.SetUserLocked(ctx, "admin", other, false))
+		account, err = s.GetUser(ctx, other)
+		require.NoError(t, err)
+		assert.False(t, account.Locked())
+		_, err = s.GetAPIKey(ctx, key.ID)
+		assert.NoError(t, err, "API keys work again after unlock")
+		assert.ErrorIs(t, s.SetUserLocked(ctx, "admin", "unknown-"+login, true), storage.ErrUserNotFound)
+
+		// Изменения пишутся в журнал аудита вместе с ними
+		actions := func(target string) []string {
+			events, err := s.GetAuditEvents(ctx, models.AuditFilter{Target: target, Limit: 10})
+			require.NoError(t, err)
+			var actions []string
+			for _, event := range events {
+				assert.Equal(t, "admin", event.Actor)
+				actions = append(actions, event.Action)
+			}
+			return actions
+		}
+		assert.Equal(t, []string{audit.ActionRoleChanged}, actions(login))
+		assert.Equal(t, []string{audit.ActionUserUnlocked, audit.ActionUserLocked, audit.ActionUserLocked}, actions(other))
+	})
+
+	t.Run("BalanceAdjustments", func(t *testing.T) {
+		login := newUser(t)
+		adjust := func(amount models.Money) (models.UserBalance, error) {
+			return s.AdjustBalance(ctx, models.BalanceAdjustment{
+				Login: login, Amount: amount, Reason: "support ticket", Actor: "admin", CreatedAt: time.Now(),
+			})
+		}
+
+		balance, err := adjust(models.NewMoney(100))
+		require.NoError(t, err)
+		assert.Equal(t, models.UserBalance{Current: models.NewMoney(100)}, balance)
+		balance, err = adjust(models.NewMoney(-40.5))
+		require.NoError(t, err)
+		assert.Equal(t, models.UserBalance{Current: models.NewMoney(59.5)}, balance)
+		_, err = adjust(models.NewMoney(-59.51))
+		assert.ErrorIs(t, err, storage.ErrInsufficientFunds)
+
+		balance, err = s.GetUserBalance(ctx, login)
+		require.NoError(t, err)
+		assert.Equal(t, models.UserBalance{Current: models.NewMoney(59.5)}, balance, "adjustments are not withdrawals")
+
+		_, err = s.AdjustBalance(ctx, models.BalanceAdjustment{
+			Login: "unknown-" + login, Amount: models.NewMoney(10), Reason: "support ticket", Actor: "admin", CreatedAt: time.Now(),
+		})
+		assert.ErrorIs(t, err, storage.ErrUserNotFound)
+	})
+
+	t.Run("Orders", func(t *testing.T) {
+		login, other := newUser(t), newUser(t)
+		number := newOrder()
+
+		added, err := s.AddOrder(ctx, login, number)
+		require.NoError(t, err)
+		assert.True(t, added)
+
+		added, err = s.AddOrder(ctx, login, number)
+		require.NoError(t, err)
+		assert.False(t, added, "same user uploads the same order again")
+
+		_, err = s.AddOrder(ctx, other, number)
+		assert.ErrorIs(t, err, storage.ErrAddOrderConflict)
+		_, err = s.AddOrder(ctx, "unknown-"+login, newOrder())
+		assert.ErrorIs(t, err, storage.ErrUserNotFound)
+
+		second := newOrder()
+		_, err = s.AddOrder(ctx, login, second)
+		require.NoError(t, err)
+
+		orders, err := s.GetOrders(ctx, login, models.ListQuery{})
+		require.NoError(t, err)
+		require.Len(t, orders, 2)
+		assert.Equal(t, strconv.Itoa(second), orders[0].Number, "newest first")
+		assert.Equal(t, "NEW", orders[0].Status)
+		assert.False(t, orders[1].UploadedAt.IsZero())
+
+		orders, err = s.GetOrders(ctx, other, models.ListQuery{})
+		require.NoError(t, err)
+		assert.Empty(t, orders)
+
+		assert.ErrorIs(t, updateOrder(newOrder(), "PROCESSED", 0), storage.ErrOrderNotFound)
+
+		order, err := s.GetOrder(ctx, number)
+		require.NoError(t, err)
+		assert.Equal(t, login, order.Login)
+		assert.Equal(t, strconv.Itoa(number), order.Number)
+		_, err = s.GetOrder(ctx, newOrder())
+		assert.ErrorIs(t, err, storage.ErrOrderNotFound)
+	})
+
+	t.Run("OrdersPage", func(t *testing.T) {
+		login := newUser(t)
+		var numbers []string
+		var number int
+		for i := 0; i < 3; i++ {
+			number = newOrder()
+			_, err := s.AddOrder(ctx, login, number)
+			require.NoError(t, err)
+			numbers = append(numbers, strconv.Itoa(number))
+		}
+		require.NoError(t, updateOrder(number, orderstate.Processed, models.NewMoney(1)))
+
+		page := func(list models.ListQuery) []string {
+			orders, err := s.GetOrders(ctx, login, list)
+			require.NoError(t, err)
+			var got []string
+			for _, o := range orders {
+				got = append(got, o.Number)
+			}
+			return got
+		}
+
+		first, err := s.GetOrders(ctx, login, models.ListQuery{Limit: 2})
+		require.NoError(t, err)
+		require.Len(t, first, 2)
+		assert.Equal(t, []string{numbers[2], numbers[1]}, []string{first[0].Number, first[1].Number})
+		lastNumber, _ := strconv.ParseInt(first[1].Number, 10, 64)
+		after := &models.ListCursor{At: first[1].UploadedAt, Number: lastNumber}
+		assert.Equal(t, []string{numbers[0]}, page(models.ListQuery{Limit: 2, After: after}))
+		assert.Equal(t, []string{numbers[0]}, page(models.ListQuery{Asc: true, Limit: 1}))
+		assert.Equal(t, []string{numbers[2]}, page(models.ListQuery{Statuses: []string{orderstate.Processed}}))
+		assert.Equal(t, []string{numbers[1], numbers[0]}, page(models.ListQuery{Statuses: []string{orderstate.New, orderstate.Invalid}}))
+		assert.Empty(t, page(models.ListQuery{From: time.Now().Add(time.Hour)}))
+		assert.Empty(t, page(models.ListQuery{To: first[1].UploadedAt.Add(-time.Hour)}))
+	})
+
+	t.Run("AccrualIsCreditedOnce", func(t *testing.T) {
+		login := newUser(t)
+		number := newOrder()
+		_, _ = s.AddOrder(ctx, login, number)
+
+		require.NoError(t, updateOrder(number, "PROCESSING", 0))
+		require.NoError(t, updateOrder(number, "PROCESSED", models.NewMoney(500.5)))
+		require.NoError(t, updateOrder(number, "PROCESSED", models.NewMoney(500.5)))
+
+		balance, err := s.GetUserBalance(ctx, login)
+		require.NoError(t, err)
+		assert.Equal(t, models.UserBalance{Current: models.NewMoney(500.5)}, balance)
+
+		orders, err := s.GetOrders(ctx, login, models.ListQuery{})
+		require.NoError(t, err)
+		require.Len(t, orders, 1)
+		assert.Equal(t, models.NewMoney(500.5), orders[0].Accrual)
+	})
+
+	t.Run("OrderStatusHistory", func(t *testing.T) {
+		login := newUser(t)
+		number := newOrder()
+		_, _ = s.AddOrder(ctx, login, number)
+
+		changed, err := s.UpdateOrder(ctx, number, orderstate.Processing, 0)
+		require.NoError(t, err)
+		assert.True(t, changed)
+		changed, err = s.UpdateOrder(ctx, number, orderstate.Processing, 0)
+		require.NoError(t, err)
+		assert.False(t, changed, "same status is not a transition")
+		require.NoError(t, updateOrder(number, orderstate.Invalid, 0))
+		assert.ErrorIs(t, updateOrder(number, orderstate.Processed, models.NewMoney(10)), orderstate.ErrTransitionNotAllowed)
+		assert.ErrorIs(t, updateOrder(number, "REGISTERED", 0), orderstate.ErrUnknownStatus)
+
+		history, err := s.GetOrderStatusHistory(ctx, number)
+		require.NoError(t, err)
+		require.Len(t, history, 3)
+		assert.Equal(t, models.OrderStatusChange{To: orderstate.New, ChangedAt: history[0].ChangedAt}, history[0])
+		assert.Equal(t, orderstate.New, history[1].From)
+		assert.Equal(t, orderstate.Processing, history[1].To)
+		assert.Equal(t, orderstate.Invalid, history[2].To)
+		assert.False(t, history[2].ChangedAt.Before(history[0].ChangedAt))
+
+		balance, err := s.GetUserBalance(ctx, login)
+		require.NoError(t, err)
+		assert.Equal(t, models.UserBalance{}, balance, "no accrual after INVALID")
+	})
+
+	t.Run("Withdrawals", func(t *testing.T) {
+		login := newUser(t)
+		number := newOrder()
+		_, _ = s.AddOrder(ctx, login, number)
+		require.NoError(t, updateOrder(number, "PROCESSED", models.NewMoney(100)))
+
+		first, second := newOrder(), newOrder()
+		require.NoError(t, s.WithdrawFromUserBalance(ctx, login, first, models.NewMoney(30)))
+		assert.ErrorIs(t, s.WithdrawFromUserBalance(ctx, login, first, models.NewMoney(10)), storage.ErrWithdrawConflict)
+		assert.ErrorIs(t, s.WithdrawFromUserBalance(ctx, login, second, models.NewMoney(70.01)), storage.ErrInsufficientFunds)
+		assert.ErrorIs(t, s.WithdrawFromUserBalance(ctx, login, second, 0), storage.ErrInvalidAmount)
+		assert.ErrorIs(t, s.WithdrawFromUserBalance(ctx, login, second, models.NewMoney(-10)), storage.ErrInvalidAmount)
+		require.NoError(t, s.WithdrawFromUserBalance(ctx, login, second, models.NewMoney(70)))
+
+		balance, err := s.GetUserBalance(ctx, login)
+		require.NoError(t, err)
+		assert.Equal(t, models.UserBalance{Current: 0, Withdrawn: models.NewMoney(100)}, balance)
+
+		withdrawals, err := s.GetUserWithdrawals(ctx, login, models.ListQuery{})
+		require.NoError(t, err)
+		require.Len(t, withdrawals, 2)
+		assert.Equal(t, strconv.Itoa(second), withdrawals[0].Order, "newest first")
+		assert.Equal(t, models.NewMoney(70), withdrawals[0].Sum)
+
+		withdrawals, err = s.GetUserWithdrawals(ctx, newUser(t), models.ListQuery{})
+		require.NoError(t, err)
+		assert.Empty(t, withdrawals)
+
+		// Номер заказа уникален для списаний одного пользователя, а не глобально
+		other := newUser(t)
+		otherNumber := newOrder()
+		_, _ = s.AddOrder(ctx, other, otherNumber)
+		require.NoError(t, updateOrder(otherNumber, "PROCESSED", models.NewMoney(10)))
+		require.NoError(t, s.WithdrawFromUserBalance(ctx, other, first, models.NewMoney(10)))
+	})
+
+	t.Run("Audit", func(t *testing.T) {
+		login := newUser(t)
+		ctx := audit.WithMeta(ctx, audit.Meta{RequestID: "req-" + login, IP: "192.0.2.1"})
+		require.NoError(t, s.AddAuditEvent(ctx, audit.New(ctx, login, audit.ActionUserRegistered, login, nil)))
+
+		// Деньги и событие о них - одна транзакция
+		number := newOrder()
+		_, _ = s.AddOrder(ctx, login, number)
+		_, err := s.UpdateOrder(ctx, number, "PROCESSED", models.NewMoney(100))
+		require.NoError(t, err)
+		require.NoError(t, s.WithdrawFromUserBalance(ctx, login, newOrder(), models.NewMoney(30)))
+		assert.ErrorIs(t, s.WithdrawFromUserBalance(ctx, login, newOrder(), models.NewMoney(1000)), storage.ErrInsufficientFunds)
+		_, err = s.AdjustBalance(ctx, models.BalanceAdjustment{Login: login, Amount: models.NewMoney(5), Reason: "refund", Actor: "admin"})
+		require.NoError(t, err)
+
+		events, err := s.GetAuditEvents(ctx, models.AuditFilter{Target: login, Limit: 10})
+		require.NoError(t, err)
+		var actions []string
+		for _, event := range events {
+			actions = append(actions, event.Action)
+		}
+		assert.Equal(t, []string{audit.ActionAdjustment, audit.ActionWithdrawal, audit.ActionAccrualCredited, audit.ActionUserRegistered},
+			actions, "newest first, failed withdrawal is not recorded")
+		assert.Equal(t, "req-"+login, events[1].RequestID)
+		assert.JSONEq(t, `{"order": "`+strconv.Itoa(number)+`", "amount": 100}`, string(events[2].Payload))
+		assert.Empty(t, events[3].Payload)
+
+		page, err := s.GetAuditEvents(ctx, models.AuditFilter{Target: login, BeforeID: events[1].ID, Limit: 1})
+		require.NoError(t, err)
+		require.Len(t, page, 1)
+		assert.Equal(t, events[2].ID, page[0].ID)
+		page, err = s.GetAuditEvents(ctx, models.AuditFilter{Actor: "admin", Action: audit.ActionAdjustment, Target: login, Limit: 10})
+		require.NoError(t, err)
+		assert.Len(t, page, 1)
+		page, err = s.GetAuditEvents(ctx, models.AuditFilter{Target: login, From: time.Now().Add(time.Hour), Limit: 10})
+		require.NoError(t, err)
+		assert.Empty(t, page)
+
+		// Каждая запись продолжает предыдущую
+		chain, err := s.GetAuditChain(ctx, events[3].ID-1, 1000)
+		require.NoError(t, err)
+		require.NotEmpty(t, chain)
+		assert.Equal(t, events[3].ID, chain[0].ID)
+		for i, event := range chain {
+			assert.Equal(t, audit.Hash(event.PrevHash, event), event.Hash, "event %d", event.ID)
+			if i > 0 {
+				assert.Equal(t, chain[i-1].Hash, event.PrevHash, "event %d", event.ID)
+			}
+		}
+	})
+
+	t.Run("AccrualTasks", func(t *testing.T) {
+		pending, err := s.CountPendingAccrualTasks(ctx)
+		require.NoError(t, err)
+
+		login := newUser(t)
+		number := newOrder()
+		_, _ = s.AddOrder(ctx, login, number)
+		count, err := s.CountPendingAccrualTasks(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, pending+1, count, "new task is pending")
+
+		// В общей БД могут быть чужие задачи, поэтому ищем свою среди захваченных
+		claim := func() (models.AccrualTask, bool) {
+			tasks, err := s.ClaimAccrualTasks(ctx, 1000, time.Minute)
+			require.NoError(t, err)
+			for _, task := range tasks {
+				if task.OrderNumber == number {
+					return task, true
+				}
+			}
+			return models.AccrualTask{}, false
+		}
+
+		task, ok := claim()
+		require.True(t, ok, "task is created with the order")
+		assert.Equal(t, models.AccrualTask{UserLogin: login, OrderNumber: number}, task)
+
+		_, ok = claim()
+		assert.False(t, ok, "claimed task is leased")
+
+		require.NoError(t, s.RescheduleAccrualTask(ctx, number, 0))
+		task, ok = claim()
+		require.True(t, ok)
+		assert.Equal(t, 0, task.Attempts, "reschedule is not a failed attempt")
+
+		dead, err := s.FailAccrualTask(ctx, number, "accrual is down", 0, 2)
+		require.NoError(t, err)
+		assert.False(t, dead)
+		task, ok = claim()
+		require.True(t, ok)
+		assert.Equal(t, 1, task.Attempts)
+
+		dead, err = s.FailAccrualTask(ctx, number, "accrual is down", 0, 2)
+		require.NoError(t, err)
+		assert.True(t, dead)
+		_, ok = claim()
+		assert.False(t, ok, "dead task is not claimed")
+		count, err = s.CountPendingAccrualTasks(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, pending, count, "dead task is not pending")
+
+		info, err := s.GetAccrualTask(ctx, number)
+		require.NoError(t, err)
+		assert.Equal(t, &models.AccrualTaskInfo{State: models.AccrualTaskDead, Attempts: 2, LastError: "accrual is down"}, info)
+
+		require.NoError(t, s.CompleteAccrualTask(ctx, number))
+		dead, err = s.FailAccrualTask(ctx, number, "accrual is down", 0, 2)
+		require.NoError(t, err)
+		assert.False(t, dead, "completed task is not retried")
+		info, err = s.GetAccrualTask(ctx, number)
+		require.NoError(t, err)
+		assert.Equal(t, models.AccrualTaskDone, info.State)
+		assert.Equal(t, 2, info.Attempts, "attempts are kept for history")
+
+		// Повторная проверка по запросу сотрудника
+		require.NoError(t, s.RequeueAccrualTask(ctx, "admin", number))
+		task, ok = claim()
+		require.True(t, ok, "requeued task is claimed again")
+		assert.Equal(t, 0, task.Attempts)
+
+		// Accrual не знает о заказе: попытки не расходуются, но после maxPolls задача в dead
+		dead, err = s.PostponeUnregisteredAccrualTask(ctx, number, "not registered", 0, 2)
+		require.NoError(t, err)
+		assert.False(t, dead)
+		task, ok = claim()
+		require.True(t, ok)
+		assert.Equal(t, models.AccrualTask{UserLogin: login, OrderNumber: number, Unregistered: 1}, task)
+		dead, err = s.PostponeUnregisteredAccrualTask(ctx, number, "not registered", 0, 2)
+		require.NoError(t, err)
+		assert.True(t, dead)
+		info, err = s.GetAccrualTask(ctx, number)
+		require.NoError(t, err)
+		assert.Equal(t, &models.AccrualTaskInfo{State: models.AccrualTaskDead, LastError: "not registered"}, info)
+
+		require.NoError(t, s.RequeueAccrualTask(ctx, "admin", number))
+		task, ok = claim()
+		require.True(t, ok)
+		assert.Equal(t, 0, task.Unregistered, "requeue resets unregistered polls")
+		assert.ErrorIs(t, s.RequeueAccrualTask(ctx, "admin", newOrder()), storage.ErrOrderNotFound)
+
+		info, err = s.GetAccrualTask(ctx, newOrder())
+		require.NoError(t, err)
+		assert.Nil(t, info)
+
+		second := newOrder()
+		_, _ = s.AddOrder(ctx, login, second)
+		require.NoError(t, s.RescheduleAccrualTask(ctx, second, time.Hour))
+		number = second
+		_, ok = claim()
+		assert.False(t, ok, "task is not claimed before next attempt")
+		info, err = s.GetAccrualTask(ctx, second)
+		require.NoError(t, err)
+		require.NotNil(t, info.NextAttemptAt)
+		assert.WithinDuration(t, time.Now().Add(time.Hour), *info.NextAttemptAt, time.Minute)
+	})
+}
+
+func Test_MemoryStorageConformance(t *testing.T) {
+	runStorageConformance(t, storage.NewMemoryStorage())
+}
+
+// Для проверки StorageDB нужна настоящая БД: TEST_DATABASE_URI=postgresql://...
+func Test_DBStorageConformance(t *testing.T) {
+	uri, ok := os.LookupEnv("TEST_DATABASE_URI")
+	if !ok {
+		t.Skip("TEST_DATABASE_URI is not set")
+	}
+
+	c := config.NewConfig()
+	c.DBConnection = uri
+	s, err := storage.NewStorage(c)
+	require.NoError(t, err)
+	defer s.DBConn.Close()
+
+	runStorageConformance(t, s)
+}
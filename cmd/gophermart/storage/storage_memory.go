@@ -0,0 +1,942 @@
+package storage
+
+import (
+	"context"
+	"gophermart/cmd/gophermart/audit"
+	"gophermart/cmd/gophermart/models"
+	"gophermart/cmd/gophermart/orderstate"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type memoryOrder struct {
+	models.Order
+	credited bool
+}
+
+type memoryTask struct {
+	models.AccrualTask
+	state         string
+	nextAttemptAt time.Time
+	lockedUntil   time.Time
+	lastError     string
+}
+
+// memoryLedgerEntry - запись balance_ledger: credit по заказу, debit по списанию или adjustment администратора
+type memoryLedgerEntry struct {
+	login       string
+	orderNumber int
+	kind        string
+	amount      models.Money
+	reason      string
+	actor       string
+	createdAt   time.Time
+}
+
+type memoryRecoveryCode struct {
+	models.RecoveryCode
+	used bool
+}
+
+// MemoryStorage - реализация StorageService в памяти процесса (для локального запуска и тестов)
+type MemoryStorage struct {
+	mu          sync.RWMutex
+	passwords   map[string]string // login -> hashedPassword
+	sessions    map[string]models.Session
+	orders      map[int]*memoryOrder
+	balances    map[string]*models.UserBalance
+	withdrawals map[string][]models.Withdrawal
+	ledger      []memoryLedgerEntry
+	tasks       map[int]*memoryTask
+	history     map[int][]models.OrderStatusChange
+	failures    map[string][]time.Time // неудачные входы по ключу
+	lockouts    map[string]time.Time
+	totp        map[string]*models.TOTP
+	recovery    map[string][]memoryRecoveryCode
+	challenges  map[string]models.MFAChallenge
+	lastCodeID  int64
+	apiKeys     map[string]models.APIKey
+	roles       map[string]string    // login -> роль, если не models.RoleUser
+	versions    map[string]int64     // login -> версия токенов, если не 0
+	locked      map[string]time.Time // login -> время блокировки
+	audit       []models.AuditEvent  // в порядке записи, ID = индекс + 1
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{
+		passwords:   make(map[string]string),
+		sessions:    make(map[string]models.Session),
+		orders:      make(map[int]*memoryOrder),
+		balances:    make(map[string]*models.UserBalance),
+		withdrawals: make(map[string][]models.Withdrawal),
+		tasks:       make(map[int]*memoryTask),
+		history:     make(map[int][]models.OrderStatusChange),
+		failures:    make(map[string][]time.Time),
+		lockouts:    make(map[string]time.Time),
+		totp:        make(map[string]*models.TOTP),
+		recovery:    make(map[string][]memoryRecoveryCode),
+		challenges:  make(map[string]models.MFAChallenge),
+		apiKeys:     make(map[string]models.APIKey),
+		roles:       make(map[string]string),
+		versions:    make(map[string]int64),
+		locked:      make(map[string]time.Time),
+	}
+}
+
+func (m *MemoryStorage) SaveLoginPassword(_ context.Context, login, hashedPassword string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.passwords[login]; ok {
+		return false
+	}
+	m.passwords[login] = hashedPassword
+	return true
+}
+
+func (m *MemoryStorage) GetHashedPasswordByLogin(_ context.Context, login string) string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return m.passwords[login]
+}
+
+func (m *MemoryStorage) ChangePassword(_ context.Context, login, hashedPassword, keepSessionID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.passwords[login]; !ok {
+		return nil
+	}
+	m.passwords[login] = hashedPassword
+	m.versions[login]++
+	for id, session := range m.sessions {
+		if session.Login == login && id != keepSessionID {
+			delete(m.sessions, id)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStorage) CreateSession(_ context.Context, session models.Session) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for id, s := range m.sessions {
+		if s.Login == session.Login && !s.ExpiresAt.After(now) {
+			delete(m.sessions, id)
+		}
+	}
+	session.LastSeenAt = session.CreatedAt
+	m.sessions[session.ID] = session
+	return nil
+}
+
+func (m *MemoryStorage) TouchSession(_ context.Context, sessionID string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session, ok := m.sessions[sessionID]
+	if !ok || !session.ExpiresAt.After(time.Now()) {
+		return "", ErrSessionNotFound
+	}
+	session.LastSeenAt = time.Now()
+	m.sessions[sessionID] = session
+	return session.Login, nil
+}
+
+func (m *MemoryStorage) GetSessions(_ context.Context, login string) ([]models.Session, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	now := time.Now()
+	var sessions []models.Session
+	for _, session := range m.sessions {
+		if session.Login == login && session.ExpiresAt.After(now) {
+			sessions = append(sessions, session)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt)
+	})
+	return sessions, nil
+}
+
+func (m *MemoryStorage) DeleteSession(_ context.Context, login, sessionID string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session, ok := m.sessions[sessionID]
+	if !ok || session.Login != login {
+		return ErrSessionNotFound
+	}
+	delete(m.sessions, sessionID)
+	return nil
+}
+
+func (m *MemoryStorage) AddLoginFailure(_ context.Context, key string, window time.Duration) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	var recent []time.Time
+	for _, failedAt := range m.failures[key] {
+		if failedAt.After(now.Add(-window)) {
+			recent = append(recent, failedAt)
+		}
+	}
+	m.failures[key] = append(recent, now)
+	return len(m.failures[key]), nil
+}
+
+func (m *MemoryStorage) ResetLoginFailures(_ context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.failures, key)
+	return nil
+}
+
+func (m *MemoryStorage) LockLogin(_ context.Context, key string, duration time.Duration) (time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	lockedUntil := time.Now().Add(duration)
+	m.lockouts[key] = lockedUntil
+	delete(m.failures, key)
+	return lockedUntil, nil
+}
+
+func (m *MemoryStorage) GetLoginLockout(_ context.Context, key string) (time.Time, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	lockedUntil, ok := m.lockouts[key]
+	if !ok || !lockedUntil.After(time.Now()) {
+		return time.Time{}, nil
+	}
+	return lockedUntil, nil
+}
+
+func (m *MemoryStorage) GetTOTP(_ context.Context, login string) (*models.TOTP, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	t, ok := m.totp[login]
+	if !ok {
+		return nil, nil
+	}
+	copied := *t
+	return &copied, nil
+}
+
+func (m *MemoryStorage) SaveTOTPSecret(_ context.Context, login, secret string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if t, ok := m.totp[login]; ok && t.Enabled {
+		return ErrTOTPEnabled
+	}
+	m.totp[login] = &models.TOTP{Secret: secret}
+	return nil
+}
+
+func (m *MemoryStorage) EnableTOTP(_ context.Context, login string, step int64, recoveryCodeHashes []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.totp[login]
+	if !ok || t.Enabled {
+		return ErrTOTPNotFound
+	}
+	t.Enabled = true
+	t.LastUsedStep = step
+
+	codes := make([]memoryRecoveryCode, 0, len(recoveryCodeHashes))
+	for _, hash := range recoveryCodeHashes {
+		m.lastCodeID++
+		codes = append(codes, memoryRecoveryCode{RecoveryCode: models.RecoveryCode{ID: m.lastCodeID, Hash: hash}})
+	}
+	m.recovery[login] = codes
+	return nil
+}
+
+func (m *MemoryStorage) DisableTOTP(_ context.Context, login string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.totp, login)
+	delete(m.recovery, login)
+	return nil
+}
+
+func (m *MemoryStorage) UseTOTPStep(_ context.Context, login string, step int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.totp[login]
+	if !ok || !t.Enabled || t.LastUsedStep >= step {
+		return false, nil
+	}
+	t.LastUsedStep = step
+	return true, nil
+}
+
+func (m *MemoryStorage) GetRecoveryCodes(_ context.Context, login string) ([]models.RecoveryCode, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var codes []models.RecoveryCode
+	for _, code := range m.recovery[login] {
+		if !code.used {
+			codes = append(codes, code.RecoveryCode)
+		}
+	}
+	return codes, nil
+}
+
+func (m *MemoryStorage) UseRecoveryCode(_ context.Context, login string, id int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	codes := m.recovery[login]
+	for i := range codes {
+		if codes[i].ID == id && !codes[i].used {
+			codes[i].used = true
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *MemoryStorage) CreateMFAChallenge(_ context.Context, challenge models.MFAChallenge) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for id, c := range m.challenges {
+		if c.Login == challenge.Login && !c.ExpiresAt.After(now) {
+			delete(m.challenges, id)
+		}
+	}
+	m.challenges[challenge.ID] = challenge
+	return nil
+}
+
+func (m *MemoryStorage) GetMFAChallenge(_ context.Context, id string) (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	c, ok := m.challenges[id]
+	if !ok || !c.ExpiresAt.After(time.Now()) {
+		return "", ErrChallengeNotFound
+	}
+	return c.Login, nil
+}
+
+func (m *MemoryStorage) DeleteMFAChallenge(_ context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.challenges, id)
+	return nil
+}
+
+func (m *MemoryStorage) CreateAPIKey(_ context.Context, key models.APIKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key.Scopes = append([]string(nil), key.Scopes...)
+	m.apiKeys[key.ID] = key
+	return nil
+}
+
+func (m *MemoryStorage) GetAPIKey(_ context.Context, id string) (*models.APIKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	key, ok := m.apiKeys[id]
+	if !ok || (key.ExpiresAt != nil && !key.ExpiresAt.After(time.Now())) {
+		return nil, ErrAPIKeyNotFound
+	}
+	if _, locked := m.locked[key.Login]; locked {
+		return nil, ErrAPIKeyNotFound
+	}
+	return &key, nil
+}
+
+func (m *MemoryStorage) GetAPIKeys(_ context.Context, login string) ([]models.APIKey, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var keys []models.APIKey
+	for _, key := range m.apiKeys {
+		if key.Login == login {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.After(keys[j].CreatedAt) })
+	return keys, nil
+}
+
+func (m *MemoryStorage) TouchAPIKey(_ context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if key, ok := m.apiKeys[id]; ok {
+		now := time.Now()
+		key.LastUsedAt = &now
+		m.apiKeys[id] = key
+	}
+	return nil
+}
+
+func (m *MemoryStorage) DeleteAPIKey(_ context.Context, login, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key, ok := m.apiKeys[id]
+	if !ok || key.Login != login {
+		return ErrAPIKeyNotFound
+	}
+	delete(m.apiKeys, id)
+	return nil
+}
+
+func (m *MemoryStorage) GetUser(_ context.Context, login string) (models.Account, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if _, ok := m.passwords[login]; !ok {
+		return models.Account{}, ErrUserNotFound
+	}
+	return m.account(login), nil
+}
+
+func (m *MemoryStorage) SearchUsers(_ context.Context, query string, limit int) ([]models.Account, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	query = strings.ToLower(query)
+	var logins []string
+	for login := range m.passwords {
+		if strings.Contains(strings.ToLower(login), query) {
+			logins = append(logins, login)
+		}
+	}
+	sort.Strings(logins)
+	if len(logins) > limit {
+		logins = logins[:limit]
+	}
+
+	var accounts []models.Account
+	for _, login := range logins {
+		accounts = append(accounts, m.account(login))
+	}
+	return accounts, nil
+}
+
+func (m *MemoryStorage) SetUserRole(ctx context.Context, actor, login, role string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.passwords[login]; !ok {
+		return ErrUserNotFound
+	}
+	from := m.account(login).Role
+	if role == models.RoleUser {
+		delete(m.roles, login)
+	} else {
+		m.roles[login] = role
+	}
+	m.appendAudit(audit.New(ctx, actor, audit.ActionRoleChanged, login, map[string]any{"from": from, "to": role}))
+	return nil
+}
+
+func (m *MemoryStorage) SetUserLocked(ctx context.Context, actor, login string, locked bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.passwords[login]; !ok {
+		return ErrUserNotFound
+	}
+	if !locked {
+		delete(m.locked, login)
+		m.appendAudit(audit.New(ctx, actor, audit.ActionUserUnlocked, login, nil))
+		return nil
+	}
+
+	if _, ok := m.locked[login]; !ok {
+		m.locked[login] = time.Now()
+	}
+	for id, session := range m.sessions {
+		if session.Login == login {
+			delete(m.sessions, id)
+		}
+	}
+	for id, challenge := range m.challenges {
+		if challenge.Login == login {
+			delete(m.challenges, id)
+		}
+	}
+	m.appendAudit(audit.New(ctx, actor, audit.ActionUserLocked, login, nil))
+	return nil
+}
+
+func (m *MemoryStorage) AdjustBalance(ctx context.Context, adjustment models.BalanceAdjustment) (models.UserBalance, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.passwords[adjustment.Login]; !ok {
+		return models.UserBalance{}, ErrUserNotFound
+	}
+	balance := m.balance(adjustment.Login)
+	if balance.Current+adjustment.Amount < 0 {
+		return *balance, ErrInsufficientFunds
+	}
+	balance.Current += adjustment.Amount
+	m.ledger = append(m.ledger, memoryLedgerEntry{
+		login:     adjustment.Login,
+		kind:      "adjustment",
+		amount:    adjustment.Amount,
+		reason:    adjustment.Reason,
+		actor:     adjustment.Actor,
+		createdAt: adjustment.CreatedAt,
+	})
+	m.appendAudit(audit.New(ctx, adjustment.Actor, audit.ActionAdjustment, adjustment.Login, map[string]any{
+		"amount":  adjustment.Amount,
+		"reason":  adjustment.Reason,
+		"current": balance.Current,
+	}))
+	return *balance, nil
+}
+
+func (m *MemoryStorage) RequeueAccrualTask(ctx context.Context, actor string, orderNumber int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	order, ok := m.orders[orderNumber]
+	if !ok {
+		return ErrOrderNotFound
+	}
+	m.tasks[orderNumber] = &memoryTask{
+		AccrualTask:   models.AccrualTask{UserLogin: order.Login, OrderNumber: orderNumber},
+		state:         models.AccrualTaskPending,
+		nextAttemptAt: time.Now(),
+	}
+	m.appendAudit(audit.New(ctx, actor, audit.ActionOrderRecheck, "", map[string]any{"order": strconv.Itoa(orderNumber)}))
+	return nil
+}
+
+func (m *MemoryStorage) AddAuditEvent(_ context.Context, event models.AuditEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.appendAudit(event)
+	return nil
+}
+
+func (m *MemoryStorage) GetAuditEvents(_ context.Context, filter models.AuditFilter) ([]models.AuditEvent, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var events []models.AuditEvent
+	for i := len(m.audit) - 1; i >= 0 && len(events) < filter.Limit; i-- {
+		event := m.audit[i]
+		switch {
+		case filter.Actor != "" && event.Actor != filter.Actor,
+			filter.Action != "" && event.Action != filter.Action,
+			filter.Target != "" && event.Target != filter.Target,
+			filter.RequestID != "" && event.RequestID != filter.RequestID,
+			!filter.From.IsZero() && event.CreatedAt.Before(filter.From),
+			!filter.To.IsZero() && !event.CreatedAt.Before(filter.To),
+			filter.BeforeID > 0 && event.ID >= filter.BeforeID:
+			continue
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+func (m *MemoryStorage) GetAuditChain(_ context.Context, afterID int64, limit int) ([]models.AuditEvent, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if afterID >= int64(len(m.audit)) {
+		return nil, nil
+	}
+	chain := m.audit[max(afterID, 0):]
+	return append([]models.AuditEvent(nil), chain[:min(limit, len(chain))]...), nil
+}
+
+func (m *MemoryStorage) AddOrder(_ context.Context, userLogin string, orderNumber int) (isAddedToDB bool, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if order, ok := m.orders[orderNumber]; ok {
+		if order.Login != userLogin {
+			return false, ErrAddOrderConflict
+		}
+		return false, nil
+	}
+	if _, ok := m.passwords[userLogin]; !ok {
+		return false, ErrUserNotFound
+	}
+
+	now := time.Now()
+	m.orders[orderNumber] = &memoryOrder{
+		Order: models.Order{
+			Login:      userLogin,
+			Number:     strconv.Itoa(orderNumber),
+			Status:     orderstate.New,
+			UploadedAt: now,
+		},
+	}
+	m.history[orderNumber] = []models.OrderStatusChange{{To: orderstate.New, ChangedAt: now}}
+	m.tasks[orderNumber] = &memoryTask{
+		AccrualTask:   models.AccrualTask{UserLogin: userLogin, OrderNumber: orderNumber},
+		state:         models.AccrualTaskPending,
+		nextAttemptAt: now,
+	}
+	return true, nil
+}
+
+func (m *MemoryStorage) GetOrder(_ context.Context, orderNumber int) (models.Order, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	order, ok := m.orders[orderNumber]
+	if !ok {
+		return models.Order{}, ErrOrderNotFound
+	}
+	return order.Order, nil
+}
+
+func (m *MemoryStorage) GetOrders(_ context.Context, userLogin string, list models.ListQuery) ([]models.Order, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var orders []models.Order
+	for _, order := range m.orders {
+		if order.Login == userLogin && (len(list.Statuses) == 0 || slices.Contains(list.Statuses, order.Status)) {
+			o := order.Order
+			o.Login = ""
+			orders = append(orders, o)
+		}
+	}
+
+	return memoryPage(orders, list, func(o models.Order) models.ListCursor {
+		number, _ := strconv.ParseInt(o.Number, 10, 64)
+		return models.ListCursor{At: o.UploadedAt, Number: number}
+	}), nil
+}
+
+// memoryPage отбирает и упорядочивает записи так же, как pageQuery в StorageDB
+func memoryPage[T any](records []T, list models.ListQuery, key func(T) models.ListCursor) []T {
+	var page []T
+	for _, record := range records {
+		k := key(record)
+		if !list.From.IsZero() && k.At.Before(list.From) ||
+			!list.To.IsZero() && !k.At.Before(list.To) ||
+			list.After != nil && !listFollows(k, *list.After, list.Asc) {
+			continue
+		}
+		page = append(page, record)
+	}
+
+	sort.Slice(page, func(i, j int) bool {
+		return listFollows(key(page[j]), key(page[i]), list.Asc)
+	})
+	if list.Limit > 0 && len(page) > list.Limit {
+		page = page[:list.Limit]
+	}
+	return page
+}
+
+// listFollows - идёт ли запись с ключом k после записи prev в порядке выдачи
+func listFollows(k, prev models.ListCursor, asc bool) bool {
+	if k.At.Equal(prev.At) {
+		if k.Number == prev.Number {
+			return false
+		}
+		return (k.Number > prev.Number) == asc
+	}
+	return k.At.After(prev.At) == asc
+}
+
+func (m *MemoryStorage) UpdateOrder(ctx context.Context, orderNumber int, status string, accrual models.Money) (changed bool, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	order, ok := m.orders[orderNumber]
+	if !ok {
+		return false, ErrOrderNotFound
+	}
+
+	if err := orderstate.Check(order.Status, status); err != nil {
+		return false, err
+	}
+	if order.Status == status {
+		return false, nil
+	}
+
+	m.history[orderNumber] = append(m.history[orderNumber], models.OrderStatusChange{
+		From:      order.Status,
+		To:        status,
+		ChangedAt: time.Now(),
+	})
+	order.Status = status
+	order.Accrual = accrual
+
+	if status == orderstate.Processed && accrual > 0 && !order.credited {
+		order.credited = true
+		m.balance(order.Login).Current += accrual
+		m.ledger = append(m.ledger, memoryLedgerEntry{
+			login: order.Login, orderNumber: orderNumber, kind: "credit", amount: accrual, createdAt: time.Now(),
+		})
+		m.appendAudit(audit.New(ctx, audit.ActorSystem, audit.ActionAccrualCredited, order.Login, map[string]any{
+			"order":  order.Number,
+			"amount": accrual,
+		}))
+	}
+	return true, nil
+}
+
+func (m *MemoryStorage) GetOrderStatusHistory(_ context.Context, orderNumber int) ([]models.OrderStatusChange, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return append([]models.OrderStatusChange(nil), m.history[orderNumber]...), nil
+}
+
+func (m *MemoryStorage) GetUserBalance(_ context.Context, userLogin string) (models.UserBalance, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return *m.balance(userLogin), nil
+}
+
+func (m *MemoryStorage) WithdrawFromUserBalance(ctx context.Context, userLogin string, orderNumber int, amount models.Money) error {
+	if amount <= 0 {
+		return ErrInvalidAmount
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	number := strconv.Itoa(orderNumber)
+	for _, w := range m.withdrawals[userLogin] {
+		if w.Order == number {
+			return ErrWithdrawConflict
+		}
+	}
+
+	balance := m.balance(userLogin)
+	if balance.Current < amount {
+		return ErrInsufficientFunds
+	}
+
+	now := time.Now()
+	balance.Current -= amount
+	balance.Withdrawn += amount
+	m.withdrawals[userLogin] = append(m.withdrawals[userLogin], models.Withdrawal{
+		Order:       strconv.Itoa(orderNumber),
+		Sum:         amount,
+		ProcessedAt: now,
+	})
+	m.ledger = append(m.ledger, memoryLedgerEntry{
+		login: userLogin, orderNumber: orderNumber, kind: "debit", amount: amount, createdAt: now,
+	})
+	m.appendAudit(audit.New(ctx, userLogin, audit.ActionWithdrawal, userLogin, map[string]any{
+		"order": strconv.Itoa(orderNumber),
+		"sum":   amount,
+	}))
+	return nil
+}
+
+func (m *MemoryStorage) GetUserWithdrawals(_ context.Context, userLogin string, list models.ListQuery) ([]models.Withdrawal, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	return memoryPage(m.withdrawals[userLogin], list, func(w models.Withdrawal) models.ListCursor {
+		number, _ := strconv.ParseInt(w.Order, 10, 64)
+		return models.ListCursor{At: w.ProcessedAt, Number: number}
+	}), nil
+}
+
+func (m *MemoryStorage) BalanceForUserLogin(_ context.Context, userLogin string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.balance(userLogin)
+	return nil
+}
+
+func (m *MemoryStorage) ClaimAccrualTasks(_ context.Context, limit int, lease time.Duration) ([]models.AccrualTask, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	var ready []*memoryTask
+	for _, task := range m.tasks {
+		if task.state == models.AccrualTaskPending && !task.nextAttemptAt.After(now) && !task.lockedUntil.After(now) {
+			ready = append(ready, task)
+		}
+	}
+
+	sort.Slice(ready, func(i, j int) bool {
+		return ready[i].nextAttemptAt.Before(ready[j].nextAttemptAt)
+	})
+	if len(ready) > limit {
+		ready = ready[:limit]
+	}
+
+	var tasks []models.AccrualTask
+	for _, task := range ready {
+		task.lockedUntil = now.Add(lease)
+		tasks = append(tasks, task.AccrualTask)
+	}
+	return tasks, nil
+}
+
+func (m *MemoryStorage) CompleteAccrualTask(_ context.Context, orderNumber int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if task, ok := m.tasks[orderNumber]; ok {
+		task.state = models.AccrualTaskDone
+		task.lockedUntil = time.Time{}
+	}
+	return nil
+}
+
+func (m *MemoryStorage) RescheduleAccrualTask(_ context.Context, orderNumber int, delay time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if task, ok := m.tasks[orderNumber]; ok && task.state == models.AccrualTaskPending {
+		task.nextAttemptAt = time.Now().Add(delay)
+		task.lockedUntil = time.Time{}
+	}
+	return nil
+}
+
+func (m *MemoryStorage) FailAccrualTask(_ context.Context, orderNumber int, cause string, delay time.Duration, maxAttempts int) (dead bool, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	task, ok := m.tasks[orderNumber]
+	if !ok || task.state != models.AccrualTaskPending {
+		return false, nil
+	}
+
+	task.Attempts++
+	task.lastError = cause
+	task.nextAttemptAt = time.Now().Add(delay)
+	task.lockedUntil = time.Time{}
+	if task.Attempts >= maxAttempts {
+		task.state = models.AccrualTaskDead
+	}
+	return task.state == models.AccrualTaskDead, nil
+}
+
+func (m *MemoryStorage) PostponeUnregisteredAccrualTask(_ context.Context, orderNumber int, cause string, delay time.Duration, maxPolls int) (dead bool, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	task, ok := m.tasks[orderNumber]
+	if !ok || task.state != models.AccrualTaskPending {
+		return false, nil
+	}
+
+	task.Unregistered++
+	task.lastError = cause
+	task.nextAttemptAt = time.Now().Add(delay)
+	task.lockedUntil = time.Time{}
+	if task.Unregistered >= maxPolls {
+		task.state = models.AccrualTaskDead
+	}
+	return task.state == models.AccrualTaskDead, nil
+}
+
+func (m *MemoryStorage) GetAccrualTask(_ context.Context, orderNumber int) (*models.AccrualTaskInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	task, ok := m.tasks[orderNumber]
+	if !ok {
+		return nil, nil
+	}
+
+	info := &models.AccrualTaskInfo{State: task.state, Attempts: task.Attempts, LastError: task.lastError}
+	if task.state == models.AccrualTaskPending {
+		nextAttemptAt := task.nextAttemptAt
+		info.NextAttemptAt = &nextAttemptAt
+	}
+	return info, nil
+}
+
+func (m *MemoryStorage) CountPendingAccrualTasks(_ context.Context) (int, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	count := 0
+	for _, task := range m.tasks {
+		if task.state == models.AccrualTaskPending {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *MemoryStorage) Ping(_ context.Context) error {
+	return nil
+}
+
+// MigrationVersion - у хранилища в памяти нет схемы
+func (m *MemoryStorage) MigrationVersion(_ context.Context) (current, latest int64, err error) {
+	return 0, 0, nil
+}
+
+func (m *MemoryStorage) Close() error {
+	return nil
+}
+
+// account - пользователь login, который точно существует. Вызывать под m.mu
+func (m *MemoryStorage) account(login string) models.Account {
+	account := models.Account{Login: login, Role: models.RoleUser}
+	if role, ok := m.roles[login]; ok {
+		account.Role = role
+	}
+	if lockedAt, ok := m.locked[login]; ok {
+		account.LockedAt = &lockedAt
+	}
+	account.TokenVersion = m.versions[login]
+	return account
+}
+
+// appendAudit продолжает цепочку журнала аудита. Вызывать под m.mu.Lock()
+func (m *MemoryStorage) appendAudit(event models.AuditEvent) {
+	var prevHash string
+	if len(m.audit) > 0 {
+		prevHash = m.audit[len(m.audit)-1].Hash
+	}
+	event = audit.Chain(prevHash, event)
+	event.ID = int64(len(m.audit) + 1)
+	m.audit = append(m.audit, event)
+}
+
+// balance возвращает баланс пользователя, создавая его при отсутствии. Вызывать под m.mu.Lock()
+func (m *MemoryStorage) balance(userLogin string) *models.UserBalance {
+	b, ok := m.balances[userLogin]
+	if !ok {
+		b = &models.UserBalance{}
+		m.balances[userLogin] = b
+	}
+	return b
+}
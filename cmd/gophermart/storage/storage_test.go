@@ -4,13 +4,18 @@
 package storage_test
 
 import (
+	"context"
 	"database/sql"
+	"encoding/json"
+	"gophermart/cmd/gophermart/audit"
 	"gophermart/cmd/gophermart/models"
+	"gophermart/cmd/gophermart/orderstate"
 	"gophermart/cmd/gophermart/storage"
 	"testing"
 	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jackc/pgx/v5/pgconn"
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -40,7 +45,7 @@ func Test_SaveLoginPassword(t *testing.T) {
 		WithArgs(login, hashedPassword).
 		WillReturnResult(sqlmock.NewResult(1, 1))
 
-	ok := storage.SaveLoginPassword(login, hashedPassword)
+	ok := storage.SaveLoginPassword(context.Background(), login, hashedPassword)
 
 	assert.True(t, ok)
 	assert.NoError(t, mock.ExpectationsWereMet())
@@ -59,49 +64,474 @@ func Test_GetHashedPasswordByLogin(t *testing.T) {
 		WithArgs(login).
 		WillReturnRows(sqlmock.NewRows([]string{"password"}).AddRow(expectedPassword))
 
-	hashedPassword := storage.GetHashedPasswordByLogin(login)
+	hashedPassword := storage.GetHashedPasswordByLogin(context.Background(), login)
 
 	assert.Equal(t, expectedPassword, hashedPassword)
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
-func Test_SaveUID(t *testing.T) {
+func Test_CreateSession(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	require.NoError(t, err)
 	defer db.Close()
 
 	storage := &storage.StorageDB{DBConn: db}
 
-	userID := "12345"
-	login := "testuser"
+	now := time.Now()
+	session := models.Session{
+		ID:        "12345",
+		Login:     "testuser",
+		CreatedAt: now,
+		UserAgent: "curl/8.0",
+		IP:        "127.0.0.1",
+		ExpiresAt: now.Add(time.Hour),
+	}
 
-	mock.ExpectExec("UPDATE users SET uid = \\$1 WHERE login = \\$2").
-		WithArgs(userID, login).
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM sessions WHERE login = \\$1 AND expires_at <= now\\(\\)").
+		WithArgs(session.Login).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO sessions").
+		WithArgs(session.ID, session.Login, session.CreatedAt, session.UserAgent, session.IP, session.ExpiresAt).
 		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
 
-	err = storage.SaveUID(userID, login)
+	err = storage.CreateSession(context.Background(), session)
 
 	assert.NoError(t, err)
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
-func Test_GetLoginByUID(t *testing.T) {
+func Test_ChangePassword(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	require.NoError(t, err)
 	defer db.Close()
 
-	storage := &storage.StorageDB{DBConn: db}
+	s := &storage.StorageDB{DBConn: db}
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE users SET password = \\$1, token_version = token_version \\+ 1 WHERE login = \\$2").
+		WithArgs("newHash", "testuser").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM sessions WHERE login = \\$1 AND id != \\$2").
+		WithArgs("testuser", "12345").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	err = s.ChangePassword(context.Background(), "testuser", "newHash", "12345")
+
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func Test_TouchSession(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	s := &storage.StorageDB{DBConn: db}
+
+	mock.ExpectQuery("UPDATE sessions SET last_seen_at = now\\(\\)").
+		WithArgs("12345").
+		WillReturnRows(sqlmock.NewRows([]string{"login"}).AddRow("testuser"))
+	mock.ExpectQuery("UPDATE sessions SET last_seen_at = now\\(\\)").
+		WithArgs("expired").
+		WillReturnRows(sqlmock.NewRows([]string{"login"}))
+
+	login, err := s.TouchSession(context.Background(), "12345")
+	assert.NoError(t, err)
+	assert.Equal(t, "testuser", login)
+
+	_, err = s.TouchSession(context.Background(), "expired")
+	assert.ErrorIs(t, err, storage.ErrSessionNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func Test_DeleteSession(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	s := &storage.StorageDB{DBConn: db}
+
+	mock.ExpectExec("DELETE FROM sessions WHERE id = \\$1 AND login = \\$2").
+		WithArgs("12345", "testuser").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM sessions WHERE id = \\$1 AND login = \\$2").
+		WithArgs("12345", "otheruser").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	assert.NoError(t, s.DeleteSession(context.Background(), "testuser", "12345"))
+	assert.ErrorIs(t, s.DeleteSession(context.Background(), "otheruser", "12345"), storage.ErrSessionNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func Test_AddLoginFailure(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	s := &storage.StorageDB{DBConn: db}
+
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM login_failures WHERE key = \\$1 AND failed_at <= now\\(\\) - make_interval").
+		WithArgs("login:testuser", float64(900)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO login_failures").
+		WithArgs("login:testuser").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery("SELECT count\\(\\*\\) FROM login_failures WHERE key = \\$1").
+		WithArgs("login:testuser").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+	mock.ExpectCommit()
 
-	userID := "12345"
-	expectedLogin := "testuser"
+	failures, err := s.AddLoginFailure(context.Background(), "login:testuser", 15*time.Minute)
 
-	mock.ExpectQuery("SELECT login FROM users WHERE uid=\\$1").
-		WithArgs(userID).
-		WillReturnRows(sqlmock.NewRows([]string{"login"}).AddRow(expectedLogin))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, failures)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func Test_LockLogin(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	s := &storage.StorageDB{DBConn: db}
+	lockedUntil := time.Now().Add(15 * time.Minute)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO login_lockouts").
+		WithArgs("ip:10.0.0.1", float64(900)).
+		WillReturnRows(sqlmock.NewRows([]string{"locked_until"}).AddRow(lockedUntil))
+	mock.ExpectExec("DELETE FROM login_failures WHERE key = \\$1").
+		WithArgs("ip:10.0.0.1").
+		WillReturnResult(sqlmock.NewResult(0, 20))
+	mock.ExpectCommit()
+	mock.ExpectQuery("SELECT locked_until FROM login_lockouts").
+		WithArgs("ip:10.0.0.2").
+		WillReturnRows(sqlmock.NewRows([]string{"locked_until"}))
+
+	until, err := s.LockLogin(context.Background(), "ip:10.0.0.1", 15*time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, lockedUntil, until)
+
+	until, err = s.GetLoginLockout(context.Background(), "ip:10.0.0.2")
+	assert.NoError(t, err)
+	assert.True(t, until.IsZero())
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func Test_SaveTOTPSecret(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
 
-	login := storage.GetLoginByUID(userID)
+	s := &storage.StorageDB{DBConn: db}
 
-	assert.Equal(t, expectedLogin, login)
+	mock.ExpectExec("INSERT INTO user_totp").
+		WithArgs("testuser", "SECRET").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO user_totp").
+		WithArgs("enabled", "SECRET").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	assert.NoError(t, s.SaveTOTPSecret(context.Background(), "testuser", "SECRET"))
+	assert.ErrorIs(t, s.SaveTOTPSecret(context.Background(), "enabled", "SECRET"), storage.ErrTOTPEnabled)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func Test_EnableTOTP(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	s := &storage.StorageDB{DBConn: db}
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE user_totp SET enabled = true").
+		WithArgs("testuser", int64(56666667)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM totp_recovery_codes WHERE login = \\$1").
+		WithArgs("testuser").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO totp_recovery_codes").
+		WithArgs("testuser", "hash-1").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO totp_recovery_codes").
+		WithArgs("testuser", "hash-2").
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	mock.ExpectCommit()
+
+	err = s.EnableTOTP(context.Background(), "testuser", 56666667, []string{"hash-1", "hash-2"})
+
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func Test_UseTOTPStep(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	s := &storage.StorageDB{DBConn: db}
+
+	mock.ExpectExec("UPDATE user_totp SET last_used_step = \\$2").
+		WithArgs("testuser", int64(56666667)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE user_totp SET last_used_step = \\$2").
+		WithArgs("testuser", int64(56666667)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	used, err := s.UseTOTPStep(context.Background(), "testuser", 56666667)
+	assert.NoError(t, err)
+	assert.True(t, used)
+
+	used, err = s.UseTOTPStep(context.Background(), "testuser", 56666667)
+	assert.NoError(t, err)
+	assert.False(t, used, "replayed code")
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func Test_GetAPIKey(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	s := &storage.StorageDB{DBConn: db}
+	createdAt := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	columns := []string{"id", "login", "name", "merchant", "scopes", "hash", "created_at", "expires_at", "last_used_at"}
+	mock.ExpectQuery("SELECT (.+) FROM api_keys WHERE id = \\$1 AND \\(expires_at IS NULL").
+		WithArgs("0123456789abcdef").
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow("0123456789abcdef", "testuser", "crm", false, "balance:read orders:read", "hash", createdAt, nil, nil))
+	mock.ExpectQuery("SELECT (.+) FROM api_keys").
+		WithArgs("unknown").
+		WillReturnError(sql.ErrNoRows)
+
+	key, err := s.GetAPIKey(context.Background(), "0123456789abcdef")
+	require.NoError(t, err)
+	assert.Equal(t, "testuser", key.Login)
+	assert.Equal(t, []string{"balance:read", "orders:read"}, key.Scopes)
+	assert.Nil(t, key.ExpiresAt)
+
+	_, err = s.GetAPIKey(context.Background(), "unknown")
+	assert.ErrorIs(t, err, storage.ErrAPIKeyNotFound)
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func Test_DeleteAPIKey(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	s := &storage.StorageDB{DBConn: db}
+
+	mock.ExpectExec("DELETE FROM api_keys WHERE id = \\$1 AND login = \\$2").
+		WithArgs("0123456789abcdef", "testuser").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM api_keys").
+		WithArgs("0123456789abcdef", "otheruser").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	assert.NoError(t, s.DeleteAPIKey(context.Background(), "testuser", "0123456789abcdef"))
+	assert.ErrorIs(t, s.DeleteAPIKey(context.Background(), "otheruser", "0123456789abcdef"), storage.ErrAPIKeyNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func Test_SetUserLocked(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	s := &storage.StorageDB{DBConn: db}
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE users SET locked_at").
+		WithArgs("testuser", true).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM sessions WHERE login = \\$1").
+		WithArgs("testuser").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("DELETE FROM mfa_challenges WHERE login = \\$1").
+		WithArgs("testuser").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	expectAuditEvent(mock, "admin", audit.ActionUserLocked, "testuser")
+	mock.ExpectCommit()
+
+	assert.NoError(t, s.SetUserLocked(context.Background(), "admin", "testuser", true))
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// Разблокировка не трогает сессии
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE users SET locked_at").
+		WithArgs("testuser", false).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectAuditEvent(mock, "admin", audit.ActionUserUnlocked, "testuser")
+	mock.ExpectCommit()
+
+	assert.NoError(t, s.SetUserLocked(context.Background(), "admin", "testuser", false))
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE users SET locked_at").
+		WithArgs("unknown", true).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	assert.ErrorIs(t, s.SetUserLocked(context.Background(), "admin", "unknown", true), storage.ErrUserNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func Test_SetUserRole(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	s := &storage.StorageDB{DBConn: db}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT role FROM users WHERE login = \\$1 FOR UPDATE").
+		WithArgs("testuser").
+		WillReturnRows(sqlmock.NewRows([]string{"role"}).AddRow(models.RoleUser))
+	mock.ExpectExec("UPDATE users SET role = \\$2 WHERE login = \\$1").
+		WithArgs("testuser", models.RoleSupport).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectAuditEvent(mock, "admin", audit.ActionRoleChanged, "testuser")
+	mock.ExpectCommit()
+
+	assert.NoError(t, s.SetUserRole(context.Background(), "admin", "testuser", models.RoleSupport))
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT role FROM users").
+		WithArgs("unknown").
+		WillReturnRows(sqlmock.NewRows([]string{"role"}))
+	mock.ExpectRollback()
+
+	assert.ErrorIs(t, s.SetUserRole(context.Background(), "admin", "unknown", models.RoleSupport), storage.ErrUserNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func Test_AdjustBalance(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	s := &storage.StorageDB{DBConn: db}
+	adjustment := models.BalanceAdjustment{
+		Login: "testuser", Amount: models.NewMoney(-30), Reason: "fraud", Actor: "admin", CreatedAt: time.Now(),
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO users_balances").
+		WithArgs("testuser").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT current, withdrawn FROM users_balances WHERE login = \\$1 FOR UPDATE").
+		WithArgs("testuser").
+		WillReturnRows(sqlmock.NewRows([]string{"current", "withdrawn"}).AddRow(int64(10000), int64(500)))
+	mock.ExpectExec("INSERT INTO balance_ledger (.+) 'adjustment'").
+		WithArgs("testuser", models.NewMoney(-30), "fraud", "admin", adjustment.CreatedAt).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("UPDATE users_balances SET current = current \\+ \\$1").
+		WithArgs(models.NewMoney(-30), "testuser").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectAuditEvent(mock, "admin", audit.ActionAdjustment, "testuser")
+	mock.ExpectCommit()
+
+	balance, err := s.AdjustBalance(context.Background(), adjustment)
+	assert.NoError(t, err)
+	assert.Equal(t, models.UserBalance{Current: models.NewMoney(70), Withdrawn: models.NewMoney(5)}, balance)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// Баланс не уходит в минус
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO users_balances").
+		WithArgs("testuser").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT current, withdrawn FROM users_balances").
+		WithArgs("testuser").
+		WillReturnRows(sqlmock.NewRows([]string{"current", "withdrawn"}).AddRow(int64(2000), int64(0)))
+	mock.ExpectRollback()
+
+	_, err = s.AdjustBalance(context.Background(), adjustment)
+	assert.ErrorIs(t, err, storage.ErrInsufficientFunds)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// Пользователя нет - нарушение внешнего ключа users_balances
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO users_balances").
+		WithArgs("testuser").
+		WillReturnError(&pgconn.PgError{Code: "23503"})
+	mock.ExpectRollback()
+
+	_, err = s.AdjustBalance(context.Background(), adjustment)
+	assert.ErrorIs(t, err, storage.ErrUserNotFound)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// expectAuditEvent - запись события в журнал аудита в конце транзакции
+func expectAuditEvent(mock sqlmock.Sqlmock, actor, action, target string) {
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT hash FROM audit_events ORDER BY id DESC LIMIT 1").
+		WillReturnRows(sqlmock.NewRows([]string{"hash"}).AddRow("prevHash"))
+	mock.ExpectExec("INSERT INTO audit_events").
+		WithArgs(actor, action, target, "", "", sqlmock.AnyArg(), sqlmock.AnyArg(), "prevHash", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+}
+
+func Test_AddAuditEvent(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	s := &storage.StorageDB{DBConn: db}
+	ctx := audit.WithMeta(context.Background(), audit.Meta{RequestID: "req-1", IP: "192.0.2.1"})
+	event := audit.New(ctx, "testuser", audit.ActionLoginSucceeded, "testuser", map[string]any{"factor": "password"})
+
+	// Первая запись журнала продолжает пустую цепочку
+	mock.ExpectBegin()
+	mock.ExpectExec("SELECT pg_advisory_xact_lock").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT hash FROM audit_events").
+		WillReturnRows(sqlmock.NewRows([]string{"hash"}))
+	mock.ExpectExec("INSERT INTO audit_events").
+		WithArgs("testuser", audit.ActionLoginSucceeded, "testuser", "req-1", "192.0.2.1", `{"factor":"password"}`,
+			event.CreatedAt, "", audit.Hash("", event)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+
+	assert.NoError(t, s.AddAuditEvent(context.Background(), event))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func Test_GetAuditEvents(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	s := &storage.StorageDB{DBConn: db}
+	from := time.Now().Add(-time.Hour)
+	columns := []string{"id", "actor", "action", "target", "request_id", "ip", "payload", "created_at", "prev_hash", "hash"}
+
+	mock.ExpectQuery("FROM audit_events WHERE actor = \\$1 AND target = \\$2 AND created_at >= \\$3 AND id < \\$4 ORDER BY id DESC LIMIT \\$5").
+		WithArgs("admin", "testuser", from, int64(100), 10).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(int64(7), "admin", audit.ActionAdjustment, "testuser", "req-1", "192.0.2.1", []byte(`{"amount":5}`), from, "h6", "h7").
+			AddRow(int64(3), "admin", "admin_view_user", "testuser", "req-2", "192.0.2.1", nil, from, "h2", "h3"))
+
+	events, err := s.GetAuditEvents(context.Background(), models.AuditFilter{
+		Actor: "admin", Target: "testuser", From: from, BeforeID: 100, Limit: 10,
+	})
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	assert.Equal(t, json.RawMessage(`{"amount":5}`), events[0].Payload)
+	assert.Nil(t, events[1].Payload)
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
@@ -123,11 +553,19 @@ func Test_AddOrder(t *testing.T) {
 		WithArgs(userLogin, orderNumber).
 		WillReturnRows(sqlmock.NewRows(nil)) // заказ отсутствует
 
+	mock.ExpectBegin()
 	mock.ExpectExec("INSERT INTO orders \\(login, number, status, uploaded_at\\) VALUES \\(\\$1, \\$2, \\$3, \\$4\\)").
 		WithArgs(userLogin, orderNumber, "NEW", sqlmock.AnyArg()).
 		WillReturnResult(sqlmock.NewResult(1, 1)) // успешное добавление
+	mock.ExpectExec("INSERT INTO order_status_history").
+		WithArgs(orderNumber, nil, "NEW", sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO accrual_tasks \\(login, order_number\\) VALUES \\(\\$1, \\$2\\)").
+		WithArgs(userLogin, orderNumber).
+		WillReturnResult(sqlmock.NewResult(1, 1)) // задача для Accrual в той же транзакции
+	mock.ExpectCommit()
 
-	isAdded, err := storage.AddOrder(userLogin, orderNumber)
+	isAdded, err := storage.AddOrder(context.Background(), userLogin, orderNumber)
 
 	assert.True(t, isAdded)
 	assert.NoError(t, err)
@@ -144,29 +582,92 @@ func Test_GetOrders(t *testing.T) {
 	userLogin := "testuser"
 
 	rows := sqlmock.NewRows([]string{"number", "status", "accrual", "uploaded_at"}).
-		AddRow("12345", "NEW", 10.5, time.Now()).
-		AddRow("67890", "PROCESSED", 15.0, time.Now())
+		AddRow("12345", "NEW", int64(1050), time.Now()).
+		AddRow("67890", "PROCESSED", int64(1500), time.Now())
 
 	mock.ExpectQuery(`SELECT number, status, accrual, uploaded_at FROM orders WHERE login = \$1 ORDER BY uploaded_at DESC`).
 		WithArgs(userLogin).
 		WillReturnRows(rows)
 
-	orders, err := storage.GetOrders(userLogin)
+	orders, err := storage.GetOrders(context.Background(), userLogin, models.ListQuery{})
 
 	assert.NoError(t, err)
 	require.Len(t, orders, 2)
 
 	assert.Equal(t, "12345", orders[0].Number)
 	assert.Equal(t, "NEW", orders[0].Status)
-	assert.Equal(t, 10.5, orders[0].Accrual)
+	assert.Equal(t, models.NewMoney(10.5), orders[0].Accrual)
 
 	assert.Equal(t, "67890", orders[1].Number)
 	assert.Equal(t, "PROCESSED", orders[1].Status)
-	assert.Equal(t, 15.0, orders[1].Accrual)
+	assert.Equal(t, models.NewMoney(15), orders[1].Accrual)
 
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
+func Test_GetOrders_Page(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	storage := &storage.StorageDB{DBConn: db}
+
+	from := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	after := models.ListCursor{At: from.Add(time.Hour), Number: 12345}
+
+	mock.ExpectQuery(`SELECT number, status, accrual, uploaded_at FROM orders WHERE login = \$1 `+
+		`AND status IN \(\$2, \$3\) AND uploaded_at >= \$4 AND \(uploaded_at, number\) > \(\$5, \$6\) `+
+		`ORDER BY uploaded_at ASC, number ASC LIMIT \$7`).
+		WithArgs("testuser", "NEW", "PROCESSING", from, after.At, after.Number, 11).
+		WillReturnRows(sqlmock.NewRows([]string{"number", "status", "accrual", "uploaded_at"}).
+			AddRow("67890", "NEW", int64(0), after.At.Add(time.Minute)))
+
+	orders, err := storage.GetOrders(context.Background(), "testuser", models.ListQuery{
+		Statuses: []string{"NEW", "PROCESSING"},
+		From:     from,
+		Asc:      true,
+		After:    &after,
+		Limit:    11,
+	})
+
+	require.NoError(t, err)
+	require.Len(t, orders, 1)
+	assert.Equal(t, "67890", orders[0].Number)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func Test_GetUserWithdrawals_Page(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	storage := &storage.StorageDB{DBConn: db}
+
+	to := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	after := models.ListCursor{At: to.Add(-time.Hour), Number: 12345}
+
+	// Статусов у списаний нет: фильтр не применяется
+	mock.ExpectQuery(`SELECT order_number, sum, processed_at FROM users_withdrawals WHERE login = \$1 `+
+		`AND processed_at < \$2 AND \(processed_at, order_number\) < \(\$3, \$4\) `+
+		`ORDER BY processed_at DESC, order_number DESC LIMIT \$5`).
+		WithArgs("testuser", to, after.At, after.Number, 2).
+		WillReturnRows(sqlmock.NewRows([]string{"order_number", "sum", "processed_at"}).
+			AddRow("67890", int64(500), after.At.Add(-time.Minute)))
+
+	withdrawals, err := storage.GetUserWithdrawals(context.Background(), "testuser", models.ListQuery{
+		Statuses: []string{"NEW"},
+		To:       to,
+		After:    &after,
+		Limit:    2,
+	})
+
+	require.NoError(t, err)
+	require.Len(t, withdrawals, 1)
+	assert.Equal(t, "67890", withdrawals[0].Order)
+	assert.Equal(t, models.NewMoney(5), withdrawals[0].Sum)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
 func Test_UpdateOrder(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	require.NoError(t, err)
@@ -176,15 +677,188 @@ func Test_UpdateOrder(t *testing.T) {
 
 	orderNumber := 12345
 	status := "PROCESSED"
-	accrual := 100.50
+	accrual := models.NewMoney(100.50)
 
-	mock.ExpectExec("UPDATE orders SET status = \\$1, accrual = \\$2, accrual_added = \\(TRUE\\) WHERE number = \\$3").
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT login, status FROM orders WHERE number = \\$1 FOR UPDATE").
+		WithArgs(orderNumber).
+		WillReturnRows(sqlmock.NewRows([]string{"login", "status"}).AddRow("testuser", "PROCESSING"))
+	mock.ExpectExec("UPDATE orders SET status = \\$1, accrual = \\$2 WHERE number = \\$3").
 		WithArgs(status, accrual, orderNumber).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO order_status_history").
+		WithArgs(orderNumber, "PROCESSING", status, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO balance_ledger").
+		WithArgs("testuser", orderNumber, accrual, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("INSERT INTO users_balances \\(login\\) VALUES \\(\\$1\\) ON CONFLICT").
+		WithArgs("testuser").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("UPDATE users_balances SET current = current \\+ \\$1 WHERE login = \\$2").
+		WithArgs(accrual, "testuser").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE orders SET accrual_added = TRUE WHERE number = \\$1").
+		WithArgs(orderNumber).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectAuditEvent(mock, audit.ActorSystem, audit.ActionAccrualCredited, "testuser")
+	mock.ExpectCommit()
+
+	changed, err := storage.UpdateOrder(context.Background(), orderNumber, status, accrual)
+
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func Test_UpdateOrder_AlreadyCredited(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	storage := &storage.StorageDB{DBConn: db}
+
+	orderNumber := 12345
+	accrual := models.NewMoney(100.50)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT login, status FROM orders").
+		WithArgs(orderNumber).
+		WillReturnRows(sqlmock.NewRows([]string{"login", "status"}).AddRow("testuser", "NEW"))
+	mock.ExpectExec("UPDATE orders SET status").
+		WithArgs("PROCESSED", accrual, orderNumber).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO order_status_history").
+		WithArgs(orderNumber, "NEW", "PROCESSED", sqlmock.AnyArg()).
 		WillReturnResult(sqlmock.NewResult(1, 1))
+	// Запись в журнале уже есть - баланс не меняется
+	mock.ExpectExec("INSERT INTO balance_ledger").
+		WithArgs("testuser", orderNumber, accrual, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	changed, err := storage.UpdateOrder(context.Background(), orderNumber, "PROCESSED", accrual)
+
+	assert.NoError(t, err)
+	assert.True(t, changed)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func Test_UpdateOrder_NoRegressionFromFinalStatus(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	s := &storage.StorageDB{DBConn: db}
+	orderNumber := 12345
+
+	// Повтор финального статуса ничего не меняет
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT login, status FROM orders").
+		WithArgs(orderNumber).
+		WillReturnRows(sqlmock.NewRows([]string{"login", "status"}).AddRow("testuser", "PROCESSED"))
+	mock.ExpectRollback()
 
-	err = storage.UpdateOrder(orderNumber, status, accrual)
+	changed, err := s.UpdateOrder(context.Background(), orderNumber, "PROCESSED", models.NewMoney(100))
+	assert.NoError(t, err)
+	assert.False(t, changed)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// Из финального статуса назад не переходим
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT login, status FROM orders").
+		WithArgs(orderNumber).
+		WillReturnRows(sqlmock.NewRows([]string{"login", "status"}).AddRow("testuser", "PROCESSED"))
+	mock.ExpectRollback()
+
+	_, err = s.UpdateOrder(context.Background(), orderNumber, "PROCESSING", 0)
+	assert.ErrorIs(t, err, orderstate.ErrTransitionNotAllowed)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func Test_CountPendingAccrualTasks(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	storage := &storage.StorageDB{DBConn: db}
+
+	mock.ExpectQuery("SELECT count\\(\\*\\) FROM accrual_tasks WHERE state = \\$1").
+		WithArgs(models.AccrualTaskPending).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(3))
+
+	count, err := storage.CountPendingAccrualTasks(context.Background())
 
 	assert.NoError(t, err)
+	assert.Equal(t, 3, count)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func Test_WithdrawFromUserBalance(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	s := &storage.StorageDB{DBConn: db}
+
+	userLogin := "testuser"
+	orderNumber := 2377225624
+
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO users_balances").
+		WithArgs(userLogin).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT current FROM users_balances WHERE login = \\$1 FOR UPDATE").
+		WithArgs(userLogin).
+		WillReturnRows(sqlmock.NewRows([]string{"current"}).AddRow(int64(10000)))
+	mock.ExpectQuery("SELECT EXISTS \\(SELECT 1 FROM users_withdrawals WHERE login = \\$1 AND order_number = \\$2\\)").
+		WithArgs(userLogin, orderNumber).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectQuery("INSERT INTO users_withdrawals .* RETURNING id").
+		WithArgs(userLogin, orderNumber, models.NewMoney(50), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(int64(7)))
+	mock.ExpectExec("INSERT INTO balance_ledger").
+		WithArgs(userLogin, orderNumber, models.NewMoney(50), sqlmock.AnyArg(), int64(7)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec("UPDATE users_balances SET current = current - \\$1").
+		WithArgs(models.NewMoney(50), userLogin).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectAuditEvent(mock, userLogin, audit.ActionWithdrawal, userLogin)
+	mock.ExpectCommit()
+
+	assert.NoError(t, s.WithdrawFromUserBalance(context.Background(), userLogin, orderNumber, models.NewMoney(50)))
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// Недостаточно средств
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO users_balances").
+		WithArgs(userLogin).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT current FROM users_balances").
+		WithArgs(userLogin).
+		WillReturnRows(sqlmock.NewRows([]string{"current"}).AddRow(int64(1000)))
+	mock.ExpectQuery("SELECT EXISTS").
+		WithArgs(userLogin, orderNumber).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(false))
+	mock.ExpectRollback()
+
+	assert.ErrorIs(t, s.WithdrawFromUserBalance(context.Background(), userLogin, orderNumber, models.NewMoney(50)), storage.ErrInsufficientFunds)
+	assert.NoError(t, mock.ExpectationsWereMet())
+
+	// Повтор списания тем же пользователем
+	mock.ExpectBegin()
+	mock.ExpectExec("INSERT INTO users_balances").
+		WithArgs(userLogin).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT current FROM users_balances").
+		WithArgs(userLogin).
+		WillReturnRows(sqlmock.NewRows([]string{"current"}).AddRow(int64(10000)))
+	mock.ExpectQuery("SELECT EXISTS").
+		WithArgs(userLogin, orderNumber).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(true))
+	mock.ExpectRollback()
+
+	assert.ErrorIs(t, s.WithdrawFromUserBalance(context.Background(), userLogin, orderNumber, models.NewMoney(50)), storage.ErrWithdrawConflict)
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
@@ -197,8 +871,8 @@ func Test_GetUserBalance(t *testing.T) {
 
 	userLogin := "testuser"
 	expectedBalance := models.UserBalance{
-		Current:   500.00,
-		Withdrawn: 200.00,
+		Current:   models.NewMoney(500),
+		Withdrawn: models.NewMoney(200),
 	}
 
 	// вместо BalanceForUserLogin
@@ -212,11 +886,33 @@ func Test_GetUserBalance(t *testing.T) {
 	mock.ExpectQuery("SELECT current, withdrawn FROM users_balances WHERE login = \\$1").
 		WithArgs(userLogin).
 		WillReturnRows(sqlmock.NewRows([]string{"current", "withdrawn"}).
-			AddRow(expectedBalance.Current, expectedBalance.Withdrawn))
+			AddRow(int64(expectedBalance.Current), int64(expectedBalance.Withdrawn)))
 
-	balance, err := storage.GetUserBalance(userLogin)
+	balance, err := storage.GetUserBalance(context.Background(), userLogin)
 
 	assert.NoError(t, err)
 	assert.Equal(t, expectedBalance, balance)
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
+
+func Test_GetOrders_ContextCanceled(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	storage := &storage.StorageDB{DBConn: db}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	mock.ExpectQuery("SELECT number, status, accrual, uploaded_at FROM orders").
+		WithArgs("testuser").
+		WillDelayFor(time.Second).
+		WillReturnRows(sqlmock.NewRows([]string{"number", "status", "accrual", "uploaded_at"}))
+
+	start := time.Now()
+	_, err = storage.GetOrders(ctx, "testuser", models.ListQuery{})
+
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), time.Second, "query must be interrupted by ctx")
+}
@@ -0,0 +1,55 @@
+package user
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/hex"
+	"strings"
+)
+
+// apiKeyPrefix отличает ключи сервиса в логах и сканерах утечек
+const apiKeyPrefix = "gm_"
+
+// GenerateAPIKey создаёт ключ вида gm_<id>_<secret>. По id ключ ищется в хранилище,
+// secret хранится только в виде хеша.
+func GenerateAPIKey() (id, secret, key string, err error) {
+	idBytes := make([]byte, 8)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", "", "", err
+	}
+	secretBytes := make([]byte, 32)
+	if _, err := rand.Read(secretBytes); err != nil {
+		return "", "", "", err
+	}
+
+	id = hex.EncodeToString(idBytes)
+	secret = strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(secretBytes))
+	return id, secret, apiKeyPrefix + id + "_" + secret, nil
+}
+
+// ParseAPIKey разбирает ключ из заголовка X-API-Key
+func ParseAPIKey(key string) (id, secret string, ok bool) {
+	rest, found := strings.CutPrefix(key, apiKeyPrefix)
+	if !found {
+		return "", "", false
+	}
+	id, secret, found = strings.Cut(rest, "_")
+	if !found || id == "" || secret == "" {
+		return "", "", false
+	}
+	return id, secret, true
+}
+
+// HashAPIKeySecret - SHA-256 секрета ключа. Секрет - 32 случайных байта, подбирать его по хешу
+// бессмысленно, поэтому медленный bcrypt не нужен и не даёт нагружать сервис запросами с чужим id.
+func HashAPIKeySecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// CheckAPIKeySecret сравнивает секрет с хешем за постоянное время
+func CheckAPIKeySecret(secret, hash string) bool {
+	return subtle.ConstantTimeCompare([]byte(HashAPIKeySecret(secret)), []byte(hash)) == 1
+}
@@ -0,0 +1,60 @@
+//go:build unit
+// +build unit
+
+package user
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_GenerateAPIKey(t *testing.T) {
+	id, secret, key, err := GenerateAPIKey()
+	require.NoError(t, err)
+	assert.Regexp(t, regexp.MustCompile(`^gm_[0-9a-f]{16}_[a-z2-7]{52}$`), key)
+
+	gotID, gotSecret, ok := ParseAPIKey(key)
+	assert.True(t, ok)
+	assert.Equal(t, id, gotID)
+	assert.Equal(t, secret, gotSecret)
+
+	_, other, _, err := GenerateAPIKey()
+	require.NoError(t, err)
+	assert.NotEqual(t, secret, other)
+}
+
+func Test_ParseAPIKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		key    string
+		id     string
+		secret string
+		ok     bool
+	}{
+		{name: "Valid", key: "gm_0123456789abcdef_secret", id: "0123456789abcdef", secret: "secret", ok: true},
+		{name: "No prefix", key: "0123456789abcdef_secret"},
+		{name: "No separator", key: "gm_0123456789abcdef"},
+		{name: "Empty id", key: "gm__secret"},
+		{name: "Empty secret", key: "gm_0123456789abcdef_"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, secret, ok := ParseAPIKey(tt.key)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.id, id)
+			assert.Equal(t, tt.secret, secret)
+		})
+	}
+}
+
+func Test_CheckAPIKeySecret(t *testing.T) {
+	hash := HashAPIKeySecret("secret")
+	assert.Regexp(t, regexp.MustCompile(`^[0-9a-f]{64}$`), hash)
+	assert.True(t, CheckAPIKeySecret("secret", hash))
+	assert.False(t, CheckAPIKeySecret("Secret", hash))
+	assert.False(t, CheckAPIKeySecret("secret", "$2a$10$legacyBcryptHash"))
+}
@@ -0,0 +1,129 @@
+package user
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Алгоритмы подписи JWT
+const (
+	AlgHS256 = "HS256"
+	AlgEdDSA = "EdDSA"
+)
+
+// minHS256SecretLen - не короче выхода SHA-256 (RFC 7518, 3.2)
+const minHS256SecretLen = 32
+
+var (
+	ErrInvalidToken         = errors.New("invalid token")
+	ErrUnsupportedAlgorithm = errors.New("unsupported JWT signing algorithm")
+	ErrWeakSecret           = errors.New("HS256 secret must be at least 32 bytes")
+)
+
+// TokenService выдаёт и проверяет токены для заголовка Authorization: Bearer
+type TokenService interface {
+	IssueToken(claims TokenClaims) (string, error)
+	ParseToken(token string) (TokenClaims, error)
+}
+
+// TokenClaims - пользователь, сессия, при открытии которой выдан токен, и версия токенов пользователя.
+// Токен действует, пока сессия не закрыта и версия не изменилась (см. StorageService.ChangePassword).
+type TokenClaims struct {
+	Login     string
+	SessionID string
+	Version   int64
+}
+
+// jwtClaims - логин в sub, сессия в sid, версия в ver
+type jwtClaims struct {
+	jwt.RegisteredClaims
+	SessionID string `json:"sid"`
+	Version   int64  `json:"ver"`
+}
+
+// JWT - токены с логином пользователя в sub
+type JWT struct {
+	method    jwt.SigningMethod
+	signKey   any
+	verifyKey any
+	issuer    string
+	audience  string
+	ttl       time.Duration
+}
+
+// NewJWT - key для HS256 это секрет, для EdDSA - закрытый ключ Ed25519 в PEM (PKCS #8)
+func NewJWT(alg string, key []byte, issuer, audience string, ttl time.Duration) (*JWT, error) {
+	j := &JWT{issuer: issuer, audience: audience, ttl: ttl}
+
+	switch alg {
+	case AlgHS256:
+		if len(key) < minHS256SecretLen {
+			return nil, ErrWeakSecret
+		}
+		j.method, j.signKey, j.verifyKey = jwt.SigningMethodHS256, key, key
+	case AlgEdDSA:
+		parsed, err := jwt.ParseEdPrivateKeyFromPEM(key)
+		if err != nil {
+			return nil, fmt.Errorf("parse Ed25519 private key: %w", err)
+		}
+		privateKey := parsed.(ed25519.PrivateKey)
+		j.method, j.signKey, j.verifyKey = jwt.SigningMethodEdDSA, privateKey, privateKey.Public()
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedAlgorithm, alg)
+	}
+
+	return j, nil
+}
+
+func (j *JWT) IssueToken(tokenClaims TokenClaims) (string, error) {
+	now := time.Now()
+	claims := jwtClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   tokenClaims.Login,
+			Issuer:    j.issuer,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(j.ttl)),
+		},
+		SessionID: tokenClaims.SessionID,
+		Version:   tokenClaims.Version,
+	}
+	if j.audience != "" {
+		claims.Audience = jwt.ClaimStrings{j.audience}
+	}
+
+	return jwt.NewWithClaims(j.method, claims).SignedString(j.signKey)
+}
+
+func (j *JWT) ParseToken(token string) (TokenClaims, error) {
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{j.method.Alg()}), // иначе можно подсунуть токен с alg=none
+		jwt.WithExpirationRequired(),
+	}
+	if j.issuer != "" {
+		options = append(options, jwt.WithIssuer(j.issuer))
+	}
+	if j.audience != "" {
+		options = append(options, jwt.WithAudience(j.audience))
+	}
+
+	var claims jwtClaims
+	_, err := jwt.ParseWithClaims(token, &claims, func(*jwt.Token) (any, error) {
+		return j.verifyKey, nil
+	}, options...)
+	if err != nil {
+		return TokenClaims{}, fmt.Errorf("%w: %w", ErrInvalidToken, err)
+	}
+	if claims.Subject == "" {
+		return TokenClaims{}, fmt.Errorf("%w: empty subject", ErrInvalidToken)
+	}
+	// Токен без сессии нельзя отозвать
+	if claims.SessionID == "" {
+		return TokenClaims{}, fmt.Errorf("%w: empty session", ErrInvalidToken)
+	}
+
+	return TokenClaims{Login: claims.Subject, SessionID: claims.SessionID, Version: claims.Version}, nil
+}
@@ -0,0 +1,126 @@
+//go:build unit
+// +build unit
+
+package user
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testSecret = []byte("0123456789abcdef0123456789abcdef")
+
+func ed25519PEM(t *testing.T) []byte {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func Test_JWT_RoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		alg  string
+		key  []byte
+	}{
+		{name: "HS256", alg: AlgHS256, key: testSecret},
+		{name: "EdDSA", alg: AlgEdDSA, key: ed25519PEM(t)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			j, err := NewJWT(tt.alg, tt.key, "gophermart", "mobile", time.Hour)
+			require.NoError(t, err)
+
+			issued := TokenClaims{Login: "testUser", SessionID: "testSessionID", Version: 3}
+			token, err := j.IssueToken(issued)
+			require.NoError(t, err)
+
+			claims, err := j.ParseToken(token)
+			require.NoError(t, err)
+			assert.Equal(t, issued, claims)
+		})
+	}
+}
+
+func Test_JWT_Rejects(t *testing.T) {
+	j, err := NewJWT(AlgHS256, testSecret, "gophermart", "mobile", time.Hour)
+	require.NoError(t, err)
+
+	sign := func(method jwt.SigningMethod, key any, claims jwtClaims) string {
+		token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+		require.NoError(t, err)
+		return token
+	}
+	valid := jwtClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   "testUser",
+			Issuer:    "gophermart",
+			Audience:  jwt.ClaimStrings{"mobile"},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+		SessionID: "testSessionID",
+	}
+	with := func(change func(*jwtClaims)) jwtClaims {
+		claims := valid
+		change(&claims)
+		return claims
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{name: "Garbage", token: "not-a-jwt"},
+		{name: "Wrong secret", token: sign(jwt.SigningMethodHS256, []byte("another-secret-another-secret-!!"), valid)},
+		{name: "Alg none", token: sign(jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, valid)},
+		{name: "Expired", token: sign(jwt.SigningMethodHS256, testSecret, with(func(c *jwtClaims) {
+			c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+		}))},
+		{name: "No expiration", token: sign(jwt.SigningMethodHS256, testSecret, with(func(c *jwtClaims) {
+			c.ExpiresAt = nil
+		}))},
+		{name: "Wrong issuer", token: sign(jwt.SigningMethodHS256, testSecret, with(func(c *jwtClaims) {
+			c.Issuer = "someone-else"
+		}))},
+		{name: "Wrong audience", token: sign(jwt.SigningMethodHS256, testSecret, with(func(c *jwtClaims) {
+			c.Audience = jwt.ClaimStrings{"web"}
+		}))},
+		{name: "Empty subject", token: sign(jwt.SigningMethodHS256, testSecret, with(func(c *jwtClaims) {
+			c.Subject = ""
+		}))},
+		{name: "Empty session", token: sign(jwt.SigningMethodHS256, testSecret, with(func(c *jwtClaims) {
+			c.SessionID = ""
+		}))},
+	}
+
+	_, err = j.ParseToken(sign(jwt.SigningMethodHS256, testSecret, valid))
+	require.NoError(t, err)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := j.ParseToken(tt.token)
+			assert.ErrorIs(t, err, ErrInvalidToken)
+		})
+	}
+}
+
+func Test_NewJWT_Errors(t *testing.T) {
+	_, err := NewJWT(AlgHS256, []byte("short"), "", "", time.Hour)
+	assert.ErrorIs(t, err, ErrWeakSecret)
+
+	_, err = NewJWT("RS256", testSecret, "", "", time.Hour)
+	assert.ErrorIs(t, err, ErrUnsupportedAlgorithm)
+
+	_, err = NewJWT(AlgEdDSA, []byte("not a pem"), "", "", time.Hour)
+	assert.Error(t, err)
+}
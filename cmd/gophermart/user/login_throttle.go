@@ -0,0 +1,109 @@
+package user
+
+import (
+	"context"
+	"time"
+)
+
+// Области ограничения неудачных входов
+const (
+	ScopeLogin = "login"
+	ScopeIP    = "ip"
+)
+
+// LoginAttemptStore - общее для всех экземпляров сервиса хранилище неудачных входов (реализует StorageService)
+type LoginAttemptStore interface {
+	AddLoginFailure(ctx context.Context, key string, window time.Duration) (failures int, err error)
+	ResetLoginFailures(ctx context.Context, key string) error
+	LockLogin(ctx context.Context, key string, duration time.Duration) (lockedUntil time.Time, err error)
+	GetLoginLockout(ctx context.Context, key string) (lockedUntil time.Time, err error)
+}
+
+// LoginLimits - сколько неудачных входов допускается за скользящее окно Window,
+// прежде чем вход блокируется на Lockout. Нулевой лимит отключает проверку.
+type LoginLimits struct {
+	MaxFailuresPerLogin int
+	MaxFailuresPerIP    int
+	Window              time.Duration
+	Lockout             time.Duration
+}
+
+// Lockout - блокировка, начавшаяся после очередной неудачной попытки
+type Lockout struct {
+	Scope       string
+	Failures    int
+	LockedUntil time.Time
+}
+
+// LoginThrottle защищает вход от перебора паролей по логину и по IP
+type LoginThrottle struct {
+	store  LoginAttemptStore
+	limits LoginLimits
+}
+
+func NewLoginThrottle(store LoginAttemptStore, limits LoginLimits) *LoginThrottle {
+	return &LoginThrottle{store: store, limits: limits}
+}
+
+type throttleKey struct {
+	scope string
+	key   string
+	limit int
+}
+
+func (t *LoginThrottle) keys(login, ip string) []throttleKey {
+	var keys []throttleKey
+	if t.limits.MaxFailuresPerLogin > 0 && login != "" {
+		keys = append(keys, throttleKey{ScopeLogin, ScopeLogin + ":" + login, t.limits.MaxFailuresPerLogin})
+	}
+	if t.limits.MaxFailuresPerIP > 0 && ip != "" {
+		keys = append(keys, throttleKey{ScopeIP, ScopeIP + ":" + ip, t.limits.MaxFailuresPerIP})
+	}
+	return keys
+}
+
+// RetryAfter - через сколько можно повторить вход (0 - вход не заблокирован).
+// Проверяется до сравнения пароля, чтобы заблокированные попытки не нагружали bcrypt.
+func (t *LoginThrottle) RetryAfter(ctx context.Context, login, ip string) (time.Duration, error) {
+	var retryAfter time.Duration
+	for _, k := range t.keys(login, ip) {
+		lockedUntil, err := t.store.GetLoginLockout(ctx, k.key)
+		if err != nil {
+			return 0, err
+		}
+		if wait := time.Until(lockedUntil); wait > retryAfter {
+			retryAfter = wait
+		}
+	}
+	return retryAfter, nil
+}
+
+// Fail учитывает неудачный вход и возвращает начавшиеся из-за него блокировки
+func (t *LoginThrottle) Fail(ctx context.Context, login, ip string) ([]Lockout, error) {
+	var lockouts []Lockout
+	for _, k := range t.keys(login, ip) {
+		failures, err := t.store.AddLoginFailure(ctx, k.key, t.limits.Window)
+		if err != nil {
+			return lockouts, err
+		}
+		if failures < k.limit {
+			continue
+		}
+
+		lockedUntil, err := t.store.LockLogin(ctx, k.key, t.limits.Lockout)
+		if err != nil {
+			return lockouts, err
+		}
+		lockouts = append(lockouts, Lockout{Scope: k.scope, Failures: failures, LockedUntil: lockedUntil})
+	}
+	return lockouts, nil
+}
+
+// Succeed сбрасывает счётчик логина. Счётчик IP не сбрасывается:
+// иначе перебор чужих паролей можно было бы чередовать со входом в свой аккаунт.
+func (t *LoginThrottle) Succeed(ctx context.Context, login string) error {
+	if t.limits.MaxFailuresPerLogin <= 0 || login == "" {
+		return nil
+	}
+	return t.store.ResetLoginFailures(ctx, ScopeLogin+":"+login)
+}
@@ -0,0 +1,110 @@
+//go:build unit
+// +build unit
+
+package user
+
+import (
+	"context"
+	"gophermart/cmd/gophermart/storage"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_LoginThrottle_PerLogin(t *testing.T) {
+	ctx := context.Background()
+	throttle := NewLoginThrottle(storage.NewMemoryStorage(), LoginLimits{
+		MaxFailuresPerLogin: 3,
+		Window:              time.Minute,
+		Lockout:             time.Hour,
+	})
+
+	for i := 0; i < 2; i++ {
+		lockouts, err := throttle.Fail(ctx, "user", "10.0.0.1")
+		require.NoError(t, err)
+		assert.Empty(t, lockouts)
+	}
+	retryAfter, err := throttle.RetryAfter(ctx, "user", "10.0.0.1")
+	require.NoError(t, err)
+	assert.Zero(t, retryAfter)
+
+	lockouts, err := throttle.Fail(ctx, "user", "10.0.0.2")
+	require.NoError(t, err)
+	require.Len(t, lockouts, 1, "failures from different addresses count for the login")
+	assert.Equal(t, ScopeLogin, lockouts[0].Scope)
+	assert.Equal(t, 3, lockouts[0].Failures)
+
+	retryAfter, err = throttle.RetryAfter(ctx, "user", "10.0.0.3")
+	require.NoError(t, err)
+	assert.InDelta(t, time.Hour, retryAfter, float64(time.Minute))
+
+	retryAfter, err = throttle.RetryAfter(ctx, "other", "10.0.0.1")
+	require.NoError(t, err)
+	assert.Zero(t, retryAfter, "other logins are not locked")
+}
+
+func Test_LoginThrottle_PerIP(t *testing.T) {
+	ctx := context.Background()
+	throttle := NewLoginThrottle(storage.NewMemoryStorage(), LoginLimits{
+		MaxFailuresPerLogin: 3,
+		MaxFailuresPerIP:    4,
+		Window:              time.Minute,
+		Lockout:             time.Hour,
+	})
+
+	// Перебор разных логинов с одного адреса
+	var lockouts []Lockout
+	for _, login := range []string{"a", "b", "c", "d"} {
+		var err error
+		lockouts, err = throttle.Fail(ctx, login, "10.0.0.1")
+		require.NoError(t, err)
+	}
+	require.Len(t, lockouts, 1)
+	assert.Equal(t, ScopeIP, lockouts[0].Scope)
+
+	retryAfter, err := throttle.RetryAfter(ctx, "e", "10.0.0.1")
+	require.NoError(t, err)
+	assert.Positive(t, retryAfter)
+
+	retryAfter, err = throttle.RetryAfter(ctx, "e", "10.0.0.2")
+	require.NoError(t, err)
+	assert.Zero(t, retryAfter)
+}
+
+func Test_LoginThrottle_SlidingWindow(t *testing.T) {
+	ctx := context.Background()
+	throttle := NewLoginThrottle(storage.NewMemoryStorage(), LoginLimits{
+		MaxFailuresPerLogin: 2,
+		Window:              50 * time.Millisecond,
+		Lockout:             time.Hour,
+	})
+
+	_, err := throttle.Fail(ctx, "user", "")
+	require.NoError(t, err)
+	time.Sleep(100 * time.Millisecond)
+
+	lockouts, err := throttle.Fail(ctx, "user", "")
+	require.NoError(t, err)
+	assert.Empty(t, lockouts, "failures outside the window are not counted")
+}
+
+func Test_LoginThrottle_Succeed(t *testing.T) {
+	ctx := context.Background()
+	throttle := NewLoginThrottle(storage.NewMemoryStorage(), LoginLimits{
+		MaxFailuresPerLogin: 2,
+		MaxFailuresPerIP:    2,
+		Window:              time.Minute,
+		Lockout:             time.Hour,
+	})
+
+	_, err := throttle.Fail(ctx, "user", "10.0.0.1")
+	require.NoError(t, err)
+	require.NoError(t, throttle.Succeed(ctx, "user"))
+
+	lockouts, err := throttle.Fail(ctx, "user", "10.0.0.1")
+	require.NoError(t, err)
+	require.Len(t, lockouts, 1, "only the login counter is reset")
+	assert.Equal(t, ScopeIP, lockouts[0].Scope)
+}
@@ -0,0 +1,108 @@
+package user
+
+import (
+	"bufio"
+	"fmt"
+	"gophermart/cmd/gophermart/models"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Правила парольной политики (поле rule в ответе 400)
+const (
+	RuleMinLength   = "min_length"
+	RuleCharClasses = "char_classes"
+	RuleSameAsLogin = "same_as_login"
+	RuleBreached    = "breached"
+)
+
+// PasswordPolicy - требования к новому паролю (регистрация и смена пароля)
+type PasswordPolicy struct {
+	minLength      int
+	minCharClasses int // из четырёх: строчные, заглавные, цифры, остальные символы
+	breached       map[string]struct{}
+}
+
+func NewPasswordPolicy(minLength, minCharClasses int) *PasswordPolicy {
+	return &PasswordPolicy{
+		minLength:      minLength,
+		minCharClasses: minCharClasses,
+		breached:       make(map[string]struct{}),
+	}
+}
+
+// LoadBreached читает список утёкших паролей: по одному в строке, без учёта регистра
+func (p *PasswordPolicy) LoadBreached(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			p.breached[strings.ToLower(line)] = struct{}{}
+		}
+	}
+	return scanner.Err()
+}
+
+// Check возвращает нарушенные правила (nil, если пароль подходит)
+func (p *PasswordPolicy) Check(login, password string) []models.PasswordViolation {
+	var violations []models.PasswordViolation
+
+	if utf8.RuneCountInString(password) < p.minLength {
+		violations = append(violations, models.PasswordViolation{
+			Rule:    RuleMinLength,
+			Message: fmt.Sprintf("password must be at least %d characters long", p.minLength),
+		})
+	}
+	if charClasses(password) < p.minCharClasses {
+		violations = append(violations, models.PasswordViolation{
+			Rule: RuleCharClasses,
+			Message: fmt.Sprintf("password must contain at least %d of: lowercase letters, uppercase letters, digits, symbols",
+				p.minCharClasses),
+		})
+	}
+	if login != "" && strings.EqualFold(password, login) {
+		violations = append(violations, models.PasswordViolation{
+			Rule:    RuleSameAsLogin,
+			Message: "password must differ from the login",
+		})
+	}
+	if _, ok := p.breached[strings.ToLower(password)]; ok {
+		violations = append(violations, models.PasswordViolation{
+			Rule:    RuleBreached,
+			Message: "password appears in a list of breached passwords",
+		})
+	}
+
+	return violations
+}
+
+func charClasses(password string) int {
+	var lower, upper, digit, other bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			other = true
+		}
+	}
+
+	n := 0
+	for _, present := range []bool{lower, upper, digit, other} {
+		if present {
+			n++
+		}
+	}
+	return n
+}
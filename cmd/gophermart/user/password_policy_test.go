@@ -0,0 +1,59 @@
+//go:build unit
+// +build unit
+
+package user
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func rules(p *PasswordPolicy, login, password string) []string {
+	var names []string
+	for _, v := range p.Check(login, password) {
+		names = append(names, v.Rule)
+	}
+	return names
+}
+
+func Test_PasswordPolicy_Check(t *testing.T) {
+	p := NewPasswordPolicy(8, 3)
+
+	tests := []struct {
+		name     string
+		login    string
+		password string
+		expected []string
+	}{
+		{name: "Valid", login: "user", password: "Secret-42"},
+		{name: "Too short", login: "user", password: "Ab1!", expected: []string{RuleMinLength}},
+		{name: "Length counts characters, not bytes", login: "user", password: "Пароль1!", expected: nil},
+		{name: "Too few character classes", login: "user", password: "onlylowercase", expected: []string{RuleCharClasses}},
+		{name: "Same as login", login: "Admin-2024", password: "admin-2024", expected: []string{RuleSameAsLogin}},
+		{name: "Several violations", login: "user", password: "abc", expected: []string{RuleMinLength, RuleCharClasses}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, rules(p, tt.login, tt.password))
+		})
+	}
+}
+
+func Test_PasswordPolicy_Breached(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	require.NoError(t, os.WriteFile(path, []byte("Password1!\n\n  qwerty123  \n"), 0o600))
+
+	p := NewPasswordPolicy(8, 2)
+	require.NoError(t, p.LoadBreached(path))
+
+	assert.Equal(t, []string{RuleBreached}, rules(p, "user", "password1!"), "case-insensitive")
+	assert.Equal(t, []string{RuleBreached}, rules(p, "user", "Qwerty123"))
+	assert.Empty(t, rules(p, "user", "Qwerty1234"))
+
+	assert.Error(t, p.LoadBreached(filepath.Join(t.TempDir(), "missing.txt")))
+}
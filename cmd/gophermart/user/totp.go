@@ -0,0 +1,90 @@
+package user
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base32"
+	"strings"
+	"time"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+)
+
+const (
+	totpPeriod = 30 // секунд
+	totpSkew   = 1  // допускается соседний интервал (расхождение часов)
+
+	recoveryCodesCount = 10
+)
+
+var recoveryEncoding = base32.NewEncoding("abcdefghijklmnopqrstuvwxyz234567").WithPadding(base32.NoPadding)
+
+// TOTP - одноразовые коды второго фактора (RFC 6238: SHA1, 6 цифр, 30 секунд)
+type TOTP struct {
+	issuer string
+	now    func() time.Time
+}
+
+func NewTOTP(issuer string) *TOTP {
+	return &TOTP{issuer: issuer, now: time.Now}
+}
+
+// Generate создаёт секрет для пользователя и otpauth:// URI для приложения-аутентификатора
+func (t *TOTP) Generate(login string) (secret, uri string, err error) {
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      t.issuer,
+		AccountName: login,
+		Period:      totpPeriod,
+		Digits:      otp.DigitsSix,
+		Algorithm:   otp.AlgorithmSHA1,
+	})
+	if err != nil {
+		return "", "", err
+	}
+	return key.Secret(), key.URL(), nil
+}
+
+// Validate проверяет код и возвращает номер интервала, которому он соответствует.
+// Номер сохраняется в хранилище, чтобы один и тот же код нельзя было использовать повторно.
+func (t *TOTP) Validate(secret, code string) (step int64, ok bool) {
+	now := t.now()
+	for skew := -totpSkew; skew <= totpSkew; skew++ {
+		at := now.Add(time.Duration(skew*totpPeriod) * time.Second)
+		expected, err := totp.GenerateCodeCustom(secret, at, totp.ValidateOpts{
+			Period:    totpPeriod,
+			Digits:    otp.DigitsSix,
+			Algorithm: otp.AlgorithmSHA1,
+		})
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return at.Unix() / totpPeriod, true
+		}
+	}
+	return 0, false
+}
+
+// GenerateRecoveryCodes - одноразовые коды на случай потери аутентификатора (вида abcde-fghij)
+func GenerateRecoveryCodes() ([]string, error) {
+	codes := make([]string, recoveryCodesCount)
+	for i := range codes {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		code := recoveryEncoding.EncodeToString(b)[:10]
+		codes[i] = code[:5] + "-" + code[5:]
+	}
+	return codes, nil
+}
+
+// NormalizeRecoveryCode приводит введённый код к виду, в котором он выдавался
+func NormalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), " ", ""))
+	if len(code) == 10 && !strings.Contains(code, "-") {
+		code = code[:5] + "-" + code[5:]
+	}
+	return code
+}
@@ -0,0 +1,79 @@
+//go:build unit
+// +build unit
+
+package user
+
+import (
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/pquerna/otp/totp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_TOTP_Generate(t *testing.T) {
+	secret, uri, err := NewTOTP("gophermart").Generate("user")
+	require.NoError(t, err)
+	assert.NotEmpty(t, secret)
+
+	u, err := url.Parse(uri)
+	require.NoError(t, err)
+	assert.Equal(t, "otpauth", u.Scheme)
+	assert.Equal(t, "totp", u.Host)
+	assert.Equal(t, "/gophermart:user", u.Path)
+	assert.Equal(t, secret, u.Query().Get("secret"))
+	assert.Equal(t, "gophermart", u.Query().Get("issuer"))
+}
+
+func Test_TOTP_Validate(t *testing.T) {
+	tt := NewTOTP("gophermart")
+	secret, _, err := tt.Generate("user")
+	require.NoError(t, err)
+
+	now := time.Unix(1_700_000_010, 0)
+	tt.now = func() time.Time { return now }
+	step := now.Unix() / 30
+
+	code, err := totp.GenerateCode(secret, now)
+	require.NoError(t, err)
+	got, ok := tt.Validate(secret, code)
+	assert.True(t, ok)
+	assert.Equal(t, step, got)
+
+	// Соседний интервал допускается и возвращает свой номер
+	previous, err := totp.GenerateCode(secret, now.Add(-30*time.Second))
+	require.NoError(t, err)
+	got, ok = tt.Validate(secret, previous)
+	assert.True(t, ok)
+	assert.Equal(t, step-1, got)
+
+	stale, err := totp.GenerateCode(secret, now.Add(-90*time.Second))
+	require.NoError(t, err)
+	_, ok = tt.Validate(secret, stale)
+	assert.False(t, ok, "codes older than one interval are rejected")
+
+	_, ok = tt.Validate(secret, "000000x")
+	assert.False(t, ok)
+}
+
+func Test_GenerateRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes()
+	require.NoError(t, err)
+	require.Len(t, codes, 10)
+
+	seen := make(map[string]struct{})
+	for _, code := range codes {
+		assert.Regexp(t, regexp.MustCompile(`^[a-z2-7]{5}-[a-z2-7]{5}$`), code)
+		seen[code] = struct{}{}
+	}
+	assert.Len(t, seen, 10, "codes are unique")
+}
+
+func Test_NormalizeRecoveryCode(t *testing.T) {
+	assert.Equal(t, "abcde-fghij", NormalizeRecoveryCode(" ABCDE-FGHIJ "))
+	assert.Equal(t, "abcde-fghij", NormalizeRecoveryCode("abcdefghij"))
+	assert.Equal(t, "abcde-fghij", NormalizeRecoveryCode("abcde fghij"))
+}
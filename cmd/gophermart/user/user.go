@@ -1,16 +1,27 @@
 package user
 
 import (
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gorilla/securecookie"
+	"go.uber.org/zap"
 )
 
+var ErrInvalidCookieKey = errors.New("invalid cookie key")
+
+// minCookieHashKeyLen - рекомендация securecookie для ключа HMAC-SHA256
+const minCookieHashKeyLen = 32
+
 type User struct {
 	cookieName string
-	cookie     *securecookie.SecureCookie
+	codecs     []securecookie.Codec
+	sessionTTL time.Duration
+	sugar      *zap.SugaredLogger
 	Login      string `json:"login"`
 	Password   string `json:"password"`
 }
@@ -18,18 +29,85 @@ type User struct {
 type UserService interface {
 	GetUserIDFromCookie(r *http.Request) (string, error)
 	SetUserIDCookie(res http.ResponseWriter, uid string) error
+	DeleteUserIDCookie(res http.ResponseWriter)
+}
+
+// CookieKey - ключи securecookie: Hash для подписи, Block для шифрования (16, 24 или 32 байта; пустой - без шифрования)
+type CookieKey struct {
+	Hash  []byte
+	Block []byte
 }
 
-func newSecurecookie() *securecookie.SecureCookie {
-	var hashKey = []byte("very-very-very-very-secret-key32")
-	var blockKey = []byte("a-lot-of-secret!")
-	return securecookie.New(hashKey, blockKey)
+// devCookieKey - только для локального запуска, в production ключи обязательны (см. config.Init)
+var devCookieKey = CookieKey{
+	Hash:  []byte("very-very-very-very-secret-key32"),
+	Block: []byte("a-lot-of-secret!"),
 }
 
-func NewUserService() *User {
+// ParseCookieKeys разбирает пары "hash:block" в base64, разделённые запятыми или переводами строк.
+// Первая пара подписывает новые cookie, остальные только проверяют старые (ротация ключей).
+// Строки, начинающиеся с #, пропускаются.
+func ParseCookieKeys(s string) ([]CookieKey, error) {
+	var keys []CookieKey
+	for _, field := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == '\n' }) {
+		field = strings.TrimSpace(field)
+		if field == "" || strings.HasPrefix(field, "#") {
+			continue
+		}
+
+		hash, block, _ := strings.Cut(field, ":")
+		key := CookieKey{}
+		var err error
+		if key.Hash, err = base64.StdEncoding.DecodeString(hash); err != nil {
+			return nil, fmt.Errorf("%w #%d: hash key: %w", ErrInvalidCookieKey, len(keys)+1, err)
+		}
+		if key.Block, err = base64.StdEncoding.DecodeString(block); err != nil {
+			return nil, fmt.Errorf("%w #%d: block key: %w", ErrInvalidCookieKey, len(keys)+1, err)
+		}
+		if err := key.validate(); err != nil {
+			return nil, fmt.Errorf("%w #%d: %w", ErrInvalidCookieKey, len(keys)+1, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (k CookieKey) validate() error {
+	if len(k.Hash) < minCookieHashKeyLen {
+		return fmt.Errorf("hash key must be at least %d bytes", minCookieHashKeyLen)
+	}
+	switch len(k.Block) {
+	case 0, 16, 24, 32:
+		return nil
+	default:
+		return errors.New("block key must be 16, 24 or 32 bytes")
+	}
+}
+
+// NewUserService - cookie живёт sessionTTL, как и сессия; без ключей используется devCookieKey
+func NewUserService(sessionTTL time.Duration, sugar *zap.SugaredLogger, keys ...CookieKey) *User {
+	if len(keys) == 0 {
+		keys = []CookieKey{devCookieKey}
+	}
+
+	pairs := make([][]byte, 0, 2*len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, key.Hash, key.Block)
+	}
+
+	codecs := securecookie.CodecsFromPairs(pairs...)
+	// securecookie по умолчанию отклоняет подписи старше 30 дней, срок должен совпадать с сессией
+	for _, codec := range codecs {
+		if sc, ok := codec.(*securecookie.SecureCookie); ok {
+			sc.MaxAge(int(sessionTTL.Seconds()))
+		}
+	}
+
 	return &User{
 		cookieName: "AuthToken",
-		cookie:     newSecurecookie(),
+		codecs:     codecs,
+		sessionTTL: sessionTTL,
+		sugar:      sugar,
 	}
 }
 
@@ -40,7 +118,7 @@ func (u *User) GetUserIDFromCookie(req *http.Request) (string, error) {
 	}
 
 	var uid string
-	if err := u.cookie.Decode(u.cookieName, cookie.Value, &uid); err != nil {
+	if err := securecookie.DecodeMulti(u.cookieName, cookie.Value, &uid, u.codecs...); err != nil {
 		return "", err
 	}
 
@@ -48,7 +126,7 @@ func (u *User) GetUserIDFromCookie(req *http.Request) (string, error) {
 }
 
 func (u *User) SetUserIDCookie(res http.ResponseWriter, uid string) error {
-	encoded, err := u.cookie.Encode(u.cookieName, uid)
+	encoded, err := securecookie.EncodeMulti(u.cookieName, uid, u.codecs...)
 
 	if err == nil {
 		cookie := &http.Cookie{
@@ -56,12 +134,22 @@ func (u *User) SetUserIDCookie(res http.ResponseWriter, uid string) error {
 			Value:   encoded,
 			Path:    "/",
 			Secure:  false,
-			Expires: time.Now().Add(30 * 24 * time.Hour),
+			Expires: time.Now().Add(u.sessionTTL),
+			MaxAge:  int(u.sessionTTL.Seconds()),
 		}
 		http.SetCookie(res, cookie)
 	} else {
-		fmt.Printf("(SetUserIDCookie) err %v\n", err)
+		u.sugar.Errorf("(SetUserIDCookie) err %v", err)
 	}
 
 	return err
 }
+
+func (u *User) DeleteUserIDCookie(res http.ResponseWriter) {
+	http.SetCookie(res, &http.Cookie{
+		Name:   u.cookieName,
+		Value:  "",
+		Path:   "/",
+		MaxAge: -1,
+	})
+}
@@ -0,0 +1,96 @@
+//go:build unit
+// +build unit
+
+package user
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func cookieKeyString(hash, block string) string {
+	return base64.StdEncoding.EncodeToString([]byte(hash)) + ":" + base64.StdEncoding.EncodeToString([]byte(block))
+}
+
+// roundTrip - выдать cookie одним сервисом и прочитать другим
+func roundTrip(t *testing.T, from, to *User) (string, error) {
+	w := httptest.NewRecorder()
+	require.NoError(t, from.SetUserIDCookie(w, "testUserID"))
+
+	req := httptest.NewRequest(http.MethodGet, "/", http.NoBody)
+	for _, cookie := range w.Result().Cookies() {
+		req.AddCookie(cookie)
+	}
+	return to.GetUserIDFromCookie(req)
+}
+
+func Test_ParseCookieKeys(t *testing.T) {
+	current := cookieKeyString(strings.Repeat("a", 32), strings.Repeat("b", 16))
+	previous := cookieKeyString(strings.Repeat("c", 64), "")
+
+	keys, err := ParseCookieKeys("# current first\n" + current + "\n\n" + previous + "\n")
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	assert.Equal(t, []byte(strings.Repeat("a", 32)), keys[0].Hash)
+	assert.Equal(t, []byte(strings.Repeat("b", 16)), keys[0].Block)
+	assert.Empty(t, keys[1].Block, "block key is optional")
+
+	keys, err = ParseCookieKeys(current + "," + previous)
+	require.NoError(t, err)
+	assert.Len(t, keys, 2)
+
+	keys, err = ParseCookieKeys("")
+	require.NoError(t, err)
+	assert.Empty(t, keys)
+
+	for name, value := range map[string]string{
+		"Not base64":       "!!!:" + base64.StdEncoding.EncodeToString([]byte(strings.Repeat("b", 16))),
+		"Short hash key":   cookieKeyString("short", strings.Repeat("b", 16)),
+		"Wrong block size": cookieKeyString(strings.Repeat("a", 32), "12345"),
+	} {
+		_, err := ParseCookieKeys(value)
+		assert.ErrorIs(t, err, ErrInvalidCookieKey, name)
+	}
+}
+
+func Test_UserService_KeyRotation(t *testing.T) {
+	oldKey := CookieKey{Hash: []byte(strings.Repeat("o", 32)), Block: []byte(strings.Repeat("p", 16))}
+	newKey := CookieKey{Hash: []byte(strings.Repeat("n", 32)), Block: []byte(strings.Repeat("m", 32))}
+
+	before := NewUserService(time.Hour, zap.NewNop().Sugar(), oldKey)
+	rotated := NewUserService(time.Hour, zap.NewNop().Sugar(), newKey, oldKey)
+	after := NewUserService(time.Hour, zap.NewNop().Sugar(), newKey)
+
+	uid, err := roundTrip(t, before, rotated)
+	require.NoError(t, err, "cookie signed with the previous key is still accepted")
+	assert.Equal(t, "testUserID", uid)
+
+	uid, err = roundTrip(t, rotated, after)
+	require.NoError(t, err, "new cookies are signed with the first key")
+	assert.Equal(t, "testUserID", uid)
+
+	_, err = roundTrip(t, before, after)
+	assert.Error(t, err, "the removed key is no longer accepted")
+
+	_, err = roundTrip(t, NewUserService(time.Hour, zap.NewNop().Sugar()), after)
+	assert.Error(t, err, "development key differs from the configured one")
+}
+
+func Test_UserService_CookieTTL(t *testing.T) {
+	ttl := 90 * 24 * time.Hour
+	w := httptest.NewRecorder()
+	require.NoError(t, NewUserService(ttl, zap.NewNop().Sugar()).SetUserIDCookie(w, "testUserID"))
+
+	cookies := w.Result().Cookies()
+	require.Len(t, cookies, 1)
+	assert.Equal(t, int(ttl.Seconds()), cookies[0].MaxAge)
+	assert.WithinDuration(t, time.Now().Add(ttl), cookies[0].Expires, time.Minute)
+}